@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-amphora/dao/maintenance"
+)
+
+const usage = `usage:
+  dao-admin backup list
+  dao-admin backup verify <folder|file>
+  dao-admin backup restore -namespace <namespace> [-table <table>] <folder|file>
+  dao-admin backup prune [-dry-run]`
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "backup" {
+		exitf(usage)
+	}
+	args := os.Args[3:]
+	switch os.Args[2] {
+	case "list":
+		listBackups(args)
+	case "verify":
+		verifyBackup(args)
+	case "restore":
+		restoreBackup(args)
+	case "prune":
+		pruneBackups(args)
+	default:
+		exitf(usage)
+	}
+}
+
+func listBackups(args []string) {
+	fs := flag.NewFlagSet("backup list", flag.ExitOnError)
+	fs.Parse(args)
+
+	sets, err := maintenance.ListBackups()
+	if err != nil {
+		exitf("error listing backups: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tNAMESPACE\tFILE\tCOMPRESSION\tCODEC\tSIZE\tCREATED")
+	for _, set := range sets {
+		if set.Err != nil {
+			fmt.Fprintf(w, "%v\t\t\t\t\t\terror: %v\n", set.Folder, set.Err)
+			continue
+		}
+		if !set.Manifest {
+			fmt.Fprintf(w, "%v\t\t(no manifest)\t\t\t\t\n", set.Folder)
+		}
+		for _, f := range set.Files {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", set.Folder, f.NameSpace, f.File, f.Compression, f.Codec, f.Size, f.Created.Format(time.RFC3339))
+		}
+	}
+	w.Flush()
+}
+
+func verifyBackup(args []string) {
+	fs := flag.NewFlagSet("backup verify", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		exitf("usage: dao-admin backup verify <folder|file>")
+	}
+
+	reports, err := maintenance.VerifyBackup(fs.Arg(0))
+	if reports == nil && err != nil {
+		exitf("error verifying %v: %v", fs.Arg(0), err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tNAMESPACE\tTABLE\tRECORDS\tSTATUS")
+	for _, report := range reports {
+		if report.Err != nil {
+			fmt.Fprintf(w, "%v\t%v\t\t\tINVALID: %v\n", report.File, report.NameSpace, report.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t\t\tOK\n", report.File, report.NameSpace)
+		for _, table := range database.TableNames(report.Tables) {
+			fmt.Fprintf(w, "\t\t%v\t%d\t\n", table, report.Tables[table])
+		}
+	}
+	w.Flush()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func restoreBackup(args []string) {
+	fs := flag.NewFlagSet("backup restore", flag.ExitOnError)
+	nameSpace := fs.String("namespace", "", "namespace to restore (required)")
+	table := fs.String("table", "", "restore only this table, into the live database")
+	fs.Parse(args)
+	if *nameSpace == "" || fs.NArg() != 1 {
+		exitf("usage: dao-admin backup restore -namespace <namespace> [-table <table>] <folder|file>")
+	}
+
+	if *table != "" {
+		count, err := maintenance.RestoreTable(*nameSpace, *table, fs.Arg(0))
+		if err != nil {
+			exitf("error restoring %v of %v: %v", *table, *nameSpace, err)
+		}
+		fmt.Printf("restored %d records of %v to %v\n", count, *table, *nameSpace)
+		return
+	}
+	if err := maintenance.RestoreNamespace(*nameSpace, fs.Arg(0)); err != nil {
+		exitf("error restoring %v: %v", *nameSpace, err)
+	}
+	fmt.Printf("restored %v\n", *nameSpace)
+}
+
+func pruneBackups(args []string) {
+	fs := flag.NewFlagSet("backup prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "show what would be pruned, without deleting it")
+	fs.Parse(args)
+
+	policy := maintenance.BackupRetentionSettings()
+	decisions, err := maintenance.PlanBackupPrune(policy)
+	if err != nil {
+		exitf("error planning prune: %v", err)
+	}
+	fmt.Printf("retention: %v\n", policy)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tTAKEN\tSIZE\tACTION\tREASON")
+	for _, d := range decisions {
+		action := "keep"
+		if !d.Keep {
+			action = "prune"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", d.Folder, d.Taken.Format(time.RFC3339), d.Size, action, d.Reason)
+	}
+	w.Flush()
+	if *dryRun {
+		return
+	}
+	job := &maintenance.DatabaseBackupCleanerJob{Retention: &policy}
+	if err := job.Run(); err != nil {
+		exitf("error pruning backups: %v", err)
+	}
+	fmt.Printf("pruned %d folders\n", len(job.Pruned()))
+}
+
+func exitf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(2)
+}
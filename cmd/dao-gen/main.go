@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"text/template"
 	"time"
@@ -18,15 +19,17 @@ import (
 var templatesFS embed.FS
 
 type config struct {
-	OutDir     string
-	Package    string
-	TypeName   string
-	TableName  string
-	Namespace  string
-	Force      bool
-	WithWorker bool
-	WithImpex  bool
-	WithDebug  bool
+	OutDir      string
+	Package     string
+	TypeName    string
+	TableName   string
+	Namespace   string
+	Force       bool
+	WithWorker  bool
+	WithImpex   bool
+	WithDebug   bool
+	SoftDelete  bool
+	WithHistory bool
 }
 
 type templateData struct {
@@ -38,8 +41,12 @@ type templateData struct {
 	FieldsVar        string
 	DomainFields     string            // Field definitions from .definition file
 	FieldDefinitions []FieldDefinition // Parsed field definitions for documentation
+	IndexFields      []string          // Fields tagged storm:"index", registered as cache indexes
+	UniqueFields     []string          // Fields tagged storm:"unique", registered as unique cache indexes
 	GeneratedDate    string            // Date and time when code was generated
 	GeneratedBy      string            // Username and hostname of the generator
+	SoftDelete       bool              // Delete marks records deleted rather than removing them
+	WithHistory      bool              // Each version of a record is kept in its history
 }
 
 type FieldDefinition struct {
@@ -60,6 +67,8 @@ func main() {
 	flag.BoolVar(&cfg.WithWorker, "with-worker", true, "generate worker file")
 	flag.BoolVar(&cfg.WithImpex, "with-impex", true, "generate import/export file")
 	flag.BoolVar(&cfg.WithDebug, "with-debug", true, "generate debug file")
+	flag.BoolVar(&cfg.SoftDelete, "soft-delete", false, "make Delete mark records deleted, rather than remove them")
+	flag.BoolVar(&cfg.WithHistory, "with-history", false, "keep a snapshot of each version of a record, for History, AsOf and Revert")
 	flag.Parse()
 
 	if cfg.Package == "" {
@@ -78,6 +87,8 @@ func main() {
 	// Read domain fields from .definition file if it exists
 	domainFields, fieldNames, fieldInits, fieldDefs := readDefinitionFile(cfg.OutDir, cfg.TypeName)
 
+	indexFields, uniqueFields := stormIndexes(fieldDefs)
+
 	// Get generation metadata
 	generatedDate := time.Now().Format("02/01/2006 & 15:04")
 	generatedBy := getGeneratedBy()
@@ -91,8 +102,12 @@ func main() {
 		FieldsVar:        "Fields",
 		DomainFields:     domainFields,
 		FieldDefinitions: fieldDefs,
+		IndexFields:      indexFields,
+		UniqueFields:     uniqueFields,
 		GeneratedDate:    generatedDate,
 		GeneratedBy:      generatedBy,
+		SoftDelete:       cfg.SoftDelete,
+		WithHistory:      cfg.WithHistory,
 	}
 
 	// Add custom functions for template
@@ -261,6 +276,37 @@ func readDefinitionFile(outDir, typeName string) (fields, fieldNames, fieldInits
 	return fields, fieldNames, fieldInits, fieldDefs
 }
 
+// standardUniqueFields are the unique fields every generated model has (see model.tmpl).
+var standardUniqueFields = []string{"Key", "Raw"}
+
+// stormIndexes returns the domain fields that Storm indexes, split into plain and unique
+// indexes, so the generated Initialise can register the same indexes in the cache.
+func stormIndexes(fieldDefs []FieldDefinition) (indexFields, uniqueFields []string) {
+	uniqueFields = append(uniqueFields, standardUniqueFields...)
+	for _, def := range fieldDefs {
+		tag, ok := reflect.StructTag(def.Tags).Lookup("storm")
+		if !ok {
+			continue
+		}
+		index, unique := false, false
+		for _, opt := range strings.Split(tag, ",") {
+			switch strings.TrimSpace(opt) {
+			case "index":
+				index = true
+			case "unique":
+				unique = true
+			}
+		}
+		switch {
+		case unique:
+			uniqueFields = append(uniqueFields, def.Name)
+		case index:
+			indexFields = append(indexFields, def.Name)
+		}
+	}
+	return indexFields, uniqueFields
+}
+
 func renderTemplate(fsys fs.FS, tmplName string, outPath string, d templateData, funcs template.FuncMap) error {
 	tmplPath := filepath.ToSlash(filepath.Join("templates", tmplName))
 	b, err := fs.ReadFile(fsys, tmplPath)
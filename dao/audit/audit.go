@@ -2,8 +2,8 @@ package audit
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -18,11 +18,21 @@ var name = "Audit"
 var cfg *commonConfig.Settings
 var upperName = strings.ToUpper(name)
 
+// ErrNoActiveUser is returned by Action when no user can be found to record against the action.
+var ErrNoActiveUser = errors.New("no active user")
+
 func (a *Action) WithMessage(in string) Action {
 	a.description = in
 	return *a
 }
 
+// WithChanges returns the action with the field changes it makes, which are kept on the
+// update it records, and on its audit event.
+func (a Action) WithChanges(changes []FieldChange) Action {
+	a.changes = changes
+	return a
+}
+
 func (a *Audit) Action(ctx context.Context, action Action) error {
 
 	message := action.popMessage()
@@ -40,8 +50,9 @@ func (a *Audit) Action(ctx context.Context, action Action) error {
 
 	if auditUser == "" {
 		//	logHandler.WarningLogger.Printf("[%v] Error: %v", strings.ToUpper(name), "No Active User")
-		logHandler.WarningLogger.Printf("Action: %v(%v) Message: %v Error: %v", action.code, action.short, message, "No Active User")
-		os.Exit(1)
+		logHandler.WarningLogger.Printf("Action: %v(%v) Message: %v Error: %v", action.code, action.short, message, ErrNoActiveUser)
+		clock.Stop(0)
+		return ErrNoActiveUser
 	}
 	//updateAction := action
 
@@ -59,6 +70,13 @@ func (a *Audit) Action(ctx context.Context, action Action) error {
 		a.DeletedAtDisplay = auditDisplay
 	}
 
+	if action.Is(RESTORE) {
+		a.DeletedAt = time.Time{}
+		a.DeletedBy = ""
+		a.DeletedOn = ""
+		a.DeletedAtDisplay = ""
+	}
+
 	if a.AuditSequence.Int() == 0 {
 		a.AuditSequence.Set(1)
 	} else {
@@ -81,11 +99,20 @@ func (a *Audit) Action(ctx context.Context, action Action) error {
 		updateMessage = updateMessage[0:messageLengthLimit] + "..."
 	}
 	update.UpdateNotes = updateMessage
+	update.Changes = action.changes
 	// a.DBVersion = dao.Version
 	dbVersion := getDBVersion()
 	a.DBVersion.Set(dbVersion)
 	if !(action.Is(SERVICE) || action.Is(SILENT) || action.IsSilent()) {
 		a.Updates = append(a.Updates, update)
+		if maxUpdates > 0 && len(a.Updates) > maxUpdates {
+			// roll the oldest updates off
+			a.Updates = append([]AuditUpdateInfo(nil), a.Updates[len(a.Updates)-maxUpdates:]...)
+		}
+	}
+	a.last = Event{Action: action.code, User: auditUser, Host: auditHost, At: auditTime, Notes: message, Changes: action.changes}
+	if a.last.Notes == "" {
+		a.last.Notes = update.UpdateNotes
 	}
 
 	logHandler.AuditLogger.Printf(AUDITMSG, upperName, action.code, auditDisplay, auditUser, auditHost, message)
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+)
+
+// MaskedValue replaces the values of fields tagged audit:"mask" in field changes.
+const MaskedValue = "********"
+
+// EventRecorder stores an audit event. See SetEventRecorder.
+type EventRecorder func(ctx context.Context, event Event) error
+
+var recorderMu sync.RWMutex
+var eventRecorder EventRecorder
+
+// SetEventRecorder sets the recorder that Publish sends audit events to, replacing any set
+// before. A nil recorder stops events being recorded.
+func SetEventRecorder(recorder EventRecorder) {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
+	eventRecorder = recorder
+}
+
+func getEventRecorder() EventRecorder {
+	recorderMu.RLock()
+	defer recorderMu.RUnlock()
+	return eventRecorder
+}
+
+// LastAction returns the last action performed by Action, until it is published.
+func (a *Audit) LastAction() Event {
+	return a.last
+}
+
+// Publish sends the last action performed by Action, with its field changes, on the record
+// recordID, with the key key, of table, to the EventRecorder, if one is set. Call it once the
+// change has been written, so that a created record has its ID. Each action is published once.
+func (a *Audit) Publish(ctx context.Context, table string, recordID int, key string) error {
+	event, ok := a.TakeEvent(table, recordID, key)
+	if !ok {
+		return nil
+	}
+	return PublishEvent(ctx, event)
+}
+
+// TakeEvent returns the last action performed by Action, with its field changes, as the event
+// on the record recordID, with the key key, of table, and reports whether there was one. The
+// action is then cleared, as it is by Publish, so the event can be published later with
+// PublishEvent; for example, once the transaction it was written in has committed.
+func (a *Audit) TakeEvent(table string, recordID int, key string) (Event, bool) {
+	event := a.last
+	a.last = Event{}
+	if event.Action == "" {
+		return Event{}, false
+	}
+	event.Table = table
+	event.RecordID = recordID
+	event.Key = key
+	return event, true
+}
+
+// PublishEvent sends event to the EventRecorder, if one is set.
+func PublishEvent(ctx context.Context, event Event) error {
+	recorder := getEventRecorder()
+	if recorder == nil {
+		return nil
+	}
+	return recorder(ctx, event)
+}
+
+var auditType = reflect.TypeOf(Audit{})
+var timeType = reflect.TypeOf(time.Time{})
+var entitiesPkg = reflect.TypeOf(entities.Int{}).PkgPath()
+
+// Diff returns the changes from before to after, which are values of the same struct type,
+// one for each exported field that differs. The Audit field is not compared.
+//
+// Values are compared as they are shown: entities types by their value, so an entities.Money
+// of "10.50" shows as 10.50, a Currency as its CCY and value, and a time.Time in RFC 3339.
+// The fields of other nested structs are compared one by one.
+//
+// A field tagged audit:"-" is not compared. A field tagged audit:"mask" is, but its values
+// are shown as MaskedValue.
+func Diff(before, after any) []FieldChange {
+	from := reflect.Indirect(reflect.ValueOf(before))
+	to := reflect.Indirect(reflect.ValueOf(after))
+	if from.Kind() != reflect.Struct || to.Kind() != reflect.Struct || from.Type() != to.Type() {
+		return nil
+	}
+	return diffStruct("", from, to, nil)
+}
+
+func diffStruct(prefix string, from, to reflect.Value, changes []FieldChange) []FieldChange {
+	for i := 0; i < to.NumField(); i++ {
+		field := to.Type().Field(i)
+		tag := field.Tag.Get("audit")
+		if !field.IsExported() || field.Type == auditType || tag == "-" {
+			continue
+		}
+		name := prefix + field.Name
+		if isNestedStruct(field.Type) && tag != "mask" {
+			changes = diffStruct(name+".", from.Field(i), to.Field(i), changes)
+			continue
+		}
+		oldValue, newValue := formatValue(from.Field(i)), formatValue(to.Field(i))
+		if oldValue == newValue {
+			continue
+		}
+		if tag == "mask" {
+			oldValue, newValue = mask(oldValue), mask(newValue)
+		}
+		changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+// isNestedStruct reports whether t is a struct whose fields are compared one by one, rather
+// than as a single value.
+func isNestedStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t.PkgPath() != entitiesPkg
+}
+
+// formatValue returns v as it is shown in a field change.
+func formatValue(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.UTC().Format(time.RFC3339Nano)
+	case entities.Currency:
+		return strings.TrimSpace(value.CCY + " " + value.Value.Value)
+	}
+	// the other entities types hold their value as a string, in Value
+	if v.Kind() == reflect.Struct && v.Type().PkgPath() == entitiesPkg {
+		if value := v.FieldByName("Value"); value.IsValid() && value.Kind() == reflect.String {
+			return value.String()
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	return MaskedValue
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+)
+
+type diffAddress struct {
+	Line1 string
+	City  string
+}
+
+type diffRecord struct {
+	ID       int
+	Name     string
+	Note     *string
+	Tags     []string
+	Amount   entities.Money
+	Price    entities.Currency
+	Count    entities.Int
+	Active   entities.Bool
+	When     time.Time
+	Address  diffAddress
+	Password string      `audit:"mask"`
+	Secret   diffAddress `audit:"mask"`
+	Scratch  string      `audit:"-"`
+	hidden   string
+	Audit    Audit
+}
+
+func TestDiff(t *testing.T) {
+	when := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+	note := "first"
+	before := diffRecord{
+		ID:       1,
+		Name:     "Ann",
+		Note:     &note,
+		Tags:     []string{"a"},
+		Amount:   entities.Money{Value: "10.50"},
+		Price:    entities.Currency{Value: entities.Float{Value: "5"}, CCY: "GBP"},
+		Count:    entities.Int{Value: "3"},
+		Active:   entities.Bool{Value: "true"},
+		When:     when,
+		Address:  diffAddress{Line1: "1 High St", City: "York"},
+		Password: "old",
+		Secret:   diffAddress{City: "Leeds"},
+		Scratch:  "x",
+		hidden:   "x",
+	}
+
+	same := before
+	same.When = when.In(time.FixedZone("CET", 3600)) // the same instant, in another zone
+	same.Scratch, same.hidden = "changed", "changed"
+	same.Audit.AuditSequence.Set(5)
+	if changes := Diff(before, same); len(changes) != 0 {
+		t.Errorf("Diff of equal records returned %+v", changes)
+	}
+
+	other := "second"
+	after := before
+	after.Name = "Bob"
+	after.Note = &other
+	after.Tags = []string{"a", "b"}
+	after.Amount = entities.Money{Value: "11.00"}
+	after.Price = entities.Currency{Value: entities.Float{Value: "5"}, CCY: "EUR"}
+	after.Count = entities.Int{Value: "4"}
+	after.Active = entities.Bool{Value: "false"}
+	after.When = time.Time{}
+	after.Address.City = "Hull"
+	after.Password = "new"
+	after.Secret.City = "Bath"
+	want := []FieldChange{
+		{Field: "Name", Old: "Ann", New: "Bob"},
+		{Field: "Note", Old: "first", New: "second"},
+		{Field: "Tags", Old: "[a]", New: "[a b]"},
+		{Field: "Amount", Old: "10.50", New: "11.00"},
+		{Field: "Price", Old: "GBP 5", New: "EUR 5"},
+		{Field: "Count", Old: "3", New: "4"},
+		{Field: "Active", Old: "true", New: "false"},
+		{Field: "When", Old: "2026-03-01T09:30:00Z", New: ""},
+		{Field: "Address.City", Old: "York", New: "Hull"},
+		{Field: "Password", Old: MaskedValue, New: MaskedValue},
+		{Field: "Secret", Old: MaskedValue, New: MaskedValue},
+	}
+	if got := Diff(&before, &after); !slices.Equal(got, want) {
+		t.Errorf("Diff returned\n%+v\nwant\n%+v", got, want)
+	}
+
+	// a masked value that is set or cleared shows as empty
+	cleared := before
+	cleared.Password = ""
+	cleared.Note = nil
+	want = []FieldChange{
+		{Field: "Note", Old: "first", New: ""},
+		{Field: "Password", Old: MaskedValue, New: ""},
+	}
+	if got := Diff(before, cleared); !slices.Equal(got, want) {
+		t.Errorf("Diff of cleared fields returned %+v, want %+v", got, want)
+	}
+
+	for _, test := range []struct {
+		name          string
+		before, after any
+	}{
+		{"different types", before, diffAddress{}},
+		{"not structs", "a", "b"},
+		{"nil", nil, &after},
+	} {
+		if got := Diff(test.before, test.after); got != nil {
+			t.Errorf("Diff of %v returned %+v, want nil", test.name, got)
+		}
+	}
+}
@@ -26,6 +26,17 @@ func getDBVersion() int {
 	return cfg.GetDatabase_Version()
 }
 
+// CurrentDBVersion returns the database schema version that records are stamped with when
+// they are written.
+func CurrentDBVersion() int {
+	return getDBVersion()
+}
+
+// IsDeleted reports whether the record has been soft-deleted, and not since restored.
+func (a *Audit) IsDeleted() bool {
+	return !a.DeletedAt.IsZero()
+}
+
 func (a *Action) popMessage() string {
 	message := a.description
 	a.description = ""
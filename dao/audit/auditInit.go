@@ -34,6 +34,9 @@ var (
 	LOGIN        Action
 	LOGOUT       Action
 	SYNC         Action
+	MIGRATE      Action
+	RESTORE      Action
+	REVERT       Action
 )
 
 func init() {
@@ -56,4 +59,7 @@ func init() {
 	LOGIN = Action{code: "LOGIN", description: "User Login", silent: false, short: "LOGIN"}
 	LOGOUT = Action{code: "LOGOUT", description: "User Logout", silent: false, short: "LOGOUT"}
 	SYNC = Action{code: "SYNC", description: "Data Synchronisation", silent: false, short: "SYNC"}
+	MIGRATE = Action{code: "MIGRATE", description: "Migrate Data", silent: false, short: "MIGRATE"}
+	RESTORE = Action{code: "RESTORE", description: "Restore Data", silent: false, short: "RESTORE"}
+	REVERT = Action{code: "REVERT", description: "Revert Data", silent: false, short: "REVERT"}
 }
@@ -20,6 +20,8 @@ type Audit struct {
 	AuditSequence    entities.Int
 	DBVersion        entities.Int
 	//Empty     time.Time // Convience Field - Used to avoid erros with dates.
+
+	last Event // the last action, held for Publish
 }
 
 type AuditUpdateInfo struct {
@@ -29,6 +31,28 @@ type AuditUpdateInfo struct {
 	UpdatedOn        string
 	UpdatedAtDisplay string
 	UpdateNotes      string
+	Changes          []FieldChange // the fields an update changed; see Diff
+}
+
+// FieldChange records the change of one field of a record. Fields of nested structs are
+// named by their path, such as "Address.Line1".
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Event is an audited action on a record, as sent to the EventRecorder by Publish.
+type Event struct {
+	Table    string
+	RecordID int
+	Key      string
+	Action   string
+	User     string
+	Host     string
+	At       time.Time
+	Notes    string // not truncated, unlike the UpdateNotes of the record's Audit
+	Changes  []FieldChange
 }
 
 // Action represents an audit action with its properties
@@ -37,4 +61,5 @@ type Action struct {
 	short       string
 	description string
 	silent      bool
+	changes     []FieldChange
 }
@@ -1,3 +1,22 @@
 package audit
 
 var messageLengthLimit = 50
+
+// maxUpdates is the most updates an Audit keeps; zero keeps them all. See SetMaxUpdates.
+var maxUpdates = 0
+
+// SetMaxUpdates caps the updates each record's Audit keeps at max. Once a record has max
+// updates, each new one rolls the oldest off; an audit event store, such as auditTrail,
+// keeps the full history. Zero, the default, keeps every update. Set it before records are
+// written.
+func SetMaxUpdates(max int) {
+	if max < 0 {
+		max = 0
+	}
+	maxUpdates = max
+}
+
+// MaxUpdates returns the most updates each record's Audit keeps; zero keeps them all.
+func MaxUpdates() int {
+	return maxUpdates
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// updateNotes returns the notes of the updates the Audit keeps, oldest first.
+func updateNotes(a *Audit) []string {
+	var notes []string
+	for _, update := range a.Updates {
+		notes = append(notes, update.UpdateNotes)
+	}
+	return notes
+}
+
+func TestMaxUpdates(t *testing.T) {
+	defer SetMaxUpdates(MaxUpdates())
+	SetMaxUpdates(-1)
+	if MaxUpdates() != 0 {
+		t.Errorf("SetMaxUpdates(-1) set %d, want 0", MaxUpdates())
+	}
+
+	var a Audit
+	for i := range 4 {
+		if err := a.Action(context.Background(), UPDATE.WithMessage(fmt.Sprintf("U%d", i))); err != nil {
+			t.Fatalf("Action %d: %v", i, err)
+		}
+	}
+	if got, want := updateNotes(&a), []string{"U0", "U1", "U2", "U3"}; !slices.Equal(got, want) {
+		t.Errorf("with no cap the Audit keeps %v, want %v", got, want)
+	}
+
+	SetMaxUpdates(2)
+	if err := a.Action(context.Background(), UPDATE.WithMessage("U4")); err != nil {
+		t.Fatalf("Action: %v", err)
+	}
+	if got, want := updateNotes(&a), []string{"U3", "U4"}; !slices.Equal(got, want) {
+		t.Errorf("with a cap of 2 the Audit keeps %v, want %v", got, want)
+	}
+	// silent actions are counted in the sequence, but not kept
+	if err := a.Action(context.Background(), SILENT); err != nil {
+		t.Fatalf("Action: %v", err)
+	}
+	if got, want := updateNotes(&a), []string{"U3", "U4"}; !slices.Equal(got, want) {
+		t.Errorf("after a silent action the Audit keeps %v, want %v", got, want)
+	}
+	if a.AuditSequence.Int() != 6 {
+		t.Errorf("the audit sequence is %d, want 6", a.AuditSequence.Int())
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var published []Event
+	SetEventRecorder(func(_ context.Context, event Event) error {
+		published = append(published, event)
+		return nil
+	})
+	t.Cleanup(func() { SetEventRecorder(nil) })
+
+	var a Audit
+	if err := a.Publish(context.Background(), "Order", 1, "K1"); err != nil || len(published) != 0 {
+		t.Errorf("Publish with no action returned %v and published %d events", err, len(published))
+	}
+	changes := []FieldChange{{Field: "Status", Old: "open", New: "closed"}}
+	if err := a.Action(context.Background(), UPDATE.WithMessage("closed").WithChanges(changes)); err != nil {
+		t.Fatalf("Action: %v", err)
+	}
+	if a.LastAction().Action != UPDATE.Code() {
+		t.Errorf("LastAction is %q, want %q", a.LastAction().Action, UPDATE.Code())
+	}
+
+	// TakeEvent holds the event back, and clears it from the Audit
+	event, ok := a.TakeEvent("Order", 1, "K1")
+	if !ok || event.Table != "Order" || event.RecordID != 1 || event.Key != "K1" || event.Notes != "closed" || !slices.Equal(event.Changes, changes) {
+		t.Fatalf("TakeEvent returned %+v, %t", event, ok)
+	}
+	if _, ok := a.TakeEvent("Order", 1, "K1"); ok || len(published) != 0 {
+		t.Errorf("the event was taken twice, or published by TakeEvent")
+	}
+	if err := PublishEvent(context.Background(), event); err != nil || len(published) != 1 {
+		t.Fatalf("PublishEvent returned %v and published %d events", err, len(published))
+	}
+
+	if err := a.Action(context.Background(), DELETE); err != nil {
+		t.Fatalf("Action: %v", err)
+	}
+	if err := a.Publish(context.Background(), "Order", 1, "K1"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := a.Publish(context.Background(), "Order", 1, "K1"); err != nil || len(published) != 2 || published[1].Action != DELETE.Code() {
+		t.Errorf("Publish returned %v and published %+v, want the delete once", err, published)
+	}
+
+	SetEventRecorder(nil)
+	if err := a.Action(context.Background(), UPDATE); err != nil {
+		t.Fatalf("Action: %v", err)
+	}
+	if err := a.Publish(context.Background(), "Order", 1, "K1"); err != nil || len(published) != 2 {
+		t.Errorf("Publish with no recorder returned %v and published %d events", err, len(published))
+	}
+}
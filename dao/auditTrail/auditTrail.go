@@ -0,0 +1,100 @@
+package auditTrail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+var name = "AuditTrail"
+
+var mu sync.RWMutex
+var activeDBConnection *database.DB
+
+// Initialise opens the audit event store, and records the events the audit package publishes
+// in it from then on.
+func Initialise(ctx context.Context) error {
+	_ = ctx
+	clock := timing.Start(name, "Initialise", NameSpace)
+
+	db, err := database.Open(AuditEvent{}, database.WithVerbose(false), database.WithCaching(false), database.WithNameSpace(NameSpace))
+	if err != nil {
+		logHandler.ErrorLogger.Printf("Error initialising %v: %v", name, err.Error())
+		clock.Stop(0)
+		return err
+	}
+	mu.Lock()
+	activeDBConnection = db
+	mu.Unlock()
+	audit.SetEventRecorder(record)
+
+	logHandler.InfoLogger.Printf("[%v] Recording audit events in [...%v.db]", name, NameSpace)
+	clock.Stop(1)
+	return nil
+}
+
+// IsInitialised reports whether the audit event store is open.
+func IsInitialised() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return activeDBConnection != nil
+}
+
+// Close stops recording audit events, and closes the store.
+func Close() error {
+	audit.SetEventRecorder(nil)
+	mu.Lock()
+	defer mu.Unlock()
+	if activeDBConnection == nil {
+		return nil
+	}
+	err := activeDBConnection.Close()
+	activeDBConnection = nil
+	return err
+}
+
+// GetDatabaseConnections returns a function that supplies the database connection of the
+// store, so it can be backed up with the DAO tables.
+func GetDatabaseConnections() func() ([]*database.DB, error) {
+	return func() ([]*database.DB, error) {
+		mu.RLock()
+		defer mu.RUnlock()
+		if activeDBConnection == nil {
+			return nil, nil
+		}
+		return []*database.DB{activeDBConnection}, nil
+	}
+}
+
+// record is the audit.EventRecorder that writes events to the store.
+func record(ctx context.Context, event audit.Event) error {
+	_ = ctx
+	db, err := connection()
+	if err != nil {
+		return err
+	}
+	return db.Create(&AuditEvent{
+		Table:    event.Table,
+		RecordID: event.RecordID,
+		Key:      event.Key,
+		Action:   event.Action,
+		User:     event.User,
+		Host:     event.Host,
+		At:       event.At,
+		Notes:    event.Notes,
+		Changes:  event.Changes,
+	})
+}
+
+func connection() (*database.DB, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if activeDBConnection == nil {
+		return nil, ErrNotInitialised
+	}
+	return activeDBConnection, nil
+}
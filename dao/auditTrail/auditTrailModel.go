@@ -0,0 +1,52 @@
+package auditTrail
+
+import (
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+)
+
+// NameSpace is the namespace, and so the database file, audit events are kept in.
+const NameSpace = "audit"
+
+// AuditEvent is an audited action on a record of a DAO table.
+type AuditEvent struct {
+	ID       int    `storm:"id,increment"`
+	Table    string `storm:"index"`
+	RecordID int    `storm:"index"`
+	Key      string `storm:"index"`
+	Action   string `storm:"index"`
+	User     string `storm:"index"`
+	Host     string
+	At       time.Time `storm:"index"`
+	Notes    string
+	Changes  []audit.FieldChange
+}
+
+type fieldNames struct {
+	ID       entities.Field
+	Table    entities.Field
+	RecordID entities.Field
+	Key      entities.Field
+	Action   entities.Field
+	User     entities.Field
+	Host     entities.Field
+	At       entities.Field
+	Notes    entities.Field
+	Changes  entities.Field
+}
+
+// Fields provides strongly-typed field names of AuditEvent.
+var Fields = fieldNames{
+	ID:       "ID",
+	Table:    "Table",
+	RecordID: "RecordID",
+	Key:      "Key",
+	Action:   "Action",
+	User:     "User",
+	Host:     "Host",
+	At:       "At",
+	Notes:    "Notes",
+	Changes:  "Changes",
+}
@@ -0,0 +1,105 @@
+package auditTrail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// ErrNotInitialised is returned when the audit event store has not been opened with Initialise.
+var ErrNotInitialised = errors.New("audit trail not initialised")
+
+// Filter selects audit events. Each field that is set must match; the zero Filter selects
+// every event.
+type Filter struct {
+	Table    string
+	RecordID int
+	Key      string
+	Action   string // an action code, such as audit.UPDATE.Code()
+	User     string
+	From     time.Time // events at or after From
+	To       time.Time // events before To
+	Limit    int       // the most events returned; zero returns all
+}
+
+func (f Filter) String() string {
+	var parts []string
+	add := func(field string, value any, set bool) {
+		if set {
+			parts = append(parts, fmt.Sprintf("%v=%v", field, value))
+		}
+	}
+	add("Table", f.Table, f.Table != "")
+	add("RecordID", f.RecordID, f.RecordID != 0)
+	add("Key", f.Key, f.Key != "")
+	add("Action", f.Action, f.Action != "")
+	add("User", f.User, f.User != "")
+	add("From", f.From.Format(time.RFC3339), !f.From.IsZero())
+	add("To", f.To.Format(time.RFC3339), !f.To.IsZero())
+	add("Limit", f.Limit, f.Limit > 0)
+	if len(parts) == 0 {
+		return "ALL"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Find returns the audit events selected by filter, oldest first.
+func Find(filter Filter) ([]AuditEvent, error) {
+	db, err := connection()
+	if err != nil {
+		return nil, err
+	}
+	logHandler.DatabaseLogger.Printf("[%v] Find %v", name, filter)
+	query := database.Query[AuditEvent](db).OrderBy(Fields.ID)
+	if filter.Table != "" {
+		query.Where(Fields.Table, database.Eq, filter.Table)
+	}
+	if filter.RecordID != 0 {
+		query.Where(Fields.RecordID, database.Eq, filter.RecordID)
+	}
+	if filter.Key != "" {
+		query.Where(Fields.Key, database.Eq, filter.Key)
+	}
+	if filter.Action != "" {
+		query.Where(Fields.Action, database.Eq, filter.Action)
+	}
+	if filter.User != "" {
+		query.Where(Fields.User, database.Eq, filter.User)
+	}
+	if !filter.From.IsZero() {
+		query.Where(Fields.At, database.Gte, filter.From)
+	}
+	if !filter.To.IsZero() {
+		query.Where(Fields.At, database.Lt, filter.To)
+	}
+	if filter.Limit > 0 {
+		query.Limit(filter.Limit)
+	}
+	return query.Find()
+}
+
+// ForRecord returns the audit events of the record id of table, oldest first. They are kept
+// after the record is deleted.
+func ForRecord(table string, id int) ([]AuditEvent, error) {
+	return Find(Filter{Table: table, RecordID: id})
+}
+
+// ByUser returns the audit events of the actions of user, oldest first.
+func ByUser(user string) ([]AuditEvent, error) {
+	return Find(Filter{User: user})
+}
+
+// ByAction returns the audit events of action, oldest first.
+func ByAction(action audit.Action) ([]AuditEvent, error) {
+	return Find(Filter{Action: action.Code()})
+}
+
+// Between returns the audit events at or after from, and before to, oldest first.
+func Between(from, to time.Time) ([]AuditEvent, error) {
+	return Find(Filter{From: from, To: to})
+}
@@ -0,0 +1,129 @@
+package auditTrail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-core/ioHelpers"
+)
+
+// eventKeys returns the keys and actions of events, as "key:action".
+func eventKeys(events []AuditEvent) []string {
+	var keys []string
+	for _, event := range events {
+		keys = append(keys, event.Key+":"+event.Action)
+	}
+	return keys
+}
+
+// openTestTrail opens an empty audit event store, and publishes events to it.
+func openTestTrail(t *testing.T, events ...audit.Event) {
+	t.Helper()
+	file := ioHelpers.GetDBFileName(NameSpace)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("creating database folder: %v", err)
+	}
+	os.Remove(file)
+	if err := Initialise(context.Background()); err != nil {
+		t.Fatalf("Initialise: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		os.Remove(file)
+	})
+	for _, event := range events {
+		if err := audit.PublishEvent(context.Background(), event); err != nil {
+			t.Fatalf("PublishEvent: %v", err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	at := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	openTestTrail(t,
+		audit.Event{Table: "Order", RecordID: 1, Key: "O1", Action: audit.CREATE.Code(), User: "ann", At: at},
+		audit.Event{Table: "Order", RecordID: 2, Key: "O2", Action: audit.CREATE.Code(), User: "bob", At: at.Add(time.Hour)},
+		audit.Event{Table: "Order", RecordID: 1, Key: "O1", Action: audit.UPDATE.Code(), User: "bob", At: at.Add(2 * time.Hour),
+			Changes: []audit.FieldChange{{Field: "Status", Old: "open", New: "closed"}}},
+		audit.Event{Table: "Customer", RecordID: 1, Key: "C1", Action: audit.CREATE.Code(), User: "ann", At: at.Add(3 * time.Hour)},
+		audit.Event{Table: "Order", RecordID: 1, Key: "O1", Action: audit.DELETE.Code(), User: "ann", At: at.Add(4 * time.Hour)},
+	)
+	if !IsInitialised() {
+		t.Error("IsInitialised returned false")
+	}
+
+	for _, test := range []struct {
+		name string
+		find func() ([]AuditEvent, error)
+		want []string
+	}{
+		{"all", func() ([]AuditEvent, error) { return Find(Filter{}) },
+			[]string{"O1:CREATE", "O2:CREATE", "O1:UPDATE", "C1:CREATE", "O1:DELETE"}},
+		{"ForRecord", func() ([]AuditEvent, error) { return ForRecord("Order", 1) },
+			[]string{"O1:CREATE", "O1:UPDATE", "O1:DELETE"}},
+		{"ByUser", func() ([]AuditEvent, error) { return ByUser("bob") },
+			[]string{"O2:CREATE", "O1:UPDATE"}},
+		{"ByAction", func() ([]AuditEvent, error) { return ByAction(audit.CREATE) },
+			[]string{"O1:CREATE", "O2:CREATE", "C1:CREATE"}},
+		{"Between includes from and excludes to", func() ([]AuditEvent, error) { return Between(at.Add(time.Hour), at.Add(3*time.Hour)) },
+			[]string{"O2:CREATE", "O1:UPDATE"}},
+		{"Key and User", func() ([]AuditEvent, error) { return Find(Filter{Key: "O1", User: "ann"}) },
+			[]string{"O1:CREATE", "O1:DELETE"}},
+		{"From", func() ([]AuditEvent, error) { return Find(Filter{Table: "Order", From: at.Add(2 * time.Hour)}) },
+			[]string{"O1:UPDATE", "O1:DELETE"}},
+		{"Limit", func() ([]AuditEvent, error) { return Find(Filter{Table: "Order", Limit: 2}) },
+			[]string{"O1:CREATE", "O2:CREATE"}},
+		{"no match", func() ([]AuditEvent, error) { return Find(Filter{Table: "Invoice"}) },
+			nil},
+	} {
+		events, err := test.find()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got := eventKeys(events); !slices.Equal(got, test.want) {
+			t.Errorf("%v returned %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	events, err := ByAction(audit.UPDATE)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("ByAction(UPDATE) returned %d events, %v", len(events), err)
+	}
+	if got := events[0]; !got.At.Equal(at.Add(2*time.Hour)) || len(got.Changes) != 1 || got.Changes[0].New != "closed" {
+		t.Errorf("the update event was stored as %+v", got)
+	}
+}
+
+func TestNotInitialised(t *testing.T) {
+	if err := Close(); err != nil {
+		t.Fatalf("Close of a closed store: %v", err)
+	}
+	if IsInitialised() {
+		t.Error("IsInitialised returned true")
+	}
+	if _, err := Find(Filter{}); !errors.Is(err, ErrNotInitialised) {
+		t.Errorf("Find returned %v, want %v", err, ErrNotInitialised)
+	}
+	if dbs, err := GetDatabaseConnections()(); err != nil || len(dbs) != 0 {
+		t.Errorf("GetDatabaseConnections returned %v, %v", dbs, err)
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	if got := (Filter{}).String(); got != "ALL" {
+		t.Errorf("the zero Filter is %q, want ALL", got)
+	}
+	from := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	if got, want := (Filter{Table: "Order", RecordID: 1, From: from, Limit: 5}).String(), "Table=Order RecordID=1 From=2026-03-01T09:00:00Z Limit=5"; got != want {
+		t.Errorf("Filter is %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,3 @@
+// Package auditTrail keeps the audit events of DAO tables in a table of their own, in its own
+// namespace, so the history of a record can be queried, and outlives the record.
+package auditTrail
@@ -1,8 +1,17 @@
 // Package cache provides in-memory caching functionalities for data access objects (DAOs),
 // including cache management, record storage, retrieval, and synchronization with the underlying database.
+//
+// The cache is safe for concurrent use. Each table has its own store guarded by a
+// sync.RWMutex, so readers of a table run in parallel and writers to different tables do
+// not contend. Hydrator and synchroniser callbacks are always invoked without any cache
+// lock held, so they may call back into the cache.
+//
+// The records of a table are held by a Backend: in memory by default, or in a warm cache
+// file (see FileBackend) that survives restarts.
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -22,65 +31,81 @@ import (
 // Returns:
 //   - bool: True if the cache is enabled for the given data type; otherwise, false.
 func IsEnabled(data any) bool {
-	table := entities.GetStructType(data)
-	enabled, exists := Cache.tablesActive[table]
+	store, exists := Cache.lookup(entities.GetStructType(data))
 	if !exists {
 		return false
 	}
-	return enabled
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.active
 }
 
 func Disable(data any) error {
-	table := entities.GetStructType(data)
-	Cache.tablesActive[table] = false
-	Cache.cache[table] = make(entrys)
-	Cache.indices[table] = []entities.Field{}
-	Cache.key[table] = ""
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.active = false
+	if err := store.clearEntries(); err != nil {
+		logHandler.WarningLogger.Printf("Error clearing Cache Backend [%v] for Table [%v]: %v", store.backend.Name(), entities.GetStructType(data), err)
+	}
+	store.indices = []entities.Field{}
+	store.indexes = nil
+	store.key = ""
 	return nil
 }
 
 func IsDisabled(data any) bool {
-	table := entities.GetStructType(data)
-	enabled, exists := Cache.tablesActive[table]
-	if !exists {
-		return true
-	}
-	return !enabled
+	return !IsEnabled(data)
 }
 
 func turnOffForTable(data entities.Table) error {
-	Cache.tablesActive[data] = false
-	return nil
+	return setActive(data, false)
 }
 
 func turnOnForTable(data entities.Table) error {
-	Cache.tablesActive[data] = true
+	return setActive(data, true)
+}
+
+func setActive(table entities.Table, active bool) error {
+	store := Cache.store(table)
+	store.mu.Lock()
+	store.active = active
+	store.mu.Unlock()
 	return nil
 }
 
 func Activate(data any) error {
 	table := entities.GetStructType(data)
 	logHandler.InfoLogger.Printf("Activating Cache for Table [%v]", table)
-	Cache.tablesActive[table] = true
-	Cache.cache[table] = make(entrys)
-	Cache.indices[table] = []entities.Field{}
-	Cache.key[table] = ""
-	Cache.count[table] = 0
-	Cache.expiry[table] = defaultCacheExpiry
-	Cache.synchroniser[table] = nil
-	Cache.hydrator[table] = nil
-	//	godump.Dump(Cache)
+	store := Cache.store(table)
+	store.mu.Lock()
+	store.active = true
+	if err := store.clearEntries(); err != nil {
+		logHandler.WarningLogger.Printf("Error clearing Cache Backend [%v] for Table [%v]: %v", store.backend.Name(), table, err)
+	}
+	if err := store.backend.Close(); err != nil {
+		logHandler.WarningLogger.Printf("Error closing Cache Backend [%v] for Table [%v]: %v", store.backend.Name(), table, err)
+	}
+	store.backend = newMemoryBackend()
+	store.indices = []entities.Field{}
+	store.indexes = nil
+	store.key = ""
+	store.expiry = defaultCacheExpiry
+	store.limits = capacity{}
+	store.ownLimits = false
+	Cache.mu.RLock()
+	store.setPolicy(Cache.limits.policy)
+	Cache.mu.RUnlock()
+	store.synchroniser = nil
+	store.hydrator = nil
+	store.source = ""
+	store.mu.Unlock()
 	logHandler.InfoLogger.Printf("Cache for Table [%v] Activated", table)
 	return nil
 }
 
 func IsInitialised(data any) bool {
-	table := entities.GetStructType(data)
-	enabled, exists := Cache.tablesActive[table]
-	if !exists {
-		return false
-	}
-	return enabled
+	return IsEnabled(data)
 }
 
 func DeInitialise(data any) error {
@@ -97,7 +122,10 @@ func RegisterExpiry(data any, duration time.Duration) error {
 		return ce.ErrCacheNotEnabledWrapper("set expiry", "", string(entities.GetStructType(data)))
 	}
 
-	Cache.expiry[entities.GetStructType(data)] = duration
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.Lock()
+	store.expiry = duration
+	store.mu.Unlock()
 	logHandler.InfoLogger.Printf("Cache Expiry for Table [%v] set to %v", entities.GetStructType(data), duration)
 	return nil
 }
@@ -107,7 +135,10 @@ func GetExpiry(data any) (time.Duration, error) {
 		return 0, ce.ErrCacheNotEnabledWrapper("get expiry", "", string(entities.GetStructType(data)))
 	}
 
-	return Cache.expiry[entities.GetStructType(data)], nil
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.expiry, nil
 }
 
 func RegisterKey(data any, key entities.Field) error {
@@ -116,29 +147,30 @@ func RegisterKey(data any, key entities.Field) error {
 		return ce.ErrCacheNotEnabledWrapper("add key", key.String(), string(entities.GetStructType(data)))
 	}
 
-	Cache.key[entities.GetStructType(data)] = key
-	//	godump.Dump(Cache)
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.Lock()
+	store.key = key
+	store.mu.Unlock()
 	logHandler.InfoLogger.Printf("Cache Key [%v] added for Table [%v]", key.String(), entities.GetStructType(data))
 	return nil
 }
 
+// RegisterIndex registers a (non-unique) index on key for the table of data.
+//
+// The cache keeps a value-to-keys map for each registered index, so GetWhere, GetAllWhere
+// and FindByIndex on the field are O(1) rather than a scan of the table.
 func RegisterIndex(data any, key entities.Field) error {
 
 	if !IsEnabled(data) {
 		return ce.ErrCacheNotEnabledWrapper("add index", key.String(), string(entities.GetStructType(data)))
 	}
 
-	// Find the index in the list of indices
-	indesList := Cache.indices[entities.GetStructType(data)]
-	for _, existingIndex := range indesList {
-		if existingIndex.String() == key.String() {
-			logHandler.WarningLogger.Printf("index %v already exists for %v", key.String(), entities.GetStructType(data))
-			return nil
-		}
+	err := registerIndex(data, key, false)
+	if errors.Is(err, errIndexExists) {
+		logHandler.WarningLogger.Printf("index %v already exists for %v", key.String(), entities.GetStructType(data))
+		return nil
 	}
-	Cache.indices[entities.GetStructType(data)] = append(Cache.indices[entities.GetStructType(data)], key)
-
-	return nil
+	return err
 }
 
 func RemoveIndex(data any, key entities.Field) error {
@@ -146,12 +178,15 @@ func RemoveIndex(data any, key entities.Field) error {
 		return ce.ErrCacheNotEnabledWrapper("remove index", key.String(), string(entities.GetStructType(data)))
 	}
 
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.Lock()
+	defer store.mu.Unlock()
 	// Find the index in the list of indices
-	indesList := Cache.indices[entities.GetStructType(data)]
-	for i, existingIndex := range indesList {
+	for i, existingIndex := range store.indices {
 		if existingIndex.String() == key.String() {
 			// Remove the index from the slice
-			Cache.indices[entities.GetStructType(data)] = append(indesList[:i], indesList[i+1:]...)
+			store.indices = append(store.indices[:i:i], store.indices[i+1:]...)
+			delete(store.indexes, key)
 			return nil
 		}
 	}
@@ -166,57 +201,62 @@ func AddEntry(data any) error {
 		return ce.ErrCacheNilDataWrapper("add")
 	}
 	table := entities.GetStructType(data)
-	if !isKeyRegistered(table) {
+	store, exists := Cache.lookup(table)
+	if !exists || !isKeyRegistered(table) {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
 		return ce.ErrCacheNoKeyDefinedWrapper("add", table.String())
 	}
-	//logHandler.InfoLogger.Printf("Adding Cache Entry for Table [%v]", table)
-	keyField, exists := Cache.key[table]
-	if !exists || keyField.String() == "" {
+
+	store.mu.Lock()
+	keyField := store.key
+	if keyField.String() == "" {
+		store.mu.Unlock()
 		return ce.ErrCacheNoKeyDefinedWrapper("add", table.String())
 	}
 
 	logHandler.InfoLogger.Printf("Adding Cache Entry for Table [%v] with Key Field [%v]", table, keyField.String())
 	// Lets get the key value and build the cache entry
-	// Get the key value, by using reflection to get the field value
-	rv := reflect.ValueOf(data)
-	if rv.Kind() == reflect.Ptr {
-		if rv.IsNil() {
-			logHandler.WarningLogger.Println("Cannot add <nil> pointer data to cache")
-			return ce.ErrCacheNilDataWrapper("add")
-		}
-		rv = rv.Elem()
+	key, err := keyOf(data, "add", table, keyField)
+	if err != nil {
+		store.mu.Unlock()
+		return err
 	}
-	if rv.Kind() != reflect.Struct {
-		return fmt.Errorf("cannot add non-struct cache entry for table %v: got %T", table.String(), data)
+	logHandler.InfoLogger.Printf("Adding Cache Entry for Table [%v] with Key [%+v]", table, key)
+	// Add the record to the cache
+	// check if the table cache exists
+	if store.entries == nil {
+		store.entries = make(entrys)
+		store.active = true
 	}
-	fv := rv.FieldByName(keyField.String())
-	if !fv.IsValid() {
-		return fmt.Errorf("cannot add cache entry for table %v: key field %q not found on %T", table.String(), keyField.String(), data)
+	if err := store.checkUnique(table, key, data); err != nil {
+		store.mu.Unlock()
+		logHandler.WarningLogger.Printf("Cache Entry for Table [%v] with Key [%v] rejected: %v", table, key, err)
+		return err
 	}
-	key := fv.Interface()
-	logHandler.InfoLogger.Printf("Adding Cache Entry for Table [%v] with Key [%+v]", table, key)
-	// Get Cache Expiry
-	expiryDuration, err := GetExpiry(data)
+	// The cache keeps its own copy, so later changes by the caller are not seen by other readers.
+	record := dataCache{cacheTimestamp: time.Now().Add(store.expiry)}
+	dataRecord := copyRecord(data)
+	replaced, err := store.insertEntry(key, record, dataRecord)
 	if err != nil {
-		expiryDuration = defaultCacheExpiry // Default to 30 years
+		store.mu.Unlock()
+		logHandler.ErrorLogger.Printf("Cache Entry for Table [%v] with Key [%v] not added to Backend [%v]: %v", table, key, store.backend.Name(), err)
+		return err
 	}
-	// Add the record to the cache
-	// check if the table cache exists
-	_, exists = Cache.cache[table]
-	if !exists {
-		Cache.cache[table] = make(entrys)
-		Cache.tablesActive[table] = true
-		record := dataCache{cacheTimestamp: time.Now().Add(expiryDuration), dataRecord: data}
-		Cache.cache[table][key] = record
-		Cache.count[table] = 1
-		Cache.updated = time.Now()
-		return nil
+	evicted := store.evictOverflow(table, key)
+	var added []Event
+	if hasSubscribers() {
+		eventType := Added
+		if replaced {
+			eventType = Updated
+		}
+		added = append(added, newEvent(eventType, table, key, dataRecord, false))
 	}
-	record := dataCache{cacheTimestamp: time.Now().Add(expiryDuration), dataRecord: data}
-	Cache.cache[table][key] = record
-	Cache.count[table]++
-	Cache.updated = time.Now()
+	store.mu.Unlock()
+
+	Cache.touch()
+	publish(added...)
+	publish(evicted...)
+	Cache.enforceLimits(table, key)
 	logHandler.CacheLogger.Printf("Cache Entry for Table [%v] added with Key [%v], expiry [%v] %v", table, key, record.cacheTimestamp.Format(time.RFC3339Nano), humanize.Time(record.cacheTimestamp))
 	return nil
 }
@@ -237,36 +277,34 @@ func RemoveEntry(data any) error {
 	// Fine and remove the record from the cache
 	table := entities.GetStructType(data)
 
-	if !isKeyRegistered(table) {
+	store, exists := Cache.lookup(table)
+	if !exists || !isKeyRegistered(table) {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
 		return ce.ErrCacheNoKeyDefinedWrapper("remove", table.String())
 	}
 
-	keyField, exists := Cache.key[table]
-	if !exists || keyField.String() == "" {
+	store.mu.Lock()
+	keyField := store.key
+	if keyField.String() == "" {
+		store.mu.Unlock()
 		return ce.ErrCacheNoKeyDefinedWrapper("remove", table.String())
 	}
 
-	// Get the key value, by using reflection to get the field value
-	rv := reflect.ValueOf(data)
-	if rv.Kind() == reflect.Ptr {
-		if rv.IsNil() {
-			return ce.ErrCacheNilDataWrapper("remove")
-		}
-		rv = rv.Elem()
+	key, err := keyOf(data, "remove", table, keyField)
+	if err != nil {
+		store.mu.Unlock()
+		return err
 	}
-	if rv.Kind() != reflect.Struct {
-		return fmt.Errorf("cannot remove non-struct cache entry for table %v: got %T", table.String(), data)
+
+	removed, ok, err := store.removeEntry(key)
+	store.mu.Unlock()
+	if err != nil {
+		return err
 	}
-	fv := rv.FieldByName(keyField.String())
-	if !fv.IsValid() {
-		return fmt.Errorf("cannot remove cache entry for table %v: key field %q not found on %T", table.String(), keyField.String(), data)
+	Cache.touch()
+	if ok {
+		publish(newEvent(Removed, table, key, removed, true))
 	}
-	key := fv.Interface()
-
-	delete(Cache.cache[table], key)
-	Cache.count[table]--
-	Cache.updated = time.Now()
 
 	return nil
 }
@@ -274,7 +312,7 @@ func RemoveEntry(data any) error {
 func RemoveByKey(data any, key any) error {
 	// Find and remove the record from the cache
 	table := entities.GetStructType(data)
-	_, exists := Cache.key[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return ce.ErrCacheNoKeyDefinedWrapper("remove", table.String())
 	}
@@ -284,9 +322,16 @@ func RemoveByKey(data any, key any) error {
 		return ce.ErrCacheNoKeyDefinedWrapper("remove", table.String())
 	}
 
-	delete(Cache.cache[table], key)
-	Cache.count[table]--
-	Cache.updated = time.Now()
+	store.mu.Lock()
+	removed, ok, err := store.removeEntry(key)
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	Cache.touch()
+	if ok {
+		publish(newEvent(Removed, table, key, removed, true))
+	}
 	return nil
 }
 
@@ -298,25 +343,37 @@ func Get[T any](data T, key any) (T, error) {
 	// Find and return the record from the cache
 	var zero T
 	table := entities.GetStructType(data)
-	inMemoryCacheEntry, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return zero, ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	if !isKeyRegistered(table) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.entries == nil {
+		return zero, ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+
+	if store.key.String() == "" {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
 		return zero, ce.ErrCacheNoKeyDefinedWrapper("get", table.String())
 	}
 
-	record, exists := inMemoryCacheEntry[key]
+	record, exists := store.entries[key]
+	store.metrics.recordRead(exists)
 	if !exists {
 		return zero, ce.ErrCacheRecordNotFoundWrapper(table.String(), key)
 	}
+	entry, _, err := store.backend.Get(key)
+	if err != nil {
+		return zero, err
+	}
+	store.touchEntry(record)
 
 	targetType := reflect.TypeOf((*T)(nil)).Elem()
-	converted, ok := coerceCacheValue[T](record.dataRecord, targetType)
+	converted, ok := coerceCacheValue[T](entry.Record, targetType)
 	if !ok {
-		return zero, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.dataRecord, targetType)
+		return zero, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), entry.Record, targetType)
 	}
 
 	return converted, nil
@@ -325,23 +382,35 @@ func Get[T any](data T, key any) (T, error) {
 func GetAll[T any](data T) ([]T, error) {
 	// Get all records from the cache
 	table := entities.GetStructType(data)
-	inMemoryCacheEntry, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return nil, ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	if !isKeyRegistered(table) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.entries == nil {
+		return nil, ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+
+	if store.key.String() == "" {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
 		return nil, ce.ErrCacheNoKeyDefinedWrapper("getall", table.String())
 	}
 
+	store.metrics.recordRead(len(store.entries) > 0)
+
+	records, err := store.backend.GetAll()
+	if err != nil {
+		return nil, err
+	}
 	// Range through the cache and build a strongly-typed return slice.
 	targetType := reflect.TypeFor[T]()
-	rtn := make([]T, 0, len(inMemoryCacheEntry))
-	for _, record := range inMemoryCacheEntry {
-		converted, ok := coerceCacheValue[T](record.dataRecord, targetType)
+	rtn := make([]T, 0, len(records))
+	for _, record := range records {
+		converted, ok := coerceCacheValue[T](record.Record, targetType)
 		if !ok {
-			return nil, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.dataRecord, targetType)
+			return nil, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.Record, targetType)
 		}
 		rtn = append(rtn, converted)
 	}
@@ -351,109 +420,146 @@ func GetAll[T any](data T) ([]T, error) {
 
 func GetWhere[T any](data T, index entities.Field, value any) (T, error) {
 	// Get records from the cache by index
-	table := entities.GetStructType(data)
-	inMemoryCacheEntry, exists := Cache.cache[table]
 	zero := *new(T)
-
-	if !exists {
-		return zero, ce.ErrCacheDoesNotExistWrapper(table.String())
-	}
-	if !isKeyRegistered(table) {
-		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
-		return zero, ce.ErrCacheNoKeyDefinedWrapper("getwhere", table.String())
+	table := entities.GetStructType(data)
+	matches, err := findWhere(data, "getwhere", index, value)
+	if err != nil {
+		return zero, err
 	}
-	targetType := reflect.TypeOf((*T)(nil)).Elem()
-	rtn := *new(T)
-	matchCount := 0
-	for _, record := range inMemoryCacheEntry {
-		rv := reflect.ValueOf(record.dataRecord)
-		if !rv.IsValid() {
-			continue
-		}
-		if rv.Kind() == reflect.Ptr {
-			if rv.IsNil() {
-				continue
-			}
-			rv = rv.Elem()
-		}
-		if rv.Kind() != reflect.Struct {
-			continue
-		}
-		fv := rv.FieldByName(index.String())
-		if !fv.IsValid() {
-			continue
-		}
-		if fv.Interface() != value {
-			continue
-		}
 
-		matchCount++
-		converted, ok := coerceCacheValue[T](record.dataRecord, targetType)
-		if !ok {
-			return zero, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.dataRecord, targetType)
-		}
-		rtn = converted
-		if matchCount > 1 {
-			logHandler.WarningLogger.Printf("GetWhere: multiple cache entries found for table %v where %v=%v (count=%d); refusing ambiguous result", table.String(), index.String(), value, matchCount)
-			return zero, ce.ErrCacheMultipleRecordsFoundWrapper(table.String(), index.String(), value, matchCount)
-		}
+	if len(matches) > 1 {
+		logHandler.WarningLogger.Printf("GetWhere: multiple cache entries found for table %v where %v=%v (count=%d); refusing ambiguous result", table.String(), index.String(), value, len(matches))
+		return zero, ce.ErrCacheMultipleRecordsFoundWrapper(table.String(), index.String(), value, len(matches))
 	}
-
-	if matchCount == 0 {
+	if len(matches) == 0 {
 		return zero, ce.ErrCacheRecordNotFoundWrapper(table.String(), value)
 	}
-	return rtn, nil
+	return matches[0], nil
 }
 
 func GetAllWhere[T any](data T, index entities.Field, value any) ([]T, error) {
 	// Get records from the cache by index
+	return findWhere(data, "getwhere", index, value)
+}
+
+// findWhere returns every cached record of the table whose field equals value.
+func findWhere[T any](data T, operation string, index entities.Field, value any) ([]T, error) {
 	table := entities.GetStructType(data)
-	inMemoryCacheEntry, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return nil, ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
-	if !isKeyRegistered(table) {
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.entries == nil {
+		return nil, ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+	if store.key.String() == "" {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
-		return nil, ce.ErrCacheNoKeyDefinedWrapper("getwhere", table.String())
+		return nil, ce.ErrCacheNoKeyDefinedWrapper(operation, table.String())
 	}
 	targetType := reflect.TypeOf((*T)(nil)).Elem()
 	rtn := make([]T, 0)
-	for _, record := range inMemoryCacheEntry {
-		rv := reflect.ValueOf(record.dataRecord)
-		if !rv.IsValid() {
-			continue
-		}
-		if rv.Kind() == reflect.Ptr {
-			if rv.IsNil() {
-				continue
+
+	// Use the secondary index when there is one
+	if keys, indexed := store.lookupIndex(index, value); indexed {
+		store.metrics.recordRead(len(keys) > 0)
+		for _, key := range keys {
+			entry, _, err := store.backend.Get(key)
+			if err != nil {
+				return nil, err
 			}
-			rv = rv.Elem()
-		}
-		if rv.Kind() != reflect.Struct {
-			continue
-		}
-		fv := rv.FieldByName(index.String())
-		if !fv.IsValid() {
-			continue
-		}
-		if fv.Interface() != value {
-			continue
+			store.touchEntry(store.entries[key])
+			converted, ok := coerceCacheValue[T](entry.Record, targetType)
+			if !ok {
+				return nil, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), entry.Record, targetType)
+			}
+			rtn = append(rtn, converted)
 		}
-		converted, ok := coerceCacheValue[T](record.dataRecord, targetType)
+		return rtn, nil
+	}
+
+	records, err := store.backend.GetWhere(index, value)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		store.touchEntry(store.entries[record.Key])
+		converted, ok := coerceCacheValue[T](record.Record, targetType)
 		if !ok {
-			return nil, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.dataRecord, targetType)
+			return nil, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.Record, targetType)
 		}
 		rtn = append(rtn, converted)
 	}
+	store.metrics.recordRead(len(rtn) > 0)
 
 	return rtn, nil
 }
 
+// fieldOf returns the named field of a cached struct (or pointer to struct).
+func fieldOf(data any, field entities.Field) (reflect.Value, bool) {
+	rv := reflect.ValueOf(data)
+	if !rv.IsValid() {
+		return reflect.Value{}, false
+	}
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	fv := rv.FieldByName(field.String())
+	if !fv.IsValid() {
+		return reflect.Value{}, false
+	}
+	return fv, true
+}
+
+// keyOf returns the value of the key field of data, for the named operation.
+func keyOf(data any, operation string, table entities.Table, keyField entities.Field) (any, error) {
+	// Get the key value, by using reflection to get the field value
+	rv := reflect.ValueOf(data)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			logHandler.WarningLogger.Printf("Cannot %v <nil> pointer data to cache", operation)
+			return nil, ce.ErrCacheNilDataWrapper(operation)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot %v non-struct cache entry for table %v: got %T", operation, table.String(), data)
+	}
+	fv := rv.FieldByName(keyField.String())
+	if !fv.IsValid() {
+		return nil, fmt.Errorf("cannot %v cache entry for table %v: key field %q not found on %T", operation, table.String(), keyField.String(), data)
+	}
+	return fv.Interface(), nil
+}
+
+// copyRecord returns a shallow copy of a struct record held by pointer.
+//
+// Records are copied on the way into and out of the cache, so callers never share a
+// struct with the cache or with each other.
+func copyRecord(data any) any {
+	rv := reflect.ValueOf(data)
+	if !rv.IsValid() || rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return data
+	}
+	cp := reflect.New(rv.Elem().Type())
+	cp.Elem().Set(rv.Elem())
+	return cp.Interface()
+}
+
 func coerceCacheValue[T any](value any, targetType reflect.Type) (T, bool) {
 	var zero T
 	if value == nil {
 		return zero, false
 	}
+	value = copyRecord(value)
 	if v, ok := value.(T); ok {
 		return v, true
 	}
@@ -503,12 +609,17 @@ func coerceCacheValue[T any](value any, targetType reflect.Type) (T, bool) {
 func Count(data any) (int64, error) {
 	// Get count of records from the cache
 	table := entities.GetStructType(data)
-	_, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return 0, ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	return Cache.count[table], nil
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.entries == nil {
+		return 0, ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+	return int64(len(store.entries)), nil
 }
 
 func FindByKey[T any](data T, key any) (T, error) {
@@ -518,55 +629,15 @@ func FindByKey[T any](data T, key any) (T, error) {
 
 func FindByIndex[T any](data T, index entities.Field, value any) ([]T, error) {
 	// Find and return the record(s) from the cache by index
-	table := entities.GetStructType(data)
-	inMemoryCacheEntry, exists := Cache.cache[table]
-	if !exists {
-		return nil, ce.ErrCacheDoesNotExistWrapper(table.String())
-	}
-
-	if !isKeyRegistered(table) {
-		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
-		return nil, ce.ErrCacheNoKeyDefinedWrapper("findbyindex", table.String())
-	}
-
-	targetType := reflect.TypeOf((*T)(nil)).Elem()
-	rtn := make([]T, 0)
-	for _, record := range inMemoryCacheEntry {
-		rv := reflect.ValueOf(record.dataRecord)
-		if !rv.IsValid() {
-			continue
-		}
-		if rv.Kind() == reflect.Ptr {
-			if rv.IsNil() {
-				continue
-			}
-			rv = rv.Elem()
-		}
-		if rv.Kind() != reflect.Struct {
-			continue
-		}
-		fv := rv.FieldByName(index.String())
-		if !fv.IsValid() {
-			continue
-		}
-		if fv.Interface() != value {
-			continue
-		}
-
-		converted, ok := coerceCacheValue[T](record.dataRecord, targetType)
-		if !ok {
-			return nil, fmt.Errorf("cache contains unexpected type for table %v: got %T, want %v", table.String(), record.dataRecord, targetType)
-		}
-		rtn = append(rtn, converted)
-	}
-
-	return rtn, nil
+	return findWhere(data, "findbyindex", index, value)
 }
 
 func RegisterSynchroniser(data any, synchroniser func(any) error) {
-	Cache.synchroniser = make(map[entities.Table]func(any) error)
 	table := entities.GetStructType(data)
-	Cache.synchroniser[table] = synchroniser
+	store := Cache.store(table)
+	store.mu.Lock()
+	store.synchroniser = synchroniser
+	store.mu.Unlock()
 	// Get the name of the function passed in
 	funcname := runtime.FuncForPC(reflect.ValueOf(synchroniser).Pointer()).Name()
 	logHandler.EventLogger.Printf("[REGISTER] Registered Function %v as Synchroniser for Table [%v]", funcname, table)
@@ -575,15 +646,15 @@ func RegisterSynchroniser(data any, synchroniser func(any) error) {
 }
 
 func RegisterHydrator(data any, hydrator func() ([]any, error)) {
-	if Cache.hydrator == nil {
-		Cache.hydrator = make(map[entities.Table]func() ([]any, error))
-	}
 	if data == nil {
 		logHandler.WarningLogger.Println("Cannot register hydrator for <nil> data")
 		return
 	}
 	table := entities.GetStructType(data)
-	Cache.hydrator[table] = hydrator
+	store := Cache.store(table)
+	store.mu.Lock()
+	store.hydrator = hydrator
+	store.mu.Unlock()
 	// Get the name of the function passed in
 	funcname := runtime.FuncForPC(reflect.ValueOf(hydrator).Pointer()).Name()
 	logHandler.EventLogger.Printf("[REGISTER] Registered Function %v as Hydrator for Table [%v]", funcname, table)
@@ -604,11 +675,10 @@ func Hydrate(table entities.Table) error {
 }
 
 func HydrateAll() error {
-	if Cache.hydrator == nil {
-		return nil
-	}
-
-	for table, hydratorFunc := range Cache.hydrator {
+	for table, store := range Cache.snapshot() {
+		store.mu.RLock()
+		hydratorFunc := store.hydrator
+		store.mu.RUnlock()
 		if hydratorFunc == nil {
 			continue
 		}
@@ -621,31 +691,59 @@ func HydrateAll() error {
 }
 
 func hydrateCacheByTable(table entities.Table) error {
-	inMemoryCacheEntry, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	if !isKeyRegistered(table) {
+	store.mu.RLock()
+	entriesExist := store.entries != nil
+	keyField := store.key
+	hydratorFunc := store.hydrator
+	count := len(store.entries)
+	store.mu.RUnlock()
+
+	if !entriesExist {
+		return ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+
+	if keyField.String() == "" {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
 		return ce.ErrCacheNoKeyDefinedWrapper("hydrate", table.String())
 	}
 
-	hydratorFunc, exists := Cache.hydrator[table]
-	if !exists || hydratorFunc == nil {
+	// a table loaded from a warm backend is already hydrated
+	store.mu.Lock()
+	warm := store.warm
+	store.warm = false
+	backendName := store.backend.Name()
+	store.mu.Unlock()
+	if warm {
+		store.metrics.hydrations.Add(1)
+		store.metrics.lastHydrationNano.Store(0)
+		publish(newEvent(Hydrated, table, nil, nil, true))
+		logHandler.InfoLogger.Printf("Cache for Table [%v] hydrated from Backend [%v] (%d)", table, backendName, count)
+		return nil
+	}
+
+	if hydratorFunc == nil {
 		return ce.ErrCacheNoHydratorDefinedWrapper(table.String())
 	}
 
-	count := len(inMemoryCacheEntry)
 	countIndex := 0
-	// turn off the cache while we hydrate
+	// hydration reloads every record, so evictions before now no longer leave gaps
+	store.mu.Lock()
+	store.evicted = false
+	store.mu.Unlock()
+	started := time.Now()
+	// turn off the cache while we hydrate, so the hydrator reads from the database
 	turnOffForTable(table)
 	records, err := hydratorFunc()
+	// turn the cache back on after hydration
+	turnOnForTable(table)
 	if err != nil {
 		return err
 	}
-	// turn the cache back on after hydration
-	turnOnForTable(table)
 	for _, record := range records {
 		err := AddEntry(record)
 		if err != nil {
@@ -653,93 +751,121 @@ func hydrateCacheByTable(table entities.Table) error {
 		}
 		countIndex++
 	}
+	elapsed := time.Since(started)
+	store.metrics.hydrations.Add(1)
+	store.metrics.hydrationNanos.Add(int64(elapsed))
+	store.metrics.lastHydrationNano.Store(int64(elapsed))
+	publish(newEvent(Hydrated, table, nil, nil, true))
 
 	logHandler.InfoLogger.Printf("Cache for Table [%v] hydrated (%d/%d)", table, countIndex, count)
 	return nil
 }
 
 func SynchroniseForType(data any) error {
-	table := entities.GetStructType(data)
+	_, err := synchroniseTable(entities.GetStructType(data))
+	return err
+}
+
+func Synchronise(table entities.Table) error {
+	_, err := synchroniseTable(table)
+	return err
+}
+
+// synchroniseTable passes every cached record of table to its synchroniser, and returns the
+// number synchronised.
+func synchroniseTable(table entities.Table) (int, error) {
 	//	logHandler.InfoLogger.Printf("Flushing Cache for Table [%v]", table)
-	inMemoryCacheEntry, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
-		return ce.ErrCacheDoesNotExistWrapper(table.String())
+		return 0, ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	if !isKeyRegistered(table) {
+	// Take a copy of the records, so the synchroniser runs without holding the table lock
+	store.mu.RLock()
+	if store.entries == nil {
+		store.mu.RUnlock()
+		return 0, ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+	if store.key.String() == "" {
+		store.mu.RUnlock()
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
-		return ce.ErrCacheNoKeyDefinedWrapper("synchronise", table.String())
+		return 0, ce.ErrCacheNoKeyDefinedWrapper("synchronise", table.String())
+	}
+	synchroniserFunc := store.synchroniser
+	entries, err := store.backend.GetAll()
+	if err != nil {
+		store.mu.RUnlock()
+		return 0, err
 	}
+	records := make([]any, 0, len(entries))
+	for _, record := range entries {
+		records = append(records, copyRecord(record.Record))
+	}
+	store.mu.RUnlock()
 
-	synchroniserFunc, exists := Cache.synchroniser[table]
-	if !exists {
-		return ce.ErrCacheNoSynchroniserDefinedWrapper(table.String())
+	if synchroniserFunc == nil {
+		return 0, ce.ErrCacheNoSynchroniserDefinedWrapper(table.String())
 	}
-	count := len(inMemoryCacheEntry)
+	count := len(records)
 	countIndex := 0
-	for _, record := range inMemoryCacheEntry {
-		err := synchroniserFunc(record.dataRecord)
+	for _, record := range records {
+		err := synchroniserFunc(record)
 		if err != nil {
-			return err
+			store.metrics.syncFailures.Add(1)
+			return countIndex, err
 		}
 		countIndex++
 	}
 
 	logHandler.InfoLogger.Printf("Cache for Table [%v] synchronised (%d/%d)", table, countIndex, count)
-	return nil
-}
-
-func Synchronise(table entities.Table) error {
-	return SynchroniseForType(table)
+	return countIndex, nil
 }
 
 func SynchroniseEntry(data any) error {
 	table := entities.GetStructType(data)
 	//	logHandler.InfoLogger.Printf("Flushing Cache Entry for Table [%v]", table)
-	inMemoryCacheEntry, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	if !isKeyRegistered(table) {
+	store.mu.RLock()
+	entriesExist := store.entries != nil
+	keyField := store.key
+	synchroniserFunc := store.synchroniser
+	store.mu.RUnlock()
+
+	if !entriesExist {
+		return ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+
+	if keyField.String() == "" {
 		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
 		return ce.ErrCacheNoKeyDefinedWrapper("synchronise", table.String())
 	}
 
-	synchroniserFunc, exists := Cache.synchroniser[table]
-	if !exists {
+	if synchroniserFunc == nil {
 		return ce.ErrCacheNoSynchroniserDefinedWrapper(table.String())
 	}
 
-	keyField, exists := Cache.key[table]
-	if !exists || keyField.String() == "" {
-		return ce.ErrCacheNoKeyDefinedWrapper("synchronise", table.String())
+	key, err := keyOf(data, "synchronise", table, keyField)
+	if err != nil {
+		return err
 	}
 
-	// Get the key value, by using reflection to get the field value
-	rv := reflect.ValueOf(data)
-	if rv.Kind() == reflect.Ptr {
-		if rv.IsNil() {
-			return ce.ErrCacheNilDataWrapper("synchronise")
-		}
-		rv = rv.Elem()
-	}
-	if rv.Kind() != reflect.Struct {
-		return fmt.Errorf("cannot synchronise non-struct cache entry for table %v: got %T", table.String(), data)
-	}
-	fv := rv.FieldByName(keyField.String())
-	if !fv.IsValid() {
-		return fmt.Errorf("cannot synchronise cache entry for table %v: key field %q not found on %T", table.String(), keyField.String(), data)
+	store.mu.RLock()
+	record, exists, err := store.backend.Get(key)
+	store.mu.RUnlock()
+	if err != nil {
+		return err
 	}
-	key := fv.Interface()
-
-	record, exists := inMemoryCacheEntry[key]
 	if !exists {
 		return ce.ErrCacheRecordNotFoundWrapper(table.String(), key)
 	}
 
-	err := synchroniserFunc(record.dataRecord)
+	err = synchroniserFunc(copyRecord(record.Record))
 	if err != nil {
+		store.metrics.syncFailures.Add(1)
 		return err
 	}
 
@@ -747,59 +873,77 @@ func SynchroniseEntry(data any) error {
 	return nil
 }
 
-func SynchroniseAll() error {
-	for table, synchroniserFunc := range Cache.synchroniser {
+// SynchroniseAll synchronises every table with a registered synchroniser, and returns the
+// number of records synchronised. It stops at the first error.
+func SynchroniseAll() (int, error) {
+	total := 0
+	for table, store := range Cache.snapshot() {
+		store.mu.RLock()
+		synchroniserFunc := store.synchroniser
+		store.mu.RUnlock()
 		if synchroniserFunc == nil {
 			continue
 		}
-		if err := SynchroniseForType(table); err != nil {
-			return err
+		count, err := synchroniseTable(table)
+		total += count
+		if err != nil {
+			return total, err
 		}
 	}
-	return nil
+	return total, nil
 }
 
 func isKeyRegistered(table entities.Table) bool {
-	keyField, exists := Cache.key[table]
-	if !exists || keyField.String() == "" {
+	store, exists := Cache.lookup(table)
+	if !exists {
 		return false
 	}
-	return true
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.key.String() != ""
 }
 
 func ClearCacheForType(data any) error {
-	table := entities.GetStructType(data)
-	_, exists := Cache.cache[table]
-	if !exists {
-		return ce.ErrCacheDoesNotExistWrapper(table.String())
-	}
-
-	Cache.cache[table] = make(entrys)
-	Cache.count[table] = 0
-	Cache.updated = time.Now()
-	logHandler.InfoLogger.Printf("Cache for Table [%v] cleared", table)
-	return nil
+	return Clear(entities.GetStructType(data))
 }
 
 func ClearAllCaches() error {
-	for table := range Cache.cache {
-		Cache.cache[table] = make(entrys)
-		Cache.count[table] = 0
+	var cleared []Event
+	for table, store := range Cache.snapshot() {
+		store.mu.Lock()
+		if store.entries != nil {
+			if err := store.clearEntries(); err != nil {
+				logHandler.ErrorLogger.Printf("Error clearing Cache Backend [%v] for Table [%v]: %v", store.backend.Name(), table, err)
+			}
+			cleared = append(cleared, newEvent(Cleared, table, nil, nil, true))
+		}
+		store.mu.Unlock()
 	}
-	Cache.updated = time.Now()
+	Cache.touch()
+	publish(cleared...)
 	logHandler.InfoLogger.Printf("All Caches cleared")
 	return nil
 }
 
 func Clear(table entities.Table) error {
-	_, exists := Cache.cache[table]
+	store, exists := Cache.lookup(table)
 	if !exists {
 		return ce.ErrCacheDoesNotExistWrapper(table.String())
 	}
 
-	Cache.cache[table] = make(entrys)
-	Cache.count[table] = 0
-	Cache.updated = time.Now()
+	store.mu.Lock()
+	if store.entries == nil {
+		store.mu.Unlock()
+		return ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+	err := store.clearEntries()
+	store.mu.Unlock()
+	Cache.touch()
+	publish(newEvent(Cleared, table, nil, nil, true))
+	if err != nil {
+		logHandler.ErrorLogger.Printf("Error clearing Cache Backend for Table [%v]: %v", table, err)
+		return err
+	}
 	logHandler.InfoLogger.Printf("Cache for Table [%v] cleared", table)
 	return nil
 }
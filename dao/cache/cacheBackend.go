@@ -0,0 +1,425 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	ce "github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/paths"
+)
+
+// Entry is a cached record, as held by a Backend.
+type Entry struct {
+	Key     any
+	Record  any
+	Expires time.Time
+}
+
+// Backend stores the cached records of one table.
+//
+// The cache keeps the key, expiry, size, indexes and eviction order of every entry itself; a
+// Backend only holds the records. The cache serialises writes to a table and allows reads to
+// run in parallel, so an implementation must be safe for concurrent readers only.
+type Backend interface {
+	// Name identifies the backend in logs. Two backends with the same name hold the same records.
+	Name() string
+	// AddEntry adds the entry, replacing any entry with the same key.
+	AddEntry(entry Entry) error
+	// Get returns the entry for key, and whether there is one.
+	Get(key any) (Entry, bool, error)
+	// GetAll returns every entry, in no particular order.
+	GetAll() ([]Entry, error)
+	// GetWhere returns every entry whose record has field equal to value.
+	GetWhere(field entities.Field, value any) ([]Entry, error)
+	// RemoveEntry removes the entry for key, if there is one.
+	RemoveEntry(key any) error
+	// Clear removes every entry.
+	Clear() error
+	// Count returns the number of entries.
+	Count() (int64, error)
+	// Close releases the backend. The cache does not use it afterwards.
+	Close() error
+}
+
+// BackendFactory returns the Backend for table, whose records look like sample and are keyed
+// by key.
+type BackendFactory func(table entities.Table, sample any, key entities.Field) (Backend, error)
+
+// MemoryBackend is the default backend. It holds the records of a table in a map, so they are
+// lost when the process exits and the table must be hydrated again.
+func MemoryBackend(table entities.Table, sample any, key entities.Field) (Backend, error) {
+	return newMemoryBackend(), nil
+}
+
+// memoryBackend holds records in a map indexed by cache key.
+type memoryBackend struct {
+	entries map[any]Entry
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{entries: make(map[any]Entry)}
+}
+
+func (m *memoryBackend) Name() string { return "memory" }
+
+func (m *memoryBackend) AddEntry(entry Entry) error {
+	m.entries[entry.Key] = entry
+	return nil
+}
+
+func (m *memoryBackend) Get(key any) (Entry, bool, error) {
+	entry, ok := m.entries[key]
+	return entry, ok, nil
+}
+
+func (m *memoryBackend) GetAll() ([]Entry, error) {
+	rtn := make([]Entry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		rtn = append(rtn, entry)
+	}
+	return rtn, nil
+}
+
+func (m *memoryBackend) GetWhere(field entities.Field, value any) ([]Entry, error) {
+	rtn := make([]Entry, 0)
+	for _, entry := range m.entries {
+		if fv, ok := fieldOf(entry.Record, field); ok && fv.Interface() == value {
+			rtn = append(rtn, entry)
+		}
+	}
+	return rtn, nil
+}
+
+func (m *memoryBackend) RemoveEntry(key any) error {
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryBackend) Clear() error {
+	m.entries = make(map[any]Entry)
+	return nil
+}
+
+func (m *memoryBackend) Count() (int64, error) {
+	return int64(len(m.entries)), nil
+}
+
+func (m *memoryBackend) Close() error {
+	m.entries = nil
+	return nil
+}
+
+// FileBackend returns a factory for "warm cache" backends, which keep the records of each
+// table in a Storm (bbolt) file named "<table>.cache.db" in dir. If dir is empty, the
+// application's database folder is used.
+//
+// Every change to the cache is written through to the file, and the file is loaded when the
+// backend is registered, so after a restart the table is warm without running its hydrator.
+// If the table has a source registered, and the source has changed since the file last did,
+// the file is emptied instead. Records are stored as JSON, so only exported fields survive a
+// restart.
+func FileBackend(dir string) BackendFactory {
+	return func(table entities.Table, sample any, key entities.Field) (Backend, error) {
+		if dir == "" {
+			dir = paths.Application().String() + paths.Seperator() + paths.Database().String()
+		}
+		path := filepath.Join(dir, strings.ToLower(table.String())+".cache.db")
+		return openFileBackend(path, sample, key)
+	}
+}
+
+// warmEntry is a record as stored in a warm cache file.
+type warmEntry struct {
+	ID      string `storm:"id"`
+	Expires time.Time
+	Record  json.RawMessage
+}
+
+// fileBackend holds records in a warm cache file.
+type fileBackend struct {
+	path       string
+	modified   time.Time // when the file last changed before it was opened; zero for a new file
+	db         *storm.DB
+	recordType reflect.Type // struct type of the records
+	pointer    bool         // records are cached as pointers to recordType
+	key        entities.Field
+	refs       int
+}
+
+// openFiles holds the warm cache files that are open, by path. bbolt locks a file while it
+// is open, so a second registration of the same file shares the backend rather than waiting.
+var openFiles = struct {
+	mu    sync.Mutex
+	files map[string]*fileBackend
+}{files: make(map[string]*fileBackend)}
+
+func openFileBackend(path string, sample any, key entities.Field) (*fileBackend, error) {
+	openFiles.mu.Lock()
+	defer openFiles.mu.Unlock()
+	if fb, ok := openFiles.files[path]; ok {
+		fb.refs++
+		return fb, nil
+	}
+
+	recordType := reflect.TypeOf(sample)
+	pointer := recordType != nil && recordType.Kind() == reflect.Ptr
+	if pointer {
+		recordType = recordType.Elem()
+	}
+	if recordType == nil || recordType.Kind() != reflect.Struct {
+		return nil, ce.ErrInvalidTypeWrapper("record", fmt.Sprintf("%T", sample), "struct")
+	}
+
+	// Opening the file can write to it, so the time is taken first
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+	db, err := storm.Open(path, storm.BoltOptions(0666, nil))
+	if err != nil {
+		logHandler.ErrorLogger.Printf("Error opening Warm Cache [%v]: %v", path, err)
+		return nil, err
+	}
+	fb := &fileBackend{path: path, modified: modified, db: db, recordType: recordType, pointer: pointer, key: key, refs: 1}
+	openFiles.files[path] = fb
+	logHandler.CacheLogger.Printf("Warm Cache [%v] opened", path)
+	return fb, nil
+}
+
+func (f *fileBackend) Name() string { return "file:" + f.path }
+
+func (f *fileBackend) Modified() time.Time { return f.modified }
+
+// id returns the storm id for a cache key.
+func (f *fileBackend) id(key any) string {
+	return fmt.Sprint(key)
+}
+
+func (f *fileBackend) AddEntry(entry Entry) error {
+	record, err := json.Marshal(entry.Record)
+	if err != nil {
+		return err
+	}
+	return f.db.Save(&warmEntry{ID: f.id(entry.Key), Expires: entry.Expires, Record: record})
+}
+
+// decode rebuilds the cache entry for a stored record.
+func (f *fileBackend) decode(stored warmEntry) (Entry, error) {
+	rv := reflect.New(f.recordType)
+	if err := json.Unmarshal(stored.Record, rv.Interface()); err != nil {
+		return Entry{}, err
+	}
+	record := rv.Interface()
+	if !f.pointer {
+		record = rv.Elem().Interface()
+	}
+	key, err := keyOf(record, "load", entities.Table(f.recordType.Name()), f.key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Key: key, Record: record, Expires: stored.Expires}, nil
+}
+
+func (f *fileBackend) Get(key any) (Entry, bool, error) {
+	var stored warmEntry
+	err := f.db.One("ID", f.id(key), &stored)
+	if errors.Is(err, storm.ErrNotFound) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	entry, err := f.decode(stored)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (f *fileBackend) GetAll() ([]Entry, error) {
+	var stored []warmEntry
+	if err := f.db.All(&stored); err != nil {
+		return nil, err
+	}
+	rtn := make([]Entry, 0, len(stored))
+	for _, s := range stored {
+		entry, err := f.decode(s)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, entry)
+	}
+	return rtn, nil
+}
+
+func (f *fileBackend) GetWhere(field entities.Field, value any) ([]Entry, error) {
+	all, err := f.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	rtn := make([]Entry, 0)
+	for _, entry := range all {
+		if fv, ok := fieldOf(entry.Record, field); ok && fv.Interface() == value {
+			rtn = append(rtn, entry)
+		}
+	}
+	return rtn, nil
+}
+
+func (f *fileBackend) RemoveEntry(key any) error {
+	err := f.db.DeleteStruct(&warmEntry{ID: f.id(key)})
+	if errors.Is(err, storm.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (f *fileBackend) Clear() error {
+	// Drop fails if the bucket has never been created
+	if count, err := f.Count(); err != nil || count == 0 {
+		return err
+	}
+	return f.db.Drop(&warmEntry{})
+}
+
+func (f *fileBackend) Count() (int64, error) {
+	count, err := f.db.Count(&warmEntry{})
+	return int64(count), err
+}
+
+// Close closes the file once every table sharing it has closed it.
+func (f *fileBackend) Close() error {
+	openFiles.mu.Lock()
+	defer openFiles.mu.Unlock()
+	f.refs--
+	if f.refs > 0 {
+		return nil
+	}
+	delete(openFiles.files, f.path)
+	logHandler.CacheLogger.Printf("Warm Cache [%v] closed", f.path)
+	return f.db.Close()
+}
+
+// modifiedBackend is implemented by backends that keep records across restarts. Modified
+// returns when the stored records last changed before the backend was opened.
+type modifiedBackend interface {
+	Modified() time.Time
+}
+
+// RegisterSource sets the file the records of the table of data are read from, normally its
+// database file. RegisterBackend does not load records from a backend that kept them across
+// a restart if the source has changed since they were stored, or no longer exists.
+func RegisterSource(data any, path string) {
+	table := entities.GetStructType(data)
+	store := Cache.store(table)
+	store.mu.Lock()
+	store.source = path
+	store.mu.Unlock()
+	logHandler.CacheLogger.Printf("[REGISTER] Registered Source [%v] for Table [%v]", path, table)
+}
+
+// outOfDate reports whether backend holds records stored before source last changed.
+func outOfDate(backend Backend, source string) bool {
+	mb, ok := backend.(modifiedBackend)
+	if !ok || source == "" {
+		return false
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return true
+	}
+	return info.ModTime().After(mb.Modified())
+}
+
+// RegisterBackend moves the records of the table of data to the backend built by factory.
+// The table must be enabled and have a key registered.
+//
+// Records already held by the new backend are loaded into the cache, and the next Hydrate of
+// the table uses them rather than running the hydrator. If they are older than the table's
+// source (see RegisterSource) they are removed from the backend instead. Records held by the previous backend
+// are dropped from the cache, but left in the backend, which is closed.
+//
+// Registering a backend with the same name as the current one does nothing.
+func RegisterBackend(data any, factory BackendFactory) error {
+	table := entities.GetStructType(data)
+	if !IsEnabled(data) {
+		return ce.ErrCacheNotEnabledWrapper("set backend", "", table.String())
+	}
+	store := Cache.store(table)
+	store.mu.RLock()
+	keyField := store.key
+	store.mu.RUnlock()
+	if keyField.String() == "" {
+		logHandler.WarningLogger.Printf("No Key registered for Table [%v]", table)
+		return ce.ErrCacheNoKeyDefinedWrapper("set backend", table.String())
+	}
+
+	backend, err := factory(table, data, keyField)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	previous := store.backend
+	if backend.Name() == previous.Name() {
+		// release the reference taken by the factory; the table keeps the current backend
+		store.mu.Unlock()
+		return backend.Close()
+	}
+	entries, err := backend.GetAll()
+	if err != nil {
+		store.mu.Unlock()
+		backend.Close()
+		logHandler.ErrorLogger.Printf("Error loading Cache Backend [%v] for Table [%v]: %v", backend.Name(), table, err)
+		return err
+	}
+	if len(entries) > 0 && outOfDate(backend, store.source) {
+		logHandler.WarningLogger.Printf("Cache Backend [%v] for Table [%v] is older than Source [%v], %d entries dropped", backend.Name(), table, store.source, len(entries))
+		if err := backend.Clear(); err != nil {
+			store.mu.Unlock()
+			backend.Close()
+			logHandler.ErrorLogger.Printf("Error clearing Cache Backend [%v] for Table [%v]: %v", backend.Name(), table, err)
+			return err
+		}
+		entries = nil
+	}
+	store.resetEntries()
+	store.backend = backend
+	for _, entry := range entries {
+		store.loadEntry(entry)
+	}
+	store.warm = len(entries) > 0
+	evicted := store.evictOverflow(table, nil)
+	store.mu.Unlock()
+
+	if err := previous.Close(); err != nil {
+		logHandler.WarningLogger.Printf("Error closing Cache Backend [%v] for Table [%v]: %v", previous.Name(), table, err)
+	}
+	Cache.touch()
+	publish(evicted...)
+	Cache.enforceLimits(table, nil)
+	logHandler.InfoLogger.Printf("Cache Backend for Table [%v] set to [%v], %d entries loaded", table, backend.Name(), len(entries))
+	return nil
+}
+
+// GetBackend returns the name of the backend holding the records of the table of data.
+func GetBackend(data any) (string, error) {
+	table := entities.GetStructType(data)
+	store, exists := Cache.lookup(table)
+	if !exists {
+		return "", ce.ErrCacheDoesNotExistWrapper(table.String())
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.backend.Name(), nil
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupFileBackend activates the orders table with a warm cache file in dir, and returns the
+// number of times its hydrator has run.
+func setupFileBackend(t *testing.T, dir string, records ...any) *int {
+	t.Helper()
+	hydrations := new(int)
+	setupTestTable(t, &cacheTestOrder{}, func() []any {
+		*hydrations++
+		return records
+	})
+	if err := RegisterBackend(&cacheTestOrder{}, FileBackend(dir)); err != nil {
+		t.Fatalf("RegisterBackend: %v", err)
+	}
+	t.Cleanup(Initialise)
+	return hydrations
+}
+
+func TestFileBackend(t *testing.T) {
+	dir := t.TempDir()
+	Initialise()
+	setupFileBackend(t, dir)
+	if name, err := GetBackend(&cacheTestOrder{}); err != nil || !strings.HasPrefix(name, "file:") {
+		t.Errorf("GetBackend returned %q, %v", name, err)
+	}
+	for id, status := range []string{"open", "closed", "open"} {
+		if err := AddEntry(&cacheTestOrder{ID: id, Status: status}); err != nil {
+			t.Fatalf("AddEntry %d: %v", id, err)
+		}
+	}
+	if err := RemoveEntry(&cacheTestOrder{ID: 2}); err != nil {
+		t.Fatalf("RemoveEntry: %v", err)
+	}
+	if orders, err := GetAllWhere(&cacheTestOrder{}, "Status", "open"); err != nil || !slices.Equal(orderIDs(orders), []int{0}) {
+		t.Errorf("GetAllWhere returned %v, %v; want [0]", orderIDs(orders), err)
+	}
+
+	// A restart loads the file, and hydrating uses it instead of the hydrator
+	Initialise()
+	hydrations := setupFileBackend(t, dir, &cacheTestOrder{ID: 9})
+	if got := cachedOrderIDs(t); !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("the warm cache holds %v, want [0 1]", got)
+	}
+	if order, err := Get(&cacheTestOrder{}, 1); err != nil || order.Status != "closed" {
+		t.Errorf("Get returned %+v, %v from the warm cache", order, err)
+	}
+	if err := HydrateForType(&cacheTestOrder{}); err != nil || *hydrations != 0 {
+		t.Errorf("HydrateForType returned %v and ran the hydrator %d times, want 0", err, *hydrations)
+	}
+	checkConsistency(t)
+
+	// Registering the same file again keeps the table as it is
+	if err := RegisterBackend(&cacheTestOrder{}, FileBackend(dir)); err != nil {
+		t.Fatalf("RegisterBackend of the same file: %v", err)
+	}
+	if count, _ := Count(&cacheTestOrder{}); count != 2 {
+		t.Errorf("the cache holds %d entries after registering the same file, want 2", count)
+	}
+
+	// Clearing the table empties the file, so the next restart hydrates
+	if err := ClearCacheForType(&cacheTestOrder{}); err != nil {
+		t.Fatalf("ClearCacheForType: %v", err)
+	}
+	Initialise()
+	hydrations = setupFileBackend(t, dir, &cacheTestOrder{ID: 9})
+	if err := HydrateForType(&cacheTestOrder{}); err != nil || *hydrations != 1 {
+		t.Errorf("HydrateForType returned %v and ran the hydrator %d times, want 1", err, *hydrations)
+	}
+	if got := cachedOrderIDs(t); !slices.Equal(got, []int{9}) {
+		t.Errorf("the cache holds %v after hydrating, want [9]", got)
+	}
+
+	// Moving back to memory leaves the records in the file
+	if err := RegisterBackend(&cacheTestOrder{}, MemoryBackend); err != nil {
+		t.Fatalf("RegisterBackend(MemoryBackend): %v", err)
+	}
+	if count, _ := Count(&cacheTestOrder{}); count != 0 {
+		t.Errorf("the memory backend holds %d entries, want 0", count)
+	}
+	Initialise()
+	setupFileBackend(t, dir)
+	if got := cachedOrderIDs(t); !slices.Equal(got, []int{9}) {
+		t.Errorf("the warm cache holds %v, want [9]", got)
+	}
+}
+
+// TestFileBackendSource checks that a warm cache file older than its source is not loaded.
+func TestFileBackendSource(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "orders.db")
+	if err := os.WriteFile(source, nil, 0644); err != nil {
+		t.Fatalf("creating the source: %v", err)
+	}
+	for _, test := range []struct {
+		name   string
+		change func() error
+		loaded bool
+	}{
+		{"unchanged", func() error { return os.Chtimes(source, time.Now(), time.Now().Add(-time.Hour)) }, true},
+		{"changed", func() error { return os.Chtimes(source, time.Now(), time.Now().Add(time.Hour)) }, false},
+		{"removed", func() error { return os.Remove(source) }, false},
+	} {
+		Initialise()
+		setupFileBackend(t, dir)
+		if err := AddEntry(&cacheTestOrder{ID: 1}); err != nil {
+			t.Fatalf("AddEntry: %v", err)
+		}
+		if err := test.change(); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		Initialise()
+		setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+		RegisterSource(&cacheTestOrder{}, source)
+		if err := RegisterBackend(&cacheTestOrder{}, FileBackend(dir)); err != nil {
+			t.Fatalf("%v: RegisterBackend: %v", test.name, err)
+		}
+		if _, err := Get(&cacheTestOrder{}, 1); (err == nil) != test.loaded {
+			t.Errorf("%v: loaded the record %t, want %t", test.name, err == nil, test.loaded)
+		}
+	}
+}
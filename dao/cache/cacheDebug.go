@@ -15,39 +15,46 @@ func Spew() {
 
 	logHandler.InfoBanner("Cache", "Report", "Starting Cache Report")
 
-	if len(Cache.tablesActive) == 0 {
+	tables := Cache.activeTables()
+	if len(tables) == 0 {
 		logHandler.InfoLogger.Println("No tables are currently cached")
 	}
 
-	logHandler.InfoLogger.Printf("Cache created at: %v", Cache.created.Format(time.RFC3339Nano))
-	logHandler.InfoLogger.Printf("Cache updated at: %v", Cache.updated.Format(time.RFC3339Nano))
-	logHandler.InfoLogger.Printf("Cache Age: %v", humanize.Time(Cache.created))
-	logHandler.InfoLogger.Printf("Cache Last Updated: %v", humanize.Time(Cache.updated))
+	Cache.mu.RLock()
+	created, updated := Cache.created, Cache.updated
+	Cache.mu.RUnlock()
+	logHandler.InfoLogger.Printf("Cache created at: %v", created.Format(time.RFC3339Nano))
+	logHandler.InfoLogger.Printf("Cache updated at: %v", updated.Format(time.RFC3339Nano))
+	logHandler.InfoLogger.Printf("Cache Age: %v", humanize.Time(created))
+	logHandler.InfoLogger.Printf("Cache Last Updated: %v", humanize.Time(updated))
 	logHandler.InfoLogger.Println("")
 	msg := ". Cached Tables: "
-	for tableName := range Cache.tablesActive {
+	for tableName := range tables {
 		msg += string(tableName) + " "
 	}
 
-	if len(Cache.tablesActive) == 0 {
+	if len(tables) == 0 {
 		logHandler.InfoBanner("Cache", "Report", "End Report")
 		return
 	}
 	logHandler.InfoLogger.Println(msg)
 
 	logHandler.InfoLogger.Println(". Cached Keys Summary")
-	for tableName, keyField := range Cache.key {
+	for tableName, store := range tables {
+		store.mu.RLock()
+		keyField := store.key
+		store.mu.RUnlock()
 		logHandler.InfoLogger.Printf(". 	Table [%v] has Key Field [%v]", tableName, keyField.String())
 	}
 
 	// Display A COUNT OF THE RECORDS IN THE CACHE
 	logHandler.InfoLogger.Println(". Cached Records Summary")
-	for tableName := range Cache.tablesActive {
+	for tableName := range tables {
 		SpewFor(tableName)
 	}
-	created, updated, noTables, noCacheEntries := Stats()
+	created, updated, noTables, noCacheEntries, noEvictions := Stats()
 	logHandler.InfoLogger.Println("")
-	logHandler.InfoLogger.Printf("Cache Stats - Created: %v, Updated: %v, Tables: %v, Entries: %v", created.Format(time.RFC3339Nano), updated.Format(time.RFC3339Nano), noTables, noCacheEntries)
+	logHandler.InfoLogger.Printf("Cache Stats - Created: %v, Updated: %v, Tables: %v, Entries: %v, Evictions: %v", created.Format(time.RFC3339Nano), updated.Format(time.RFC3339Nano), noTables, noCacheEntries, noEvictions)
 
 	logHandler.InfoBanner("Cache", "Report", "End Report")
 }
@@ -72,40 +79,76 @@ func SpewForType(data any) {
 
 func spewForEntity(table entities.Table) {
 	tableNameStr := table.String()
-	if len(Cache.tablesActive) == 0 {
+	tables := Cache.activeTables()
+	if len(tables) == 0 {
 		logHandler.WarningLogger.Printf(". \tTable [%v] is not cached (no active tables)", tableNameStr)
 		return
 	}
 
-	if _, ok := Cache.tablesActive[table]; !ok {
+	store, ok := tables[table]
+	if !ok {
 		logHandler.WarningLogger.Printf(". \tTable [%v] is not currently cached", tableNameStr)
 		return
 	}
 
-	cachedEntry, exists := Cache.cache[table]
-	if !exists {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.entries == nil {
 		logHandler.WarningLogger.Printf(". \tTable [%v] has 0 cached records", tableNameStr)
 		return
 	}
 
-	cacheExpiry := Cache.expiry[table]
-	logHandler.InfoLogger.Printf(". \tTable [%v] has [%d] cached records and expiry set to [%v]", tableNameStr, len(cachedEntry), cacheExpiry)
-	for key, record := range cachedEntry {
-		keyField, ok := Cache.key[table]
-		if ok {
-			logHandler.InfoLogger.Printf(".       %v>%v: %v - expires: %v(%v)", tableNameStr, keyField.String(), key, record.cacheTimestamp.Format(time.RFC3339Nano), humanize.Time(record.cacheTimestamp))
+	logHandler.InfoLogger.Printf(". \tTable [%v] has [%d] cached records and expiry set to [%v]", tableNameStr, len(store.entries), store.expiry)
+	logHandler.InfoLogger.Printf(". \tTable [%v] holds ~%v, capacity [%d entries, %d bytes, %v], [%d] evictions, complete [%t]", tableNameStr, humanize.Bytes(uint64(max(store.bytes, 0))), store.limits.maxEntries, store.limits.maxBytes, store.order.policy, store.metrics.evictions.Load(), !store.evicted)
+	logHandler.InfoLogger.Printf(". \tTable [%v] hits [%d], misses [%d], fallbacks [%d], expiries [%d], sync failures [%d]", tableNameStr, store.metrics.hits.Load(), store.metrics.misses.Load(), store.metrics.fallbacks.Load(), store.metrics.expiries.Load(), store.metrics.syncFailures.Load())
+	for key, record := range store.entries {
+		if store.key.String() != "" {
+			logHandler.InfoLogger.Printf(".       %v>%v: %v - expires: %v(%v)", tableNameStr, store.key.String(), key, record.cacheTimestamp.Format(time.RFC3339Nano), humanize.Time(record.cacheTimestamp))
 			continue
 		}
 		logHandler.InfoLogger.Printf(".       %v>%v: %v - expires: %v(%v)", tableNameStr, "<unknown-key>", key, record.cacheTimestamp.Format(time.RFC3339Nano), humanize.Time(record.cacheTimestamp))
 	}
 }
 
-func Stats() (created time.Time, updated time.Time, noTables int64, noCacheEntries int64) {
-	// Loop through Cache.cache to count total entries
+// Stats returns when the cache was created and last updated, the number of cached tables and
+// entries, and the number of entries evicted to keep the cache within its capacity.
+func Stats() (created time.Time, updated time.Time, noTables int64, noCacheEntries int64, noEvictions int64) {
+	// Loop through the table stores to count total entries
 	var totalEntries int64 = 0
-	for _, entries := range Cache.cache {
-		totalEntries += int64(len(entries))
+	tables := Cache.activeTables()
+	for _, store := range tables {
+		store.mu.RLock()
+		totalEntries += int64(len(store.entries))
+		store.mu.RUnlock()
 	}
-	return Cache.created, Cache.updated, int64(len(Cache.tablesActive)), totalEntries
+	Cache.mu.RLock()
+	defer Cache.mu.RUnlock()
+	return Cache.created, Cache.updated, int64(len(tables)), totalEntries, Cache.evictions.Load()
 	//
 }
+
+// TableStats returns the number of entries cached for the table of data, their approximate
+// size in bytes, and the number of entries evicted from the table.
+func TableStats(data any) (noCacheEntries int64, noBytes int64, noEvictions int64) {
+	store, exists := Cache.lookup(entities.GetStructType(data))
+	if !exists {
+		return 0, 0, 0
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return int64(len(store.entries)), store.bytes, int64(store.metrics.evictions.Load())
+}
+
+// activeTables returns the stores of tables that have been activated (or disabled) for caching.
+func (c *cache) activeTables() map[entities.Table]*tableStore {
+	rtn := c.snapshot()
+	for table, store := range rtn {
+		store.mu.RLock()
+		registered := store.entries != nil
+		store.mu.RUnlock()
+		if !registered {
+			delete(rtn, table)
+		}
+	}
+	return rtn
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// EventType identifies what happened to the cache.
+type EventType int
+
+const (
+	// Added is published when AddEntry caches a record under a new key.
+	Added EventType = iota + 1
+	// Updated is published when AddEntry replaces the record cached under a key.
+	Updated
+	// Removed is published when RemoveEntry or RemoveByKey removes a record.
+	Removed
+	// Cleared is published when every record of a table is removed.
+	Cleared
+	// Expired is published when PurgeExpiredEntries removes an expired record.
+	Expired
+	// Hydrated is published when a table has been reloaded by its hydrator.
+	Hydrated
+	// Evicted is published when a record is removed to keep the cache within its capacity.
+	Evicted
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case Added:
+		return "Added"
+	case Updated:
+		return "Updated"
+	case Removed:
+		return "Removed"
+	case Cleared:
+		return "Cleared"
+	case Expired:
+		return "Expired"
+	case Hydrated:
+		return "Hydrated"
+	case Evicted:
+		return "Evicted"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event describes a change to the cache.
+//
+// Key and Record are nil for Cleared and Hydrated events. Record is a copy; changing it does
+// not change the cache.
+type Event struct {
+	Type   EventType
+	Table  entities.Table
+	Key    any
+	Record any
+	Time   time.Time
+}
+
+type subscriber struct {
+	table entities.Table
+	fn    func(Event)
+}
+
+// subscribers is the registry of event subscribers.
+var subscribers = struct {
+	mu    sync.RWMutex
+	next  uint64
+	subs  map[uint64]subscriber
+	count atomic.Int64
+}{subs: make(map[uint64]subscriber)}
+
+// Subscribe registers fn to be called for every event on table, or on every table if table
+// is empty. It returns a function that cancels the subscription.
+//
+// fn is called on the goroutine that changed the cache, after the change has been made and
+// without any cache lock held, so it may read the cache. It should return quickly; hand slow
+// work, such as pushing to clients, to another goroutine.
+func Subscribe(table entities.Table, fn func(Event)) (unsubscribe func()) {
+	subscribers.mu.Lock()
+	subscribers.next++
+	id := subscribers.next
+	subscribers.subs[id] = subscriber{table: table, fn: fn}
+	subscribers.count.Add(1)
+	subscribers.mu.Unlock()
+	logHandler.InfoLogger.Printf("Cache Subscriber [%d] added for Table [%v]", id, table)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			subscribers.mu.Lock()
+			delete(subscribers.subs, id)
+			subscribers.count.Add(-1)
+			subscribers.mu.Unlock()
+			logHandler.InfoLogger.Printf("Cache Subscriber [%d] removed for Table [%v]", id, table)
+		})
+	}
+}
+
+// SubscribeForType registers fn to be called for every event on the table of data.
+// See Subscribe.
+func SubscribeForType(data any, fn func(Event)) (unsubscribe func()) {
+	return Subscribe(entities.GetStructType(data), fn)
+}
+
+// hasSubscribers reports whether any subscriber is registered, so events need not be built.
+func hasSubscribers() bool {
+	return subscribers.count.Load() > 0
+}
+
+// newEvent builds an event. record is copied unless owned is true, meaning the record has
+// already left the cache and can be handed over as it is.
+func newEvent(eventType EventType, table entities.Table, key, record any, owned bool) Event {
+	if record != nil && !owned {
+		record = copyRecord(record)
+	}
+	return Event{Type: eventType, Table: table, Key: key, Record: record, Time: time.Now()}
+}
+
+// publish calls the subscribers of each event's table.
+// It must be called without any cache lock held.
+func publish(events ...Event) {
+	if len(events) == 0 || !hasSubscribers() {
+		return
+	}
+	subscribers.mu.RLock()
+	subs := make([]subscriber, 0, len(subscribers.subs))
+	for _, sub := range subscribers.subs {
+		subs = append(subs, sub)
+	}
+	subscribers.mu.RUnlock()
+
+	for _, event := range events {
+		for _, sub := range subs {
+			if sub.table != "" && sub.table != event.Table {
+				continue
+			}
+			deliver(sub, event)
+		}
+	}
+}
+
+// deliver calls a subscriber, logging rather than propagating a panic.
+func deliver(sub subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logHandler.ErrorLogger.Printf("Cache Subscriber for Table [%v] panicked on %v event for Key [%v]: %v", event.Table, event.Type, event.Key, r)
+		}
+	}()
+	sub.fn(event)
+}
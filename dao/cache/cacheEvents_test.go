@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+)
+
+// eventLog records the events a subscriber receives.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) record(event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+// take returns the events recorded since the last call as "Type Table Key" strings.
+func (l *eventLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rtn := make([]string, 0, len(l.events))
+	for _, event := range l.events {
+		rtn = append(rtn, fmt.Sprintf("%v %v %v", event.Type, event.Table, event.Key))
+	}
+	l.events = nil
+	return rtn
+}
+
+func TestEvents(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any {
+		return []any{&cacheTestOrder{ID: 1, Status: "open"}, &cacheTestOrder{ID: 2, Status: "open"}}
+	})
+	var log eventLog
+	unsubscribe := SubscribeForType(&cacheTestOrder{}, log.record)
+	t.Cleanup(unsubscribe)
+
+	for _, test := range []struct {
+		name   string
+		change func() error
+		want   []string
+	}{
+		{"add", func() error { return AddEntry(&cacheTestOrder{ID: 1}) }, []string{"Added cacheTestOrder 1"}},
+		{"update", func() error { return AddEntry(&cacheTestOrder{ID: 1, Status: "closed"}) }, []string{"Updated cacheTestOrder 1"}},
+		{"remove", func() error { return RemoveEntry(&cacheTestOrder{ID: 1}) }, []string{"Removed cacheTestOrder 1"}},
+		{"hydrate", func() error { return HydrateForType(&cacheTestOrder{}) }, []string{
+			"Added cacheTestOrder 1", "Added cacheTestOrder 2", "Hydrated cacheTestOrder <nil>",
+		}},
+		{"clear", func() error { return ClearCacheForType(&cacheTestOrder{}) }, []string{"Cleared cacheTestOrder <nil>"}},
+		{"evict", func() error {
+			if err := RegisterCapacity(&cacheTestOrder{}, 1, 0, FIFO); err != nil {
+				return err
+			}
+			if err := AddEntry(&cacheTestOrder{ID: 3}); err != nil {
+				return err
+			}
+			return AddEntry(&cacheTestOrder{ID: 4})
+		}, []string{"Added cacheTestOrder 3", "Added cacheTestOrder 4", "Evicted cacheTestOrder 3"}},
+		{"expire", func() error {
+			if err := RegisterExpiry(&cacheTestOrder{}, time.Millisecond); err != nil {
+				return err
+			}
+			if err := AddEntry(&cacheTestOrder{ID: 4}); err != nil {
+				return err
+			}
+			time.Sleep(5 * time.Millisecond)
+			PurgeExpiredEntries()
+			return nil
+		}, []string{"Updated cacheTestOrder 4", "Expired cacheTestOrder 4"}},
+	} {
+		if err := test.change(); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if got := log.take(); !slices.Equal(got, test.want) {
+			t.Errorf("%v published %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// TestEventRecordIsCopy checks that a subscriber cannot change the cache through an event.
+func TestEventRecordIsCopy(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+	var received *cacheTestOrder
+	unsubscribe := SubscribeForType(&cacheTestOrder{}, func(event Event) {
+		received, _ = event.Record.(*cacheTestOrder)
+	})
+	t.Cleanup(unsubscribe)
+
+	added := &cacheTestOrder{ID: 1, Status: "open"}
+	if err := AddEntry(added); err != nil {
+		t.Fatalf("AddEntry: %v", err)
+	}
+	if received == nil || received == added || received.Status != "open" {
+		t.Fatalf("the event carried %+v, want a copy of %+v", received, added)
+	}
+	received.Status = "changed"
+	if order, err := Get(&cacheTestOrder{}, 1); err != nil || order.Status != "open" {
+		t.Errorf("the cache holds %+v, %v after the event record was changed", order, err)
+	}
+}
+
+// TestSubscriptions checks table filtering, subscriptions to every table, unsubscribing,
+// and that a panicking subscriber does not stop the others.
+func TestSubscriptions(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+	setupTestTable(t, &cacheTestCustomer{}, func() []any { return nil })
+
+	var orders, all eventLog
+	unsubscribeOrders := Subscribe(entities.GetStructType(&cacheTestOrder{}), orders.record)
+	t.Cleanup(unsubscribeOrders)
+	unsubscribePanic := Subscribe("", func(Event) { panic("subscriber failed") })
+	t.Cleanup(unsubscribePanic)
+	unsubscribeAll := Subscribe("", all.record)
+	t.Cleanup(unsubscribeAll)
+
+	if err := AddEntry(&cacheTestOrder{ID: 1}); err != nil {
+		t.Fatalf("AddEntry order: %v", err)
+	}
+	if err := AddEntry(&cacheTestCustomer{ID: 2}); err != nil {
+		t.Fatalf("AddEntry customer: %v", err)
+	}
+	if got, want := orders.take(), []string{"Added cacheTestOrder 1"}; !slices.Equal(got, want) {
+		t.Errorf("the order subscriber received %q, want %q", got, want)
+	}
+	if got, want := all.take(), []string{"Added cacheTestOrder 1", "Added cacheTestCustomer 2"}; !slices.Equal(got, want) {
+		t.Errorf("the all-table subscriber received %q, want %q", got, want)
+	}
+
+	unsubscribeOrders()
+	unsubscribeOrders()
+	if err := RemoveEntry(&cacheTestOrder{ID: 1}); err != nil {
+		t.Fatalf("RemoveEntry: %v", err)
+	}
+	if got := orders.take(); len(got) != 0 {
+		t.Errorf("an unsubscribed subscriber received %q", got)
+	}
+	if got, want := all.take(), []string{"Removed cacheTestOrder 1"}; !slices.Equal(got, want) {
+		t.Errorf("the all-table subscriber received %q, want %q", got, want)
+	}
+}
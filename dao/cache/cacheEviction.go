@@ -0,0 +1,418 @@
+package cache
+
+import (
+	"container/heap"
+	"reflect"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	ce "github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// EvictionPolicy selects which entry is removed when a cache is over capacity.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry. This is the default.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used entry, oldest use first on a tie.
+	LFU
+	// FIFO evicts the oldest entry, regardless of use.
+	FIFO
+)
+
+// String returns the name of the eviction policy.
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LFU:
+		return "LFU"
+	case FIFO:
+		return "FIFO"
+	default:
+		return "LRU"
+	}
+}
+
+// capacity holds the limits of a table, or of the whole cache. A zero limit is unbounded.
+type capacity struct {
+	maxEntries int
+	maxBytes   int64
+	policy     EvictionPolicy
+}
+
+func (c capacity) bounded() bool {
+	return c.maxEntries > 0 || c.maxBytes > 0
+}
+
+func (c capacity) exceeded(entries int, bytes int64) bool {
+	return (c.maxEntries > 0 && entries > c.maxEntries) || (c.maxBytes > 0 && bytes > c.maxBytes)
+}
+
+// evictionItem tracks the use of one cache entry.
+type evictionItem struct {
+	key   any
+	added uint64 // tick when the entry was first added, for FIFO
+	used  uint64 // tick of the last add or read, for LRU
+	hits  uint64 // number of adds and reads, for LFU
+	index int    // position in the heap, -1 once removed
+}
+
+// evictionHeap orders the entries of a table with the next entry to evict on top.
+type evictionHeap struct {
+	policy EvictionPolicy
+	items  []*evictionItem
+}
+
+// before reports whether a should be evicted before b under policy.
+func before(policy EvictionPolicy, a, b *evictionItem) bool {
+	switch policy {
+	case FIFO:
+		return a.added < b.added
+	case LFU:
+		if a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.used < b.used
+	default:
+		return a.used < b.used
+	}
+}
+
+func (h *evictionHeap) Len() int           { return len(h.items) }
+func (h *evictionHeap) Less(i, j int) bool { return before(h.policy, h.items[i], h.items[j]) }
+func (h *evictionHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+func (h *evictionHeap) Push(x any) {
+	item := x.(*evictionItem)
+	item.index = len(h.items)
+	h.items = append(h.items, item)
+}
+func (h *evictionHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	item.index = -1
+	return item
+}
+
+// peek returns the next entry to evict, or nil if the heap is empty.
+func (h *evictionHeap) peek() *evictionItem {
+	if len(h.items) == 0 {
+		return nil
+	}
+	return h.items[0]
+}
+
+// setPolicy re-orders the table for a new eviction policy.
+// The caller must hold store.mu.
+func (store *tableStore) setPolicy(policy EvictionPolicy) {
+	store.orderMu.Lock()
+	defer store.orderMu.Unlock()
+	if store.order.policy == policy {
+		return
+	}
+	store.order.policy = policy
+	heap.Init(&store.order)
+}
+
+// trackEntry records that the entry for key has been added or replaced.
+// The caller must hold store.mu.
+func (store *tableStore) trackEntry(key any, item *evictionItem) *evictionItem {
+	tick := Cache.clock.Add(1)
+	store.orderMu.Lock()
+	defer store.orderMu.Unlock()
+	if item == nil || item.index < 0 {
+		item = &evictionItem{key: key, added: tick, used: tick, hits: 1}
+		heap.Push(&store.order, item)
+		return item
+	}
+	item.used = tick
+	item.hits++
+	heap.Fix(&store.order, item.index)
+	return item
+}
+
+// untrackEntry removes an entry from the eviction order.
+// The caller must hold store.mu.
+func (store *tableStore) untrackEntry(item *evictionItem) {
+	if item == nil {
+		return
+	}
+	store.orderMu.Lock()
+	defer store.orderMu.Unlock()
+	if item.index >= 0 {
+		heap.Remove(&store.order, item.index)
+	}
+}
+
+// touchEntry records a read of an entry.
+// The caller must hold store.mu, for reading at least.
+func (store *tableStore) touchEntry(record dataCache) {
+	if record.item == nil || store.order.policy == FIFO {
+		return
+	}
+	tick := Cache.clock.Add(1)
+	store.orderMu.Lock()
+	defer store.orderMu.Unlock()
+	if record.item.index < 0 {
+		return
+	}
+	record.item.used = tick
+	record.item.hits++
+	heap.Fix(&store.order, record.item.index)
+}
+
+// evictEntry removes the entry for key because the cache is over capacity, and returns
+// the Evicted event to publish once store.mu is released. It reports false if the backend
+// could not remove the record.
+// The caller must hold store.mu.
+func (store *tableStore) evictEntry(table entities.Table, key any) (Event, bool) {
+	removed, _, err := store.removeEntry(key)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("Cache Entry for Table [%v] with Key [%v] not evicted from Backend [%v]: %v", table, key, store.backend.Name(), err)
+		return Event{}, false
+	}
+	store.metrics.evictions.Add(1)
+	store.evicted = true
+	Cache.evictions.Add(1)
+	logHandler.CacheLogger.Printf("Cache Entry for Table [%v] with Key [%v] evicted (%v)", table, key, store.order.policy)
+	return newEvent(Evicted, table, key, removed, true), true
+}
+
+// evictOverflow evicts entries until the table is within its own limits, and returns the
+// events to publish once store.mu is released. The entry for protect, which has just been
+// added, is not evicted.
+// The caller must hold store.mu.
+func (store *tableStore) evictOverflow(table entities.Table, protect any) []Event {
+	if !store.limits.bounded() {
+		return nil
+	}
+	var evicted []Event
+	var held *evictionItem
+	for store.limits.exceeded(len(store.entries), store.bytes) {
+		store.orderMu.Lock()
+		top := store.order.peek()
+		if top != nil && held == nil && top.key == protect {
+			held = heap.Pop(&store.order).(*evictionItem)
+			top = store.order.peek()
+		}
+		store.orderMu.Unlock()
+		if top == nil {
+			break
+		}
+		event, ok := store.evictEntry(table, top.key)
+		if !ok {
+			break
+		}
+		evicted = append(evicted, event)
+	}
+	if held != nil {
+		store.orderMu.Lock()
+		heap.Push(&store.order, held)
+		store.orderMu.Unlock()
+	}
+	return evicted
+}
+
+// enforceLimits evicts entries, across all tables, until the cache is within its global
+// limits. Each table offers its next entry to evict, and the global policy picks between them.
+// The entry for protect in table, which has just been added, is not evicted.
+func (c *cache) enforceLimits(table entities.Table, protect any) {
+	c.mu.RLock()
+	limits := c.limits
+	c.mu.RUnlock()
+	if !limits.bounded() {
+		return
+	}
+
+	c.evictMu.Lock()
+	defer c.evictMu.Unlock()
+	for limits.exceeded(int(c.totalEntries.Load()), c.totalBytes.Load()) {
+		var victimTable entities.Table
+		var victimStore *tableStore
+		var victim *evictionItem
+		var victimUse evictionItem // copy of victim's counters, which reads keep updating
+		for name, store := range c.snapshot() {
+			store.mu.RLock()
+			store.orderMu.Lock()
+			top := store.order.peek()
+			var use evictionItem
+			if top != nil {
+				use = *top
+			}
+			store.orderMu.Unlock()
+			store.mu.RUnlock()
+			if top == nil || (name == table && top.key == protect) {
+				continue
+			}
+			if victim == nil || before(limits.policy, &use, &victimUse) {
+				victimTable, victimStore, victim, victimUse = name, store, top, use
+			}
+		}
+		if victim == nil {
+			return
+		}
+		victimStore.mu.Lock()
+		var event *Event
+		if existing, ok := victimStore.entries[victim.key]; ok && existing.item == victim {
+			evicted, ok := victimStore.evictEntry(victimTable, victim.key)
+			if !ok {
+				victimStore.mu.Unlock()
+				return
+			}
+			event = &evicted
+		}
+		victimStore.mu.Unlock()
+		if event != nil {
+			publish(*event)
+		}
+	}
+}
+
+// RegisterCapacity limits the number of entries, and the approximate bytes, cached for the
+// table of data. When either limit is exceeded, entries are evicted using policy.
+// A limit of zero is unbounded.
+//
+// Evicted records are read from the database on the next DB.Get, and collection reads fall
+// back to the database until the table is cleared or hydrated (see IsComplete).
+func RegisterCapacity(data any, maxEntries int, maxBytes int64, policy EvictionPolicy) error {
+	table := entities.GetStructType(data)
+	logHandler.InfoLogger.Printf("Setting Cache Capacity for Table [%v] to %d entries, %d bytes (%v)", table, maxEntries, maxBytes, policy)
+	if !IsEnabled(data) {
+		return ce.ErrCacheNotEnabledWrapper("set capacity", "", string(table))
+	}
+
+	store := Cache.store(table)
+	store.mu.Lock()
+	store.limits = capacity{maxEntries: maxEntries, maxBytes: maxBytes, policy: policy}
+	store.ownLimits = true
+	store.setPolicy(policy)
+	evicted := store.evictOverflow(table, nil)
+	store.mu.Unlock()
+
+	if len(evicted) > 0 {
+		Cache.touch()
+		publish(evicted...)
+	}
+	logHandler.InfoLogger.Printf("Cache Capacity for Table [%v] set, %d entries evicted", table, len(evicted))
+	return nil
+}
+
+// GetCapacity returns the capacity limits of the table of data.
+func GetCapacity(data any) (maxEntries int, maxBytes int64, policy EvictionPolicy, err error) {
+	if !IsEnabled(data) {
+		return 0, 0, LRU, ce.ErrCacheNotEnabledWrapper("get capacity", "", string(entities.GetStructType(data)))
+	}
+
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.limits.maxEntries, store.limits.maxBytes, store.order.policy, nil
+}
+
+// RegisterGlobalCapacity limits the number of entries, and the approximate bytes, held by
+// the whole cache. When either limit is exceeded, entries are evicted from any table using
+// policy. Tables without their own capacity also use policy to order their entries.
+// A limit of zero is unbounded.
+func RegisterGlobalCapacity(maxEntries int, maxBytes int64, policy EvictionPolicy) {
+	logHandler.InfoLogger.Printf("Setting Global Cache Capacity to %d entries, %d bytes (%v)", maxEntries, maxBytes, policy)
+	Cache.mu.Lock()
+	Cache.limits = capacity{maxEntries: maxEntries, maxBytes: maxBytes, policy: policy}
+	Cache.mu.Unlock()
+
+	for _, store := range Cache.snapshot() {
+		store.mu.Lock()
+		if !store.ownLimits {
+			store.setPolicy(policy)
+		}
+		store.mu.Unlock()
+	}
+	Cache.enforceLimits("", nil)
+}
+
+// IsComplete reports whether the cache for the table of data holds every record it has been
+// given, that is, nothing has been evicted or has expired since it was activated, cleared
+// or hydrated.
+//
+// Reads of whole tables, or of every record matching a value, only use the cache when it is
+// complete.
+func IsComplete(data any) bool {
+	store, exists := Cache.lookup(entities.GetStructType(data))
+	if !exists {
+		return false
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.entries != nil && !store.evicted
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// sizeOf returns the approximate number of bytes held by a record.
+func sizeOf(data any) int64 {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() {
+		return 0
+	}
+	return int64(v.Type().Size()) + indirectSize(v, 0)
+}
+
+// indirectSize returns the approximate number of bytes v refers to, beyond its own size.
+func indirectSize(v reflect.Value, depth int) int64 {
+	if depth > 8 {
+		return 0
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() {
+			return 0
+		}
+		var size int64
+		iter := v.MapRange()
+		for iter.Next() {
+			size += int64(v.Type().Key().Size()) + indirectSize(iter.Key(), depth+1)
+			size += int64(v.Type().Elem().Size()) + indirectSize(iter.Value(), depth+1)
+		}
+		return size
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		return int64(elem.Type().Size()) + indirectSize(elem, depth+1)
+	case reflect.Struct:
+		// A time.Time only refers to a shared location.
+		if v.Type() == timeType {
+			return 0
+		}
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSize(v.Field(i), depth+1)
+		}
+		return size
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cachedOrderIDs returns the sorted ids of the orders cached.
+func cachedOrderIDs(t *testing.T) []int {
+	t.Helper()
+	orders, err := GetAll(&cacheTestOrder{})
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	return orderIDs(orders)
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	// Each step adds or reads an order; the fourth add evicts one
+	for _, test := range []struct {
+		steps string
+		want  map[EvictionPolicy][]int
+	}{
+		{"a1 a2 a3 r1 a4", map[EvictionPolicy][]int{LRU: {1, 3, 4}, LFU: {1, 3, 4}, FIFO: {2, 3, 4}}},
+		{"a1 a2 a3 r1 r1 r2 r3 a4", map[EvictionPolicy][]int{LRU: {2, 3, 4}, LFU: {1, 3, 4}, FIFO: {2, 3, 4}}},
+	} {
+		for _, policy := range []EvictionPolicy{LRU, LFU, FIFO} {
+			t.Run(fmt.Sprintf("%v/%v", policy, test.steps), func(t *testing.T) {
+				Initialise()
+				setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+				if err := RegisterCapacity(&cacheTestOrder{}, 3, 0, policy); err != nil {
+					t.Fatalf("RegisterCapacity: %v", err)
+				}
+				_, _, evictionsBefore := TableStats(&cacheTestOrder{})
+				for _, step := range strings.Fields(test.steps) {
+					id, _ := strconv.Atoi(step[1:])
+					if step[0] == 'a' {
+						if err := AddEntry(&cacheTestOrder{ID: id}); err != nil {
+							t.Fatalf("AddEntry %d: %v", id, err)
+						}
+					} else if _, err := Get(&cacheTestOrder{}, id); err != nil {
+						t.Fatalf("Get %d: %v", id, err)
+					}
+				}
+				if got := cachedOrderIDs(t); !slices.Equal(got, test.want[policy]) {
+					t.Errorf("cache holds %v, want %v", got, test.want[policy])
+				}
+				if _, _, evictions := TableStats(&cacheTestOrder{}); evictions != evictionsBefore+1 {
+					t.Errorf("TableStats counted %d evictions, want %d", evictions, evictionsBefore+1)
+				}
+				if IsComplete(&cacheTestOrder{}) {
+					t.Error("IsComplete is true after an eviction")
+				}
+				if _, _, got, err := GetCapacity(&cacheTestOrder{}); err != nil || got != policy {
+					t.Errorf("GetCapacity returned policy %v, %v; want %v", got, err, policy)
+				}
+				checkConsistency(t)
+			})
+		}
+	}
+}
+
+func TestByteCapacity(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestCustomer{}, func() []any { return nil })
+	record := &cacheTestCustomer{ID: 1, Name: "0123456789"}
+	size := sizeOf(record)
+	if err := RegisterCapacity(&cacheTestCustomer{}, 0, 3*size, FIFO); err != nil {
+		t.Fatalf("RegisterCapacity: %v", err)
+	}
+	for id := range 5 {
+		if err := AddEntry(&cacheTestCustomer{ID: id, Name: "0123456789"}); err != nil {
+			t.Fatalf("AddEntry %d: %v", id, err)
+		}
+	}
+	entries, bytes, _ := TableStats(&cacheTestCustomer{})
+	if entries != 3 || bytes > 3*size {
+		t.Errorf("table holds %d entries, %d bytes; want 3 within %d bytes", entries, bytes, 3*size)
+	}
+	if _, err := Get(&cacheTestCustomer{}, 1); err == nil {
+		t.Error("the oldest entries were not evicted")
+	}
+	checkConsistency(t)
+}
+
+// TestGlobalCapacity checks that the global limit evicts across tables, and that a table's
+// own limit still applies within it.
+func TestGlobalCapacity(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+	setupTestTable(t, &cacheTestCustomer{}, func() []any { return nil })
+	RegisterGlobalCapacity(4, 0, FIFO)
+	t.Cleanup(func() { RegisterGlobalCapacity(0, 0, LRU) })
+	_, _, _, _, evictionsBefore := Stats()
+
+	for id := range 3 {
+		if err := AddEntry(&cacheTestOrder{ID: id}); err != nil {
+			t.Fatalf("AddEntry order %d: %v", id, err)
+		}
+	}
+	for id := range 3 {
+		if err := AddEntry(&cacheTestCustomer{ID: id}); err != nil {
+			t.Fatalf("AddEntry customer %d: %v", id, err)
+		}
+	}
+	// The two oldest entries were orders
+	if got := cachedOrderIDs(t); !slices.Equal(got, []int{2}) {
+		t.Errorf("cache holds orders %v, want [2]", got)
+	}
+	if count, _ := Count(&cacheTestCustomer{}); count != 3 {
+		t.Errorf("cache holds %d customers, want 3", count)
+	}
+	if _, _, _, entries, evictions := Stats(); entries != 4 || evictions != evictionsBefore+2 {
+		t.Errorf("Stats returned %d entries, %d evictions; want 4 and %d", entries, evictions, evictionsBefore+2)
+	}
+	if !IsComplete(&cacheTestCustomer{}) || IsComplete(&cacheTestOrder{}) {
+		t.Error("IsComplete does not reflect which table lost entries")
+	}
+
+	if err := RegisterCapacity(&cacheTestCustomer{}, 1, 0, LRU); err != nil {
+		t.Fatalf("RegisterCapacity: %v", err)
+	}
+	if count, _ := Count(&cacheTestCustomer{}); count != 1 {
+		t.Errorf("cache holds %d customers after RegisterCapacity, want 1", count)
+	}
+	checkConsistency(t)
+}
@@ -7,22 +7,37 @@ import (
 	"github.com/mt1976/frantic-core/timing"
 )
 
-// Remove any cached entries that have expired
-func PurgeExpiredEntries() {
+// PurgeExpiredEntries removes any cached entries that have expired, and returns the number removed.
+func PurgeExpiredEntries() int {
 	watch := timing.Start("Cache", "Purge_Expired_Entries", "")
 	now := time.Now()
 	logHandler.ServiceLogger.Printf("Cache Purge Started at %v", now.Format(time.RFC3339Nano))
 	noPurged := 0
-	for tableName, entries := range Cache.cache {
-		for key, record := range entries {
+	for tableName, store := range Cache.snapshot() {
+		var expired []Event
+		store.mu.Lock()
+		for key, record := range store.entries {
 			if now.After(record.cacheTimestamp) {
 				logHandler.InfoLogger.Printf("Cache Entry for Table [%v] with Key [%v] expired at [%v], removing it", tableName, key, record.cacheTimestamp.Format(time.RFC3339Nano))
-				delete(entries, key)
-				Cache.count[tableName]--
+				removed, _, err := store.removeEntry(key)
+				if err != nil {
+					logHandler.ErrorLogger.Printf("Cache Entry for Table [%v] with Key [%v] not removed from Backend [%v]: %v", tableName, key, store.backend.Name(), err)
+					continue
+				}
+				// The table no longer holds every record, so whole-table reads must go to the database
+				store.evicted = true
+				store.metrics.expiries.Add(1)
+				expired = append(expired, newEvent(Expired, tableName, key, removed, true))
 				noPurged++
 			}
 		}
+		store.mu.Unlock()
+		publish(expired...)
+	}
+	if noPurged > 0 {
+		Cache.touch()
 	}
 	watch.Stop(noPurged)
-	logHandler.ServiceLogger.Printf("Cache Purge Completed at %v", now.Format(time.RFC3339Nano))
+	logHandler.ServiceLogger.Printf("Cache Purge Completed at %v, %d entries purged", now.Format(time.RFC3339Nano), noPurged)
+	return noPurged
 }
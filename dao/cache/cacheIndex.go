@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	ce "github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// secondaryIndex maps the values of one field to the cache keys of the records holding them.
+type secondaryIndex struct {
+	unique bool
+	values map[any]map[any]struct{}
+}
+
+func newSecondaryIndex(unique bool) *secondaryIndex {
+	return &secondaryIndex{unique: unique, values: make(map[any]map[any]struct{})}
+}
+
+// indexValue returns the value of field on record, and whether it can be used as a map key.
+func indexValue(record any, field entities.Field) (any, bool) {
+	fv, ok := fieldOf(record, field)
+	if !ok || !fv.Type().Comparable() {
+		return nil, false
+	}
+	return fv.Interface(), true
+}
+
+// isZeroValue reports whether v is the zero value of its type.
+//
+// Like Storm, unique indexes do not constrain zero values.
+func isZeroValue(v any) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
+
+// checkIndexField checks that field exists on the type of data and can be indexed.
+func checkIndexField(data any, field entities.Field) error {
+	t := reflect.TypeOf(data)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return ce.ErrInvalidTypeWrapper(field.String(), fmt.Sprintf("%T", data), "struct")
+	}
+	sf, ok := t.FieldByName(field.String())
+	if !ok {
+		return ce.ErrInvalidFieldWrapper(field.String())
+	}
+	if !sf.Type.Comparable() {
+		return ce.ErrInvalidTypeWrapper(field.String(), sf.Type.String(), "comparable type")
+	}
+	return nil
+}
+
+// registerIndex adds an index on field and builds it from the records already cached.
+func registerIndex(data any, field entities.Field, unique bool) error {
+	if err := checkIndexField(data, field); err != nil {
+		return err
+	}
+
+	table := entities.GetStructType(data)
+	store := Cache.store(table)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, exists := store.indexes[field]; exists {
+		return errIndexExists
+	}
+
+	records, err := store.backend.GetAll()
+	if err != nil {
+		return err
+	}
+	idx := newSecondaryIndex(unique)
+	for _, record := range records {
+		value, ok := indexValue(record.Record, field)
+		if !ok {
+			continue
+		}
+		if err := idx.check(table, field, record.Key, value); err != nil {
+			return err
+		}
+		idx.add(record.Key, value)
+	}
+	if store.indexes == nil {
+		store.indexes = make(map[entities.Field]*secondaryIndex)
+	}
+	store.indexes[field] = idx
+	store.indices = append(store.indices, field)
+	return nil
+}
+
+var errIndexExists = errors.New("index already exists")
+
+// check returns a duplicate error if a unique index already holds value for another key.
+func (idx *secondaryIndex) check(table entities.Table, field entities.Field, key, value any) error {
+	if !idx.unique || isZeroValue(value) {
+		return nil
+	}
+	for existing := range idx.values[value] {
+		if existing != key {
+			return fmt.Errorf("%w: table %v already has %v=%v (key %v)", ce.ErrDuplicate, table.String(), field.String(), value, existing)
+		}
+	}
+	return nil
+}
+
+func (idx *secondaryIndex) add(key, value any) {
+	keys, ok := idx.values[value]
+	if !ok {
+		keys = make(map[any]struct{})
+		idx.values[value] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx *secondaryIndex) remove(key, value any) {
+	keys, ok := idx.values[value]
+	if !ok {
+		return
+	}
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(idx.values, value)
+	}
+}
+
+// checkUnique checks record against every unique index of the table.
+// The caller must hold store.mu.
+func (store *tableStore) checkUnique(table entities.Table, key, record any) error {
+	for field, idx := range store.indexes {
+		if !idx.unique {
+			continue
+		}
+		value, ok := indexValue(record, field)
+		if !ok {
+			continue
+		}
+		if err := idx.check(table, field, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRecord adds record to every index of the table.
+// The caller must hold store.mu.
+func (store *tableStore) indexRecord(key, record any) {
+	for field, idx := range store.indexes {
+		if value, ok := indexValue(record, field); ok {
+			idx.add(key, value)
+		}
+	}
+}
+
+// unindexRecord removes record from every index of the table.
+// The caller must hold store.mu.
+func (store *tableStore) unindexRecord(key, record any) {
+	for field, idx := range store.indexes {
+		if value, ok := indexValue(record, field); ok {
+			idx.remove(key, value)
+		}
+	}
+}
+
+// insertEntry adds or replaces the entry for key, writing data to the backend and updating
+// the entry's index values, size and eviction order. It reports whether an entry was replaced.
+// The caller must hold store.mu.
+func (store *tableStore) insertEntry(key any, record dataCache, data any) (bool, error) {
+	existing, replacing := store.entries[key]
+	var previous Entry
+	if replacing && len(store.indexes) > 0 {
+		var err error
+		if previous, _, err = store.backend.Get(key); err != nil {
+			return false, err
+		}
+	}
+	if err := store.backend.AddEntry(Entry{Key: key, Record: data, Expires: record.cacheTimestamp}); err != nil {
+		return false, err
+	}
+	record.size = sizeOf(data)
+	if replacing {
+		store.unindexRecord(key, previous.Record)
+		store.bytes -= existing.size
+		Cache.totalBytes.Add(-existing.size)
+	} else {
+		Cache.totalEntries.Add(1)
+	}
+	record.item = store.trackEntry(key, existing.item)
+	store.entries[key] = record
+	store.bytes += record.size
+	Cache.totalBytes.Add(record.size)
+	store.indexRecord(key, data)
+	return replacing, nil
+}
+
+// loadEntry adds an entry the backend already holds.
+// The caller must hold store.mu.
+func (store *tableStore) loadEntry(entry Entry) {
+	record := dataCache{cacheTimestamp: entry.Expires, size: sizeOf(entry.Record)}
+	record.item = store.trackEntry(entry.Key, nil)
+	store.entries[entry.Key] = record
+	store.bytes += record.size
+	Cache.totalEntries.Add(1)
+	Cache.totalBytes.Add(record.size)
+	store.indexRecord(entry.Key, entry.Record)
+}
+
+// removeEntry removes the entry for key from the backend, and its index values, and returns
+// the record removed.
+// The caller must hold store.mu.
+func (store *tableStore) removeEntry(key any) (any, bool, error) {
+	existing, ok := store.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	removed, _, err := store.backend.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := store.backend.RemoveEntry(key); err != nil {
+		return nil, false, err
+	}
+	store.unindexRecord(key, removed.Record)
+	store.untrackEntry(existing.item)
+	delete(store.entries, key)
+	store.bytes -= existing.size
+	Cache.totalEntries.Add(-1)
+	Cache.totalBytes.Add(-existing.size)
+	return removed.Record, true, nil
+}
+
+// clearEntries removes every entry from the backend and the table, keeping the registered
+// indexes.
+// The caller must hold store.mu.
+func (store *tableStore) clearEntries() error {
+	err := store.backend.Clear()
+	store.resetEntries()
+	return err
+}
+
+// resetEntries forgets every entry, leaving the backend as it is.
+// The caller must hold store.mu.
+func (store *tableStore) resetEntries() {
+	Cache.totalEntries.Add(-int64(len(store.entries)))
+	Cache.totalBytes.Add(-store.bytes)
+	store.entries = make(entrys)
+	store.bytes = 0
+	store.evicted = false
+	store.warm = false
+	store.orderMu.Lock()
+	store.order.items = nil
+	store.orderMu.Unlock()
+	for field, idx := range store.indexes {
+		store.indexes[field] = newSecondaryIndex(idx.unique)
+	}
+}
+
+// lookupIndex returns the cache keys holding value for field, if field is indexed.
+// The caller must hold store.mu.
+func (store *tableStore) lookupIndex(field entities.Field, value any) ([]any, bool) {
+	idx, ok := store.indexes[field]
+	if !ok || value == nil || !reflect.TypeOf(value).Comparable() {
+		return nil, false
+	}
+	keys := make([]any, 0, len(idx.values[value]))
+	for key := range idx.values[value] {
+		keys = append(keys, key)
+	}
+	return keys, true
+}
+
+// RegisterUniqueIndex registers a unique index on field for the table of data.
+//
+// Like RegisterIndex, lookups on the field become O(1). In addition, AddEntry rejects a
+// record whose (non-zero) field value is already held by a record with a different key,
+// returning an error wrapping commonErrors.ErrDuplicate.
+func RegisterUniqueIndex(data any, field entities.Field) error {
+	if !IsEnabled(data) {
+		return ce.ErrCacheNotEnabledWrapper("add unique index", field.String(), string(entities.GetStructType(data)))
+	}
+	err := registerIndex(data, field, true)
+	if errors.Is(err, errIndexExists) {
+		logHandler.WarningLogger.Printf("index %v already exists for %v", field.String(), entities.GetStructType(data))
+		return nil
+	}
+	return err
+}
+
+// IsIndexed reports whether field is indexed in the cache for the table of data.
+func IsIndexed(data any, field entities.Field) bool {
+	store, exists := Cache.lookup(entities.GetStructType(data))
+	if !exists {
+		return false
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	_, ok := store.indexes[field]
+	return ok
+}
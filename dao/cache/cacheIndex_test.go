@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	ce "github.com/mt1976/frantic-core/commonErrors"
+)
+
+// orderIDs returns the sorted ids of orders.
+func orderIDs(orders []*cacheTestOrder) []int {
+	ids := make([]int, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// indexedKeys returns the number of keys the index on field holds for value.
+func indexedKeys(data any, field entities.Field, value any) int {
+	store := Cache.store(entities.GetStructType(data))
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return len(store.indexes[field].values[value])
+}
+
+func TestIndexLookups(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+	for id, status := range []string{"open", "closed", "open", "open"} {
+		if err := AddEntry(&cacheTestOrder{ID: id, Status: status}); err != nil {
+			t.Fatalf("AddEntry %d: %v", id, err)
+		}
+	}
+	// An index registered after records are cached is built from them
+	if err := RegisterIndex(&cacheTestOrder{}, "Status"); err != nil {
+		t.Fatalf("RegisterIndex: %v", err)
+	}
+	if !IsIndexed(&cacheTestOrder{}, "Status") || IsIndexed(&cacheTestOrder{}, "ID") {
+		t.Error("IsIndexed does not report the registered index")
+	}
+	find := func(status string) []int {
+		t.Helper()
+		orders, err := FindByIndex(&cacheTestOrder{}, "Status", status)
+		if err != nil {
+			t.Fatalf("FindByIndex(%v): %v", status, err)
+		}
+		return orderIDs(orders)
+	}
+	if got := find("open"); !slices.Equal(got, []int{0, 2, 3}) {
+		t.Errorf("open orders are %v, want [0 2 3]", got)
+	}
+
+	// Updates and removals move the record's keys between values
+	if err := AddEntry(&cacheTestOrder{ID: 2, Status: "closed"}); err != nil {
+		t.Fatalf("AddEntry: %v", err)
+	}
+	if err := RemoveEntry(&cacheTestOrder{ID: 3}); err != nil {
+		t.Fatalf("RemoveEntry: %v", err)
+	}
+	if got := find("open"); !slices.Equal(got, []int{0}) {
+		t.Errorf("open orders are %v after update and removal, want [0]", got)
+	}
+	if got := find("closed"); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("closed orders are %v after update, want [1 2]", got)
+	}
+	if n := indexedKeys(&cacheTestOrder{}, "Status", "open"); n != 1 {
+		t.Errorf("the index holds %d keys for open, want 1", n)
+	}
+	if _, err := GetWhere(&cacheTestOrder{}, "Status", "closed"); !errors.Is(err, ce.ErrCacheMultipleRecordsFound) {
+		t.Errorf("GetWhere of two records returned %v, want %v", err, ce.ErrCacheMultipleRecordsFound)
+	}
+	if order, err := GetWhere(&cacheTestOrder{}, "Status", "open"); err != nil || order.ID != 0 {
+		t.Errorf("GetWhere(open) returned %+v, %v", order, err)
+	}
+
+	if err := Clear(entities.GetStructType(&cacheTestOrder{})); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if n := indexedKeys(&cacheTestOrder{}, "Status", "closed"); n != 0 {
+		t.Errorf("the index holds %d keys after Clear, want 0", n)
+	}
+	if !IsIndexed(&cacheTestOrder{}, "Status") {
+		t.Error("Clear dropped the index")
+	}
+	if err := RemoveIndex(&cacheTestOrder{}, "Status"); err != nil || IsIndexed(&cacheTestOrder{}, "Status") {
+		t.Errorf("RemoveIndex returned %v and left the index", err)
+	}
+	if err := RegisterIndex(&cacheTestOrder{}, "Nope"); err == nil {
+		t.Error("RegisterIndex of an unknown field returned no error")
+	}
+	checkConsistency(t)
+}
+
+func TestUniqueIndex(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestCustomer{}, func() []any { return nil })
+	if err := RegisterUniqueIndex(&cacheTestCustomer{}, "Name"); err != nil {
+		t.Fatalf("RegisterUniqueIndex: %v", err)
+	}
+	if err := AddEntry(&cacheTestCustomer{ID: 1, Name: "ann"}); err != nil {
+		t.Fatalf("AddEntry: %v", err)
+	}
+	if err := AddEntry(&cacheTestCustomer{ID: 2, Name: "ann"}); !errors.Is(err, ce.ErrDuplicate) {
+		t.Errorf("AddEntry of a duplicate returned %v, want %v", err, ce.ErrDuplicate)
+	}
+	if _, err := Get(&cacheTestCustomer{}, 2); err == nil {
+		t.Error("the rejected record was cached")
+	}
+	// A record can be replaced with its own value, and zero values are not constrained
+	if err := AddEntry(&cacheTestCustomer{ID: 1, Name: "ann"}); err != nil {
+		t.Errorf("AddEntry replacing a record with its own value: %v", err)
+	}
+	for id := 3; id <= 4; id++ {
+		if err := AddEntry(&cacheTestCustomer{ID: id}); err != nil {
+			t.Errorf("AddEntry with an empty name: %v", err)
+		}
+	}
+	// Removing a record frees its value
+	if err := RemoveEntry(&cacheTestCustomer{ID: 1}); err != nil {
+		t.Fatalf("RemoveEntry: %v", err)
+	}
+	if err := AddEntry(&cacheTestCustomer{ID: 2, Name: "ann"}); err != nil {
+		t.Errorf("AddEntry after the holder was removed: %v", err)
+	}
+
+	// A unique index cannot be built over records that break it
+	if err := AddEntry(&cacheTestCustomer{ID: 5, Name: "ann"}); !errors.Is(err, ce.ErrDuplicate) {
+		t.Fatalf("AddEntry of a duplicate returned %v", err)
+	}
+	if err := RemoveIndex(&cacheTestCustomer{}, "Name"); err != nil {
+		t.Fatalf("RemoveIndex: %v", err)
+	}
+	if err := AddEntry(&cacheTestCustomer{ID: 5, Name: "ann"}); err != nil {
+		t.Fatalf("AddEntry without the index: %v", err)
+	}
+	if err := RegisterUniqueIndex(&cacheTestCustomer{}, "Name"); !errors.Is(err, ce.ErrDuplicate) {
+		t.Errorf("RegisterUniqueIndex over duplicates returned %v, want %v", err, ce.ErrDuplicate)
+	}
+	checkConsistency(t)
+}
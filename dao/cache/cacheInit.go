@@ -4,23 +4,74 @@ import (
 	"time"
 
 	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/logHandler"
 )
 
 const defaultCacheExpiry = 100 * 365 * 24 * time.Hour // 100 years
 
 // Initialise sets up the cache system.
-
+//
+// Any existing cached tables are discarded, and their backends closed.
 func Initialise() {
-	// Initialise global cache maps.
-	// Note: do not use ':=' here, that would shadow the package-level Cache.
+	Cache.mu.Lock()
+	defer Cache.mu.Unlock()
+	for table, store := range Cache.tables {
+		store.mu.Lock()
+		if err := store.backend.Close(); err != nil {
+			logHandler.WarningLogger.Printf("Error closing Cache Backend [%v] for Table [%v]: %v", store.backend.Name(), table, err)
+		}
+		store.mu.Unlock()
+	}
 	Cache.created = time.Now()
 	Cache.updated = time.Time{}
-	Cache.cache = make(map[entities.Table]entrys)
-	Cache.indices = make(map[entities.Table][]entities.Field)
-	Cache.key = make(map[entities.Table]entities.Field)
-	Cache.tablesActive = make(map[entities.Table]bool)
-	Cache.count = make(map[entities.Table]int64)
-	Cache.expiry = make(map[entities.Table]time.Duration)
-	Cache.synchroniser = make(map[entities.Table]func(any) error)
-	Cache.hydrator = make(map[entities.Table]func() ([]any, error))
+	Cache.tables = make(map[entities.Table]*tableStore)
+	Cache.totalEntries.Store(0)
+	Cache.totalBytes.Store(0)
+	Cache.evictions.Store(0)
+}
+
+// lookup returns the store for a table, if one exists.
+func (c *cache) lookup(table entities.Table) (*tableStore, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	store, ok := c.tables[table]
+	return store, ok
+}
+
+// store returns the store for a table, creating it if needed.
+func (c *cache) store(table entities.Table) *tableStore {
+	if store, ok := c.lookup(table); ok {
+		return store
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tables == nil {
+		// Initialise was not called; start lazily.
+		c.created = time.Now()
+		c.tables = make(map[entities.Table]*tableStore)
+	}
+	store, ok := c.tables[table]
+	if !ok {
+		store = &tableStore{expiry: defaultCacheExpiry, backend: newMemoryBackend(), order: evictionHeap{policy: c.limits.policy}, metrics: &tableMetrics{}}
+		c.tables[table] = store
+	}
+	return store
+}
+
+// snapshot returns the table stores currently registered.
+func (c *cache) snapshot() map[entities.Table]*tableStore {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rtn := make(map[entities.Table]*tableStore, len(c.tables))
+	for table, store := range c.tables {
+		rtn[table] = store
+	}
+	return rtn
+}
+
+// touch records that the cache contents have changed.
+func (c *cache) touch() {
+	c.mu.Lock()
+	c.updated = time.Now()
+	c.mu.Unlock()
 }
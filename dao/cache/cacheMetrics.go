@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// tableMetrics holds the counters of a table. They survive Activate, Disable and Clear.
+type tableMetrics struct {
+	hits              atomic.Uint64
+	misses            atomic.Uint64
+	fallbacks         atomic.Uint64
+	evictions         atomic.Uint64
+	expiries          atomic.Uint64
+	hydrations        atomic.Uint64
+	hydrationNanos    atomic.Int64
+	lastHydrationNano atomic.Int64
+	syncFailures      atomic.Uint64
+}
+
+// TableMetrics is a snapshot of the cache metrics of one table.
+type TableMetrics struct {
+	Table             entities.Table
+	Enabled           bool
+	Complete          bool
+	Entries           int64
+	Bytes             int64
+	Hits              uint64        // reads answered from the cache
+	Misses            uint64        // reads the cache could not answer
+	Fallbacks         uint64        // reads passed to the database while caching was enabled
+	Evictions         uint64        // entries removed to stay within capacity
+	Expiries          uint64        // entries removed by PurgeExpiredEntries
+	Hydrations        uint64        // completed hydrations
+	HydrationDuration time.Duration // total time spent hydrating
+	LastHydration     time.Duration // time taken by the last hydration
+	SyncFailures      uint64        // synchroniser calls that returned an error
+}
+
+// HitRatio returns hits as a fraction of all cache reads, or 0 if there have been none.
+func (m TableMetrics) HitRatio() float64 {
+	total := m.Hits + m.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Hits) / float64(total)
+}
+
+// Metrics is a snapshot of the cache metrics of every table, sorted by table name.
+type Metrics struct {
+	Created time.Time
+	Updated time.Time
+	Tables  []TableMetrics
+}
+
+// metricsFor returns the counters of the table of data, if the table has a store.
+func metricsFor(data any) *tableMetrics {
+	store, exists := Cache.lookup(entities.GetStructType(data))
+	if !exists {
+		return nil
+	}
+	return store.metrics
+}
+
+// recordRead counts a cache read as a hit or a miss.
+func (m *tableMetrics) recordRead(hit bool) {
+	if hit {
+		m.hits.Add(1)
+		return
+	}
+	m.misses.Add(1)
+}
+
+// RecordFallback counts a read of the table of data that was passed to the database while
+// caching was enabled, because the cache could not answer it.
+func RecordFallback(data any) {
+	if m := metricsFor(data); m != nil {
+		m.fallbacks.Add(1)
+	}
+}
+
+// GetMetrics returns a snapshot of the cache metrics.
+func GetMetrics() Metrics {
+	Cache.mu.RLock()
+	rtn := Metrics{Created: Cache.created, Updated: Cache.updated}
+	Cache.mu.RUnlock()
+
+	for table, store := range Cache.snapshot() {
+		store.mu.RLock()
+		tm := TableMetrics{
+			Table:    table,
+			Enabled:  store.active,
+			Complete: store.entries != nil && !store.evicted,
+			Entries:  int64(len(store.entries)),
+			Bytes:    store.bytes,
+		}
+		store.mu.RUnlock()
+		m := store.metrics
+		tm.Hits = m.hits.Load()
+		tm.Misses = m.misses.Load()
+		tm.Fallbacks = m.fallbacks.Load()
+		tm.Evictions = m.evictions.Load()
+		tm.Expiries = m.expiries.Load()
+		tm.Hydrations = m.hydrations.Load()
+		tm.HydrationDuration = time.Duration(m.hydrationNanos.Load())
+		tm.LastHydration = time.Duration(m.lastHydrationNano.Load())
+		tm.SyncFailures = m.syncFailures.Load()
+		rtn.Tables = append(rtn.Tables, tm)
+	}
+	sort.Slice(rtn.Tables, func(i, j int) bool { return rtn.Tables[i].Table < rtn.Tables[j].Table })
+	return rtn
+}
+
+// GetMetricsForType returns a snapshot of the cache metrics of the table of data.
+func GetMetricsForType(data any) (TableMetrics, bool) {
+	table := entities.GetStructType(data)
+	for _, tm := range GetMetrics().Tables {
+		if tm.Table == table {
+			return tm, true
+		}
+	}
+	return TableMetrics{Table: table}, false
+}
+
+// metricFamily describes one Prometheus metric and how to read it from a table snapshot.
+type metricFamily struct {
+	name  string
+	kind  string
+	help  string
+	value func(TableMetrics) string
+}
+
+func formatUint(v uint64) string { return fmt.Sprintf("%d", v) }
+
+var metricFamilies = []metricFamily{
+	{"amphora_cache_entries", "gauge", "Number of entries cached for the table.", func(m TableMetrics) string { return fmt.Sprintf("%d", m.Entries) }},
+	{"amphora_cache_bytes", "gauge", "Approximate size of the entries cached for the table.", func(m TableMetrics) string { return fmt.Sprintf("%d", m.Bytes) }},
+	{"amphora_cache_complete", "gauge", "1 if nothing has been evicted from, or has expired in, the table since it was last cleared or hydrated.", func(m TableMetrics) string { return boolGauge(m.Complete) }},
+	{"amphora_cache_hits_total", "counter", "Reads answered from the cache.", func(m TableMetrics) string { return formatUint(m.Hits) }},
+	{"amphora_cache_misses_total", "counter", "Reads the cache could not answer.", func(m TableMetrics) string { return formatUint(m.Misses) }},
+	{"amphora_cache_fallbacks_total", "counter", "Reads passed to the database while caching was enabled.", func(m TableMetrics) string { return formatUint(m.Fallbacks) }},
+	{"amphora_cache_evictions_total", "counter", "Entries evicted to stay within capacity.", func(m TableMetrics) string { return formatUint(m.Evictions) }},
+	{"amphora_cache_expiries_total", "counter", "Entries removed after expiring.", func(m TableMetrics) string { return formatUint(m.Expiries) }},
+	{"amphora_cache_hydrations_total", "counter", "Completed hydrations of the table.", func(m TableMetrics) string { return formatUint(m.Hydrations) }},
+	{"amphora_cache_hydration_seconds_total", "counter", "Total time spent hydrating the table.", func(m TableMetrics) string { return fmt.Sprintf("%g", m.HydrationDuration.Seconds()) }},
+	{"amphora_cache_last_hydration_seconds", "gauge", "Time taken by the last hydration of the table.", func(m TableMetrics) string { return fmt.Sprintf("%g", m.LastHydration.Seconds()) }},
+	{"amphora_cache_sync_failures_total", "counter", "Synchroniser calls that returned an error.", func(m TableMetrics) string { return formatUint(m.SyncFailures) }},
+}
+
+func boolGauge(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes the cache metrics to w in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	metrics := GetMetrics()
+	bw := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		fmt.Fprintf(bw, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.name, family.kind)
+		for _, tm := range metrics.Tables {
+			fmt.Fprintf(bw, "%s{table=\"%s\"} %s\n", family.name, labelEscaper.Replace(tm.Table.String()), family.value(tm))
+		}
+	}
+	return bw.Flush()
+}
+
+// MetricsHandler returns an http.Handler that serves the cache metrics in the Prometheus
+// text exposition format.
+//
+// Example:
+//
+//	http.Handle("/metrics", cache.MetricsHandler())
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteMetrics(w); err != nil {
+			logHandler.ErrorLogger.Printf("Error writing cache metrics: %v", err)
+		}
+	})
+}
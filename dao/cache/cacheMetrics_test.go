@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any {
+		return []any{&cacheTestOrder{ID: 1, Status: "open"}, &cacheTestOrder{ID: 2, Status: "closed"}}
+	})
+	if err := HydrateForType(&cacheTestOrder{}); err != nil {
+		t.Fatalf("HydrateForType: %v", err)
+	}
+	_, _ = Get(&cacheTestOrder{}, 1)
+	_, _ = Get(&cacheTestOrder{}, 1)
+	_, _ = Get(&cacheTestOrder{}, 9)
+	_, _ = GetAllWhere(&cacheTestOrder{}, "Status", "none")
+	RecordFallback(&cacheTestOrder{})
+
+	failure := errors.New("sync failed")
+	RegisterSynchroniser(&cacheTestOrder{}, func(any) error { return failure })
+	if err := SynchroniseForType(&cacheTestOrder{}); !errors.Is(err, failure) {
+		t.Errorf("SynchroniseForType returned %v, want %v", err, failure)
+	}
+	if err := SynchroniseEntry(&cacheTestOrder{ID: 2}); !errors.Is(err, failure) {
+		t.Errorf("SynchroniseEntry returned %v, want %v", err, failure)
+	}
+
+	if err := RegisterCapacity(&cacheTestOrder{}, 1, 0, LRU); err != nil {
+		t.Fatalf("RegisterCapacity: %v", err)
+	}
+	if err := RegisterExpiry(&cacheTestOrder{}, time.Millisecond); err != nil {
+		t.Fatalf("RegisterExpiry: %v", err)
+	}
+	if err := AddEntry(&cacheTestOrder{ID: 3}); err != nil {
+		t.Fatalf("AddEntry: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	PurgeExpiredEntries()
+
+	m, ok := GetMetricsForType(&cacheTestOrder{})
+	if !ok {
+		t.Fatal("GetMetricsForType found no metrics")
+	}
+	for _, check := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"Hits", m.Hits, 2},
+		{"Misses", m.Misses, 2},
+		{"Fallbacks", m.Fallbacks, 1},
+		{"Evictions", m.Evictions, 2},
+		{"Expiries", m.Expiries, 1},
+		{"Hydrations", m.Hydrations, 1},
+		{"SyncFailures", m.SyncFailures, 2},
+		{"Entries", uint64(m.Entries), 0},
+	} {
+		if check.got != check.want {
+			t.Errorf("%v is %d, want %d", check.name, check.got, check.want)
+		}
+	}
+	if m.HitRatio() != 0.5 {
+		t.Errorf("HitRatio is %v, want 0.5", m.HitRatio())
+	}
+	if m.LastHydration <= 0 || m.HydrationDuration < m.LastHydration {
+		t.Errorf("hydration took %v, %v in total", m.LastHydration, m.HydrationDuration)
+	}
+	if !m.Enabled || m.Complete {
+		t.Errorf("metrics report enabled %t, complete %t", m.Enabled, m.Complete)
+	}
+	if _, ok := GetMetricsForType(&cacheTestCustomer{}); ok {
+		t.Error("GetMetricsForType found metrics for a table never cached")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestOrder{}, func() []any { return nil })
+	if err := AddEntry(&cacheTestOrder{ID: 1}); err != nil {
+		t.Fatalf("AddEntry: %v", err)
+	}
+	_, _ = Get(&cacheTestOrder{}, 1)
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type is %q", got)
+	}
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE amphora_cache_hits_total counter",
+		"# TYPE amphora_cache_entries gauge",
+		`amphora_cache_hits_total{table="cacheTestOrder"} 1`,
+		`amphora_cache_entries{table="cacheTestOrder"} 1`,
+		`amphora_cache_complete{table="cacheTestOrder"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not include %q", line)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "#") && len(strings.Fields(line)) != 2 {
+			t.Errorf("malformed sample %q", line)
+		}
+	}
+}
@@ -1,29 +1,61 @@
 package cache
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mt1976/frantic-amphora/dao/entities"
 )
 
+// cache is the registry of per-table stores.
+//
+// mu guards the tables map and the created/updated timestamps; each table store has its
+// own lock, so work on one table does not block another.
 type cache struct {
-	created      time.Time
-	updated      time.Time
-	tablesActive map[entities.Table]bool
-	key          map[entities.Table]entities.Field
-	indices      map[entities.Table][]entities.Field
-	cache        map[entities.Table]entrys // in-memory storage, indexde by table then by cache key
-	count        map[entities.Table]int64
-	expiry       map[entities.Table]time.Duration
-	synchroniser map[entities.Table]func(any) error
-	hydrator     map[entities.Table]func() ([]any, error)
+	mu      sync.RWMutex
+	created time.Time
+	updated time.Time
+	tables  map[entities.Table]*tableStore
+	limits  capacity // global limits, guarded by mu
+
+	evictMu      sync.Mutex    // serialises global eviction
+	clock        atomic.Uint64 // ticks for the eviction order
+	totalEntries atomic.Int64
+	totalBytes   atomic.Int64
+	evictions    atomic.Int64
+}
+
+// tableStore holds the cached records and configuration for a single table.
+type tableStore struct {
+	mu           sync.RWMutex
+	active       bool
+	key          entities.Field
+	indices      []entities.Field // registered indexes, in registration order
+	indexes      map[entities.Field]*secondaryIndex
+	entries      entrys  // the cached entries, indexed by cache key; nil until the table is activated
+	backend      Backend // holds the records of the entries
+	warm         bool    // entries were loaded from the backend, and the table has not been hydrated since
+	source       string  // file the records are read from, see RegisterSource
+	expiry       time.Duration
+	synchroniser func(any) error
+	hydrator     func() ([]any, error)
+
+	limits    capacity
+	ownLimits bool         // limits set by RegisterCapacity, rather than following the global policy
+	bytes     int64        // approximate size of the entries
+	evicted   bool         // an entry has been evicted or has expired since the table was activated, cleared or hydrated
+	orderMu   sync.Mutex   // guards order, which reads update under store.mu.RLock
+	order     evictionHeap // eviction order of the entries
+	metrics   *tableMetrics
 }
 
-type entrys map[any]dataCache // Map indexed by keyfield, storing one record per slot
-// dataCache is the structure stored in each cache entry
+type entrys map[any]dataCache // Map indexed by keyfield, storing one entry per slot
+// dataCache is the structure stored in each cache entry; the record itself is held by the backend
 type dataCache struct {
-	dataRecord     any
 	cacheTimestamp time.Time
+	size           int64
+	item           *evictionItem
 }
 
 var Cache = cache{}
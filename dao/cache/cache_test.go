@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+)
+
+type cacheTestOrder struct {
+	ID     int
+	Status string
+}
+
+type cacheTestCustomer struct {
+	ID   int
+	Name string
+}
+
+const (
+	testWorkers    = 8
+	testIterations = 200
+	testKeys       = 64
+)
+
+// setupTestTable activates the cache for data, keyed on ID, with a hydrator that returns
+// records.
+func setupTestTable(t *testing.T, data any, records func() []any) {
+	t.Helper()
+	if err := Activate(data); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if err := RegisterKey(data, entities.Field("ID")); err != nil {
+		t.Fatalf("RegisterKey: %v", err)
+	}
+	RegisterHydrator(data, func() ([]any, error) { return records(), nil })
+}
+
+// checkConsistency verifies that every table's entries, eviction order and byte count
+// agree, and that the cache totals match the tables.
+func checkConsistency(t *testing.T) {
+	t.Helper()
+	Cache.evictMu.Lock()
+	defer Cache.evictMu.Unlock()
+	var entries, bytes int64
+	for table, store := range Cache.snapshot() {
+		store.mu.RLock()
+		store.orderMu.Lock()
+		if len(store.order.items) != len(store.entries) {
+			t.Errorf("table %v: %d entries but %d in the eviction order", table, len(store.entries), len(store.order.items))
+		}
+		for key, record := range store.entries {
+			if record.item == nil || record.item.index < 0 || record.item.key != key {
+				t.Errorf("table %v: entry %v is not tracked in the eviction order", table, key)
+			}
+		}
+		store.orderMu.Unlock()
+		if count, _ := store.backend.Count(); count != int64(len(store.entries)) {
+			t.Errorf("table %v: %d entries but the backend holds %d", table, len(store.entries), count)
+		}
+		if store.limits.maxEntries > 0 && len(store.entries) > store.limits.maxEntries {
+			t.Errorf("table %v: %d entries exceeds capacity %d", table, len(store.entries), store.limits.maxEntries)
+		}
+		entries += int64(len(store.entries))
+		bytes += store.bytes
+		store.mu.RUnlock()
+	}
+	if total := Cache.totalEntries.Load(); total != entries {
+		t.Errorf("cache total is %d entries, tables hold %d", total, entries)
+	}
+	if total := Cache.totalBytes.Load(); total != bytes {
+		t.Errorf("cache total is %d bytes, tables hold %d", total, bytes)
+	}
+}
+
+// TestConcurrentAccess runs hydration, expiry, eviction and reads and writes against two
+// tables at once. Run it with -race.
+func TestConcurrentAccess(t *testing.T) {
+	Initialise()
+	orders := func() []any {
+		records := make([]any, 0, testKeys)
+		for i := range testKeys {
+			records = append(records, &cacheTestOrder{ID: i, Status: fmt.Sprintf("S%d", i%4)})
+		}
+		return records
+	}
+	customers := func() []any {
+		records := make([]any, 0, testKeys)
+		for i := range testKeys {
+			records = append(records, &cacheTestCustomer{ID: i, Name: fmt.Sprintf("C%d", i)})
+		}
+		return records
+	}
+	setupTestTable(t, &cacheTestOrder{}, orders)
+	setupTestTable(t, &cacheTestCustomer{}, customers)
+	if err := RegisterIndex(&cacheTestOrder{}, entities.Field("Status")); err != nil {
+		t.Fatalf("RegisterIndex: %v", err)
+	}
+	if err := RegisterCapacity(&cacheTestOrder{}, testKeys/2, 0, LRU); err != nil {
+		t.Fatalf("RegisterCapacity: %v", err)
+	}
+	if err := RegisterExpiry(&cacheTestCustomer{}, time.Millisecond); err != nil {
+		t.Fatalf("RegisterExpiry: %v", err)
+	}
+	RegisterGlobalCapacity(testKeys, 0, LFU)
+	t.Cleanup(func() { RegisterGlobalCapacity(0, 0, LRU) })
+
+	var wg sync.WaitGroup
+	run := func(work func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range testIterations {
+				work(i)
+			}
+		}()
+	}
+
+	run(func(int) { _ = HydrateForType(&cacheTestOrder{}) })
+	run(func(int) { _ = HydrateForType(&cacheTestCustomer{}) })
+	run(func(int) { PurgeExpiredEntries() })
+	for w := range testWorkers {
+		run(func(i int) {
+			id := (w*testIterations + i) % testKeys
+			_ = AddEntry(&cacheTestOrder{ID: id, Status: fmt.Sprintf("S%d", id%4)})
+			_ = AddEntry(&cacheTestCustomer{ID: id, Name: fmt.Sprintf("C%d", id)})
+			if i%3 == 0 {
+				_ = RemoveEntry(&cacheTestOrder{ID: id})
+				_ = RemoveEntry(&cacheTestCustomer{ID: id})
+			}
+		})
+		run(func(i int) {
+			id := (w + i) % testKeys
+			if order, err := Get(&cacheTestOrder{}, id); err == nil && order.ID != id {
+				t.Errorf("Get(%d) returned order %d", id, order.ID)
+			}
+			_, _ = GetAll(&cacheTestCustomer{})
+			if matches, err := GetAllWhere(&cacheTestOrder{}, entities.Field("Status"), "S1"); err == nil {
+				for _, order := range matches {
+					if order.Status != "S1" {
+						t.Errorf("GetAllWhere(Status=S1) returned status %v", order.Status)
+					}
+				}
+			}
+			_, _ = GetWhere(&cacheTestCustomer{}, entities.Field("Name"), fmt.Sprintf("C%d", id))
+			_ = IsComplete(&cacheTestOrder{})
+			_, _, _ = TableStats(&cacheTestCustomer{})
+		})
+	}
+	wg.Wait()
+
+	checkConsistency(t)
+}
+
+// TestPurgeExpiredEntriesMarksTableIncomplete checks that a table with expired entries is
+// no longer served as complete, and is again once hydrated.
+func TestPurgeExpiredEntriesMarksTableIncomplete(t *testing.T) {
+	Initialise()
+	customers := func() []any {
+		return []any{&cacheTestCustomer{ID: 1, Name: "one"}, &cacheTestCustomer{ID: 2, Name: "two"}}
+	}
+	setupTestTable(t, &cacheTestCustomer{}, customers)
+	if err := RegisterExpiry(&cacheTestCustomer{}, time.Millisecond); err != nil {
+		t.Fatalf("RegisterExpiry: %v", err)
+	}
+	if err := HydrateForType(&cacheTestCustomer{}); err != nil {
+		t.Fatalf("HydrateForType: %v", err)
+	}
+	if !IsComplete(&cacheTestCustomer{}) {
+		t.Fatal("IsComplete is false after hydration")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if purged := PurgeExpiredEntries(); purged != 2 {
+		t.Fatalf("PurgeExpiredEntries purged %d entries, want 2", purged)
+	}
+	if IsComplete(&cacheTestCustomer{}) {
+		t.Error("IsComplete is true after entries expired")
+	}
+
+	if err := RegisterExpiry(&cacheTestCustomer{}, time.Hour); err != nil {
+		t.Fatalf("RegisterExpiry: %v", err)
+	}
+	if err := HydrateForType(&cacheTestCustomer{}); err != nil {
+		t.Fatalf("HydrateForType: %v", err)
+	}
+	if !IsComplete(&cacheTestCustomer{}) {
+		t.Error("IsComplete is false after rehydration")
+	}
+	checkConsistency(t)
+}
+
+// TestRemoveEntryKeepsTableComplete checks that deleting a record does not mark its table
+// incomplete, as the cache still holds every record of the table.
+func TestRemoveEntryKeepsTableComplete(t *testing.T) {
+	Initialise()
+	setupTestTable(t, &cacheTestCustomer{}, func() []any { return []any{&cacheTestCustomer{ID: 1, Name: "one"}} })
+	if err := HydrateForType(&cacheTestCustomer{}); err != nil {
+		t.Fatalf("HydrateForType: %v", err)
+	}
+	if err := RemoveEntry(&cacheTestCustomer{ID: 1}); err != nil {
+		t.Fatalf("RemoveEntry: %v", err)
+	}
+	if !IsComplete(&cacheTestCustomer{}) {
+		t.Error("IsComplete is false after a delete")
+	}
+}
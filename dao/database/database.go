@@ -29,8 +29,7 @@ import (
 // DEPRECATED: Use Get instead.
 func (db *DB) Retrieve(field entities.Field, value, to any) (any, error) {
 	logHandler.WarningLogger.Printf("Retrieve is DEPRECATED, use Get instead")
-	panic("Retrieve is DEPRECATED, use Get instead")
-	//return db.get(field, value, to)
+	return db.get(field, value, to)
 }
 
 // Get retrieves a single record from the database based on the specified fields.Field and value.
@@ -48,6 +47,18 @@ func (db *DB) Get(field entities.Field, value, to any) (any, error) {
 	return db.get(field, value, to)
 }
 
+// GetStored retrieves a single record from Storm, bypassing the cache, whether or not it has
+// been soft-deleted.
+//
+// Generated DAOs use it to read the stored version of a record they are about to change.
+func (db *DB) GetStored(field entities.Field, value, to any) (any, error) {
+	logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - Stored", entities.GetStructType(to), field.String(), value, db.Name)
+	if err := db.connection.One(field.String(), value, to); err != nil {
+		return nil, err
+	}
+	return to, nil
+}
+
 // get is the internal implementation for retrieving a single record from the database.
 //
 // Parameters:
@@ -63,8 +74,13 @@ func (db *DB) get(field entities.Field, value, to any) (any, error) {
 
 	if cache.IsEnabled(to) {
 		cachedValue, err := cache.GetWhere(to, field, value)
+		if err == nil && IsSoftDelete(to) && IsDeleted(cachedValue) {
+			logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - Deleted", entities.GetStructType(to), field.String(), value, db.Name)
+			return nil, storm.ErrNotFound
+		}
 		if err == nil {
-			reflect.ValueOf(to).Elem().Set(reflect.ValueOf(cachedValue).Elem())
+			// GetAll and GetAllWhere cache the records Storm returns, which are not pointers
+			reflect.ValueOf(to).Elem().Set(reflect.Indirect(reflect.ValueOf(cachedValue)))
 			logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - From Cache", entities.GetStructType(to), field.String(), value, db.Name)
 			return cachedValue, nil
 		}
@@ -72,6 +88,7 @@ func (db *DB) get(field entities.Field, value, to any) (any, error) {
 	}
 
 	logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - From Database", entities.GetStructType(to), field.String(), value, db.Name)
+	cacheFallback(to)
 
 	// [GET] from database
 	err := db.connection.One(field.String(), value, to)
@@ -82,6 +99,10 @@ func (db *DB) get(field entities.Field, value, to any) (any, error) {
 		logHandler.ErrorLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - Error from DB: %v", entities.GetStructType(to), field.String(), value, db.Name, err)
 		return nil, err
 	}
+	if IsSoftDelete(to) && IsDeleted(to) {
+		logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - Deleted", entities.GetStructType(to), field.String(), value, db.Name)
+		return nil, storm.ErrNotFound
+	}
 
 	if cache.IsEnabled(to) {
 		logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v) [...%v.db] - Populating Cache", entities.GetStructType(to), field.String(), value, db.Name)
@@ -106,7 +127,8 @@ func (db *DB) get(field entities.Field, value, to any) (any, error) {
 func (db *DB) GetAll(to any, options ...func(*index.Options)) ([]any, error) {
 	logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db]", entities.GetStructType(to), options, db.Name)
 
-	if cache.IsEnabled(to) {
+	// Only use the cache when it holds the whole table
+	if cache.IsEnabled(to) && cache.IsComplete(to) {
 		var resultList []any
 		// Get all records from cache
 		allRecords, err := cache.GetAll(to)
@@ -118,6 +140,8 @@ func (db *DB) GetAll(to any, options ...func(*index.Options)) ([]any, error) {
 		for _, record := range allRecords {
 			resultList = append(resultList, record)
 		}
+		// Storm applies the options to its result, so apply them to the cache's too
+		resultList = applyIndexOptions(withoutDeletedRecords(to, resultList), options...)
 		logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db] - Returning %d cached entries", entities.GetStructType(to), options, db.Name, len(resultList))
 		if len(resultList) > 0 {
 			return resultList, nil
@@ -125,8 +149,14 @@ func (db *DB) GetAll(to any, options ...func(*index.Options)) ([]any, error) {
 	}
 
 	logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db] - From Database", entities.GetStructType(to), options, db.Name)
+	cacheFallback(to)
 	// [GET] from database
-	err := db.connection.All(to, options...)
+	// Storm's Limit and Skip would count deleted records, so they are applied after they are removed
+	storedOptions := options
+	if IsSoftDelete(to) {
+		storedOptions = nil
+	}
+	err := db.connection.All(to, storedOptions...)
 	if err != nil {
 		// On error, do not attempt to use or populate the cache
 		logHandler.ErrorLogger.Printf("[GET] %v ALL [%+v] [...%v.db] - Error from DB: %v", entities.GetStructType(to), options, db.Name, err)
@@ -150,8 +180,8 @@ func (db *DB) GetAll(to any, options ...func(*index.Options)) ([]any, error) {
 		result[i] = sliceValue.Index(i).Interface()
 	}
 
-	// Populate cache if enabled
-	if cache.IsEnabled(to) {
+	// Populate cache if enabled; once entries have been evicted, re-adding the whole table would only evict them again
+	if cache.IsEnabled(to) && cache.IsComplete(to) {
 		logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db] - Populating Cache", entities.GetStructType(to), options, db.Name)
 		err = cache.AddEntries(result)
 		if err != nil {
@@ -161,8 +191,11 @@ func (db *DB) GetAll(to any, options ...func(*index.Options)) ([]any, error) {
 	} else {
 		logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db] - Caching Disabled or Not Initialised", entities.GetStructType(to), options, db.Name)
 	}
+	if IsSoftDelete(to) {
+		result = applyIndexOptions(withoutDeletedRecords(to, result), options...)
+	}
 
-	logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db] on %v - Returning %d entries from cache", entities.GetStructType(to), entities.GetStructType(to), db.Name, "GetAll", sliceValue.Len())
+	logHandler.InfoLogger.Printf("[GET] %v ALL [%+v] [...%v.db] on %v - Returning %d entries from cache", entities.GetStructType(to), entities.GetStructType(to), db.Name, "GetAll", len(result))
 	return result, nil
 }
 
@@ -194,9 +227,10 @@ func (db *DB) GetAllWhere(field entities.Field, value, to any) ([]any, error) {
 		return nil, err
 	}
 
-	// If caching is enabled, attempt to retrieve records from cache
-	if cache.IsEnabled(to) {
+	// If caching is enabled, and nothing has been evicted, attempt to retrieve records from cache
+	if cache.IsEnabled(to) && cache.IsComplete(to) {
 		cachedValues, err := cache.GetAllWhere(to, field, value)
+		cachedValues = withoutDeletedRecords(to, cachedValues)
 		if err == nil && len(cachedValues) > 0 {
 			logHandler.DatabaseLogger.Printf("[GET] %v WHERE %v=%v - From Cache", tableName, field.String(), value)
 			clock.Stop(len(cachedValues))
@@ -206,6 +240,7 @@ func (db *DB) GetAllWhere(field entities.Field, value, to any) ([]any, error) {
 	}
 
 	// Otherwise, use Storm's indexed query to retrieve matching records directly.
+	cacheFallback(to)
 	query := db.connection.Select(q.Eq(field.String(), value))
 	err := query.Find(to)
 	if err != nil {
@@ -242,6 +277,8 @@ func (db *DB) GetAllWhere(field entities.Field, value, to any) ([]any, error) {
 		logHandler.DatabaseLogger.Printf("[GET] %v WHERE %v=%v - Caching Disabled or Not Initialised", tableName, field.String(), value)
 	}
 
+	resultList = withoutDeletedRecords(to, resultList)
+
 	// hydrateerr := db.hydrateCacheBulk(resultList)
 	// if hydrateerr != nil {
 	// 	logHandler.ErrorLogger.Printf("[CCH]<%v>{AddBulk} Error hydrating cache: %v", tableName, hydrateerr)
@@ -260,6 +297,8 @@ func (db *DB) GetAllWhere(field entities.Field, value, to any) ([]any, error) {
 //   - error: An error object if any issues occur during the deletion process; otherwise, nil.
 func (db *DB) Delete(data any) error {
 	logHandler.DatabaseLogger.Printf("[DELETE] %v [...%v.db] (%.10s)", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+	// Make sure queued creates are written before the record is removed
+	db.flushPending()
 	err := db.connection.DeleteStruct(data)
 	if err != nil {
 		logHandler.ErrorLogger.Printf("[DELETE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
@@ -304,6 +343,23 @@ func (db *DB) Drop(data any) error {
 	return nil
 }
 
+// ReIndex rebuilds the Storm indexes of the bucket associated with the specified struct.
+//
+// Parameters:
+//   - data: A pointer to the struct representing the type whose indexes are to be rebuilt.
+//
+// Returns:
+//   - error: An error object if any issues occur during the rebuild; otherwise, nil.
+func (db *DB) ReIndex(data any) error {
+	logHandler.DatabaseLogger.Printf("[REINDEX] %v [...%v.db]", entities.GetStructType(data), db.Name)
+	err := db.connection.ReIndex(data)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[REINDEX] %v [...%v.db] - Error: %v", entities.GetStructType(data), db.Name, err)
+		return err
+	}
+	return nil
+}
+
 // Update modifies an existing record in the database.
 //
 // Parameters:
@@ -319,41 +375,45 @@ func (db *DB) Update(data any) error {
 		return commonErrors.ErrWrapper(err)
 	}
 	logHandler.DatabaseLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - End", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+	// Make sure queued creates are written before they are updated
+	db.flushPending()
 
-	if cache.IsEnabled(data) {
-		logHandler.InfoLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Updating Cache", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
-		//		godump.Dump(data, "Updating Cache with data:")
-		err := cache.AddEntry(data)
-		if err != nil {
-			logHandler.ErrorLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Error updating Cache: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
-			return err
-		}
-	}
-	// Concurrently update the database
-	// 	go bgUpdate(data, db)
-	// } else {
-	logHandler.DatabaseLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Caching Disabled or Not Initialised", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+	// Save first, so the cache never holds a change that was not stored
 	err = db.connection.Update(data)
 	if err != nil {
 		logHandler.ErrorLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Error updating DB: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
 		return err
 	}
-	//	}
 
-	return err
+	if cache.IsEnabled(data) {
+		logHandler.InfoLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Updating Cache", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+		if cacheErr := cache.AddEntry(data); cacheErr != nil {
+			// The database is the source of truth; drop the stale entry so it is reloaded.
+			logHandler.ErrorLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Error updating Cache: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), cacheErr)
+			_ = cache.RemoveEntry(data)
+		}
+	} else {
+		logHandler.DatabaseLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Caching Disabled or Not Initialised", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+	}
+	return nil
 }
 
 func bgUpdate(data any, db *DB) {
 	logHandler.DatabaseLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Caching Disabled or Not Initialised", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
 	err := db.connection.Update(data)
 	if err != nil {
-		logHandler.ErrorLogger.Panicf("[UPDATE] %v [...%v.db] (%.10s) - Error updating DB: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
+		logHandler.ErrorLogger.Printf("[UPDATE] %v [...%v.db] (%.10s) - Error updating DB: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
 		return
 	}
 }
 
 // Create adds a new record to the database.
 //
+// By default (WriteThrough) the record is saved before Create returns, and the cache is
+// only updated once the save succeeds. With WithWriteMode(WriteBehind) and caching enabled,
+// the record is cached immediately and saved by a background writer; any ID assigned by
+// Storm is only reflected in the cached copy once the save completes.
+//
 // Parameters:
 //   - data: A pointer to the struct representing the record to be created.
 //
@@ -368,26 +428,33 @@ func (db *DB) Create(data any) error {
 	}
 	logHandler.DatabaseLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - End", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
 
-	if cache.IsEnabled(data) {
-		logHandler.InfoLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Adding to Cache", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
-		err := cache.AddEntry(data)
+	if cache.IsEnabled(data) && db.writeBehind != nil {
+		// Write-behind: cache now, save from the background writer
+		logHandler.InfoLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Adding to Cache, queueing Save", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+		provisional := snapshot(data)
+		err := cache.AddEntry(provisional)
 		if err != nil {
 			logHandler.ErrorLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Error adding to Cache: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
 			return err
 		}
-		// Save to database asynchronously when cache is enabled
-		go func() {
-			saveErr := db.connection.Save(data)
-			if saveErr != nil {
-				logHandler.ErrorLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), saveErr)
-			}
-		}()
+		return db.writeBehind.enqueue(writeBehindItem{provisional: provisional, record: snapshot(data)})
+	}
+
+	// Write-through: save first, so the cache holds the record as stored (including any assigned ID)
+	err = db.connection.Save(data)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
+		return err
+	}
+
+	if cache.IsEnabled(data) {
+		logHandler.InfoLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Adding to Cache", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
+		if cacheErr := cache.AddEntry(data); cacheErr != nil {
+			// The record is saved; the cache will be corrected on the next read or hydration.
+			logHandler.ErrorLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Error adding to Cache: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), cacheErr)
+		}
 	} else {
 		logHandler.DatabaseLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Caching Disabled or Not Initialised", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data))
-		err = db.connection.Save(data)
-		if err != nil {
-			logHandler.ErrorLogger.Printf("[CREATE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), db.Name, fmt.Sprintf("%+v", data), err)
-		}
 	}
 	return err
 }
@@ -409,6 +476,9 @@ func (db *DB) Count(data any) (int, error) {
 	// for key, value := range connectionPool {
 	// 	logHandler.DatabaseLogger.Printf("[CON]<%v>{CONNECTION POOL} Connection Pool [%v] [%v] [codec=%v]", entities.GetStructType(data), key, value.databaseName, value.connection.Node.Codec().Name())
 	// }
+	if IsSoftDelete(data) {
+		return db.connection.Select(notDeleted()).Count(data)
+	}
 	return db.connection.Count(data)
 }
 
@@ -440,8 +510,20 @@ func (db *DB) CountWhere(field entities.Field, value any, to any) (int, error) {
 	// 	}
 	// 	return count, nil
 	// }
-	query := db.connection.Select(q.Eq(field.String(), value))
+	matcher := q.Eq(field.String(), value)
+	if IsSoftDelete(to) {
+		matcher = q.And(matcher, notDeleted())
+	}
+	query := db.connection.Select(matcher)
 	count, err := query.Count(to)
 	logHandler.DatabaseLogger.Printf("[COUNT] %v WHERE %+v=%+v [...%v.db] - Result: %d", entities.GetStructType(to), field.String(), value, db.Name, count)
 	return count, err
 }
+
+// cacheFallback records that a read of data is going to the database although caching is
+// enabled for it, because the cache could not answer the read.
+func cacheFallback(data any) {
+	if cache.IsEnabled(data) {
+		cache.RecordFallback(data)
+	}
+}
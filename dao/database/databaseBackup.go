@@ -0,0 +1,443 @@
+package database
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/ioHelpers"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// Compression selects how a backup is compressed.
+type Compression string
+
+const (
+	// NoCompression stores the backup as a plain database file. This is the default.
+	NoCompression Compression = "none"
+	// GzipCompression compresses the backup with gzip.
+	GzipCompression Compression = "gzip"
+	// ZstdCompression compresses the backup with Zstandard.
+	ZstdCompression Compression = "zstd"
+)
+
+// extension returns the file extension added to backups compressed with c.
+func (c Compression) extension() (string, error) {
+	switch c {
+	case NoCompression, "":
+		return "", nil
+	case GzipCompression:
+		return ".gz", nil
+	case ZstdCompression:
+		return ".zst", nil
+	default:
+		return "", fmt.Errorf("unknown backup compression %q", string(c))
+	}
+}
+
+// BackupManifestName is the name of the manifest kept in each backup folder.
+const BackupManifestName = "manifest.json"
+
+// restoreLockTimeout is how long Restore waits for another process to release the database
+// file before it gives up. The pool is held while it waits, so it is kept short.
+const restoreLockTimeout = time.Second
+
+// ErrBackupInvalid is returned by Restore when a backup does not match its manifest, or is
+// not a valid database.
+var ErrBackupInvalid = errors.New("invalid backup")
+
+// BackupManifest lists the backups in a backup folder.
+type BackupManifest struct {
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile describes a backup in a BackupManifest.
+type BackupFile struct {
+	NameSpace   string      `json:"nameSpace"`
+	File        string      `json:"file"` // name of the backup in its folder
+	Compression Compression `json:"compression"`
+	Codec       Codec       `json:"codec"`
+	Size        int64       `json:"size"`   // size of the database, uncompressed
+	SHA256      string      `json:"sha256"` // of the backup file, as stored
+	Created     time.Time   `json:"created"`
+}
+
+// manifestMu serialises updates to backup manifests.
+var manifestMu sync.Mutex
+
+// BackupTo writes a backup of the database to the folder dir, and records it, with its
+// SHA-256 checksum, in the folder's manifest.
+//
+// The backup is taken from a read transaction, so the database stays online: reads and
+// writes carry on, and the backup holds the database as it was when the backup started.
+func (db *DB) BackupTo(dir string, compression Compression) (BackupFile, error) {
+	ext, err := compression.extension()
+	if err != nil {
+		return BackupFile{}, err
+	}
+	if compression == "" {
+		compression = NoCompression
+	}
+	timer := timing.Start(db.Name, "Backup", db.databaseName)
+	logHandler.DatabaseLogger.Printf("[ADM] Backup [...%v.db] data started... %v (%v)", db.Name, dir, compression)
+	// Queued creates are written first, so they are in the backup
+	db.flushPending()
+
+	entry := BackupFile{
+		NameSpace:   db.Name,
+		File:        filepath.Base(db.databaseName) + ext,
+		Compression: compression,
+		Codec:       db.codec,
+		Created:     time.Now(),
+	}
+	target := filepath.Join(dir, entry.File)
+	entry.Size, entry.SHA256, err = db.writeBackup(target+".tmp", compression)
+	if err == nil {
+		err = os.Rename(target+".tmp", target)
+	}
+	if err == nil {
+		err = addToManifest(dir, entry)
+	}
+	if err != nil {
+		os.Remove(target + ".tmp")
+		timer.Stop(0)
+		logHandler.ErrorLogger.Printf("[ADM] Backup [...%v.db] Error: %v", db.Name, err.Error())
+		return BackupFile{}, err
+	}
+	timer.Stop(1)
+	logHandler.DatabaseLogger.Printf("[ADM] Backup [...%v.db] written to %v, %d bytes, sha256 %v", db.Name, target, entry.Size, entry.SHA256)
+	return entry, nil
+}
+
+// writeBackup writes the database to path, and returns its uncompressed size and the checksum
+// of the file written.
+func (db *DB) writeBackup(path string, compression Compression) (int64, string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	out := io.MultiWriter(f, hash)
+
+	var w io.WriteCloser
+	switch compression {
+	case GzipCompression:
+		w = gzip.NewWriter(out)
+	case ZstdCompression:
+		if w, err = zstd.NewWriter(out); err != nil {
+			return 0, "", err
+		}
+	default:
+		w = nopWriteCloser{out}
+	}
+	var size int64
+	err = db.connection.Bolt.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		_, err := tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	if err := w.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), f.Close()
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ReadBackupManifest reads the manifest of the backup folder dir.
+func ReadBackupManifest(dir string) (BackupManifest, error) {
+	var manifest BackupManifest
+	data, err := os.ReadFile(filepath.Join(dir, BackupManifestName))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// addToManifest records entry in the manifest of dir, replacing any entry for the same file.
+func addToManifest(dir string, entry BackupFile) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	files := manifest.Files[:0]
+	for _, f := range manifest.Files {
+		if f.File != entry.File {
+			files = append(files, f)
+		}
+	}
+	manifest.Files = append(files, entry)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, BackupManifestName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Restore replaces the database of the namespace nameSpace with a backup. backupPath is either
+// a backup folder, in which case the latest backup of the namespace in the folder is restored,
+// or a backup file of the namespace in such a folder.
+//
+// The backup is checked against the checksum in the manifest, unpacked next to the database,
+// and checked as a bolt database before it replaces the current file, which is kept with a
+// ".pre-restore" suffix. The namespace must not be connected, here or by another process, and
+// the caches of its tables are cleared and hydrated again once the file is replaced.
+func Restore(nameSpace, backupPath string) error {
+	nameSpace = strings.ToLower(nameSpace)
+	logHandler.DatabaseLogger.Printf("[ADM] Restore [...%v.db] from %v started", nameSpace, backupPath)
+	timer := timing.Start(nameSpace, "Restore", backupPath)
+
+	entry, file, tables, err := restoreNameSpace(nameSpace, backupPath)
+	if err != nil {
+		timer.Stop(0)
+		logHandler.ErrorLogger.Printf("[ADM] Restore [...%v.db] - Error: %v", nameSpace, err)
+		return err
+	}
+	timer.Stop(1)
+	for _, table := range tables {
+		refreshCache(entities.Table(table))
+	}
+	logHandler.DatabaseLogger.Printf("[ADM] Restore [...%v.db] completed from %v, taken %v", nameSpace, file, entry.Created.Format(time.RFC3339))
+	return nil
+}
+
+// restoreNameSpace restores the backup of the namespace, holding the pool so the namespace
+// cannot be connected while its file is replaced. It returns the tables of the file before
+// and after the restore.
+func restoreNameSpace(nameSpace, backupPath string) (BackupFile, string, []string, error) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	waitForOpen(nameSpace)
+	if connectionPool[nameSpace] != nil {
+		return BackupFile{}, "", nil, fmt.Errorf("%w: cannot restore [...%v.db]; disconnect it first", ErrInUse, nameSpace)
+	}
+	entry, file, err := findBackup(nameSpace, backupPath)
+	if err != nil {
+		return BackupFile{}, "", nil, err
+	}
+	target := ioHelpers.GetDBFileName(nameSpace)
+	before, err := fileTables(nameSpace, target)
+	if err != nil {
+		return BackupFile{}, "", nil, err
+	}
+	if err := restoreBackup(target, file, entry); err != nil {
+		return BackupFile{}, "", nil, err
+	}
+	after, err := fileTables(nameSpace, target)
+	if err != nil {
+		return BackupFile{}, "", nil, err
+	}
+	for _, table := range after {
+		if !slices.Contains(before, table) {
+			before = append(before, table)
+		}
+	}
+	return entry, file, before, nil
+}
+
+// fileTables returns the tables in the database file, which must not be locked by another
+// process. A file that does not exist has no tables.
+func fileTables(nameSpace, file string) ([]string, error) {
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	boltDB, err := bolt.Open(file, 0666, &bolt.Options{Timeout: restoreLockTimeout})
+	if err != nil {
+		if errors.Is(err, bolterrors.ErrTimeout) {
+			return nil, &ConnectError{NameSpace: nameSpace, Path: file, Reason: "file locked by another process", Err: ErrInUse}
+		}
+		return nil, err
+	}
+	defer boltDB.Close()
+	var tables []string
+	err = boltDB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			tables = append(tables, string(name))
+			return nil
+		})
+	})
+	return tables, err
+}
+
+// findBackup returns the entry, and the path, of the backup of the namespace to restore. An
+// empty nameSpace matches a backup file of any namespace.
+func findBackup(nameSpace, backupPath string) (BackupFile, string, error) {
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		return BackupFile{}, "", err
+	}
+	dir, name := backupPath, ""
+	if !info.IsDir() {
+		dir, name = filepath.Dir(backupPath), filepath.Base(backupPath)
+	}
+	files, err := ListBackupFiles(dir)
+	if err != nil {
+		return BackupFile{}, "", fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	var found *BackupFile
+	for i, f := range files {
+		if (name == "" && f.NameSpace == nameSpace) || (name != "" && f.File == name && (nameSpace == "" || f.NameSpace == nameSpace)) {
+			if found == nil || f.Created.After(found.Created) {
+				found = &files[i]
+			}
+		}
+	}
+	if found == nil && name != "" {
+		return BackupFile{}, "", fmt.Errorf("%w: %v is not a backup of [...%v.db]", ErrBackupInvalid, backupPath, nameSpace)
+	}
+	if found == nil {
+		return BackupFile{}, "", fmt.Errorf("%w: no backup of [...%v.db] in %v", ErrBackupInvalid, nameSpace, dir)
+	}
+	return *found, filepath.Join(dir, found.File), nil
+}
+
+// restoreBackup unpacks the backup file into target, once it has been checked.
+func restoreBackup(target, file string, entry BackupFile) error {
+	restoring := target + ".restoring"
+	defer os.Remove(restoring)
+	if err := unpackChecked(file, restoring, entry); err != nil {
+		return err
+	}
+
+	previous := target + ".pre-restore"
+	if _, err := os.Stat(target); err == nil {
+		if err := os.Rename(target, previous); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(restoring, target); err != nil {
+		os.Rename(previous, target)
+		return err
+	}
+	return nil
+}
+
+// unpackChecked unpacks the backup file into target, checking it against entry, and checks
+// the result is a consistent bolt database. The checksum and size are not checked for
+// backups taken before manifests were written.
+func unpackChecked(file, target string, entry BackupFile) error {
+	if entry.SHA256 != "" {
+		sum, err := fileChecksum(file)
+		if err != nil {
+			return err
+		}
+		if sum != entry.SHA256 {
+			return fmt.Errorf("%w: checksum of %v is %v, the manifest has %v", ErrBackupInvalid, file, sum, entry.SHA256)
+		}
+	}
+	size, err := unpackBackup(file, target, entry.Compression)
+	if err != nil {
+		return err
+	}
+	if entry.SHA256 != "" && size != entry.Size {
+		return fmt.Errorf("%w: %v unpacks to %d bytes, the manifest has %d", ErrBackupInvalid, file, size, entry.Size)
+	}
+	if err := checkBolt(target); err != nil {
+		return fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	return nil
+}
+
+// fileChecksum returns the SHA-256 checksum of the file at path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// unpackBackup decompresses the backup file into target, and returns its size.
+func unpackBackup(file, target string, compression Compression) (int64, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	var r io.ReadCloser
+	switch compression {
+	case GzipCompression:
+		if r, err = gzip.NewReader(in); err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+		}
+	case ZstdCompression:
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+		}
+		r = zr.IOReadCloser()
+	case NoCompression, "":
+		r = in
+	default:
+		return 0, fmt.Errorf("%w: unknown compression %q", ErrBackupInvalid, string(compression))
+	}
+	defer r.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	size, err := io.Copy(out, r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	return size, out.Close()
+}
+
+// checkBolt checks the consistency of the bolt database at path.
+func checkBolt(path string) error {
+	boltDB, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+	return boltDB.View(func(tx *bolt.Tx) error {
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
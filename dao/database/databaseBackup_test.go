@@ -0,0 +1,206 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-core/ioHelpers"
+)
+
+const testTable = "testRecord"
+
+// createTestRecords adds records with the codes given to db.
+func createTestRecords(t *testing.T, db *DB, codes ...string) {
+	t.Helper()
+	for _, code := range codes {
+		if err := db.Create(&testRecord{Code: code}); err != nil {
+			t.Fatalf("Create %v: %v", code, err)
+		}
+	}
+}
+
+// storedCodes returns the sorted codes of the records Storm holds for db.
+func storedCodes(t *testing.T, db *DB) []string {
+	t.Helper()
+	var codes []string
+	for _, record := range storedRecords(t, db) {
+		codes = append(codes, record.Code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// corruptBackup appends a byte to the backup file, so it no longer matches its manifest.
+func corruptBackup(t *testing.T, file string) {
+	t.Helper()
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("opening backup: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Fatalf("corrupting backup: %v", err)
+	}
+}
+
+func TestBackupManifest(t *testing.T) {
+	for _, compression := range []Compression{NoCompression, GzipCompression, ZstdCompression} {
+		t.Run(string(compression), func(t *testing.T) {
+			db := openTestDB(t, "test_backup_"+string(compression))
+			createTestRecords(t, db, "A", "B", "C")
+			dir := t.TempDir()
+
+			entry, err := db.BackupTo(dir, compression)
+			if err != nil {
+				t.Fatalf("BackupTo: %v", err)
+			}
+			if entry.NameSpace != db.Name || entry.Compression != compression || entry.Codec != db.codec {
+				t.Errorf("BackupTo returned %+v", entry)
+			}
+			sum, err := fileChecksum(filepath.Join(dir, entry.File))
+			if err != nil {
+				t.Fatalf("checksum: %v", err)
+			}
+			if sum != entry.SHA256 {
+				t.Errorf("backup checksum is %v, BackupTo returned %v", sum, entry.SHA256)
+			}
+
+			manifest, err := ReadBackupManifest(dir)
+			if err != nil {
+				t.Fatalf("ReadBackupManifest: %v", err)
+			}
+			if len(manifest.Files) != 1 || manifest.Files[0].SHA256 != entry.SHA256 || manifest.Files[0].File != entry.File {
+				t.Fatalf("manifest holds %+v, want %+v", manifest.Files, entry)
+			}
+		})
+	}
+}
+
+func TestBackupChecksumMismatch(t *testing.T) {
+	const nameSpace = "test_backup_corrupt"
+	db := openTestDB(t, nameSpace)
+	createTestRecords(t, db, "A")
+	dir := t.TempDir()
+	entry, err := db.BackupTo(dir, GzipCompression)
+	if err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+
+	corruptBackup(t, filepath.Join(dir, entry.File))
+	closeTestDB(t, db)
+	if err := Restore(nameSpace, dir); !errors.Is(err, ErrBackupInvalid) {
+		t.Errorf("Restore returned %v, want %v", err, ErrBackupInvalid)
+	}
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	const nameSpace = "test_restore"
+	db := openTestDB(t, nameSpace)
+	createTestRecords(t, db, "A", "B", "C")
+	dir := t.TempDir()
+	if _, err := db.BackupTo(dir, ZstdCompression); err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+	createTestRecords(t, db, "D", "E")
+
+	if err := Restore(nameSpace, dir); !errors.Is(err, ErrInUse) {
+		t.Fatalf("Restore of a connected namespace returned %v, want %v", err, ErrInUse)
+	}
+	closeTestDB(t, db)
+	if err := Restore(nameSpace, dir); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := os.Stat(ioHelpers.GetDBFileName(nameSpace) + ".pre-restore"); err != nil {
+		t.Errorf("the replaced database was not kept: %v", err)
+	}
+
+	restored, err := Open(&testRecord{}, WithNameSpace(nameSpace))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeTestDB(t, restored)
+	if got, want := storedCodes(t, restored), []string{"A", "B", "C"}; !slices.Equal(got, want) {
+		t.Errorf("restored records are %v, want %v", got, want)
+	}
+}
+
+func TestRestoreLatestBackup(t *testing.T) {
+	const nameSpace = "test_restore_latest"
+	db := openTestDB(t, nameSpace)
+	dir := t.TempDir()
+	for i := range 3 {
+		createTestRecords(t, db, fmt.Sprintf("R%d", i))
+		if _, err := db.BackupTo(dir, GzipCompression); err != nil {
+			t.Fatalf("BackupTo %d: %v", i, err)
+		}
+	}
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		t.Fatalf("ReadBackupManifest: %v", err)
+	}
+	if len(manifest.Files) == 0 {
+		t.Fatal("manifest is empty")
+	}
+
+	closeTestDB(t, db)
+	if err := Restore(nameSpace, dir); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	restored, err := Open(&testRecord{}, WithNameSpace(nameSpace))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeTestDB(t, restored)
+	if got, want := storedCodes(t, restored), []string{"R0", "R1", "R2"}; !slices.Equal(got, want) {
+		t.Errorf("restored records are %v, want %v", got, want)
+	}
+}
+
+// TestRestoreChecks checks that Restore refuses a backup of another namespace and a file
+// locked by another process, and clears the cache of the restored tables.
+func TestRestoreChecks(t *testing.T) {
+	const nameSpace = "test_restore_checks"
+	dir := t.TempDir()
+	other := openTestDB(t, "test_restore_other")
+	createTestRecords(t, other, "X")
+	otherEntry, err := other.BackupTo(dir, NoCompression)
+	if err != nil {
+		t.Fatalf("BackupTo of the other namespace: %v", err)
+	}
+	closeTestDB(t, other)
+
+	db := openTestDB(t, nameSpace, WithCaching(true))
+	createTestRecords(t, db, "A")
+	if _, err := RestoreTable(nameSpace, testTable, filepath.Join(dir, otherEntry.File)); !errors.Is(err, ErrBackupInvalid) {
+		t.Errorf("RestoreTable from another namespace's backup returned %v, want %v", err, ErrBackupInvalid)
+	}
+	if _, err := db.BackupTo(dir, ZstdCompression); err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+	createTestRecords(t, db, "B")
+	closeTestDB(t, db)
+	if err := Restore(nameSpace, t.TempDir()); !errors.Is(err, ErrBackupInvalid) {
+		t.Errorf("Restore from an empty folder returned %v, want %v", err, ErrBackupInvalid)
+	}
+	if err := Restore(nameSpace, filepath.Join(dir, otherEntry.File)); !errors.Is(err, ErrBackupInvalid) {
+		t.Errorf("Restore from another namespace's backup returned %v, want %v", err, ErrBackupInvalid)
+	}
+
+	unlock := lockTestDB(t, nameSpace)
+	checkConnectError(t, "Restore of a locked file", Restore(nameSpace, dir), ErrInUse)
+	unlock()
+	if _, err := cache.GetWhere(&testRecord{}, "Code", "B"); err != nil {
+		t.Fatalf("B is not cached before the restore: %v", err)
+	}
+	if err := Restore(nameSpace, dir); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := cache.GetWhere(&testRecord{}, "Code", "B"); err == nil {
+		t.Error("the cache still holds a record the restore removed")
+	}
+}
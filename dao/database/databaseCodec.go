@@ -0,0 +1,256 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/codec"
+	"github.com/asdine/storm/v3/codec/gob"
+	"github.com/asdine/storm/v3/codec/json"
+	"github.com/asdine/storm/v3/codec/msgpack"
+	"github.com/asdine/storm/v3/codec/sereal"
+	"github.com/mt1976/frantic-core/ioHelpers"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Codec selects how Storm encodes the records of a namespace.
+type Codec string
+
+const (
+	// JSONCodec encodes records as JSON. This is the default.
+	JSONCodec Codec = "json"
+	// GobCodec encodes records with encoding/gob.
+	GobCodec Codec = "gob"
+	// MsgpackCodec encodes records as MessagePack.
+	MsgpackCodec Codec = "msgpack"
+	// SerealCodec encodes records as Sereal.
+	SerealCodec Codec = "sereal"
+)
+
+// ErrUnknownCodec is returned for a Codec that is not supported.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// String returns the name of the codec, as Storm records it in bucket metadata.
+func (c Codec) String() string {
+	return string(c)
+}
+
+// stormCodec returns the Storm codec for c.
+func stormCodec(c Codec) (codec.MarshalUnmarshaler, error) {
+	switch c {
+	case JSONCodec, "":
+		return json.Codec, nil
+	case GobCodec:
+		return gob.Codec, nil
+	case MsgpackCodec:
+		return msgpack.Codec, nil
+	case SerealCodec:
+		return sereal.Codec, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, string(c))
+	}
+}
+
+// openStorm opens the Storm database at path with the codec, and encryption, of config.
+func openStorm(path string, config *connectionConfig) (*storm.DB, error) {
+	base, err := stormCodec(config.codec)
+	if err != nil {
+		return nil, err
+	}
+	stormCodec := codec.MarshalUnmarshaler(base)
+	if config.withEncryption {
+		if config.keyProvider == nil {
+			config.keyProvider = EnvKeyProvider(DefaultKeyEnvPrefix)
+		}
+		encrypted, err := newEncryptedCodec(base, config.keyProvider)
+		if err != nil {
+			return nil, err
+		}
+		stormCodec = encrypted
+	}
+	// Check the codec before Storm reads anything with it; Storm decodes its version with the
+	// codec when it opens the file, which fails with an obscure error on a mismatch.
+	boltDB, err := bolt.Open(path, 0666, &bolt.Options{Timeout: time.Duration(config.timeout) * time.Second, ReadOnly: config.readOnly})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCodec(boltDB, stormCodec.Name()); err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+	conn, err := storm.Open(path, storm.UseDB(boltDB), storm.Codec(stormCodec))
+	if err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// checkCodec checks that every bucket of boltDB was written with the codec want.
+//
+// Storm records the codec of a bucket in its metadata, but only checks it when the bucket is
+// written to; reads with the wrong codec fail record by record, or worse, decode garbage.
+func checkCodec(boltDB *bolt.DB, want string) error {
+	return boltDB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if bytes.HasPrefix(name, stormBucketPrefix) {
+				return nil
+			}
+			meta := bucket.Bucket([]byte("__storm_metadata"))
+			if meta == nil {
+				return nil
+			}
+			if got := string(meta.Get([]byte("codec"))); got != "" && got != want {
+				return fmt.Errorf("%w: bucket %v was written with codec %q, but the connection uses %q; connect WithCodec(%q), or migrate it with MigrateCodec",
+					storm.ErrDifferentCodec, string(name), got, want, got)
+			}
+			return nil
+		})
+	})
+}
+
+// MigrateCodec re-encodes the namespace nameSpace with the codec to, and returns the number
+// of records migrated.
+//
+// options describe the namespace as it is now, as they would be passed to Connect: its
+// WithCodec and encryption options. records holds a sample of each type stored in the
+// namespace, such as User{}; migration fails if the namespace holds a bucket no sample maps to.
+//
+// The namespace must not be connected. It is migrated into a new file, which then replaces
+// the original; the original is kept alongside it, with a ".<codec>.bak" suffix.
+func MigrateCodec(nameSpace string, to Codec, records []any, options ...Option) (int, error) {
+	config := &connectionConfig{nameSpace: nameSpace, codec: JSONCodec, timeout: 30}
+	for _, option := range options {
+		option(config)
+	}
+	config.nameSpace = strings.ToLower(config.nameSpace)
+	if _, err := stormCodec(to); err != nil {
+		return 0, err
+	}
+	poolMu.Lock()
+	waitForOpen(config.nameSpace)
+	connected := connectionPool[config.nameSpace] != nil
+	poolMu.Unlock()
+	if connected {
+		return 0, fmt.Errorf("%w: cannot migrate [...%v.db]; disconnect it first", ErrInUse, config.nameSpace)
+	}
+
+	path := ioHelpers.GetDBFileName(config.nameSpace)
+	migrating := path + ".migrating"
+	backup := path + "." + config.codec.String() + ".bak"
+	logHandler.DatabaseLogger.Printf("[ADM] MigrateCodec [...%v.db] from [%v] to [%v] started", config.nameSpace, config.codec, to)
+	timer := timing.Start(config.nameSpace, "MigrateCodec", path)
+
+	count, err := migrateCodec(path, migrating, config, to, records)
+	timer.Stop(count)
+	if err != nil {
+		os.Remove(migrating)
+		logHandler.ErrorLogger.Printf("[ADM] MigrateCodec [...%v.db] - Error: %v", config.nameSpace, err)
+		return 0, err
+	}
+	if err := os.Rename(path, backup); err != nil {
+		os.Remove(migrating)
+		return 0, err
+	}
+	if err := os.Rename(migrating, path); err != nil {
+		return 0, err
+	}
+	logHandler.DatabaseLogger.Printf("[ADM] MigrateCodec [...%v.db] completed, %d records migrated to [%v], original kept as %v", config.nameSpace, count, to, backup)
+	return count, nil
+}
+
+// migrateCodec copies the records of the database at path, opened with config, to a new
+// database at target encoded with the codec to.
+func migrateCodec(path, target string, config *connectionConfig, to Codec, records []any) (int, error) {
+	src, err := openStorm(path, config)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	targetConfig := *config
+	targetConfig.codec = to
+	dst, err := openStorm(target, &targetConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	types := make(map[string]reflect.Type, len(records))
+	for _, record := range records {
+		t := reflect.TypeOf(record)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Struct {
+			return 0, fmt.Errorf("cannot migrate %T: not a struct", record)
+		}
+		types[t.Name()] = t
+	}
+	var missing []string
+	err = src.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if _, ok := types[string(name)]; !ok && !bytes.HasPrefix(name, stormBucketPrefix) {
+				missing = append(missing, string(name))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(missing) > 0 {
+		return 0, fmt.Errorf("cannot migrate [...%v.db]: no record type given for buckets %v", config.nameSpace, missing)
+	}
+
+	count := 0
+	for name, t := range types {
+		if err := dst.Init(reflect.New(t).Interface()); err != nil {
+			return count, err
+		}
+		all := reflect.New(reflect.SliceOf(t))
+		if err := src.All(all.Interface()); err != nil {
+			return count, fmt.Errorf("reading %v: %w", name, err)
+		}
+		for i := 0; i < all.Elem().Len(); i++ {
+			if err := dst.Save(all.Elem().Index(i).Addr().Interface()); err != nil {
+				return count, fmt.Errorf("writing %v: %w", name, err)
+			}
+			count++
+		}
+		logHandler.DatabaseLogger.Printf("[ADM] MigrateCodec [...%v.db] %v - %d records", config.nameSpace, name, all.Elem().Len())
+	}
+	return count, copyCounters(src, dst)
+}
+
+// copyCounters copies Storm's auto-increment counters, so new records do not reuse ids.
+func copyCounters(src, dst *storm.DB) error {
+	return src.Bolt.View(func(srcTx *bolt.Tx) error {
+		return dst.Bolt.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+				srcMeta := bucket.Bucket([]byte("__storm_metadata"))
+				dstBucket := dstTx.Bucket(name)
+				if srcMeta == nil || dstBucket == nil {
+					return nil
+				}
+				dstMeta, err := dstBucket.CreateBucketIfNotExists([]byte("__storm_metadata"))
+				if err != nil {
+					return err
+				}
+				return srcMeta.ForEach(func(k, v []byte) error {
+					if string(k) == "codec" || v == nil {
+						return nil
+					}
+					return dstMeta.Put(k, v)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-core/ioHelpers"
+)
+
+// migrationTestDB creates the namespace with the records given, encoded with options, and
+// closes it. The files MigrateCodec leaves are removed when the test ends.
+func migrationTestDB(t *testing.T, nameSpace string, codes []string, options ...Option) {
+	t.Helper()
+	db := openTestDB(t, nameSpace, options...)
+	createTestRecords(t, db, codes...)
+	closeTestDB(t, db)
+	file := ioHelpers.GetDBFileName(nameSpace)
+	t.Cleanup(func() {
+		for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec, SerealCodec} {
+			os.Remove(file + "." + codec.String() + ".bak")
+		}
+		os.Remove(file + ".migrating")
+	})
+}
+
+func TestMigrateCodec(t *testing.T) {
+	const nameSpace = "test_migrate_codec"
+	migrationTestDB(t, nameSpace, []string{"A", "B"})
+	file := ioHelpers.GetDBFileName(nameSpace)
+
+	if _, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithCodec(GobCodec)); !errors.Is(err, storm.ErrDifferentCodec) {
+		t.Fatalf("Open with another codec returned %v, want %v", err, storm.ErrDifferentCodec)
+	}
+	for _, test := range []struct {
+		name    string
+		to      Codec
+		records []any
+	}{
+		{"unknown codec", "nope", []any{testRecord{}}},
+		{"no record types", GobCodec, nil},
+		{"not a struct", GobCodec, []any{testRecord{}, "text"}},
+	} {
+		if count, err := MigrateCodec(nameSpace, test.to, test.records); err == nil || count != 0 {
+			t.Errorf("%v: MigrateCodec returned %d, %v; want an error", test.name, count, err)
+		}
+	}
+	if _, err := os.Stat(file + ".json.bak"); !os.IsNotExist(err) {
+		t.Error("a failed migration replaced the database file")
+	}
+
+	count, err := MigrateCodec(nameSpace, GobCodec, []any{&testRecord{}})
+	if err != nil || count != 2 {
+		t.Fatalf("MigrateCodec returned %d, %v; want 2", count, err)
+	}
+	if _, err := os.Stat(file + ".json.bak"); err != nil {
+		t.Errorf("the original file was not kept: %v", err)
+	}
+	if _, err := os.Stat(file + ".migrating"); !os.IsNotExist(err) {
+		t.Error("the migration file was left behind")
+	}
+
+	db, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithCodec(GobCodec))
+	if err != nil {
+		t.Fatalf("Open with the new codec: %v", err)
+	}
+	defer closeTestDB(t, db)
+	if got := db.connection.Node.Codec().Name(); got != GobCodec.String() {
+		t.Errorf("the connection uses codec %v, want %v", got, GobCodec)
+	}
+	if got := storedCodes(t, db); !slices.Equal(got, []string{"A", "B"}) {
+		t.Errorf("the migrated file holds %v, want [A B]", got)
+	}
+	// The counters were copied, so new records do not reuse ids
+	record := &testRecord{Code: "C"}
+	if err := db.Create(record); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if record.ID != 102 {
+		t.Errorf("the next record has ID %d, want 102", record.ID)
+	}
+	if _, err := MigrateCodec(nameSpace, JSONCodec, []any{&testRecord{}}, WithCodec(GobCodec)); !errors.Is(err, ErrInUse) {
+		t.Errorf("MigrateCodec of a connected namespace returned %v, want %v", err, ErrInUse)
+	}
+}
+
+// TestMigrateCodecEncrypted checks that an encrypted namespace stays encrypted.
+func TestMigrateCodecEncrypted(t *testing.T) {
+	const nameSpace = "test_migrate_encrypted"
+	keys, err := NewKeyRing("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	migrationTestDB(t, nameSpace, []string{"A"}, WithKeyProvider(keys))
+
+	if count, err := MigrateCodec(nameSpace, MsgpackCodec, []any{&testRecord{}}, WithKeyProvider(keys)); err != nil || count != 1 {
+		t.Fatalf("MigrateCodec returned %d, %v; want 1", count, err)
+	}
+	if _, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithCodec(MsgpackCodec)); err == nil {
+		t.Error("the migrated file opened without encryption")
+	}
+	db, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithCodec(MsgpackCodec), WithKeyProvider(keys))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeTestDB(t, db)
+	if got := storedCodes(t, db); !slices.Equal(got, []string{"A"}) {
+		t.Errorf("the migrated file holds %v, want [A]", got)
+	}
+	for _, value := range storedValues(t, db) {
+		if !bytes.HasPrefix(value, encryptedMagic) {
+			t.Error("a migrated record is not encrypted")
+		}
+	}
+}
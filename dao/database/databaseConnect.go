@@ -1,33 +1,44 @@
 package database
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
-	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/cache"
 	"github.com/mt1976/frantic-amphora/dao/entities"
 	"github.com/mt1976/frantic-core/commonErrors"
 
 	"github.com/mt1976/frantic-core/ioHelpers"
 	"github.com/mt1976/frantic-core/logHandler"
 	"github.com/mt1976/frantic-core/timing"
+	bolterrors "go.etcd.io/bbolt/errors"
 )
 
 // connect establishes a database connection with the provided options
 // It applies default settings and overrides them with any specified options.
 // It also manages the connection pool to reuse existing connections.
-func connect(table any, options ...Option) *DB {
-	_ = table // currently unused
+// Connections that cannot be opened, or that the pool refuses, return a *ConnectError.
+func connect(table any, options ...Option) (*DB, error) {
 	// Create default configuration
 	config := &connectionConfig{
 		withCaching:      false,
 		Verbose:          false,
 		timeout:          30,
 		poolSize:         connectionPoolMaxSize,
+		readOnly:         false,
 		nameSpace:        "main",
 		withEncryption:   false,
+		codec:            JSONCodec,
 		indices:          []entities.Field{},
+		uniqueIndices:    []entities.Field{},
 		withCacheKey:     "ID",
 		cacheInitialised: false,
+		writeMode:        WriteThrough,
+		writeQueueSize:   1000,
+		writeRetries:     3,
+		writeRetryDelay:  100 * time.Millisecond,
 	}
 
 	// Apply all provided options
@@ -36,91 +47,155 @@ func connect(table any, options ...Option) *DB {
 	}
 
 	// Log the applied configuration
-	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Configuration for %v.db: caching: %t, cacheKey: %v, verbose: %t, timeout: %d, poolSize: %d, nameSpace: %s, encryption: %t, indices: %v",
-		config.nameSpace, config.withCaching, config.withCacheKey, config.Verbose, config.timeout, config.poolSize, config.nameSpace, config.withEncryption, config.indices)
+	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Configuration for %v.db: caching: %t, cacheKey: %v, verbose: %t, timeout: %d, poolSize: %d, readOnly: %t, nameSpace: %s, encryption: %t, codec: %v, indices: %v, uniqueIndices: %v, writeMode: %v, softDelete: %t, history: %t",
+		config.nameSpace, config.withCaching, config.withCacheKey, config.Verbose, config.timeout, config.poolSize, config.readOnly, config.nameSpace, config.withEncryption, config.codec, config.indices, config.uniqueIndices, config.writeMode, config.softDelete, config.withHistory)
 
 	if config.withCaching && config.withCacheKey == "" {
-		logHandler.DatabaseLogger.Panicf("[CON]{CONNECT} Caching enabled but no cache key provided for [...%v.db]", config.nameSpace)
-		panic(commonErrors.ErrDBConnect)
+		logHandler.ErrorLogger.Printf("[CON]{CONNECT} Caching enabled but no cache key provided for [...%v.db]", config.nameSpace)
+		return nil, &ConnectError{NameSpace: config.nameSpace, Path: ioHelpers.GetDBFileName(config.nameSpace), Reason: "caching enabled but no cache key provided", Err: commonErrors.ErrCacheNoKeyDefined}
 	}
 
 	// Ensure the name is lowercase
 	config.nameSpace = strings.ToLower(config.nameSpace)
+
+	if config.withHistory && config.writeMode == WriteBehind {
+		logHandler.ErrorLogger.Printf("[CON]{CONNECT} History enabled with write-behind for [...%v.db]", config.nameSpace)
+		return nil, &ConnectError{NameSpace: config.nameSpace, Path: ioHelpers.GetDBFileName(config.nameSpace), Reason: "history enabled with write-behind", Err: ErrHistoryWriteBehind}
+	}
+
+	if config.withEncryption && config.cacheBackend != nil {
+		logHandler.ErrorLogger.Printf("[CON]{CONNECT} Cache backend set with encryption for [...%v.db]", config.nameSpace)
+		return nil, &ConnectError{NameSpace: config.nameSpace, Path: ioHelpers.GetDBFileName(config.nameSpace), Reason: "cache backend set with encryption", Err: ErrEncryptedCacheBackend}
+	}
+
+	// Enable caching for the specified table if caching is enabled.
+	// Tables share pooled connections, so this is done before the pool is checked.
+	if config.withCaching && table != nil {
+		enableCachingForTable(table, config)
+	}
+	if table != nil {
+		setSoftDelete(table, config.softDelete)
+	}
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	waitForOpen(config.nameSpace)
 	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Opening Connection to [...%v.db] data (%v)", config.nameSpace, len(connectionPool))
 	// list the connection pool
 	if config.Verbose {
 		for key, value := range connectionPool {
-			logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Connection Pool [%v] [%v] [codec=%v]", key, value.databaseName, value.connection.Node.Codec().Name())
+			logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Connection Pool [%v] [%v] [codec=%v] [refs=%d]", key, value.databaseName, value.connection.Node.Codec().Name(), value.refs)
 		}
 	}
 	// check if connection already exists
-	if connectionPool[config.nameSpace] != nil && connectionPool[config.nameSpace].Name == config.nameSpace {
-		logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Connection already open [%v], using connection pool [%v] [codec=%v]", connectionPool[config.nameSpace].Name, connectionPool[config.nameSpace].databaseName, connectionPool[config.nameSpace].connection.Node.Codec().Name())
-		rtn := connectionPool[config.nameSpace]
-		// Update configuration in case options have changed
-		//rtn.withCaching = config.withCaching
-		//rtn.withCacheKey = config.withCacheKey
-		// rtn.verbose = config.Verbose
-		// rtn.timeout = config.timeout
-		// rtn.poolSize = config.poolSize
-		// rtn.withEncryption = config.withEncryption
-		// rtn.indices = config.indices
-		//rtn.cacheInitialised = config.cacheInitialised
-
-		return rtn
+	if rtn := connectionPool[config.nameSpace]; rtn != nil {
+		logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Connection already open [%v], using connection pool [%v] [codec=%v]", rtn.Name, rtn.databaseName, rtn.connection.Node.Codec().Name())
+		if rtn.readOnly && !config.readOnly {
+			logHandler.WarningLogger.Printf("[CON]{CONNECT} Connection [%v] is open read-only; refusing a writable connection", rtn.Name)
+			return nil, &ConnectError{NameSpace: rtn.Name, Path: rtn.databaseName, Reason: "the open connection is read-only", Err: ErrReadOnly}
+		}
+		if rtn.writeMode == WriteBehind && config.withHistory {
+			logHandler.WarningLogger.Printf("[CON]{CONNECT} Connection [%v] is open write-behind; refusing a table with history", rtn.Name)
+			return nil, &ConnectError{NameSpace: rtn.Name, Path: rtn.databaseName, Reason: "the open connection is write-behind", Err: ErrHistoryWriteBehind}
+		}
+		if reason := mismatch(rtn, config); reason != "" {
+			logHandler.WarningLogger.Printf("[CON]{CONNECT} Connection [%v] %v; refusing the connection", rtn.Name, reason)
+			return nil, &ConnectError{NameSpace: rtn.Name, Path: rtn.databaseName, Reason: reason, Err: ErrOptionsMismatch}
+		}
+		if rtn.codec != config.codec {
+			logHandler.WarningLogger.Printf("[CON]{CONNECT} Connection [%v] is already open with codec [%v]; codec [%v] ignored", rtn.Name, rtn.codec, config.codec)
+		}
+		rtn.refs++
+		logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Connection [%v] shared [refs=%d]", rtn.Name, rtn.refs)
+		return rtn, nil
+	}
+	if err := checkPool(config); err != nil {
+		logHandler.WarningLogger.Printf("[CON]{CONNECT} Refused connection to [...%v.db]: %v", config.nameSpace, err.Error())
+		return nil, err
 	}
 
 	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} (re)Opening [...%v.db] data connection", config.nameSpace)
 	// Open a new connection
+	db := &DB{}
+	if err := openConnection(db, config); err != nil {
+		return nil, err
+	}
+	if db.verbose {
+		for key, value := range connectionPool {
+			logHandler.DatabaseLogger.Printf("[CON]{CONNECT}  Connection Pool [%v] [%v] [codec=%v] %v", key, value.databaseName, value.connection.Node.Codec().Name(), value.initialised)
+		}
+	}
+	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Opened [...%v.db] data connection [codec=%v] %v", db.databaseName, db.connection.Node.Codec().Name(), db.initialised)
+	return db, nil
+}
 
-	db := DB{}
+// open opens the database file of the namespace described by config into db.
+func (db *DB) open(config *connectionConfig) error {
 	db.Name = config.nameSpace
 	db.databaseName = ioHelpers.GetDBFileName(db.Name)
 	db.initialised = false
-	// db.withCaching = config.withCaching
-	// db.withCacheKey = config.withCacheKey
+	db.config = config
 	db.verbose = config.Verbose
 	db.timeout = config.timeout
 	db.poolSize = config.poolSize
+	db.readOnly = config.readOnly
 	db.withEncryption = config.withEncryption
-	//db.indices = config.indices
-	// db.cacheInitialised = false
-	// logHandler.DatabaseLogger.Printf("[CON]{CONNECT}  Opening [...%v.db] data connection *%+v*", db.Name, db)
+	db.codec = config.codec
+	db.writeMode = config.writeMode
 	connect := timing.Start(db.Name, "Connect", db.databaseName)
-	var err error
-	db.connection, err = storm.Open(db.databaseName, storm.BoltOptions(0666, nil))
+	conn, err := openStorm(db.databaseName, config)
+	db.keyProvider = config.keyProvider
 	if err != nil {
 		connect.Stop(0)
-		logHandler.DatabaseLogger.Fatalf("[CON]{CONNECT} Opening [...%v.db] connection Error=[%v]", strings.ToLower(db.databaseName), err.Error())
-		panic(commonErrors.ErrConnectWrapper(err))
+		logHandler.ErrorLogger.Printf("[CON]{CONNECT} Opening [...%v.db] connection Error=[%v]", db.Name, err.Error())
+		if errors.Is(err, bolterrors.ErrTimeout) {
+			return &ConnectError{NameSpace: db.Name, Path: db.databaseName, Reason: fmt.Sprintf("file still locked after %ds", db.timeout), Err: ErrConnectTimeout}
+		}
+		return &ConnectError{NameSpace: db.Name, Path: db.databaseName, Reason: "cannot open database", Err: err}
 	}
-	if db.verbose {
-		logHandler.DatabaseLogger.Printf("[CON]{CONNECT}  Connection Pool [%+v]", connectionPool)
-		for key, value := range connectionPool {
-			logHandler.DatabaseLogger.Printf("[CON]{CONNECT}  Connection Pool [%v] [%v] [codec=%v]", key, value.databaseName, value.connection.Node.Codec().Name())
+	db.connection = conn
+	if db.writeMode == WriteBehind {
+		db.writeBehind = newWriteBehindQueue(db, config.writeQueueSize, config.writeRetries, config.writeRetryDelay, config.writeErrorFunc)
+	}
+	connect.Stop(1)
+	return nil
+}
+
+// enableCachingForTable activates the cache for table, if it is not already active, and
+// registers the cache key, indexes, capacity and backend from config.
+// Errors are logged; the connection can be used without the indexes.
+func enableCachingForTable(table any, config *connectionConfig) {
+	tableName := entities.GetStructType(table)
+	if !cache.IsEnabled(table) {
+		if err := cache.Activate(table); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{CONNECT} Error enabling caching for table %v [...%v.db]: %v", tableName, config.nameSpace, err.Error())
+			return
 		}
 	}
-	// Add to connection pool
-	addConnectionToPool(db, db.Name)
-	if db.verbose {
-		logHandler.DatabaseLogger.Printf("[CON]{CONNECT}  Connection Pool [%+v]", connectionPool)
-		for key, value := range connectionPool {
-			logHandler.DatabaseLogger.Printf("[CON]{CONNECT}  Connection Pool [%v] [%v] [codec=%v] %v", key, value.databaseName, value.connection.Node.Codec().Name(), value.initialised)
+	if err := cache.RegisterKey(table, config.withCacheKey); err != nil {
+		logHandler.ErrorLogger.Printf("[CON]{CONNECT} Error registering cache key %v for table %v [...%v.db]: %v", config.withCacheKey, tableName, config.nameSpace, err.Error())
+	}
+	for _, field := range config.indices {
+		if err := cache.RegisterIndex(table, field); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{CONNECT} Error registering cache index %v for table %v [...%v.db]: %v", field, tableName, config.nameSpace, err.Error())
 		}
 	}
-	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Opened [...%v.db] data connection [codec=%v] %v", db.databaseName, db.connection.Node.Codec().Name(), db.initialised)
-
-	// // Enable caching for the specified table if caching is enabled
-	// if config.withCaching && table != nil {
-	// 	enableCachingForTable(&db, table)
-	// 	if err != nil {
-	// 		logHandler.DatabaseLogger.Panicf("[CON]{CONNECT} Error enabling caching for table %v: %v", entities.GetStructType(table), err.Error())
-	// 		panic(commonErrors.ErrConnectWrapper(err))
-	// 	}
-	// }
-
-	connect.Stop(1)
-	return &db
+	for _, field := range config.uniqueIndices {
+		if err := cache.RegisterUniqueIndex(table, field); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{CONNECT} Error registering unique cache index %v for table %v [...%v.db]: %v", field, tableName, config.nameSpace, err.Error())
+		}
+	}
+	if capacity := config.cacheCapacity; capacity != nil {
+		if err := cache.RegisterCapacity(table, capacity.maxEntries, capacity.maxBytes, capacity.policy); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{CONNECT} Error setting cache capacity for table %v [...%v.db]: %v", tableName, config.nameSpace, err.Error())
+		}
+	}
+	if config.cacheBackend != nil {
+		cache.RegisterSource(table, ioHelpers.GetDBFileName(config.nameSpace))
+		if err := cache.RegisterBackend(table, config.cacheBackend); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{CONNECT} Error setting cache backend for table %v [...%v.db]: %v", tableName, config.nameSpace, err.Error())
+		}
+	}
+	logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Caching enabled for table %v [...%v.db] key: %v, indices: %v, uniqueIndices: %v", tableName, config.nameSpace, config.withCacheKey, config.indices, config.uniqueIndices)
 }
 
 // validate checks the data against validation rules before database operations
@@ -131,7 +206,7 @@ func validate(data any, db *DB) error {
 	//logHandler.DatabaseLogger.Printf("[CON]{VALIDATE} Validate [%+v] [...%v.db]", entities.GetStructType(data), db.Name)
 	err := commonErrors.HandleGoValidatorError(dataValidator.Struct(data))
 	if err != nil {
-		logHandler.DatabaseLogger.Panicf("[CON]{VALIDATE} error validating %v %v [...%v.db]", err.Error(), entities.GetStructType(data), db.Name)
+		logHandler.ErrorLogger.Printf("[CON]{VALIDATE} error validating %v %v [...%v.db]", err.Error(), entities.GetStructType(data), db.Name)
 		timer.Stop(0)
 		return commonErrors.ErrValidationWrapper(err)
 	}
@@ -141,7 +216,19 @@ func validate(data any, db *DB) error {
 
 // Connect establishes a database connection with the provided options
 // It is the primary function to initiate a connection using various configuration options.
+// It panics with a *ConnectError if the connection cannot be opened; use Open to handle the error.
 func Connect(table any, options ...Option) *DB {
+	logHandler.DatabaseLogger.Printf("[CON] %d Options ", len(options))
+	db, err := connect(table, options...)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// Open establishes a database connection with the provided options, as Connect does, but
+// returns a *ConnectError, rather than panicking, if the connection cannot be opened.
+func Open(table any, options ...Option) (*DB, error) {
 	logHandler.DatabaseLogger.Printf("[CON] %d Options ", len(options))
 	return connect(table, options...)
 }
@@ -150,20 +237,47 @@ func Connect(table any, options ...Option) *DB {
 // DEPRECATED: ConnectToNamedDB - Use Connect with WithNameSpace option instead
 func ConnectToNamedDB(name string, options ...Option) *DB {
 	logHandler.WarningLogger.Println("[CON] DEPRECATED: ConnectToNamedDB - Use Connect with WithNameSpace option instead")
-	panic("Deprecated: ConnectToNamedDB - Use Connect with WithNameSpace option instead")
-	//return connect(options...)
+	return Connect(nil, append([]Option{WithNameSpace(name)}, options...)...)
 }
 
-// Disconnect closes the database connection and removes it from the connection pool
-// It uses a timing mechanism to log the duration of the disconnection process.
-// If disconnection fails, it logs the error and panics with a wrapped disconnect error.
+// Disconnect releases this handle on the pooled connection, closing it once no handle is left.
+// It panics if the connection cannot be closed; use Close to handle the error.
 func (db *DB) Disconnect() {
+	if err := db.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// Close releases this handle on the pooled connection. Every Connect or Open of a namespace
+// shares one connection; the database file is only closed, and the connection removed from
+// the pool, when each of them has been closed.
+// It uses a timing mechanism to log the duration of the disconnection process.
+// If disconnection fails, it logs the error and returns a wrapped disconnect error.
+func (db *DB) Close() error {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if db.connection == nil || db.refs <= 0 {
+		return commonErrors.ErrDisconnectWrapper(ErrNotConnected)
+	}
+	db.refs--
+	if db.refs > 0 {
+		logHandler.DatabaseLogger.Printf("[CON]{DISCONNECT} Released [...%v.db] connection, still in use [refs=%d]", db.Name, db.refs)
+		return nil
+	}
 	timer := timing.Start(db.Name, "Disconnect", db.databaseName)
 	logHandler.DatabaseLogger.Printf("[CON]{DISCONNECT} Disconnecting [...%v.db] connection", db.Name)
+	if db.writeBehind != nil {
+		if err := db.writeBehind.close(); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{DISCONNECT} Flushing [...%v.db] write-behind queue %v ", db.Name, err.Error())
+		}
+		db.writeBehind = nil
+	}
+	db.endPause()
 	err := db.connection.Close()
 	if err != nil {
-		logHandler.DatabaseLogger.Panicf("[CON]{DISCONNECT} Closing [...%v.db] %v ", db.Name, err.Error())
-		panic(commonErrors.ErrDisconnectWrapper(err))
+		logHandler.ErrorLogger.Printf("[CON]{DISCONNECT} Closing [...%v.db] %v ", db.Name, err.Error())
+		timer.Stop(0)
+		return commonErrors.ErrDisconnectWrapper(err)
 	}
 	releaseFromConnectionPool(db)
 	logHandler.DatabaseLogger.Printf("[CON]{DISCONNECT} Closed [...%v.db] connection", db.Name)
@@ -173,14 +287,40 @@ func (db *DB) Disconnect() {
 		}
 	}
 	timer.Stop(1)
+	return nil
 }
 
-func (db *DB) Reconnect() {
-	logHandler.DatabaseLogger.Printf("[CON]{RECONNECT} Reconnecting [...%v.db] data - %+v", db.Name, db)
-	logHandler.DatabaseLogger.Printf("[CON]{RECONNECT} Connection Pool [%+v]", connectionPool)
+// Reconnect reopens a connection that has been closed, with the options it was first opened
+// with, and returns it to the pool. Handles on the connection become usable again. It does
+// nothing if the connection is open.
+// It returns a *ConnectError if the namespace has been reopened by another handle, or cannot
+// be opened.
+func (db *DB) Reconnect() error {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	waitForOpen(db.Name)
+	logHandler.DatabaseLogger.Printf("[CON]{RECONNECT} Reconnecting [...%v.db] data", db.Name)
 	for key, value := range connectionPool {
-		logHandler.DatabaseLogger.Printf("[CON]{RECONNECT} Connection Pool [%v] [%v] [codec=%v]", key, value.databaseName, value.connection.Node.Codec().Name())
+		logHandler.DatabaseLogger.Printf("[CON]{RECONNECT} Connection Pool [%v] [%v] [codec=%v] [refs=%d]", key, value.databaseName, value.connection.Node.Codec().Name(), value.refs)
+	}
+	if db.config == nil {
+		logHandler.ErrorLogger.Printf("[CON]{RECONNECT} Reconnecting [...%v.db] Error=[%v]", db.Name, ErrNotConnected)
+		return ErrNotConnected
+	}
+	if current := connectionPool[db.Name]; current != nil {
+		if current != db {
+			logHandler.ErrorLogger.Printf("[CON]{RECONNECT} Reconnecting [...%v.db] Error=[namespace has been reopened by another handle]", db.Name)
+			return &ConnectError{NameSpace: db.Name, Path: db.databaseName, Reason: "namespace has been reopened by another handle", Err: ErrInUse}
+		}
+		return nil
+	}
+	if err := checkPool(db.config); err != nil {
+		logHandler.ErrorLogger.Printf("[CON]{RECONNECT} Reconnecting [...%v.db] Error=[%v]", db.Name, err.Error())
+		return err
+	}
+	if err := openConnection(db, db.config); err != nil {
+		return err
 	}
-	connect(WithNameSpace(db.Name))
 	logHandler.DatabaseLogger.Printf("[CON]{RECONNECT} Reconnected [...%v.db] data", db.Name)
+	return nil
 }
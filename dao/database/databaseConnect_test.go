@@ -0,0 +1,185 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/ioHelpers"
+	bolt "go.etcd.io/bbolt"
+)
+
+// lockTestDB holds the file lock of nameSpace, as another process would, until the returned
+// function is called.
+func lockTestDB(t *testing.T, nameSpace string) (unlock func()) {
+	t.Helper()
+	boltDB, err := bolt.Open(ioHelpers.GetDBFileName(nameSpace), 0666, nil)
+	if err != nil {
+		t.Fatalf("locking %v: %v", nameSpace, err)
+	}
+	unlocked := false
+	unlock = func() {
+		if !unlocked {
+			unlocked = true
+			boltDB.Close()
+		}
+	}
+	t.Cleanup(unlock)
+	return unlock
+}
+
+// checkConnectError checks that err is a *ConnectError for cause.
+func checkConnectError(t *testing.T, what string, err, cause error) {
+	t.Helper()
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) || !errors.Is(err, cause) || !errors.Is(err, commonErrors.ErrDBConnect) {
+		t.Errorf("%v returned %v, want a *ConnectError for %v", what, err, cause)
+	}
+}
+
+// TestConnectTimeout checks that a connect waiting for the file lock times out, without
+// holding up connects to other namespaces.
+func TestConnectTimeout(t *testing.T) {
+	const nameSpace = "test_connect_locked"
+	removeTestDB(t, nameSpace)
+	t.Cleanup(func() { removeTestDB(t, nameSpace) })
+	lockTestDB(t, nameSpace)
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithTimeout(1))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	other := openTestDB(t, "test_connect_other")
+	select {
+	case err := <-done:
+		t.Fatalf("the locked connect returned %v before another namespace could connect", err)
+	default:
+	}
+	closeTestDB(t, other)
+
+	err := <-done
+	checkConnectError(t, "Open of a locked file", err, ErrConnectTimeout)
+	if elapsed := time.Since(started); elapsed < time.Second/2 {
+		t.Errorf("Open gave up after %v, before its timeout", elapsed)
+	}
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if connectionPool[nameSpace] != nil || connectionsOpening[nameSpace] != nil {
+		t.Error("the failed connect was left in the pool")
+	}
+}
+
+func TestConnectReadOnly(t *testing.T) {
+	const nameSpace = "test_connect_readonly"
+	db := openTestDB(t, nameSpace)
+	createTestRecords(t, db, "A")
+	closeTestDB(t, db)
+
+	readOnly, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithReadOnly(true))
+	if err != nil {
+		t.Fatalf("Open read-only: %v", err)
+	}
+	defer closeTestDB(t, readOnly)
+	var record testRecord
+	if _, err := readOnly.Get("Code", "A", &record); err != nil {
+		t.Errorf("Get on a read-only connection: %v", err)
+	}
+	if err := readOnly.Create(&testRecord{Code: "B"}); err == nil {
+		t.Error("Create on a read-only connection returned no error")
+	}
+	_, err = Open(&testRecord{}, WithNameSpace(nameSpace))
+	checkConnectError(t, "a writable Open of a read-only connection", err, ErrReadOnly)
+	if readOnly.refs != 1 {
+		t.Errorf("the connection has %d refs after the refusal, want 1", readOnly.refs)
+	}
+	shared, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithReadOnly(true))
+	if err != nil || shared != readOnly {
+		t.Fatalf("a second read-only Open returned %p, %v; want the pooled connection", shared, err)
+	}
+	closeTestDB(t, shared)
+}
+
+func TestConnectPoolFull(t *testing.T) {
+	db := openTestDB(t, "test_connect_pool")
+	poolMu.Lock()
+	open := len(connectionPool)
+	poolMu.Unlock()
+	_, err := Open(&testRecord{}, WithNameSpace("test_connect_pool_full"), WithPoolSize(open))
+	checkConnectError(t, "Open of a full pool", err, ErrPoolFull)
+	if shared, err := Open(&testRecord{}, WithNameSpace("test_connect_pool"), WithPoolSize(open)); err != nil {
+		t.Errorf("sharing a connection in a full pool returned %v", err)
+	} else {
+		closeTestDB(t, shared)
+	}
+	closeTestDB(t, db)
+}
+
+func TestReconnect(t *testing.T) {
+	const nameSpace = "test_reconnect"
+	db := openTestDB(t, nameSpace, WithTimeout(1))
+	createTestRecords(t, db, "A")
+	if err := db.Reconnect(); err != nil {
+		t.Errorf("Reconnect of an open connection: %v", err)
+	}
+	closeTestDB(t, db)
+
+	unlock := lockTestDB(t, nameSpace)
+	checkConnectError(t, "Reconnect of a locked file", db.Reconnect(), ErrConnectTimeout)
+	unlock()
+	if err := db.Reconnect(); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	if got := storedCodes(t, db); len(got) != 1 || got[0] != "A" {
+		t.Errorf("the reconnected handle reads %v, want [A]", got)
+	}
+	closeTestDB(t, db)
+
+	other, err := Open(&testRecord{}, WithNameSpace(nameSpace))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeTestDB(t, other)
+	checkConnectError(t, "Reconnect of a namespace reopened by another handle", db.Reconnect(), ErrInUse)
+	if err := (&DB{Name: "test_never_opened"}).Reconnect(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Reconnect of a handle never opened returned %v, want %v", err, ErrNotConnected)
+	}
+}
+
+// TestOpenAndCloseErrors checks that Open and Close return the errors Connect and Disconnect
+// panic with.
+func TestOpenAndCloseErrors(t *testing.T) {
+	const nameSpace = "test_open_errors"
+	removeTestDB(t, nameSpace)
+	t.Cleanup(func() { removeTestDB(t, nameSpace) })
+	_, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithCaching(true), WithCacheKey(""))
+	checkConnectError(t, "Open with caching but no cache key", err, commonErrors.ErrCacheNoKeyDefined)
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("Connect with caching but no cache key did not panic")
+			}
+		}()
+		Connect(&testRecord{}, WithNameSpace(nameSpace), WithCaching(true), WithCacheKey(""))
+	}()
+
+	db := openTestDB(t, nameSpace)
+	closeTestDB(t, db)
+	if err := db.Close(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("a second Close returned %v, want %v", err, ErrNotConnected)
+	}
+	if err := (&DB{}).Close(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Close of a DB never opened returned %v, want %v", err, ErrNotConnected)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("Disconnect of a closed DB did not panic")
+			}
+		}()
+		db.Disconnect()
+	}()
+}
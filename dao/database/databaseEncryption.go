@@ -0,0 +1,389 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/asdine/storm/v3/codec"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+	bolt "go.etcd.io/bbolt"
+)
+
+// KeyProvider supplies the AES keys used to encrypt records at rest.
+//
+// Each key has an id, which is stored with every record it encrypts, so a record can be read
+// after the current key has been rotated. Keys must be 16, 24 or 32 bytes long.
+type KeyProvider interface {
+	// CurrentKey returns the key used to encrypt records, and its id.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id.
+	Key(id string) ([]byte, error)
+}
+
+// ErrKeyNotFound is returned when a record was encrypted with a key the KeyProvider does not have.
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// DefaultKeyEnvPrefix is the prefix of the environment variables read by the default KeyProvider.
+const DefaultKeyEnvPrefix = "AMPHORA_DB_KEY"
+
+// keyRing is a KeyProvider holding a fixed set of keys.
+type keyRing struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing returns a KeyProvider holding keys, by id, which encrypts with the key current.
+func NewKeyRing(current string, keys map[string][]byte) (KeyProvider, error) {
+	ring := &keyRing{current: current, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if err := checkKey(id, key); err != nil {
+			return nil, err
+		}
+		ring.keys[id] = bytes.Clone(key)
+	}
+	if _, ok := ring.keys[current]; !ok {
+		return nil, fmt.Errorf("%w: current key %q", ErrKeyNotFound, current)
+	}
+	return ring, nil
+}
+
+func (r *keyRing) CurrentKey() (string, []byte, error) {
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *keyRing) Key(id string) ([]byte, error) {
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// envKeyProvider reads base64 keys from environment variables named "<prefix>_<id>"; the id of
+// the current key is held in "<prefix>_CURRENT".
+type envKeyProvider struct {
+	prefix string
+}
+
+// EnvKeyProvider returns a KeyProvider that reads keys from the environment. The id of the
+// current key is read from "<prefix>_CURRENT", and each key, base64 encoded, from
+// "<prefix>_<id>". For example, with the DefaultKeyEnvPrefix:
+//
+//	AMPHORA_DB_KEY_CURRENT=2025
+//	AMPHORA_DB_KEY_2025=<base64 of 32 random bytes>
+//	AMPHORA_DB_KEY_2024=<the previous key, until the database has been re-encrypted>
+func EnvKeyProvider(prefix string) KeyProvider {
+	return &envKeyProvider{prefix: prefix}
+}
+
+func (p *envKeyProvider) CurrentKey() (string, []byte, error) {
+	id := os.Getenv(p.prefix + "_CURRENT")
+	if id == "" {
+		return "", nil, fmt.Errorf("%w: %v_CURRENT is not set", ErrKeyNotFound, p.prefix)
+	}
+	key, err := p.Key(id)
+	return id, key, err
+}
+
+func (p *envKeyProvider) Key(id string) ([]byte, error) {
+	name := p.prefix + "_" + strings.ToUpper(id)
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("%w: %v is not set", ErrKeyNotFound, name)
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %v: %w", name, err)
+	}
+	return key, checkKey(id, key)
+}
+
+// checkKey checks that key can be used for AES, and that id can be stored with a record.
+func checkKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid encryption key id %q: must be 1 to 255 bytes", id)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid encryption key %q: %d bytes, must be 16, 24 or 32", id, len(key))
+	}
+}
+
+// encryptedMagic starts every encrypted record.
+var encryptedMagic = []byte("\x00AE2")
+
+// legacyEncryptedMagic starts records encrypted before subkeys were derived, which used the
+// key itself both to encrypt and to derive the nonce. They are still read, and are rewritten
+// by DB.ReEncrypt.
+var legacyEncryptedMagic = []byte("\x00AE1")
+
+// Labels of the subkeys derived, with HKDF-SHA256, from each key.
+const (
+	encryptionKeyLabel = "amphora record encryption"
+	nonceKeyLabel      = "amphora record nonce"
+)
+
+// encryptedCodec is a Storm codec that encrypts the output of another codec with AES-GCM.
+//
+// An encrypted record is stored as the magic, the length and id of its key, the nonce, and the
+// sealed data. The nonce is an HMAC of the data, so the same value always encrypts to the same
+// bytes; Storm relies on this to look up index values it has encoded with the codec. The AES
+// key and the HMAC key are separate subkeys derived from the key.
+//
+// The codec has the name of the codec it wraps, and reads records without the magic as plain
+// text, so a database written before encryption was enabled can still be opened. Its records
+// are encrypted when they are next saved, or by DB.ReEncrypt.
+type encryptedCodec struct {
+	inner codec.MarshalUnmarshaler
+	keys  KeyProvider
+
+	mu      sync.Mutex
+	ciphers map[string]*recordCipher // by key id
+}
+
+// recordCipher holds the ciphers of one key.
+type recordCipher struct {
+	aead     cipher.AEAD // encrypts with the encryption subkey
+	nonceKey []byte      // HMAC key deriving the nonce from the data
+	legacy   cipher.AEAD // encrypts with the key itself, for records in the legacy format
+}
+
+func newEncryptedCodec(inner codec.MarshalUnmarshaler, keys KeyProvider) (*encryptedCodec, error) {
+	c := &encryptedCodec{inner: inner, keys: keys, ciphers: make(map[string]*recordCipher)}
+	// Fail now, rather than on the first write, if there is no usable key
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKey(id, key); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *encryptedCodec) Name() string {
+	return c.inner.Name()
+}
+
+// cipherFor returns the ciphers for the key id.
+func (c *encryptedCodec) cipherFor(id string, key []byte) (*recordCipher, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rc, ok := c.ciphers[id]; ok {
+		return rc, nil
+	}
+	encryptionKey, err := hkdf.Key(sha256.New, key, nil, encryptionKeyLabel, len(key))
+	if err != nil {
+		return nil, err
+	}
+	nonceKey, err := hkdf.Key(sha256.New, key, nil, nonceKeyLabel, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rc := &recordCipher{aead: aead, nonceKey: nonceKey, legacy: legacy}
+	c.ciphers[id] = rc
+	return rc, nil
+}
+
+// newGCM returns an AES-GCM cipher with key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *encryptedCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.seal(data)
+}
+
+// seal encrypts data with the current key.
+func (c *encryptedCodec) seal(data []byte) ([]byte, error) {
+	id, key, err := c.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := c.cipherFor(id, key)
+	if err != nil {
+		return nil, err
+	}
+	aead := rc.aead
+	mac := hmac.New(sha256.New, rc.nonceKey)
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	header := make([]byte, 0, len(encryptedMagic)+1+len(id))
+	header = append(header, encryptedMagic...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(data)+aead.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, data, header), nil
+}
+
+func (c *encryptedCodec) Unmarshal(b []byte, v any) error {
+	data, _, err := c.open(b)
+	if err != nil {
+		return err
+	}
+	return c.inner.Unmarshal(data, v)
+}
+
+// open decrypts b, and returns the id of the key it was encrypted with. Data that is not
+// encrypted is returned as it is, with an empty id.
+func (c *encryptedCodec) open(b []byte) ([]byte, string, error) {
+	legacy := bytes.HasPrefix(b, legacyEncryptedMagic)
+	if !legacy && !bytes.HasPrefix(b, encryptedMagic) {
+		return b, "", nil
+	}
+	rest := b[len(encryptedMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return nil, "", errors.New("encrypted record is truncated")
+	}
+	id := string(rest[1 : 1+rest[0]])
+	header := b[:len(encryptedMagic)+1+len(id)]
+	key, err := c.keys.Key(id)
+	if err != nil {
+		return nil, id, err
+	}
+	rc, err := c.cipherFor(id, key)
+	if err != nil {
+		return nil, id, err
+	}
+	aead := rc.aead
+	if legacy {
+		aead = rc.legacy
+	}
+	sealed := b[len(header):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, id, errors.New("encrypted record is truncated")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], header)
+	if err != nil {
+		return nil, id, fmt.Errorf("error decrypting record with key %q: %w", id, err)
+	}
+	return data, id, nil
+}
+
+// ReEncrypt rewrites every record that is not encrypted with the current key: records written
+// with an earlier key, after a key rotation, records in the legacy format, and records written
+// before encryption was enabled. It returns the number of records rewritten.
+//
+// Index values that Storm stores with the codec, which are those of fields that are not strings
+// or numbers, are not rewritten. Rebuild them with DB.ReIndex after rotating the key.
+func (db *DB) ReEncrypt() (int, error) {
+	logHandler.DatabaseLogger.Printf("[ADM] ReEncrypt [...%v.db] started", db.Name)
+	c, ok := db.connection.Node.Codec().(*encryptedCodec)
+	if !ok {
+		logHandler.WarningLogger.Printf("[ADM] ReEncrypt [...%v.db] - Encryption is not enabled", db.Name)
+		return 0, errors.New("encryption is not enabled for " + db.Name)
+	}
+	current, _, err := c.keys.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+	timer := timing.Start(db.Name, "ReEncrypt", db.databaseName)
+	count := 0
+	err = db.connection.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if bytes.HasPrefix(name, stormBucketPrefix) {
+				return nil
+			}
+			n, err := c.reEncryptBucket(bucket, current)
+			count += n
+			return err
+		})
+	})
+	timer.Stop(count)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[ADM] ReEncrypt [...%v.db] - Error: %v", db.Name, err)
+		return 0, err
+	}
+	logHandler.DatabaseLogger.Printf("[ADM] ReEncrypt [...%v.db] completed, %d records rewritten with key [%v]", db.Name, count, current)
+	return count, nil
+}
+
+// stormBucketPrefix starts the names of the buckets Storm uses for itself.
+var stormBucketPrefix = []byte("__storm_")
+
+// reEncryptBucket rewrites the records of bucket, and of the nested buckets holding records.
+// Storm's own buckets, for indexes and metadata, are skipped.
+func (c *encryptedCodec) reEncryptBucket(bucket *bolt.Bucket, current string) (int, error) {
+	type rewrite struct{ key, value []byte }
+	var rewrites []rewrite
+	var nested [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			if !bytes.HasPrefix(k, stormBucketPrefix) {
+				nested = append(nested, bytes.Clone(k))
+			}
+			return nil
+		}
+		data, id, err := c.open(v)
+		if err != nil {
+			return err
+		}
+		if id == current && !bytes.HasPrefix(v, legacyEncryptedMagic) {
+			return nil
+		}
+		if id == "" {
+			// only plain text records are encrypted; other values, such as counters, are left alone
+			var probe any
+			if c.inner.Unmarshal(data, &probe) != nil {
+				return nil
+			}
+		}
+		sealed, err := c.seal(data)
+		if err != nil {
+			return err
+		}
+		rewrites = append(rewrites, rewrite{bytes.Clone(k), sealed})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// A bucket must not be changed while it is iterated
+	for _, r := range rewrites {
+		if err := bucket.Put(r.key, r.value); err != nil {
+			return 0, err
+		}
+	}
+	count := len(rewrites)
+	for _, name := range nested {
+		n, err := c.reEncryptBucket(bucket.Bucket(name), current)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
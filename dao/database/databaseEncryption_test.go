@@ -0,0 +1,200 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// encryptedTestDB opens an empty namespace encrypted with a fixed key, holding one record.
+func encryptedTestDB(t *testing.T, nameSpace string) (*DB, *encryptedCodec, []byte) {
+	t.Helper()
+	key := bytes.Repeat([]byte{7}, 32)
+	keys, err := NewKeyRing("k1", map[string][]byte{"k1": key})
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	db := openTestDB(t, nameSpace, WithEncryption(true), WithKeyProvider(keys))
+	if err := db.Create(&testRecord{Code: "SECRET", Name: "plain text"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c, ok := db.connection.Node.Codec().(*encryptedCodec)
+	if !ok {
+		t.Fatalf("codec is %T, want *encryptedCodec", db.connection.Node.Codec())
+	}
+	return db, c, key
+}
+
+// storedValues returns the raw values of the records of testRecord.
+func storedValues(t *testing.T, db *DB) [][]byte {
+	t.Helper()
+	var values [][]byte
+	err := db.connection.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(testTable)).ForEach(func(k, v []byte) error {
+			if v != nil {
+				values = append(values, bytes.Clone(v))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("reading %v: %v", testTable, err)
+	}
+	return values
+}
+
+func TestEncryptionUsesSubkeys(t *testing.T) {
+	db, c, key := encryptedTestDB(t, "test_encrypt_subkeys")
+	values := storedValues(t, db)
+	if len(values) != 1 {
+		t.Fatalf("%v holds %d values, want 1", testTable, len(values))
+	}
+	value := values[0]
+	if !bytes.HasPrefix(value, encryptedMagic) {
+		t.Fatalf("record does not start with %q", encryptedMagic)
+	}
+	if bytes.Contains(value, []byte("plain text")) {
+		t.Error("record holds its plain text")
+	}
+
+	data, id, err := c.open(value)
+	if err != nil || id != "k1" {
+		t.Fatalf("open returned id %q, error %v", id, err)
+	}
+	again, err := c.seal(data)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !bytes.Equal(again, value) {
+		t.Error("the same data sealed to different bytes")
+	}
+
+	header := len(encryptedMagic) + 1 + len("k1")
+	nonce := value[header : header+12]
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if bytes.Equal(nonce, mac.Sum(nil)[:12]) {
+		t.Error("the nonce is derived with the encryption key itself")
+	}
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	if _, err := aead.Open(nil, nonce, value[header+12:], value[:header]); err == nil {
+		t.Error("the record opens with the key itself, rather than its encryption subkey")
+	}
+}
+
+// TestEncryptionLeavesIndexValues checks what an encrypted file shows: the values of indexed
+// string fields, in Storm's index buckets, and nothing else of the record.
+func TestEncryptionLeavesIndexValues(t *testing.T) {
+	db, _, _ := encryptedTestDB(t, "test_encrypt_index")
+	if err := db.Create(&testRecord{Code: "OTHER", Group: "GROUP", Name: "other text"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	found := make(map[string][]string)
+	var walk func(path string, bucket *bolt.Bucket) error
+	walk = func(path string, bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return walk(path+"/"+string(k), bucket.Bucket(k))
+			}
+			for _, text := range []string{"SECRET", "OTHER", "GROUP", "plain text", "other text"} {
+				if bytes.Contains(k, []byte(text)) || bytes.Contains(v, []byte(text)) {
+					found[text] = append(found[text], path)
+				}
+			}
+			return nil
+		})
+	}
+	err := db.connection.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			return walk(string(name), bucket)
+		})
+	})
+	if err != nil {
+		t.Fatalf("reading the file: %v", err)
+	}
+
+	for _, text := range []string{"plain text", "other text"} {
+		if len(found[text]) > 0 {
+			t.Errorf("%q, which is not indexed, is readable in %v", text, found[text])
+		}
+	}
+	for text, index := range map[string]string{"SECRET": "Code", "OTHER": "Code", "GROUP": "Group"} {
+		want := testTable + "/__storm_index_" + index
+		if len(found[text]) == 0 {
+			t.Errorf("%q is not in the %v index; update the README if Storm now hides it", text, index)
+		}
+		for _, path := range found[text] {
+			if !strings.HasPrefix(path, want) {
+				t.Errorf("%q is readable in %v, outside the index bucket %v", text, path, want)
+			}
+		}
+	}
+}
+
+func TestEncryptionReadsAndRewritesLegacyRecords(t *testing.T) {
+	db, c, key := encryptedTestDB(t, "test_encrypt_legacy")
+
+	// Rewrite the record as it was sealed before subkeys were derived
+	err := db.connection.Bolt.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(testTable))
+		var k, v []byte
+		bucket.ForEach(func(key, value []byte) error {
+			if value != nil {
+				k, v = bytes.Clone(key), bytes.Clone(value)
+			}
+			return nil
+		})
+		data, _, err := c.open(v)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(k, legacySeal(t, key, "k1", data))
+	})
+	if err != nil {
+		t.Fatalf("writing legacy record: %v", err)
+	}
+
+	var record testRecord
+	if err := db.connection.One("Code", "SECRET", &record); err != nil || record.Name != "plain text" {
+		t.Fatalf("reading legacy record returned %+v, %v", record, err)
+	}
+	count, err := db.ReEncrypt()
+	if err != nil {
+		t.Fatalf("ReEncrypt: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("ReEncrypt rewrote %d records, want 1", count)
+	}
+	if values := storedValues(t, db); len(values) != 1 || !bytes.HasPrefix(values[0], encryptedMagic) {
+		t.Error("ReEncrypt did not rewrite the legacy record")
+	}
+	if count, err := db.ReEncrypt(); err != nil || count != 0 {
+		t.Errorf("second ReEncrypt rewrote %d records, error %v; want 0", count, err)
+	}
+}
+
+// legacySeal seals data as records were sealed before subkeys were derived.
+func legacySeal(t *testing.T, key []byte, id string, data []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("aes: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	header := append(append(bytes.Clone(legacyEncryptedMagic), byte(len(id))), id...)
+	out := append(bytes.Clone(header), nonce...)
+	return aead.Seal(out, nonce, data, header)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mt1976/frantic-core/commonErrors"
+)
+
+var (
+	// ErrConnectTimeout is returned when the database file is still locked by another process
+	// once the WithTimeout period has passed.
+	ErrConnectTimeout = errors.New("timed out waiting for the database file lock")
+	// ErrPoolFull is returned when opening a connection would exceed the WithPoolSize limit.
+	ErrPoolFull = errors.New("connection pool full")
+	// ErrReadOnly is returned when a namespace opened WithReadOnly is asked for a writable connection.
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrNotConnected is returned when a DB that was never opened is closed.
+	ErrNotConnected = errors.New("database is not connected")
+	// ErrInUse is returned by operations that replace the database file while it is connected.
+	ErrInUse = errors.New("database is connected")
+	// ErrSortNotIndexed is returned by GetPage when asked to sort by a field that is neither the
+	// id nor indexed.
+	ErrSortNotIndexed = errors.New("sort field is not indexed")
+	// ErrInvalidCursor is returned by GetPage for a cursor it did not issue for the same sort.
+	ErrInvalidCursor = errors.New("invalid page cursor")
+	// ErrSoftDeleteDisabled is returned when a soft-delete operation is used on a table that
+	// was not opened WithSoftDelete.
+	ErrSoftDeleteDisabled = errors.New("soft delete is not enabled")
+	// ErrHistoryDisabled is returned when the history of a table that does not keep one is
+	// read.
+	ErrHistoryDisabled = errors.New("history is not enabled")
+	// ErrHistoryWriteBehind is returned when a table that keeps a history is opened on a
+	// WriteBehind connection, where Create returns before the record has its ID.
+	ErrHistoryWriteBehind = errors.New("history cannot be kept with write-behind")
+	// ErrEncryptedCacheBackend is returned when a table on an encrypted connection is given a
+	// cache backend, which would hold its records unencrypted.
+	ErrEncryptedCacheBackend = errors.New("cache backends cannot be used with encryption")
+	// ErrOptionsMismatch is returned when a namespace is connected with encryption, a key
+	// provider or a write mode other than those of its open connection.
+	ErrOptionsMismatch = errors.New("options differ from the open connection")
+)
+
+// ConnectError describes why a connection to a namespace was refused.
+//
+// It matches commonErrors.ErrDBConnect and its cause with errors.Is, so callers can check for
+// ErrConnectTimeout, ErrPoolFull, ErrReadOnly, ErrOptionsMismatch, ErrHistoryWriteBehind,
+// ErrEncryptedCacheBackend or commonErrors.ErrCacheNoKeyDefined.
+type ConnectError struct {
+	NameSpace string
+	Path      string
+	Reason    string
+	Err       error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("%v [...%v.db]: %v: %v", commonErrors.ErrDBConnect, e.NameSpace, e.Reason, e.Err)
+}
+
+func (e *ConnectError) Unwrap() []error {
+	return []error{commonErrors.ErrDBConnect, e.Err}
+}
@@ -31,6 +31,10 @@ func GetTyped[T any](db *DB, field entities.Field, value any) (T, error) {
 	// Check if a record exists in the cache
 	if cache.IsEnabled(record) {
 		cachedValue, err := cache.GetWhere(record, field, value)
+		if err == nil && IsSoftDelete(record) && IsDeleted(cachedValue) {
+			logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v [...%v.db] - Deleted", entities.GetStructType(record), field.String(), value, db.Name)
+			return zero, storm.ErrNotFound
+		}
 		if err == nil {
 			logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v [...%v.db] - From Cache", entities.GetStructType(record), field.String(), value, db.Name)
 			return cachedValue, nil
@@ -39,9 +43,13 @@ func GetTyped[T any](db *DB, field entities.Field, value any) (T, error) {
 	}
 
 	logHandler.DatabaseLogger.Printf("[GET] %v WHERE %+v=%+v [...%v.db]", entities.GetStructType(record), field.String(), value, db.Name)
+	cacheFallback(record)
 	if err := db.connection.One(field.String(), value, &record); err != nil {
 		return zero, err
 	}
+	if IsSoftDelete(record) && IsDeleted(record) {
+		return zero, storm.ErrNotFound
+	}
 	return record, nil
 }
 
@@ -54,18 +62,26 @@ func GetAllTyped[T any](db *DB, options ...func(*index.Options)) ([]T, error) {
 		return nil, commonErrors.ErrInvalidTypeWrapper("GetAllTyped", fmt.Sprintf("%T", record), "non-pointer struct")
 	}
 
-	// Check if cache is enabled and retrieve from cache if available
-	if cache.IsEnabled(record) {
+	// Check if cache is enabled and complete, and retrieve from cache if available
+	if cache.IsEnabled(record) && cache.IsComplete(record) {
 		cachedResult, err := cache.GetAll(record)
 		if err == nil {
 			logHandler.DatabaseLogger.Printf("[GET] %v ALL [...%v.db] - From Cache", entities.GetStructType(record), db.Name)
-			return cachedResult, nil
+			return applyIndexOptions(withoutDeleted(cachedResult), options...), nil
 		}
 		logHandler.DatabaseLogger.Printf("[GET] %v ALL [...%v.db] - Not Found in Cache", entities.GetStructType(record), db.Name)
 	}
 
 	logHandler.DatabaseLogger.Printf("[GET] %v ALL [...%v.db]", entities.GetStructType(record), db.Name)
+	cacheFallback(record)
 	result := []T{}
+	if IsSoftDelete(record) {
+		// Storm's Limit and Skip would count deleted records, so they are applied after they are removed
+		if err := db.connection.All(&result); err != nil {
+			return nil, err
+		}
+		return applyIndexOptions(withoutDeleted(result), options...), nil
+	}
 	if err := db.connection.All(&result, options...); err != nil {
 		return nil, err
 	}
@@ -90,17 +106,18 @@ func GetAllWhereTyped[T any](db *DB, field entities.Field, value any) ([]T, erro
 	}
 	logHandler.DatabaseLogger.Printf("Valid field/type check passed for %v.%v=%T(%v)", entities.GetStructType(record), field.String(), value, value)
 
-	// Check if cache is enabled and retrieve from cache if available
-	if cache.IsEnabled(record) {
+	// Check if cache is enabled and complete, and retrieve from cache if available
+	if cache.IsEnabled(record) && cache.IsComplete(record) {
 		cachedResult, err := cache.GetAllWhere(record, field, value)
 		if err == nil {
 			logHandler.DatabaseLogger.Printf("[GET] %v WHERE (%+v=%+v) ALL [...%v.db] - From Cache", entities.GetStructType(record), field.String(), value, db.Name)
-			return cachedResult, nil
+			return withoutDeleted(cachedResult), nil
 		}
 		logHandler.DatabaseLogger.Printf("[GET] %v WHERE (%+v=%+v) ALL [...%v.db] - Not Found in Cache", entities.GetStructType(record), field.String(), value, db.Name)
 	}
 
 	logHandler.DatabaseLogger.Printf("[GET] %v WHERE (%+v=%+v) ALL [...%v.db]", entities.GetStructType(record), field.String(), value, db.Name)
+	cacheFallback(record)
 	result := []T{}
 	query := db.connection.Select(q.Eq(field.String(), value))
 	if err := query.Find(&result); err != nil {
@@ -111,6 +128,7 @@ func GetAllWhereTyped[T any](db *DB, field entities.Field, value any) ([]T, erro
 		logHandler.ErrorLogger.Printf("Error in GetAllWhereTyped for %v where %v=%v: %v", entities.GetStructType(record), field.String(), value, err)
 		return nil, err
 	}
+	result = withoutDeleted(result)
 	logHandler.DatabaseLogger.Printf("Found %d records for %v where %v=%v", len(result), entities.GetStructType(record), field.String(), value)
 	return result, nil
 }
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/logHandler"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Version is a snapshot of a record, as it was written by an audited action.
+type Version[T any] struct {
+	Version int       // the record's Audit.AuditSequence once the action was performed
+	At      time.Time // when the action was performed
+	Action  string    // the code of the audit action
+	User    string
+	Notes   string
+	Record  T
+}
+
+// HistoryBucket returns the name of the bucket the history of table is kept in.
+func HistoryBucket(table entities.Table) string {
+	return string(table) + "History"
+}
+
+// SaveVersion adds version to the history of the record id of type T, through writer, so a
+// version written in a transaction is only kept if it commits. A version already saved with
+// the same number is replaced.
+func SaveVersion[T any](writer Writer, id int, version Version[T]) error {
+	var node storm.Node
+	var name string
+	switch w := writer.(type) {
+	case *DB:
+		node, name = w.connection, w.Name
+	case *Tx:
+		node, name = w.node, w.db.Name
+	default:
+		return fmt.Errorf("saving version: unsupported writer %T", writer)
+	}
+	bucket := HistoryBucket(entities.GetStructType(version.Record))
+	if id <= 0 {
+		// a record created write-behind has no ID until it is saved
+		return fmt.Errorf("saving version of %v: record has no ID", bucket)
+	}
+	logHandler.DatabaseLogger.Printf("[HISTORY] %v %v VERSION %v %v [...%v.db]", bucket, id, version.Version, version.Action, name)
+	return node.From(bucket).Set(strconv.Itoa(id), version.Version, &version)
+}
+
+// History returns the versions of the record id of type T, oldest first. A record with no
+// history has none.
+func History[T any](db *DB, id int) ([]Version[T], error) {
+	var record T
+	bucketName := HistoryBucket(entities.GetStructType(record))
+	var versions []Version[T]
+	err := db.connection.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := db.connection.GetBucket(tx, bucketName, strconv.Itoa(id))
+		if bucket == nil {
+			return nil
+		}
+		codec := db.connection.Codec()
+		return bucket.ForEach(func(k, v []byte) error {
+			// a nested bucket holds Storm's metadata
+			if v == nil {
+				return nil
+			}
+			var version Version[T]
+			if err := codec.Unmarshal(v, &version); err != nil {
+				return fmt.Errorf("decoding %v %v version: %w", bucketName, id, err)
+			}
+			versions = append(versions, version)
+			return nil
+		})
+	})
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[HISTORY] %v %v [...%v.db] - Error: %v", bucketName, id, db.Name, err)
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetVersion returns the version numbered version of the record id of type T. If there is
+// none, the Storm ErrNotFound error is returned.
+func GetVersion[T any](db *DB, id, version int) (Version[T], error) {
+	versions, err := History[T](db, id)
+	if err != nil {
+		return Version[T]{}, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version[T]{}, storm.ErrNotFound
+}
+
+// VersionAsOf returns the version of the record id of type T that was current at the time
+// at: the last written at or before it. If the record did not exist then, or had been
+// deleted, the Storm ErrNotFound error is returned.
+func VersionAsOf[T any](db *DB, id int, at time.Time) (Version[T], error) {
+	versions, err := History[T](db, id)
+	if err != nil {
+		return Version[T]{}, err
+	}
+	found := -1
+	for i, v := range versions {
+		if !v.At.After(at) {
+			found = i
+		}
+	}
+	if found < 0 || IsDeleted(versions[found].Record) {
+		return Version[T]{}, storm.ErrNotFound
+	}
+	return versions[found], nil
+}
+
+// DropHistory removes the history of every record of type T.
+func DropHistory[T any](db *DB) error {
+	var record T
+	bucketName := HistoryBucket(entities.GetStructType(record))
+	logHandler.DatabaseLogger.Printf("[HISTORY] DROP %v [...%v.db]", bucketName, db.Name)
+	err := db.connection.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(bucketName))
+	})
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+)
+
+// historyVersion returns the version numbered number of record 1, with code, saved at at.
+func historyVersion(number int, code string, at time.Time) Version[softDeleteRecord] {
+	return Version[softDeleteRecord]{Version: number, At: at, Action: "UPDATE", User: "test", Record: softDeleteRecord{ID: 1, Code: code}}
+}
+
+// historyCodes returns the codes of the history of record 1, oldest first.
+func historyCodes(t *testing.T, db *DB) []string {
+	t.Helper()
+	versions, err := History[softDeleteRecord](db, 1)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	var codes []string
+	for _, v := range versions {
+		codes = append(codes, v.Record.Code)
+	}
+	return codes
+}
+
+func TestHistory(t *testing.T) {
+	db := openTestDB(t, "test_history")
+	start := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	if got := historyCodes(t, db); len(got) != 0 {
+		t.Errorf("a record with no history has %v", got)
+	}
+	// saved out of order, and numbered so that a text sort would put 10 before 2
+	for _, v := range []Version[softDeleteRecord]{
+		historyVersion(10, "C", start.Add(2*time.Hour)),
+		historyVersion(1, "A", start),
+		historyVersion(2, "wrong", start.Add(time.Hour)),
+		historyVersion(2, "B", start.Add(time.Hour)),
+	} {
+		if err := SaveVersion(db, 1, v); err != nil {
+			t.Fatalf("SaveVersion %d: %v", v.Version, err)
+		}
+	}
+	if got, want := historyCodes(t, db), []string{"A", "B", "C"}; !slices.Equal(got, want) {
+		t.Errorf("History returned %v, want %v", got, want)
+	}
+	if v, err := GetVersion[softDeleteRecord](db, 1, 2); err != nil || v.Record.Code != "B" {
+		t.Errorf("GetVersion 2 returned %+v, %v", v, err)
+	}
+	if _, err := GetVersion[softDeleteRecord](db, 1, 3); !errors.Is(err, storm.ErrNotFound) {
+		t.Errorf("GetVersion of a missing version returned %v, want %v", err, storm.ErrNotFound)
+	}
+
+	for _, test := range []struct {
+		at   time.Time
+		want string
+	}{
+		{start.Add(-time.Second), ""},
+		{start, "A"},
+		{start.Add(90 * time.Minute), "B"},
+		{start.Add(24 * time.Hour), "C"},
+	} {
+		v, err := VersionAsOf[softDeleteRecord](db, 1, test.at)
+		if test.want == "" {
+			if !errors.Is(err, storm.ErrNotFound) {
+				t.Errorf("VersionAsOf %v returned %+v, %v; want %v", test.at, v, err, storm.ErrNotFound)
+			}
+			continue
+		}
+		if err != nil || v.Record.Code != test.want {
+			t.Errorf("VersionAsOf %v returned %+v, %v; want %v", test.at, v, err, test.want)
+		}
+	}
+
+	// once the record is deleted, it did not exist
+	deleted := historyVersion(11, "C", start.Add(3*time.Hour))
+	deleted.Record.Audit.DeletedAt = deleted.At
+	if err := SaveVersion(db, 1, deleted); err != nil {
+		t.Fatalf("SaveVersion: %v", err)
+	}
+	if _, err := VersionAsOf[softDeleteRecord](db, 1, start.Add(24*time.Hour)); !errors.Is(err, storm.ErrNotFound) {
+		t.Errorf("VersionAsOf after the delete returned %v, want %v", err, storm.ErrNotFound)
+	}
+
+	if err := SaveVersion(db, 0, historyVersion(1, "A", start)); err == nil {
+		t.Error("SaveVersion of a record with no ID returned no error")
+	}
+	if err := DropHistory[softDeleteRecord](db); err != nil {
+		t.Fatalf("DropHistory: %v", err)
+	}
+	if got := historyCodes(t, db); len(got) != 0 {
+		t.Errorf("History after DropHistory returned %v", got)
+	}
+	if err := DropHistory[softDeleteRecord](db); err != nil {
+		t.Errorf("DropHistory with no history: %v", err)
+	}
+}
+
+// TestHistoryInTx checks that versions saved in a transaction are only kept if it commits.
+func TestHistoryInTx(t *testing.T) {
+	db := openTestDB(t, "test_history_tx")
+	start := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	if err := SaveVersion(db, 1, historyVersion(1, "A", start)); err != nil {
+		t.Fatalf("SaveVersion: %v", err)
+	}
+
+	failed := errors.New("failed")
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		if err := SaveVersion(tx, 1, historyVersion(2, "B", start.Add(time.Hour))); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("WithTx returned %v, want %v", err, failed)
+	}
+	if got := historyCodes(t, db); !slices.Equal(got, []string{"A"}) {
+		t.Errorf("History after a rollback returned %v, want [A]", got)
+	}
+	if v, err := VersionAsOf[softDeleteRecord](db, 1, start.Add(2*time.Hour)); err != nil || v.Record.Code != "A" {
+		t.Errorf("VersionAsOf after a rollback returned %+v, %v; want A", v, err)
+	}
+
+	err = db.WithTx(context.Background(), func(tx *Tx) error {
+		return SaveVersion(tx, 1, historyVersion(2, "B", start.Add(time.Hour)))
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if got := historyCodes(t, db); !slices.Equal(got, []string{"A", "B"}) {
+		t.Errorf("History after a commit returned %v, want [A B]", got)
+	}
+	if v, err := GetVersion[softDeleteRecord](db, 1, 2); err != nil || v.Record.Code != "B" {
+		t.Errorf("GetVersion after a commit returned %+v, %v; want B", v, err)
+	}
+}
@@ -1,16 +1,20 @@
 package database
 
 import (
+	"sync"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/mt1976/frantic-core/commonConfig"
 	"github.com/mt1976/frantic-core/logHandler"
 )
 
 var (
-	connectionPool        map[string]*DB         = make(map[string]*DB)                                 // map of database connections, indexed by domain.
-	connectionPoolMaxSize int                    = 10                                                   // maximum number of connections
-	cfg                   *commonConfig.Settings = commonConfig.Get()                                   // configuration settings
-	dataValidator         *validator.Validate    = validator.New(validator.WithRequiredStructEnabled()) // data validator
+	connectionPool        map[string]*DB           = make(map[string]*DB)                                 // map of database connections, indexed by domain.
+	poolMu                sync.Mutex                                                                      // guards connectionPool, connectionsOpening and the refs of the connections
+	connectionsOpening    map[string]chan struct{} = make(map[string]chan struct{})                       // namespaces being opened, each closed once its open ends
+	connectionPoolMaxSize int                      = 10                                                   // maximum number of connections
+	cfg                   *commonConfig.Settings   = commonConfig.Get()                                   // configuration settings
+	dataValidator         *validator.Validate      = validator.New(validator.WithRequiredStructEnabled()) // data validator
 )
 
 // init initializes the database package
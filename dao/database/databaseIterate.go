@@ -0,0 +1,120 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+	bolt "go.etcd.io/bbolt"
+)
+
+// EachBatchSize is the number of records Each reads from the database at a time.
+var EachBatchSize = 500
+
+// errStopIteration ends Each early when the consumer of Iterate stops.
+var errStopIteration = errors.New("stop iteration")
+
+// Each calls fn with each record of type T, in key order, and stops at the first error fn
+// returns, which Each returns.
+//
+// Records are read straight from the database, EachBatchSize at a time, so the table is never
+// held in memory, and the cache is neither read nor filled. No transaction is open while fn
+// runs, so fn may write to the database, even to the record it was given.
+//
+// NOTE: T is expected to be a struct type (not a pointer).
+func Each[T any](db *DB, fn func(T) error) error {
+	var zero T
+	typ := reflect.TypeOf(zero)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return commonErrors.ErrInvalidTypeWrapper("Each", fmt.Sprintf("%T", zero), "non-pointer struct")
+	}
+	logHandler.DatabaseLogger.Printf("[EACH] %v ALL [...%v.db]", entities.GetStructType(zero), db.Name)
+	// Queued creates are written first, so they are included
+	db.flushPending()
+
+	var after []byte
+	count := 0
+	for {
+		batch, last, err := readBatch[T](db, typ.Name(), after)
+		if err != nil {
+			return err
+		}
+		for _, record := range batch {
+			if err := fn(record); err != nil {
+				return err
+			}
+			count++
+		}
+		if len(batch) < EachBatchSize {
+			break
+		}
+		after = last
+	}
+	logHandler.DatabaseLogger.Printf("[EACH] %v ALL [...%v.db] - %d records", entities.GetStructType(zero), db.Name, count)
+	return nil
+}
+
+// readBatch decodes up to EachBatchSize records from the bucket, starting after the key after,
+// and returns them with the key of the last.
+func readBatch[T any](db *DB, bucketName string, after []byte) ([]T, []byte, error) {
+	var batch []T
+	var last []byte
+	err := db.connection.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := db.connection.GetBucket(tx, bucketName)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.First()
+		if after != nil {
+			k, v = c.Seek(after)
+			if k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		codec := db.connection.Codec()
+		for ; k != nil && len(batch) < EachBatchSize; k, v = c.Next() {
+			// nested buckets hold Storm's indexes and metadata
+			if v == nil {
+				continue
+			}
+			var record T
+			if err := codec.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("decoding %v record: %w", bucketName, err)
+			}
+			batch = append(batch, record)
+			last = bytes.Clone(k)
+		}
+		return nil
+	})
+	return batch, last, err
+}
+
+// Iterate returns an iterator over the records of type T, read as Each reads them. An error
+// ends the iteration, and is yielded with the zero value of T.
+//
+//	for record, err := range database.Iterate[User](db) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func Iterate[T any](db *DB) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		err := Each(db, func(record T) error {
+			if !yield(record, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopIteration) {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// eachCodes returns the codes Each visits, in order.
+func eachCodes(t *testing.T, db *DB) []string {
+	t.Helper()
+	var codes []string
+	if err := Each(db, func(record testRecord) error {
+		codes = append(codes, record.Code)
+		return nil
+	}); err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	return codes
+}
+
+func TestEach(t *testing.T) {
+	defer func(size int) { EachBatchSize = size }(EachBatchSize)
+	EachBatchSize = 2
+	// the index is a nested bucket, which Each must skip
+	db := openTestDB(t, "test_each", WithIndex("Group"))
+
+	if got := eachCodes(t, db); len(got) != 0 {
+		t.Errorf("Each of an empty table visited %v", got)
+	}
+	var want []string
+	for i := range 5 {
+		want = append(want, fmt.Sprintf("R%d", i))
+		createTestRecords(t, db, want[i])
+		// batches that end exactly on the last record, and part way through the next
+		if got := eachCodes(t, db); !slices.Equal(got, want) {
+			t.Errorf("Each of %d records visited %v, want %v", i+1, got, want)
+		}
+	}
+
+	// fn may write while Each runs, and its error stops Each
+	stop := errors.New("stop")
+	visited := 0
+	err := Each(db, func(record testRecord) error {
+		visited++
+		record.Name = "seen"
+		if err := db.Update(&record); err != nil {
+			return err
+		}
+		if visited == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || visited != 3 {
+		t.Errorf("Each returned %v after %d records, want %v after 3", err, visited, stop)
+	}
+	seen := 0
+	for _, record := range storedRecords(t, db) {
+		if record.Name == "seen" {
+			seen++
+		}
+	}
+	if seen != 3 {
+		t.Errorf("%d records were updated from Each, want 3", seen)
+	}
+
+	if err := Each(db, func(*testRecord) error { return nil }); err == nil {
+		t.Error("Each of a pointer type returned no error")
+	}
+}
+
+// TestEachPending checks that Each includes records still queued by write-behind, and
+// decrypts encrypted records.
+func TestEachPending(t *testing.T) {
+	db := writeBehindDB(t, "test_each_pending")
+	createTestRecords(t, db, "A", "B")
+	if got := eachCodes(t, db); !slices.Equal(got, []string{"A", "B"}) {
+		t.Errorf("Each of queued records visited %v, want [A B]", got)
+	}
+	encrypted, _, _ := encryptedTestDB(t, "test_each_encrypted")
+	if got := eachCodes(t, encrypted); !slices.Equal(got, []string{"SECRET"}) {
+		t.Errorf("Each of an encrypted table visited %v, want [SECRET]", got)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	defer func(size int) { EachBatchSize = size }(EachBatchSize)
+	EachBatchSize = 2
+	db := openTestDB(t, "test_iterate")
+	createTestRecords(t, db, "A", "B", "C", "D")
+
+	var codes []string
+	for record, err := range Iterate[testRecord](db) {
+		if err != nil {
+			t.Fatalf("Iterate: %v", err)
+		}
+		codes = append(codes, record.Code)
+		if record.Code == "C" {
+			break
+		}
+	}
+	if !slices.Equal(codes, []string{"A", "B", "C"}) {
+		t.Errorf("Iterate visited %v before the break, want [A B C]", codes)
+	}
+
+	errs := 0
+	for record, err := range Iterate[*testRecord](db) {
+		if err == nil || record != nil {
+			t.Errorf("Iterate of a pointer type yielded %v, %v", record, err)
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("Iterate of a pointer type yielded %d errors, want 1", errs)
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// ErrMigrationConflict is returned when a migration is registered over the versions of one
+// already registered.
+var ErrMigrationConflict = errors.New("migration overlaps a registered migration")
+
+// MigrationFunc upgrades a record from one schema version to the next.
+type MigrationFunc[T any] func(T) (T, error)
+
+// Migrations holds the ordered schema migrations of a table, and applies them to records
+// written at an older version.
+//
+// Each migration takes records from one version to a later one. Versions with no migration
+// between them need none: a record at version 2, with migrations registered from 1 to 2 and
+// from 3 to 4, is upgraded by the second only.
+type Migrations[T any] struct {
+	table string
+	mu    sync.RWMutex
+	steps []migrationStep[T] // sorted by from
+}
+
+type migrationStep[T any] struct {
+	from, to int
+	fn       MigrationFunc[T]
+}
+
+// NewMigrations returns an empty migration registry for the table.
+func NewMigrations[T any](table string) *Migrations[T] {
+	return &Migrations[T]{table: table}
+}
+
+// Register adds the migration from the version from to the version to, which must be later.
+// Migrations must not overlap.
+func (m *Migrations[T]) Register(from, to int, fn MigrationFunc[T]) error {
+	if to <= from {
+		return fmt.Errorf("invalid migration of %v from version %d to %d", m.table, from, to)
+	}
+	if fn == nil {
+		return fmt.Errorf("invalid migration of %v from version %d to %d: no function", m.table, from, to)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.steps {
+		if from < s.to && s.from < to {
+			return fmt.Errorf("%w: %v from version %d to %d, and from %d to %d", ErrMigrationConflict, m.table, from, to, s.from, s.to)
+		}
+	}
+	m.steps = append(m.steps, migrationStep[T]{from: from, to: to, fn: fn})
+	sort.Slice(m.steps, func(i, j int) bool { return m.steps[i].from < m.steps[j].from })
+	logHandler.DatabaseLogger.Printf("[MIGRATE] Registered migration of %v from version %d to %d", m.table, from, to)
+	return nil
+}
+
+// Pending reports whether a record at version has a migration to apply on the way to target.
+func (m *Migrations[T]) Pending(version, target int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, s := range m.steps {
+		if s.from >= version && s.to <= target {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply upgrades record, written at version, with each migration on the way to target, in
+// order. Migrations beyond target are not applied. If a migration fails, the record is
+// returned as it was.
+func (m *Migrations[T]) Apply(record T, version, target int) (T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	upgraded := record
+	for _, s := range m.steps {
+		if s.from < version || s.to > target {
+			continue
+		}
+		next, err := s.fn(upgraded)
+		if err != nil {
+			return record, fmt.Errorf("migrating %v from version %d to %d: %w", m.table, s.from, s.to, err)
+		}
+		upgraded = next
+	}
+	return upgraded, nil
+}
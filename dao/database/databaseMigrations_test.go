@@ -0,0 +1,82 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// step returns a migration that appends "from>to" to the record.
+func step(from, to int) MigrationFunc[string] {
+	return func(record string) (string, error) {
+		return record + fmt.Sprintf(" %d>%d", from, to), nil
+	}
+}
+
+func TestMigrationsRegister(t *testing.T) {
+	m := NewMigrations[string]("testRecord")
+	if err := m.Register(1, 3, step(1, 3)); err != nil {
+		t.Fatalf("Register 1>3: %v", err)
+	}
+	for _, test := range []struct {
+		name     string
+		from, to int
+		fn       MigrationFunc[string]
+		conflict bool
+		ok       bool
+	}{
+		{"backwards", 3, 2, step(3, 2), false, false},
+		{"to itself", 3, 3, step(3, 3), false, false},
+		{"no function", 3, 4, nil, false, false},
+		{"overlapping the start", 0, 2, step(0, 2), true, false},
+		{"overlapping the end", 2, 4, step(2, 4), true, false},
+		{"inside", 1, 2, step(1, 2), true, false},
+		{"around", 0, 5, step(0, 5), true, false},
+		{"the same", 1, 3, step(1, 3), true, false},
+		{"after", 3, 4, step(3, 4), false, true},
+		{"before", 0, 1, step(0, 1), false, true},
+	} {
+		err := m.Register(test.from, test.to, test.fn)
+		if test.ok != (err == nil) || test.conflict != errors.Is(err, ErrMigrationConflict) {
+			t.Errorf("Register %v (%d>%d) returned %v", test.name, test.from, test.to, err)
+		}
+	}
+}
+
+func TestMigrationsApply(t *testing.T) {
+	m := NewMigrations[string]("testRecord")
+	// registered out of order, to check they are applied in version order
+	for _, s := range [][2]int{{5, 7}, {3, 4}, {1, 2}, {0, 1}} {
+		if err := m.Register(s[0], s[1], step(s[0], s[1])); err != nil {
+			t.Fatalf("Register %d>%d: %v", s[0], s[1], err)
+		}
+	}
+	for _, test := range []struct {
+		version, target int
+		want            string
+		pending         bool
+	}{
+		{0, 7, "r 0>1 1>2 3>4 5>7", true},
+		{2, 7, "r 3>4 5>7", true},
+		{0, 2, "r 0>1 1>2", true},
+		{3, 6, "r 3>4", true}, // 5>7 goes beyond the target
+		{4, 4, "r", false},
+		{7, 7, "r", false},
+	} {
+		got, err := m.Apply("r", test.version, test.target)
+		if err != nil || got != test.want {
+			t.Errorf("Apply from %d to %d returned %q, %v; want %q", test.version, test.target, got, err, test.want)
+		}
+		if pending := m.Pending(test.version, test.target); pending != test.pending {
+			t.Errorf("Pending from %d to %d returned %t, want %t", test.version, test.target, pending, test.pending)
+		}
+	}
+
+	failed := errors.New("migration failed")
+	if err := m.Register(7, 8, func(string) (string, error) { return "changed", failed }); err != nil {
+		t.Fatalf("Register 7>8: %v", err)
+	}
+	if got, err := m.Apply("r", 0, 8); !errors.Is(err, failed) || got != "r" {
+		t.Errorf("Apply with a failing migration returned %q, %v; want the record unchanged and %v", got, err, failed)
+	}
+}
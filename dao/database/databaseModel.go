@@ -1,7 +1,10 @@
 package database
 
 import (
+	"sync"
+
 	"github.com/asdine/storm/v3"
+	bolt "go.etcd.io/bbolt"
 )
 
 // DB represents a database connection and its configuration
@@ -15,7 +18,17 @@ type DB struct {
 	verbose        bool
 	timeout        int
 	poolSize       int
+	readOnly       bool
 	withEncryption bool
+	keyProvider    KeyProvider
+	codec          Codec
+	writeMode      WriteMode
+	writeBehind    *writeBehindQueue
+	config         *connectionConfig // the options the connection was opened with, for Reconnect
+	refs           int               // handles on the pooled connection, guarded by poolMu
+	pauseMu        sync.Mutex
+	pauses         int
+	pauseTx        *bolt.Tx // held open while paused, to block writers
 	//indices        []Field
 	//	cacheInitialised bool
 	// cachedTables  map[string]bool
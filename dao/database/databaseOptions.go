@@ -1,6 +1,9 @@
 package database
 
 import (
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
 	"github.com/mt1976/frantic-amphora/dao/entities"
 	"github.com/mt1976/frantic-core/logHandler"
 )
@@ -12,10 +15,53 @@ type connectionConfig struct {
 	Verbose          bool
 	timeout          int
 	poolSize         int
+	readOnly         bool
 	nameSpace        string
 	withEncryption   bool
+	keyProvider      KeyProvider
+	codec            Codec
 	indices          []entities.Field
+	uniqueIndices    []entities.Field
+	cacheCapacity    *cacheCapacity
+	cacheBackend     cache.BackendFactory
 	cacheInitialised bool
+	writeMode        WriteMode
+	writeQueueSize   int
+	writeRetries     int
+	writeRetryDelay  time.Duration
+	writeErrorFunc   WriteErrorHandler
+	softDelete       bool
+	withHistory      bool
+}
+
+// WriteMode controls how DB.Create persists records when caching is enabled.
+type WriteMode int
+
+const (
+	// WriteThrough saves the record to the database before adding it to the cache.
+	// Create only returns once the save is confirmed. This is the default.
+	WriteThrough WriteMode = iota
+	// WriteBehind adds the record to the cache and queues the save to a background writer.
+	// Queued saves are retried, and flushed by DB.Flush and DB.Disconnect.
+	WriteBehind
+)
+
+// String returns the name of the write mode.
+func (m WriteMode) String() string {
+	if m == WriteBehind {
+		return "WriteBehind"
+	}
+	return "WriteThrough"
+}
+
+// WriteErrorHandler is called when a write-behind save has failed after all retries.
+type WriteErrorHandler func(data any, err error)
+
+// cacheCapacity holds the cache limits set by WithCacheCapacity.
+type cacheCapacity struct {
+	maxEntries int
+	maxBytes   int64
+	policy     cache.EvictionPolicy
 }
 
 // Option is a function that configures the database connection
@@ -45,7 +91,8 @@ func WithVerbose(enabled bool) Option {
 	}
 }
 
-// WithTimeout sets the connection timeout in seconds
+// WithTimeout sets how long, in seconds, to wait for another process to release the database
+// file. By default, 30 seconds; zero waits indefinitely.
 func WithTimeout(seconds int) Option {
 	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithTimeout set to %d", seconds)
 	return func(c *connectionConfig) {
@@ -53,7 +100,9 @@ func WithTimeout(seconds int) Option {
 	}
 }
 
-// WithPoolSize sets the maximum connection pool size
+// WithPoolSize sets the maximum connection pool size. A new connection is refused with
+// ErrPoolFull if the pool already holds this many connections. By default, the configured
+// database pool size is used.
 func WithPoolSize(size int) Option {
 	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithPoolSize set to %d", size)
 	return func(c *connectionConfig) {
@@ -61,6 +110,15 @@ func WithPoolSize(size int) Option {
 	}
 }
 
+// WithReadOnly opens the database file read-only, so it can be shared with other processes
+// that also open it read-only. Writes fail with a bbolt read-only error.
+func WithReadOnly(enabled bool) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithReadOnly set to %v", enabled)
+	return func(c *connectionConfig) {
+		c.readOnly = enabled
+	}
+}
+
 // WithNameSpace sets the namespace (database name) for the connection
 func WithNameSpace(name string) Option {
 	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithNameSpace set to %s", name)
@@ -69,13 +127,127 @@ func WithNameSpace(name string) Option {
 	}
 }
 
-// WithEncryption enables or disables encryption for the database connection
-// By default, encryption is disabled.
-// Reserved for Future use.
+// WithEncryption enables or disables encryption of records at rest for the database connection.
+// By default, encryption is disabled. Keys are read from the environment by
+// EnvKeyProvider(DefaultKeyEnvPrefix), unless WithKeyProvider is used.
+//
+// Storm keeps the values of indexed and unique string and number fields unencrypted in its
+// index buckets, and equal records encrypt to equal bytes; see the package README.
 func WithEncryption(enabled bool) Option {
 	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithEncryption set to %v", enabled)
-	logHandler.WarningLogger.Printf("WithEncryption is not yet implemented")
 	return func(c *connectionConfig) {
 		c.withEncryption = enabled
 	}
 }
+
+// WithKeyProvider enables encryption of records at rest, using keys from provider.
+func WithKeyProvider(provider KeyProvider) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithKeyProvider set")
+	return func(c *connectionConfig) {
+		c.withEncryption = true
+		c.keyProvider = provider
+	}
+}
+
+// WithCodec sets the codec Storm uses to encode records. By default, JSONCodec is used.
+// A namespace must always be opened with the codec it was written with; use MigrateCodec to
+// change it.
+func WithCodec(codec Codec) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithCodec set to %v", codec)
+	return func(c *connectionConfig) {
+		c.codec = codec
+	}
+}
+
+// WithIndex adds cache indexes on the given fields.
+// Lookups on an indexed field are answered from the index rather than a scan of the table.
+func WithIndex(fields ...entities.Field) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithIndex set to %v", fields)
+	return func(c *connectionConfig) {
+		c.indices = append(c.indices, fields...)
+	}
+}
+
+// WithUniqueIndex adds unique cache indexes on the given fields.
+// Adding a record whose value is already held by another record fails with a duplicate error.
+func WithUniqueIndex(fields ...entities.Field) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithUniqueIndex set to %v", fields)
+	return func(c *connectionConfig) {
+		c.uniqueIndices = append(c.uniqueIndices, fields...)
+	}
+}
+
+// WithCacheCapacity limits the entries, and the approximate bytes, cached for the table.
+// When either limit is exceeded, entries are evicted using policy. A limit of zero is unbounded.
+func WithCacheCapacity(maxEntries int, maxBytes int64, policy cache.EvictionPolicy) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithCacheCapacity set to %d entries, %d bytes (%v)", maxEntries, maxBytes, policy)
+	return func(c *connectionConfig) {
+		c.cacheCapacity = &cacheCapacity{maxEntries: maxEntries, maxBytes: maxBytes, policy: policy}
+	}
+}
+
+// WithCacheBackend sets where the cached records of the table are held. By default they are
+// held in memory (cache.MemoryBackend); cache.FileBackend keeps them in a warm cache file, so
+// the table does not need to be hydrated again after a restart, unless the database file has
+// changed since. A backend cannot be set on an encrypted connection.
+func WithCacheBackend(backend cache.BackendFactory) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithCacheBackend set")
+	return func(c *connectionConfig) {
+		c.cacheBackend = backend
+	}
+}
+
+// WithWriteMode sets how Create persists records when caching is enabled.
+// By default, WriteThrough is used.
+func WithWriteMode(mode WriteMode) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithWriteMode set to %v", mode)
+	return func(c *connectionConfig) {
+		c.writeMode = mode
+	}
+}
+
+// WithWriteQueueSize sets the maximum number of pending write-behind saves.
+// Create blocks when the queue is full.
+func WithWriteQueueSize(size int) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithWriteQueueSize set to %d", size)
+	return func(c *connectionConfig) {
+		c.writeQueueSize = size
+	}
+}
+
+// WithWriteRetries sets how many times a failed write-behind save is retried, and the
+// delay before the first retry. The delay grows with each attempt.
+func WithWriteRetries(retries int, delay time.Duration) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithWriteRetries set to %d (%v)", retries, delay)
+	return func(c *connectionConfig) {
+		c.writeRetries = retries
+		c.writeRetryDelay = delay
+	}
+}
+
+// WithWriteErrorHandler sets the function called when a write-behind save fails after all retries.
+func WithWriteErrorHandler(fn WriteErrorHandler) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithWriteErrorHandler set")
+	return func(c *connectionConfig) {
+		c.writeErrorFunc = fn
+	}
+}
+
+// WithSoftDelete makes reads of the table skip records whose Audit.DeletedAt is set, so that
+// deleting a record can mark it rather than remove it. See QueryBuilder.IncludeDeleted.
+func WithSoftDelete(enabled bool) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithSoftDelete set to %v", enabled)
+	return func(c *connectionConfig) {
+		c.softDelete = enabled
+	}
+}
+
+// WithHistory records that the table keeps a history of its records with SaveVersion.
+// A version is saved under the ID of its record, so a table with a history cannot be
+// opened on a WriteBehind connection.
+func WithHistory(enabled bool) Option {
+	logHandler.DatabaseLogger.Printf("[CON]{OPTION} WithHistory set to %v", enabled)
+	return func(c *connectionConfig) {
+		c.withHistory = enabled
+	}
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/asdine/storm/v3/index"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// DefaultPageSize is the number of records in a page when a PageRequest has no Limit.
+var DefaultPageSize = 50
+
+// PageRequest describes the page of records GetPage returns.
+type PageRequest struct {
+	Offset int            // records to skip; ignored when Cursor is set
+	Limit  int            // records in the page; DefaultPageSize if not above zero
+	SortBy entities.Field // the id, or an indexed field; the id if empty
+	Desc   bool           // sort in descending order
+	Filter Condition      // records must match, if set; built with Cond, AllOf and AnyOf
+	Cursor string         // NextCursor of the previous page, to read the page after it
+
+	IncludeDeleted bool // include soft-deleted records; see QueryBuilder.IncludeDeleted
+}
+
+// String returns a readable form of the request, used for logging and timing.
+func (r PageRequest) String() string {
+	rtn := "ALL"
+	if !r.Filter.isEmpty() {
+		rtn = r.Filter.String()
+	}
+	if r.SortBy != "" {
+		rtn += " ORDER BY " + r.SortBy.String()
+	}
+	if r.Desc {
+		rtn += " DESC"
+	}
+	switch {
+	case r.Cursor != "":
+		rtn += " AFTER " + r.Cursor
+	case r.Offset > 0:
+		rtn += fmt.Sprintf(" SKIP %d", r.Offset)
+	}
+	rtn += fmt.Sprintf(" LIMIT %d", r.limit())
+	if r.IncludeDeleted {
+		rtn += " INCLUDING DELETED"
+	}
+	return rtn
+}
+
+func (r PageRequest) limit() int {
+	if r.Limit <= 0 {
+		return DefaultPageSize
+	}
+	return r.Limit
+}
+
+// Page is a page of records returned by GetPage.
+type Page[T any] struct {
+	Items      []T
+	Total      int    // records matching the request's Filter, in all pages
+	NextCursor string // pass as the Cursor of the next request; empty on the last page
+}
+
+// pageCursor is the position after the last record of a page: its sort value and id.
+type pageCursor struct {
+	SortBy string          `json:"s"`
+	Desc   bool            `json:"d,omitempty"`
+	Value  json.RawMessage `json:"v"`
+	ID     json.RawMessage `json:"k"`
+}
+
+// GetPage returns a page of the records of type T that match the request's Filter, sorted by
+// its SortBy field, then by id.
+//
+// Pages are read with a keyset cursor: the NextCursor of a page selects the records after its
+// last record, so a page is not shifted by records added or deleted before it, as it would be
+// with Offset. Only the id and indexed fields can be sorted by.
+//
+// The query is run by QueryBuilder, so a page is the same whether it is served from the cache
+// or from Storm.
+//
+// NOTE: T is expected to be a struct type (not a pointer).
+func GetPage[T any](db *DB, request PageRequest) (Page[T], error) {
+	var record T
+	tableName := entities.GetStructType(record)
+	clock := timing.Start(fmt.Sprintf("%v", tableName), "Page", request.String())
+
+	page, err := getPage[T](db, request)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[PAGE] %v WHERE %v [...%v.db] - Error: %v", tableName, request.String(), db.Name, err)
+		clock.Stop(0)
+		return Page[T]{}, err
+	}
+	logHandler.DatabaseLogger.Printf("[PAGE] %v WHERE %v [...%v.db] - %d of %d records", tableName, request.String(), db.Name, len(page.Items), page.Total)
+	clock.Stop(len(page.Items))
+	return page, nil
+}
+
+func getPage[T any](db *DB, request PageRequest) (Page[T], error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return Page[T]{}, commonErrors.ErrInvalidTypeWrapper("GetPage", typ.String(), "non-pointer struct")
+	}
+	idField := primaryKeyField(typ)
+	sortBy := request.SortBy
+	if sortBy == "" {
+		sortBy = idField
+	}
+	if err := checkSortable(typ, sortBy, idField); err != nil {
+		return Page[T]{}, err
+	}
+
+	total := Query[T](db)
+	if !request.Filter.isEmpty() {
+		total.Match(request.Filter)
+	}
+	if request.IncludeDeleted {
+		total.IncludeDeleted()
+	}
+	count, err := total.Count()
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	query := Query[T](db)
+	if !request.Filter.isEmpty() {
+		query.Match(request.Filter)
+	}
+	if request.IncludeDeleted {
+		query.IncludeDeleted()
+	}
+	if request.Cursor != "" {
+		after, err := decodeCursor(typ, request.Cursor, sortBy, idField, request.Desc)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		query.Match(after)
+	} else if request.Offset > 0 {
+		query.Skip(request.Offset)
+	}
+	if sortBy == idField {
+		query.OrderBy(idField)
+	} else {
+		query.OrderBy(sortBy, idField)
+	}
+	if request.Desc {
+		query.Reverse()
+	}
+	// one more than the page, to tell whether there is a next page
+	limit := request.limit()
+	query.Limit(limit + 1)
+	items, err := query.Find()
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Items: items, Total: count}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := reflect.ValueOf(page.Items[limit-1])
+		page.NextCursor, err = encodeCursor(last, sortBy, idField, request.Desc)
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+	return page, nil
+}
+
+// checkSortable checks that field is the id, or an indexed field, of the struct type t.
+func checkSortable(t reflect.Type, field, idField entities.Field) error {
+	if field == "" {
+		return commonErrors.ErrInvalidFieldWrapper("SortBy")
+	}
+	if field == idField {
+		return nil
+	}
+	structField, ok := t.FieldByName(field.String())
+	if !ok {
+		return commonErrors.ErrInvalidFieldWrapper(field.String())
+	}
+	for _, option := range strings.Split(structField.Tag.Get("storm"), ",") {
+		if option == "index" || option == "unique" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrSortNotIndexed, field)
+}
+
+// encodeCursor returns the cursor for the position after record.
+func encodeCursor(record reflect.Value, sortBy, idField entities.Field, desc bool) (string, error) {
+	value, err := json.Marshal(record.FieldByName(sortBy.String()).Interface())
+	if err != nil {
+		return "", err
+	}
+	id, err := json.Marshal(record.FieldByName(idField.String()).Interface())
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(pageCursor{SortBy: sortBy.String(), Desc: desc, Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor returns the condition selecting the records after the cursor, in the order of
+// sortBy then id, or the reverse if desc.
+func decodeCursor(t reflect.Type, cursor string, sortBy, idField entities.Field, desc bool) (Condition, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	var position pageCursor
+	if err := json.Unmarshal(data, &position); err != nil {
+		return Condition{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if position.SortBy != sortBy.String() || position.Desc != desc {
+		return Condition{}, fmt.Errorf("%w: issued for a different sort", ErrInvalidCursor)
+	}
+	decode := func(field entities.Field, raw json.RawMessage) (any, error) {
+		structField, _ := t.FieldByName(field.String())
+		value := reflect.New(structField.Type)
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+		return value.Elem().Interface(), nil
+	}
+	id, err := decode(idField, position.ID)
+	if err != nil {
+		return Condition{}, err
+	}
+	after := Gt
+	if desc {
+		after = Lt
+	}
+	if sortBy == idField {
+		return Cond(idField, after, id), nil
+	}
+	value, err := decode(sortBy, position.Value)
+	if err != nil {
+		return Condition{}, err
+	}
+	return AnyOf(
+		Cond(sortBy, after, value),
+		AllOf(Cond(sortBy, Eq, value), Cond(idField, after, id)),
+	), nil
+}
+
+// applyIndexOptions applies Storm's All options to records read from the cache, so they come
+// back as Storm would return them: in id order, reversed, skipped and limited.
+func applyIndexOptions[T any](records []T, options ...func(*index.Options)) []T {
+	if len(records) == 0 {
+		return records
+	}
+	opts := index.NewOptions()
+	for _, option := range options {
+		option(opts)
+	}
+	result := slices.Clone(records)
+	idField := primaryKeyField(reflect.TypeOf(result[0]))
+	if idField != "" {
+		sort.SliceStable(result, func(i, j int) bool {
+			left := reflect.Indirect(reflect.ValueOf(result[i])).FieldByName(idField.String())
+			right := reflect.Indirect(reflect.ValueOf(result[j])).FieldByName(idField.String())
+			return compareValues(left, right) < 0
+		})
+	}
+	if opts.Reverse {
+		slices.Reverse(result)
+	}
+	if opts.Skip > 0 {
+		if opts.Skip >= len(result) {
+			return result[:0]
+		}
+		result = result[opts.Skip:]
+	}
+	if opts.Limit >= 0 && opts.Limit < len(result) {
+		result = result[:opts.Limit]
+	}
+	return result
+}
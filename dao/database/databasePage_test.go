@@ -0,0 +1,172 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+)
+
+// pageTestDB opens an empty namespace holding count records spread over three groups, with
+// the cache on or off.
+func pageTestDB(t *testing.T, nameSpace string, caching bool, count int) *DB {
+	t.Helper()
+	db := openTestDB(t, nameSpace, WithCaching(caching))
+	for i := range count {
+		// Groups are created out of order, so the sort is not the order of the ids
+		group := fmt.Sprintf("G%d", (i*7)%3)
+		if err := db.Create(&testRecord{Code: fmt.Sprintf("P%02d", i), Group: group}); err != nil {
+			t.Fatalf("Create %d: %v", i, err)
+		}
+	}
+	if caching && !cache.IsComplete(&testRecord{}) {
+		t.Fatal("the cache does not hold the whole table")
+	}
+	return db
+}
+
+// sortedRecords returns the records Storm holds, in the order of sortBy then id.
+func sortedRecords(t *testing.T, db *DB, sortBy entities.Field, desc bool) []testRecord {
+	t.Helper()
+	records := storedRecords(t, db)
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if desc {
+			a, b = b, a
+		}
+		if sortBy == "Group" && a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.ID < b.ID
+	})
+	return records
+}
+
+// readPages reads every page of request, following the cursors, and returns the ids read.
+func readPages(t *testing.T, db *DB, request PageRequest, total int) []int {
+	t.Helper()
+	var ids []int
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatalf("more than %d pages read; the cursor is not advancing", total)
+		}
+		page, err := GetPage[testRecord](db, request)
+		if err != nil {
+			t.Fatalf("GetPage(%v): %v", request, err)
+		}
+		if page.Total != total {
+			t.Errorf("GetPage(%v) Total is %d, want %d", request, page.Total, total)
+		}
+		for _, record := range page.Items {
+			ids = append(ids, record.ID)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		request.Cursor = page.NextCursor
+	}
+}
+
+func idsOf(records []testRecord) []int {
+	ids := make([]int, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func TestGetPageOrder(t *testing.T) {
+	for _, caching := range []bool{false, true} {
+		t.Run(fmt.Sprintf("caching=%t", caching), func(t *testing.T) {
+			db := pageTestDB(t, fmt.Sprintf("test_page_order_%t", caching), caching, 23)
+			for _, request := range []PageRequest{
+				{Limit: 5},
+				{Limit: 5, Desc: true},
+				{Limit: 4, SortBy: "Group"},
+				{Limit: 4, SortBy: "Group", Desc: true},
+			} {
+				want := idsOf(sortedRecords(t, db, request.SortBy, request.Desc))
+				if got := readPages(t, db, request, len(want)); !slices.Equal(got, want) {
+					t.Errorf("pages of %v are %v, want %v", request, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestGetPageCursorStability checks that a cursor continues after the last record of its
+// page, however records before it change.
+func TestGetPageCursorStability(t *testing.T) {
+	for _, caching := range []bool{false, true} {
+		t.Run(fmt.Sprintf("caching=%t", caching), func(t *testing.T) {
+			db := pageTestDB(t, fmt.Sprintf("test_page_stable_%t", caching), caching, 20)
+			request := PageRequest{Limit: 6, SortBy: "Group"}
+			before := idsOf(sortedRecords(t, db, request.SortBy, false))
+
+			first, err := GetPage[testRecord](db, request)
+			if err != nil {
+				t.Fatalf("GetPage: %v", err)
+			}
+			if len(first.Items) != request.Limit || first.NextCursor == "" {
+				t.Fatalf("first page has %d records and cursor %q", len(first.Items), first.NextCursor)
+			}
+
+			// Add a record that sorts before the cursor, and delete one from the first page
+			if err := db.Create(&testRecord{Code: "NEW", Group: "A"}); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if err := db.Delete(&first.Items[0]); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+
+			request.Cursor = first.NextCursor
+			rest := readPages(t, db, request, len(before))
+			if want := before[request.Limit:]; !slices.Equal(rest, want) {
+				t.Errorf("pages after the first are %v, want %v", rest, want)
+			}
+		})
+	}
+}
+
+// TestGetPageCursorAcrossPaths checks that a cursor issued from the cache reads the same next
+// page from Storm.
+func TestGetPageCursorAcrossPaths(t *testing.T) {
+	db := pageTestDB(t, "test_page_paths", true, 15)
+	request := PageRequest{Limit: 5, SortBy: "Group", Desc: true}
+	want := idsOf(sortedRecords(t, db, request.SortBy, request.Desc))
+
+	first, err := GetPage[testRecord](db, request)
+	if err != nil {
+		t.Fatalf("GetPage from the cache: %v", err)
+	}
+	if err := cache.Disable(&testRecord{}); err != nil {
+		t.Fatalf("cache.Disable: %v", err)
+	}
+	request.Cursor = first.NextCursor
+	got := append(idsOf(first.Items), readPages(t, db, request, len(want))...)
+	if !slices.Equal(got, want) {
+		t.Errorf("pages are %v, want %v", got, want)
+	}
+}
+
+func TestGetPageInvalidRequests(t *testing.T) {
+	db := pageTestDB(t, "test_page_invalid", false, 3)
+	if _, err := GetPage[testRecord](db, PageRequest{SortBy: "Name"}); !errors.Is(err, ErrSortNotIndexed) {
+		t.Errorf("sort by an unindexed field returned %v, want %v", err, ErrSortNotIndexed)
+	}
+	if _, err := GetPage[testRecord](db, PageRequest{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("a malformed cursor returned %v, want %v", err, ErrInvalidCursor)
+	}
+
+	page, err := GetPage[testRecord](db, PageRequest{Limit: 1, SortBy: "Group"})
+	if err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	if _, err := GetPage[testRecord](db, PageRequest{Limit: 1, SortBy: "Group", Desc: true, Cursor: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("a cursor for another sort returned %v, want %v", err, ErrInvalidCursor)
+	}
+}
@@ -1,28 +1,191 @@
 package database
 
-import "github.com/mt1976/frantic-core/logHandler"
+import (
+	"errors"
+	"fmt"
+	"reflect"
 
-// addConnectionToPool adds a database connection to the connection pool
-func addConnectionToPool(db DB, key string) {
-	logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL} Adding [%v] to connection pool (%v)", key, db.databaseName)
-	if len(connectionPool) >= connectionPoolMaxSize {
-		logHandler.DatabaseLogger.Panicf("[CON]{CONNECTION}{POOL} Connection pool full [%v]", connectionPoolMaxSize)
-		return
+	"github.com/mt1976/frantic-core/ioHelpers"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// ErrNotPaused is returned by Resume when the connection is not paused.
+var ErrNotPaused = errors.New("database is not paused")
+
+// ErrPaused is returned by RestoreTable when the connection is paused.
+var ErrPaused = errors.New("database is paused")
+
+// checkPool returns a *ConnectError if the pool cannot take a new connection for config.
+// The caller must hold poolMu.
+func checkPool(config *connectionConfig) error {
+	limit := config.poolSize
+	if limit <= 0 {
+		limit = connectionPoolMaxSize
+	}
+	if open := len(connectionPool) + len(connectionsOpening); open >= limit {
+		return &ConnectError{NameSpace: config.nameSpace, Path: ioHelpers.GetDBFileName(config.nameSpace),
+			Reason: fmt.Sprintf("%d of %d connections open", open, limit), Err: ErrPoolFull}
+	}
+	return nil
+}
+
+// mismatch describes how config differs from the open connection db in the options every
+// handle on a connection must share, or returns "" if it does not.
+func mismatch(db *DB, config *connectionConfig) string {
+	switch {
+	case db.withEncryption && !config.withEncryption:
+		return "the open connection is encrypted"
+	case !db.withEncryption && config.withEncryption:
+		return "the open connection is not encrypted"
+	case db.withEncryption && !sameKeyProvider(db.keyProvider, config.keyProvider):
+		return "the open connection uses another key provider"
+	case db.writeMode != config.writeMode:
+		return fmt.Sprintf("the open connection is %v", db.writeMode)
+	}
+	return ""
+}
+
+// sameKeyProvider reports whether a and b are the same KeyProvider. A nil provider is the
+// default, EnvKeyProvider(DefaultKeyEnvPrefix).
+func sameKeyProvider(a, b KeyProvider) bool {
+	if a == nil {
+		a = EnvKeyProvider(DefaultKeyEnvPrefix)
+	}
+	if b == nil {
+		b = EnvKeyProvider(DefaultKeyEnvPrefix)
+	}
+	if envA, ok := a.(*envKeyProvider); ok {
+		envB, ok := b.(*envKeyProvider)
+		return ok && envA.prefix == envB.prefix
+	}
+	typeA := reflect.TypeOf(a)
+	return typeA == reflect.TypeOf(b) && typeA.Comparable() && a == b
+}
+
+// waitForOpen returns once no connection to nameSpace is being opened, so the pool shows
+// whether it is connected. The caller must hold poolMu, which is released while waiting.
+func waitForOpen(nameSpace string) {
+	for {
+		opening, ok := connectionsOpening[nameSpace]
+		if !ok {
+			return
+		}
+		poolMu.Unlock()
+		<-opening
+		poolMu.Lock()
+	}
+}
+
+// openConnection opens the database file of config into db and adds it to the pool.
+// The caller must hold poolMu. It is released while the file is opened, which can wait for
+// the file lock, so other namespaces can be connected meanwhile; connects to this namespace
+// wait in waitForOpen.
+func openConnection(db *DB, config *connectionConfig) error {
+	opening := make(chan struct{})
+	connectionsOpening[config.nameSpace] = opening
+	poolMu.Unlock()
+	err := db.open(config)
+	poolMu.Lock()
+	delete(connectionsOpening, config.nameSpace)
+	close(opening)
+	if err != nil {
+		return err
 	}
-	connectionPool[key] = &db
+	addConnectionToPool(db)
+	return nil
+}
+
+// addConnectionToPool adds a database connection to the connection pool, with one handle.
+// The caller must hold poolMu.
+func addConnectionToPool(db *DB) {
+	logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL} Adding [%v] to connection pool (%v)", db.Name, db.databaseName)
+	db.refs = 1
+	connectionPool[db.Name] = db
 	logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL} Connection pool [size=%v]", len(connectionPool))
 }
 
-// releaseFromConnectionPool removes a database connection from the connection pool
+// releaseFromConnectionPool removes a database connection from the connection pool.
+// The caller must hold poolMu.
 func releaseFromConnectionPool(db *DB) {
 	logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL} Removing [%v] from connection pool (%v)", db.Name, db.databaseName)
 	for key, value := range connectionPool {
 		logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL}  Connection Pool [%v] [%v] [codec=%v]", key, value.databaseName, value.connection.Node.Codec().Name())
 	}
-	connectionPool[db.Name] = nil
-	delete(connectionPool, db.Name)
+	if connectionPool[db.Name] == db {
+		delete(connectionPool, db.Name)
+	}
 	logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL} Connection pool [size=%v]", len(connectionPool))
 	for key, value := range connectionPool {
 		logHandler.DatabaseLogger.Printf("[CON]{CONNECTION}{POOL}  Connection Pool [%v] [%v] [codec=%v]", key, value.databaseName, value.connection.Node.Codec().Name())
 	}
 }
+
+// Pause blocks writes to the pooled connection, for every handle sharing it, until Resume is
+// called, so the database file can be copied safely. Reads carry on while it is paused.
+//
+// Queued write-behind saves are flushed first. Writes started while paused wait; a goroutine
+// must not write to a connection it has paused, or it waits forever. Pauses nest: each Pause
+// needs a matching Resume.
+func (db *DB) Pause() error {
+	db.pauseMu.Lock()
+	defer db.pauseMu.Unlock()
+	if db.pauses > 0 {
+		db.pauses++
+		return nil
+	}
+	if db.connection == nil {
+		return ErrNotConnected
+	}
+	db.flushPending()
+	if !db.readOnly {
+		// bbolt allows one writer at a time; holding a write transaction blocks the others
+		tx, err := db.connection.Bolt.Begin(true)
+		if err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{PAUSE} Pausing [...%v.db] Error=[%v]", db.Name, err.Error())
+			return err
+		}
+		db.pauseTx = tx
+	}
+	db.pauses = 1
+	logHandler.DatabaseLogger.Printf("[CON]{PAUSE} Paused [...%v.db] writes", db.Name)
+	return nil
+}
+
+// Resume ends a Pause of the pooled connection.
+func (db *DB) Resume() error {
+	db.pauseMu.Lock()
+	defer db.pauseMu.Unlock()
+	if db.pauses == 0 {
+		return ErrNotPaused
+	}
+	db.pauses--
+	if db.pauses > 0 {
+		return nil
+	}
+	return db.rollbackPause()
+}
+
+// endPause ends any Pause, before the connection is closed.
+func (db *DB) endPause() {
+	db.pauseMu.Lock()
+	defer db.pauseMu.Unlock()
+	if db.pauses > 0 {
+		logHandler.WarningLogger.Printf("[CON]{PAUSE} Closing [...%v.db] while paused; resuming", db.Name)
+		db.pauses = 0
+		db.rollbackPause()
+	}
+}
+
+// rollbackPause releases the write transaction held by a Pause. The caller must hold pauseMu.
+func (db *DB) rollbackPause() error {
+	tx := db.pauseTx
+	db.pauseTx = nil
+	if tx != nil {
+		if err := tx.Rollback(); err != nil {
+			logHandler.ErrorLogger.Printf("[CON]{PAUSE} Resuming [...%v.db] Error=[%v]", db.Name, err.Error())
+			return err
+		}
+	}
+	logHandler.DatabaseLogger.Printf("[CON]{PAUSE} Resumed [...%v.db] writes", db.Name)
+	return nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolReferenceCounts(t *testing.T) {
+	const nameSpace = "test_pool_refs"
+	db := openTestDB(t, nameSpace)
+
+	const handles = 8
+	var wg sync.WaitGroup
+	for range handles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shared, err := Open(&testRecord{}, WithNameSpace(nameSpace))
+			if err != nil || shared != db {
+				t.Errorf("Open returned %p, %v; want the pooled connection %p", shared, err, db)
+				return
+			}
+			if err := shared.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if db.refs != 1 {
+		t.Fatalf("the connection has %d refs after the handles closed, want 1", db.refs)
+	}
+
+	// Closing one handle leaves the connection open for the others
+	shared, err := Open(&testRecord{}, WithNameSpace(nameSpace))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	closeTestDB(t, shared)
+	createTestRecords(t, db, "A")
+
+	closeTestDB(t, db)
+	poolMu.Lock()
+	pooled := connectionPool[nameSpace]
+	poolMu.Unlock()
+	if pooled != nil {
+		t.Error("the connection is still pooled after its last handle closed")
+	}
+	if err := db.Create(&testRecord{Code: "B"}); err == nil {
+		t.Error("Create on a closed connection returned no error")
+	}
+}
+
+func TestPoolOptionsMismatch(t *testing.T) {
+	ring := func(key byte) KeyProvider {
+		keys, err := NewKeyRing("k1", map[string][]byte{"k1": bytes.Repeat([]byte{key}, 32)})
+		if err != nil {
+			t.Fatalf("NewKeyRing: %v", err)
+		}
+		return keys
+	}
+	t.Setenv(DefaultKeyEnvPrefix+"_CURRENT", "k1")
+	t.Setenv(DefaultKeyEnvPrefix+"_K1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)))
+	keys := ring(1)
+
+	for _, test := range []struct {
+		name    string
+		open    []Option
+		connect []Option
+		err     error
+	}{
+		{"plain, then encrypted", nil, []Option{WithKeyProvider(keys)}, ErrOptionsMismatch},
+		{"encrypted, then plain", []Option{WithKeyProvider(keys)}, nil, ErrOptionsMismatch},
+		{"another key provider", []Option{WithKeyProvider(keys)}, []Option{WithKeyProvider(ring(2))}, ErrOptionsMismatch},
+		{"the same key provider", []Option{WithKeyProvider(keys)}, []Option{WithKeyProvider(keys)}, nil},
+		{"the default key provider", []Option{WithEncryption(true)}, []Option{WithKeyProvider(EnvKeyProvider(DefaultKeyEnvPrefix))}, nil},
+		{"write-behind, then write-through", []Option{WithCaching(true), WithWriteMode(WriteBehind)}, []Option{WithCaching(true)}, ErrOptionsMismatch},
+		{"write-through, then write-behind", nil, []Option{WithCaching(true), WithWriteMode(WriteBehind)}, ErrOptionsMismatch},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db := openTestDB(t, "test_pool_mismatch", test.open...)
+			shared, err := Open(&testRecord{}, append([]Option{WithNameSpace("test_pool_mismatch")}, test.connect...)...)
+			if test.err == nil {
+				if err != nil || shared != db {
+					t.Fatalf("Open returned %p, %v; want the pooled connection", shared, err)
+				}
+				closeTestDB(t, shared)
+				return
+			}
+			checkConnectError(t, "Open", err, test.err)
+			if db.refs != 1 {
+				t.Errorf("the connection has %d refs after the refusal, want 1", db.refs)
+			}
+		})
+	}
+}
+
+func TestPause(t *testing.T) {
+	db := openTestDB(t, "test_pause")
+	createTestRecords(t, db, "A")
+	if err := db.Resume(); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("Resume of a running connection returned %v, want %v", err, ErrNotPaused)
+	}
+
+	// Pauses nest, and writes wait for the last Resume
+	for range 2 {
+		if err := db.Pause(); err != nil {
+			t.Fatalf("Pause: %v", err)
+		}
+	}
+	written := make(chan error, 1)
+	go func() { written <- db.Create(&testRecord{Code: "B"}) }()
+	var record testRecord
+	if _, err := db.Get("Code", "A", &record); err != nil {
+		t.Errorf("Get while paused: %v", err)
+	}
+	if err := db.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	select {
+	case err := <-written:
+		t.Fatalf("Create returned %v while the connection was paused", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := db.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("Create after Resume: %v", err)
+	}
+
+	// Closing a paused connection ends the pause
+	if err := db.Pause(); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	closeTestDB(t, db)
+	if db.pauses != 0 || db.pauseTx != nil {
+		t.Errorf("the closed connection is still paused (%d)", db.pauses)
+	}
+}
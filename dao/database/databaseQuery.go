@@ -0,0 +1,642 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// Operator identifies the comparison applied by a query condition.
+type Operator int
+
+const (
+	Eq    Operator = iota // field == value
+	Ne                    // field != value
+	Gt                    // field > value
+	Gte                   // field >= value
+	Lt                    // field < value
+	Lte                   // field <= value
+	In                    // field is one of the values in the supplied slice
+	NotIn                 // field is none of the values in the supplied slice
+	Re                    // field matches the supplied regular expression (string fields only)
+)
+
+var operatorNames = map[Operator]string{
+	Eq:    "=",
+	Ne:    "!=",
+	Gt:    ">",
+	Gte:   ">=",
+	Lt:    "<",
+	Lte:   "<=",
+	In:    "IN",
+	NotIn: "NOT IN",
+	Re:    "~",
+}
+
+// String returns the display form of the operator, as used in log messages.
+func (o Operator) String() string {
+	if name, ok := operatorNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Operator(%d)", int(o))
+}
+
+// Condition is a single field predicate, or an AND/OR group of conditions.
+//
+// Conditions are built with Cond, AllOf and AnyOf and can be nested to any depth.
+type Condition struct {
+	field    entities.Field
+	operator Operator
+	value    any
+	allOf    []Condition
+	anyOf    []Condition
+}
+
+// Cond returns a condition comparing field with value using the given operator.
+func Cond(field entities.Field, operator Operator, value any) Condition {
+	return Condition{field: field, operator: operator, value: value}
+}
+
+// AllOf returns a condition that matches when every supplied condition matches.
+func AllOf(conditions ...Condition) Condition {
+	return Condition{allOf: conditions}
+}
+
+// AnyOf returns a condition that matches when at least one supplied condition matches.
+func AnyOf(conditions ...Condition) Condition {
+	return Condition{anyOf: conditions}
+}
+
+func (c Condition) isGroup() bool {
+	return c.field == ""
+}
+
+// isEmpty reports whether the condition is the zero Condition, which sets no filter.
+func (c Condition) isEmpty() bool {
+	return c.isGroup() && len(c.allOf) == 0 && len(c.anyOf) == 0
+}
+
+// String returns a readable form of the condition, used for logging and timing.
+func (c Condition) String() string {
+	switch {
+	case !c.isGroup():
+		return fmt.Sprintf("%v %v %v", c.field.String(), c.operator, c.value)
+	case len(c.anyOf) > 0:
+		return joinConditions(c.anyOf, " OR ")
+	default:
+		return joinConditions(c.allOf, " AND ")
+	}
+}
+
+func joinConditions(conditions []Condition, separator string) string {
+	parts := make([]string, len(conditions))
+	for i, condition := range conditions {
+		parts[i] = condition.String()
+	}
+	return "(" + strings.Join(parts, separator) + ")"
+}
+
+// validate checks that every field referenced by the condition exists on the target struct,
+// and that the supplied value is of a suitable type for the operator.
+func (c Condition) validate(forStruct any) error {
+	if c.isGroup() {
+		for _, child := range append(append([]Condition{}, c.allOf...), c.anyOf...) {
+			if err := child.validate(forStruct); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := entities.IsValidFieldInStruct(c.field, forStruct); err != nil {
+		return err
+	}
+
+	switch c.operator {
+	case In, NotIn:
+		rv := reflect.ValueOf(c.value)
+		if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+			return commonErrors.ErrInvalidTypeWrapper(c.field.String(), fmt.Sprintf("%T", c.value), "slice")
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := entities.IsValidTypeForField(c.field, rv.Index(i).Interface(), forStruct); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Re:
+		if _, ok := c.value.(string); !ok {
+			return commonErrors.ErrInvalidTypeWrapper(c.field.String(), fmt.Sprintf("%T", c.value), "string")
+		}
+		return nil
+	case Eq, Ne, Gt, Gte, Lt, Lte:
+		return entities.IsValidTypeForField(c.field, c.value, forStruct)
+	default:
+		return commonErrors.ErrInvalidFilterWrapper(commonErrors.ErrInvalidType, c.operator.String())
+	}
+}
+
+// matcher compiles the condition into a Storm matcher tree.
+func (c Condition) matcher() q.Matcher {
+	if c.isGroup() {
+		if len(c.anyOf) > 0 {
+			return q.Or(matchers(c.anyOf)...)
+		}
+		return q.And(matchers(c.allOf)...)
+	}
+
+	name := c.field.String()
+	switch c.operator {
+	case Ne:
+		return q.Not(q.Eq(name, c.value))
+	case Gt:
+		return q.Gt(name, c.value)
+	case Gte:
+		return q.Gte(name, c.value)
+	case Lt:
+		return q.Lt(name, c.value)
+	case Lte:
+		return q.Lte(name, c.value)
+	case In:
+		return q.In(name, c.value)
+	case NotIn:
+		return q.Not(q.In(name, c.value))
+	case Re:
+		return q.Re(name, c.value.(string))
+	default:
+		return q.Eq(name, c.value)
+	}
+}
+
+func matchers(conditions []Condition) []q.Matcher {
+	rtn := make([]q.Matcher, len(conditions))
+	for i, condition := range conditions {
+		rtn[i] = condition.matcher()
+	}
+	return rtn
+}
+
+// QueryBuilder composes a typed query over records of type T.
+//
+// Conditions added with Where/And are combined with AND; Or starts a new group, so
+// Where(a).And(b).Or(c).And(d) selects records matching (a AND b) OR (c AND d).
+// The compiled query is run against Storm, or evaluated in memory against the cache
+// when caching is enabled for T.
+//
+// NOTE: T is expected to be a struct type (not a pointer).
+type QueryBuilder[T any] struct {
+	db             *DB
+	groups         [][]Condition
+	orderBy        []entities.Field
+	reverse        bool
+	limit          int
+	skip           int
+	includeDeleted bool
+}
+
+// Query starts a new typed query against db for records of type T.
+//
+// Example:
+//
+//	users, err := database.Query[User](db).
+//		Where(Fields.GID, database.Eq, "admin").
+//		And(Fields.LastLogin, database.Gt, since).
+//		OrderBy(Fields.UserName).
+//		Limit(20).
+//		Find()
+func Query[T any](db *DB) *QueryBuilder[T] {
+	return &QueryBuilder[T]{db: db}
+}
+
+// Where adds a condition to the current AND group.
+func (qb *QueryBuilder[T]) Where(field entities.Field, operator Operator, value any) *QueryBuilder[T] {
+	return qb.Match(Cond(field, operator, value))
+}
+
+// And adds a condition to the current AND group.
+func (qb *QueryBuilder[T]) And(field entities.Field, operator Operator, value any) *QueryBuilder[T] {
+	return qb.Match(Cond(field, operator, value))
+}
+
+// Or starts a new AND group, which is OR'd with the groups before it.
+func (qb *QueryBuilder[T]) Or(field entities.Field, operator Operator, value any) *QueryBuilder[T] {
+	qb.groups = append(qb.groups, []Condition{Cond(field, operator, value)})
+	return qb
+}
+
+// Match adds an arbitrary condition (including AllOf/AnyOf groups) to the current AND group.
+func (qb *QueryBuilder[T]) Match(condition Condition) *QueryBuilder[T] {
+	if len(qb.groups) == 0 {
+		qb.groups = append(qb.groups, []Condition{})
+	}
+	last := len(qb.groups) - 1
+	qb.groups[last] = append(qb.groups[last], condition)
+	return qb
+}
+
+// OrderBy sorts the results by the given fields, in the order supplied.
+func (qb *QueryBuilder[T]) OrderBy(fields ...entities.Field) *QueryBuilder[T] {
+	qb.orderBy = append(qb.orderBy, fields...)
+	return qb
+}
+
+// Reverse reverses the sort order of the results.
+func (qb *QueryBuilder[T]) Reverse() *QueryBuilder[T] {
+	qb.reverse = true
+	return qb
+}
+
+// Limit restricts the number of records returned.
+func (qb *QueryBuilder[T]) Limit(n int) *QueryBuilder[T] {
+	qb.limit = n
+	return qb
+}
+
+// Skip skips the first n matching records.
+func (qb *QueryBuilder[T]) Skip(n int) *QueryBuilder[T] {
+	qb.skip = n
+	return qb
+}
+
+// IncludeDeleted includes soft-deleted records, which are otherwise skipped if T was opened
+// WithSoftDelete.
+func (qb *QueryBuilder[T]) IncludeDeleted() *QueryBuilder[T] {
+	qb.includeDeleted = true
+	return qb
+}
+
+// condition returns the query's conditions as a single condition tree.
+func (qb *QueryBuilder[T]) condition() Condition {
+	groups := make([]Condition, 0, len(qb.groups))
+	for _, group := range qb.groups {
+		groups = append(groups, AllOf(group...))
+	}
+	return AnyOf(groups...)
+}
+
+// String returns a readable form of the query, used for logging and timing.
+func (qb *QueryBuilder[T]) String() string {
+	rtn := "ALL"
+	if len(qb.groups) > 0 {
+		rtn = qb.condition().String()
+	}
+	if len(qb.orderBy) > 0 {
+		names := make([]string, len(qb.orderBy))
+		for i, field := range qb.orderBy {
+			names[i] = field.String()
+		}
+		rtn += " ORDER BY " + strings.Join(names, ",")
+		if qb.reverse {
+			rtn += " DESC"
+		}
+	}
+	if qb.skip > 0 {
+		rtn += fmt.Sprintf(" SKIP %d", qb.skip)
+	}
+	if qb.limit > 0 {
+		rtn += fmt.Sprintf(" LIMIT %d", qb.limit)
+	}
+	if qb.includeDeleted {
+		rtn += " INCLUDING DELETED"
+	}
+	return rtn
+}
+
+// validate checks the query's conditions and sort fields against T.
+func (qb *QueryBuilder[T]) validate(record T) error {
+	if reflect.TypeOf(record) != nil && reflect.TypeOf(record).Kind() == reflect.Ptr {
+		return commonErrors.ErrInvalidTypeWrapper("Query", fmt.Sprintf("%T", record), "non-pointer struct")
+	}
+	if qb.db == nil {
+		return commonErrors.ErrDAONotInitialisedWrapper(fmt.Sprintf("%v", entities.GetStructType(record)), "Query")
+	}
+	if len(qb.groups) > 0 {
+		if err := qb.condition().validate(record); err != nil {
+			return err
+		}
+	}
+	for _, field := range qb.orderBy {
+		if err := entities.IsValidFieldInStruct(field, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns all records matching the query.
+func (qb *QueryBuilder[T]) Find() ([]T, error) {
+	var record T
+	tableName := entities.GetStructType(record)
+	clock := timing.Start(fmt.Sprintf("%v", tableName), "Query", qb.String())
+
+	if err := qb.validate(record); err != nil {
+		logHandler.ErrorLogger.Printf("[QUERY] %v WHERE %v [...%v.db] - Error: %v", tableName, qb.String(), qb.dbName(), err)
+		clock.Stop(0)
+		return nil, err
+	}
+
+	if cache.IsEnabled(record) && cache.IsComplete(record) {
+		cachedResult, err := cache.GetAll(record)
+		if err == nil {
+			result, err := qb.evaluate(cachedResult)
+			if err != nil {
+				logHandler.ErrorLogger.Printf("[QUERY] %v WHERE %v [...%v.db] - Error evaluating Cache: %v", tableName, qb.String(), qb.db.Name, err)
+				clock.Stop(0)
+				return nil, err
+			}
+			logHandler.DatabaseLogger.Printf("[QUERY] %v WHERE %v [...%v.db] - From Cache", tableName, qb.String(), qb.db.Name)
+			clock.Stop(len(result))
+			return result, nil
+		}
+		logHandler.DatabaseLogger.Printf("[QUERY] %v WHERE %v [...%v.db] - Not Found in Cache", tableName, qb.String(), qb.db.Name)
+	}
+
+	logHandler.DatabaseLogger.Printf("[QUERY] %v WHERE %v [...%v.db] - From Database", tableName, qb.String(), qb.db.Name)
+	cacheFallback(record)
+	result := []T{}
+	if err := qb.stormQuery().Find(&result); err != nil {
+		if err == storm.ErrNotFound {
+			clock.Stop(0)
+			return []T{}, nil
+		}
+		logHandler.ErrorLogger.Printf("[QUERY] %v WHERE %v [...%v.db] - Error from DB: %v", tableName, qb.String(), qb.db.Name, err)
+		clock.Stop(0)
+		return nil, err
+	}
+
+	clock.Stop(len(result))
+	return result, nil
+}
+
+// First returns the first record matching the query.
+//
+// If no record matches, the Storm ErrNotFound error is returned. The query is run on a copy
+// of the builder, so qb can be shared and reused.
+func (qb *QueryBuilder[T]) First() (T, error) {
+	var zero T
+	first := *qb
+	first.limit = 1
+	result, err := first.Find()
+	if err != nil {
+		return zero, err
+	}
+	if len(result) == 0 {
+		return zero, storm.ErrNotFound
+	}
+	return result[0], nil
+}
+
+// Count returns the number of records matching the query.
+func (qb *QueryBuilder[T]) Count() (int, error) {
+	var record T
+	if err := qb.validate(record); err != nil {
+		return 0, err
+	}
+
+	if cache.IsEnabled(record) && cache.IsComplete(record) {
+		cachedResult, err := cache.GetAll(record)
+		if err == nil {
+			count, err := qb.countMatches(cachedResult)
+			if err != nil {
+				logHandler.ErrorLogger.Printf("[COUNT] %v WHERE %v [...%v.db] - Error evaluating Cache: %v", entities.GetStructType(record), qb.String(), qb.dbName(), err)
+				return 0, err
+			}
+			logHandler.DatabaseLogger.Printf("[COUNT] %v WHERE %v [...%v.db] - From Cache: %d", entities.GetStructType(record), qb.String(), qb.dbName(), count)
+			return count, nil
+		}
+		logHandler.DatabaseLogger.Printf("[COUNT] %v WHERE %v [...%v.db] - Not Found in Cache", entities.GetStructType(record), qb.String(), qb.dbName())
+	}
+
+	cacheFallback(record)
+	count, err := qb.stormQuery().Count(&record)
+	logHandler.DatabaseLogger.Printf("[COUNT] %v WHERE %v [...%v.db] - Result: %d", entities.GetStructType(record), qb.String(), qb.db.Name, count)
+	return count, err
+}
+
+func (qb *QueryBuilder[T]) dbName() string {
+	if qb.db == nil {
+		return "<nil>"
+	}
+	return qb.db.Name
+}
+
+// matcher compiles the query's conditions into a Storm matcher, which also skips soft-deleted
+// records unless they are included. It returns nil if every record matches.
+func (qb *QueryBuilder[T]) matcher() q.Matcher {
+	var record T
+	var rtn []q.Matcher
+	if len(qb.groups) > 0 {
+		rtn = append(rtn, qb.condition().matcher())
+	}
+	if !qb.includeDeleted && IsSoftDelete(record) {
+		rtn = append(rtn, notDeleted())
+	}
+	switch len(rtn) {
+	case 0:
+		return nil
+	case 1:
+		return rtn[0]
+	default:
+		return q.And(rtn...)
+	}
+}
+
+// stormQuery compiles the builder into a Storm query.
+func (qb *QueryBuilder[T]) stormQuery() storm.Query {
+	var query storm.Query
+	if matcher := qb.matcher(); matcher != nil {
+		query = qb.db.connection.Select(matcher)
+	} else {
+		query = qb.db.connection.Select()
+	}
+	if len(qb.orderBy) > 0 {
+		names := make([]string, len(qb.orderBy))
+		for i, field := range qb.orderBy {
+			names[i] = field.String()
+		}
+		query = query.OrderBy(names...)
+	}
+	if qb.reverse {
+		query = query.Reverse()
+	}
+	if qb.skip > 0 {
+		query = query.Skip(qb.skip)
+	}
+	if qb.limit > 0 {
+		query = query.Limit(qb.limit)
+	}
+	return query
+}
+
+// countMatches returns the number of records in an in-memory set that the query returns,
+// after skip and limit, without ordering or copying them.
+func (qb *QueryBuilder[T]) countMatches(records []T) (int, error) {
+	matcher := qb.matcher()
+	count := 0
+	for i := range records {
+		if matcher != nil {
+			ok, err := matcher.Match(&records[i])
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		count++
+	}
+	count = max(count-qb.skip, 0)
+	if qb.limit > 0 {
+		count = min(count, qb.limit)
+	}
+	return count, nil
+}
+
+// evaluate applies the query to an in-memory set of records, mirroring Storm's
+// matching, ordering, skip and limit behaviour.
+func (qb *QueryBuilder[T]) evaluate(records []T) ([]T, error) {
+	matcher := qb.matcher()
+
+	result := make([]T, 0, len(records))
+	for i := range records {
+		if matcher != nil {
+			ok, err := matcher.Match(&records[i])
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		result = append(result, records[i])
+	}
+
+	// Storm returns records in primary key order unless told otherwise.
+	orderBy := qb.orderBy
+	if len(orderBy) == 0 {
+		if idField := primaryKeyField(reflect.TypeFor[T]()); idField != "" {
+			orderBy = []entities.Field{idField}
+		}
+	}
+	direction := 1
+	if qb.reverse {
+		direction = -1
+	}
+	if len(orderBy) > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			left := reflect.ValueOf(result[i])
+			right := reflect.ValueOf(result[j])
+			for _, field := range orderBy {
+				switch compareValues(left.FieldByName(field.String()), right.FieldByName(field.String())) * direction {
+				case -1:
+					return true
+				case 1:
+					return false
+				}
+			}
+			return false
+		})
+	}
+
+	if qb.skip > 0 {
+		if qb.skip >= len(result) {
+			return []T{}, nil
+		}
+		result = result[qb.skip:]
+	}
+	if qb.limit > 0 && qb.limit < len(result) {
+		result = result[:qb.limit]
+	}
+	return result, nil
+}
+
+// primaryKeyField returns the name of the Storm id field for the struct type t.
+func primaryKeyField(t reflect.Type) entities.Field {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("storm")
+		if tag == "id" || strings.HasPrefix(tag, "id,") {
+			return entities.Field(t.Field(i).Name)
+		}
+	}
+	if _, ok := t.FieldByName("ID"); ok {
+		return entities.Field("ID")
+	}
+	return ""
+}
+
+// compareValues orders two field values the same way Storm's query sorter does. Pointers and
+// interfaces are compared by the values they hold; missing and nil values sort first, and are
+// equal to each other, so the order is consistent.
+func compareValues(left, right reflect.Value) int {
+	left, right = elemValue(left), elemValue(right)
+	if !left.IsValid() || !right.IsValid() {
+		switch {
+		case left.IsValid():
+			return 1
+		case right.IsValid():
+			return -1
+		default:
+			return 0
+		}
+	}
+	if left.Kind() != right.Kind() {
+		return compareOrdered(fmt.Sprintf("%v", left.Interface()), fmt.Sprintf("%v", right.Interface()))
+	}
+
+	switch left.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(left.Int(), right.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(left.Uint(), right.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(left.Float(), right.Float())
+	case reflect.String:
+		return compareOrdered(left.String(), right.String())
+	case reflect.Struct:
+		if lt, ok := left.Interface().(time.Time); ok {
+			if rt, ok := right.Interface().(time.Time); ok {
+				return lt.Compare(rt)
+			}
+		}
+	}
+	return compareOrdered(fmt.Sprintf("%v", left.Interface()), fmt.Sprintf("%v", right.Interface()))
+}
+
+// elemValue follows pointers and interfaces to the value they hold, returning the zero Value
+// if one is nil.
+func elemValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func compareOrdered[V int64 | uint64 | float64 | string](left, right V) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// queryTestDB opens an empty namespace holding six records over three groups, with the
+// cache on or off, and returns the ids of the records in the order they were created.
+func queryTestDB(t *testing.T, nameSpace string, caching bool) (*DB, []int) {
+	t.Helper()
+	db := openTestDB(t, nameSpace, WithCaching(caching))
+	var ids []int
+	for _, record := range []testRecord{
+		{Code: "A", Group: "G1", Name: "alpha"},
+		{Code: "B", Group: "G0", Name: "beta"},
+		{Code: "C", Group: "G1", Name: "gamma"},
+		{Code: "D", Group: "G2", Name: "delta"},
+		{Code: "E", Group: "G0", Name: "epsilon"},
+		{Code: "F", Group: "G1"},
+	} {
+		if err := db.Create(&record); err != nil {
+			t.Fatalf("Create %v: %v", record.Code, err)
+		}
+		stored, err := Query[testRecord](db).Where("Code", Eq, record.Code).First()
+		if err != nil {
+			t.Fatalf("reading %v: %v", record.Code, err)
+		}
+		ids = append(ids, stored.ID)
+	}
+	return db, ids
+}
+
+func codesFound(records []testRecord) []string {
+	codes := make([]string, 0, len(records))
+	for _, record := range records {
+		codes = append(codes, record.Code)
+	}
+	return codes
+}
+
+// TestQueryFind checks that the cache and Storm return the same records, in the same order.
+func TestQueryFind(t *testing.T) {
+	for _, caching := range []bool{false, true} {
+		t.Run(fmt.Sprintf("caching=%t", caching), func(t *testing.T) {
+			db, ids := queryTestDB(t, fmt.Sprintf("test_query_%t", caching), caching)
+			for _, test := range []struct {
+				query *QueryBuilder[testRecord]
+				want  []string
+			}{
+				{Query[testRecord](db), []string{"A", "B", "C", "D", "E", "F"}},
+				{Query[testRecord](db).Where("Group", Eq, "G1"), []string{"A", "C", "F"}},
+				{Query[testRecord](db).Where("Group", Eq, "G1").And("Name", Ne, ""), []string{"A", "C"}},
+				{Query[testRecord](db).Where("Group", Eq, "G0").Or("Code", Eq, "D"), []string{"B", "D", "E"}},
+				{Query[testRecord](db).Where("Code", In, []string{"A", "E", "Z"}), []string{"A", "E"}},
+				{Query[testRecord](db).Where("Group", NotIn, []string{"G0", "G1"}), []string{"D"}},
+				{Query[testRecord](db).Where("Name", Re, "^.e"), []string{"B", "D"}},
+				{Query[testRecord](db).Where("ID", Gt, ids[3]), []string{"E", "F"}},
+				{Query[testRecord](db).Where("ID", Lte, ids[1]), []string{"A", "B"}},
+				{Query[testRecord](db).Match(AnyOf(
+					Cond("Code", Eq, "A"),
+					AllOf(Cond("Group", Eq, "G0"), Cond("Name", Eq, "epsilon")),
+				)), []string{"A", "E"}},
+				{Query[testRecord](db).OrderBy("Group", "Code"), []string{"B", "E", "A", "C", "F", "D"}},
+				{Query[testRecord](db).OrderBy("Group", "Code").Reverse(), []string{"D", "F", "C", "A", "E", "B"}},
+				{Query[testRecord](db).OrderBy("Code").Skip(2).Limit(3), []string{"C", "D", "E"}},
+				{Query[testRecord](db).Where("Group", Eq, "G9"), []string{}},
+			} {
+				result, err := test.query.Find()
+				if err != nil {
+					t.Errorf("Find(%v): %v", test.query, err)
+					continue
+				}
+				if got := codesFound(result); !slices.Equal(got, test.want) {
+					t.Errorf("Find(%v) returned %v, want %v", test.query, got, test.want)
+				}
+				count, err := test.query.Count()
+				if err != nil || count != len(test.want) {
+					t.Errorf("Count(%v) returned %d, %v; want %d", test.query, count, err, len(test.want))
+				}
+			}
+		})
+	}
+}
+
+// TestQueryFirstKeepsLimit checks that First does not change the builder it is called on,
+// even when the builder is shared.
+func TestQueryFirstKeepsLimit(t *testing.T) {
+	db, _ := queryTestDB(t, "test_query_first", true)
+	query := Query[testRecord](db).Where("Group", Eq, "G1").Limit(2)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record, err := query.First()
+			if err != nil || record.Code != "A" {
+				t.Errorf("First returned %+v, %v", record, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := query.Find()
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if got := codesFound(result); !slices.Equal(got, []string{"A", "C"}) {
+		t.Errorf("Find after First returned %v, want [A C]", got)
+	}
+	if _, err := Query[testRecord](db).Where("Code", Eq, "Z").First(); err == nil {
+		t.Error("First with no match returned no error")
+	}
+}
+
+func TestQueryValidation(t *testing.T) {
+	db, _ := queryTestDB(t, "test_query_invalid", false)
+	for _, test := range []struct {
+		name string
+		err  error
+	}{
+		{"unknown field", func() error { _, err := Query[testRecord](db).Where("Nope", Eq, "A").Find(); return err }()},
+		{"wrong type", func() error { _, err := Query[testRecord](db).Where("ID", Eq, "A").Find(); return err }()},
+		{"In without a slice", func() error { _, err := Query[testRecord](db).Where("Code", In, "A").Find(); return err }()},
+		{"Re without a string", func() error { _, err := Query[testRecord](db).Where("Code", Re, 1).Find(); return err }()},
+		{"unknown sort field", func() error { _, err := Query[testRecord](db).OrderBy("Nope").Find(); return err }()},
+		{"pointer type", func() error { _, err := Query[*testRecord](db).Find(); return err }()},
+		{"no database", func() error { _, err := Query[testRecord](nil).Count(); return err }()},
+	} {
+		if test.err == nil {
+			t.Errorf("%v: query returned no error", test.name)
+		}
+	}
+}
+
+// nullableRecord has a field that can be nil, to check how the cache sorts nil values.
+type nullableRecord struct {
+	ID   int `storm:"id"`
+	Note *string
+}
+
+func TestCompareValues(t *testing.T) {
+	a, b := "a", "b"
+	var nilString *string
+	var nilAny any
+	for _, test := range []struct {
+		left, right reflect.Value
+		want        int
+	}{
+		{reflect.ValueOf(1), reflect.ValueOf(2), -1},
+		{reflect.ValueOf(uint(2)), reflect.ValueOf(uint(1)), 1},
+		{reflect.ValueOf(1.5), reflect.ValueOf(1.5), 0},
+		{reflect.ValueOf("b"), reflect.ValueOf("a"), 1},
+		{reflect.ValueOf(&a), reflect.ValueOf(&b), -1},
+		{reflect.ValueOf(nilString), reflect.ValueOf(&a), -1},
+		{reflect.ValueOf(&a), reflect.ValueOf(nilString), 1},
+		{reflect.ValueOf(nilString), reflect.ValueOf(nilString), 0},
+		{reflect.ValueOf(&nilAny).Elem(), reflect.ValueOf(nilString), 0},
+		{reflect.Value{}, reflect.Value{}, 0},
+		{reflect.Value{}, reflect.ValueOf(1), -1},
+	} {
+		if got := compareValues(test.left, test.right); got != test.want {
+			t.Errorf("compareValues(%v, %v) = %d, want %d", test.left, test.right, got, test.want)
+		}
+	}
+}
+
+// TestQueryOrderByNil checks that ordering by a field holding nils gives the same order
+// whatever order the cache holds the records in.
+func TestQueryOrderByNil(t *testing.T) {
+	a, b := "a", "b"
+	records := []nullableRecord{{ID: 1, Note: &b}, {ID: 2}, {ID: 3, Note: &a}, {ID: 4}}
+	notes := func(records []nullableRecord) string {
+		var rtn []string
+		for _, record := range records {
+			if record.Note == nil {
+				rtn = append(rtn, "nil")
+			} else {
+				rtn = append(rtn, *record.Note)
+			}
+		}
+		return fmt.Sprint(rtn)
+	}
+	for _, reverse := range []bool{false, true} {
+		want := "[nil nil a b]"
+		if reverse {
+			want = "[b a nil nil]"
+		}
+		for range len(records) {
+			// Rotate the input, so each record starts first once
+			records = append(records[1:], records[0])
+			query := Query[nullableRecord](nil).OrderBy("Note")
+			if reverse {
+				query = query.Reverse()
+			}
+			result, err := query.evaluate(slices.Clone(records))
+			if err != nil {
+				t.Fatalf("evaluate: %v", err)
+			}
+			if got := notes(result); got != want {
+				t.Errorf("%v of %v returned %v, want %v", query, notes(records), got, want)
+			}
+		}
+	}
+}
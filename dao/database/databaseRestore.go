@@ -0,0 +1,282 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/ioHelpers"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// BackupReport is the result of inspecting a backup with InspectBackup.
+type BackupReport struct {
+	BackupFile
+	Path   string
+	Tables map[string]int // records in each table
+	Err    error          // why the backup is not valid; nil if it is
+}
+
+// ListBackupFiles returns the backups in the backup folder dir, from its manifest.
+//
+// Folders written before manifests were kept are listed from their database files, with the
+// namespace taken from the file name and the folder's modification time; their backups have
+// no checksum.
+func ListBackupFiles(dir string) ([]BackupFile, error) {
+	manifest, err := ReadBackupManifest(dir)
+	if err == nil {
+		return manifest.Files, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading manifest of %v: %w", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []BackupFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if file, ok := legacyBackup(e); ok {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// legacyBackup describes a database file in a backup folder without a manifest.
+func legacyBackup(e os.DirEntry) (BackupFile, bool) {
+	name := e.Name()
+	file := BackupFile{File: name, Compression: NoCompression}
+	for _, c := range []Compression{GzipCompression, ZstdCompression} {
+		ext, _ := c.extension()
+		if strings.HasSuffix(name, ext) {
+			file.Compression = c
+			name = strings.TrimSuffix(name, ext)
+		}
+	}
+	if !strings.HasSuffix(name, ".db") {
+		return BackupFile{}, false
+	}
+	name = strings.TrimSuffix(name, ".db")
+	prefix := strings.ToLower(cfg.GetApplication_Name()) + "-"
+	file.NameSpace = strings.TrimPrefix(name, prefix)
+	if info, err := e.Info(); err == nil {
+		file.Created = info.ModTime()
+	}
+	return file, true
+}
+
+// InspectBackup checks the backup file at path against its folder's manifest, opens it
+// read-only, checks the consistency of its buckets and counts the records in each table.
+// Problems are reported in the Err of the report.
+func InspectBackup(path string) BackupReport {
+	report := BackupReport{Path: path, BackupFile: BackupFile{File: filepath.Base(path)}}
+	entry, file, err := findBackup("", path)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	report.BackupFile = entry
+	report.Path = file
+	report.Err = withBackup(file, entry, func(tx *bolt.Tx) error {
+		report.Tables = countTables(tx)
+		return nil
+	})
+	return report
+}
+
+// withBackup unpacks and checks the backup file, and calls fn with a read transaction on it.
+func withBackup(file string, entry BackupFile, fn func(tx *bolt.Tx) error) error {
+	temp, err := os.CreateTemp("", "amphora-backup-*.db")
+	if err != nil {
+		return err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	if err := unpackChecked(file, temp.Name(), entry); err != nil {
+		return err
+	}
+	boltDB, err := bolt.Open(temp.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+	return boltDB.View(fn)
+}
+
+// countTables returns the number of records in each table of tx.
+func countTables(tx *bolt.Tx) map[string]int {
+	tables := make(map[string]int)
+	tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+		if !bytes.HasPrefix(name, stormBucketPrefix) {
+			tables[string(name)] = countRecords(bucket)
+		}
+		return nil
+	})
+	return tables
+}
+
+// countRecords returns the number of records in a table bucket; Storm's nested index and
+// metadata buckets are not counted.
+func countRecords(bucket *bolt.Bucket) int {
+	count := 0
+	bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// TableNames returns the names of the tables in tables, sorted.
+func TableNames(tables map[string]int) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RestoreTable replaces one table of the namespace nameSpace with the table as it is in a
+// backup, and returns the number of records restored. backupPath is a backup folder or file,
+// as for Restore.
+//
+// Unlike Restore, the namespace may be connected: the table is replaced in one transaction on
+// the live connection, and its cache, if it has one, is cleared and hydrated again. A paused
+// connection returns ErrPaused. The backup must have been written with the codec of the
+// namespace.
+func RestoreTable(nameSpace, table, backupPath string) (int, error) {
+	nameSpace = strings.ToLower(nameSpace)
+	logHandler.DatabaseLogger.Printf("[ADM] RestoreTable [...%v.db] %v from %v started", nameSpace, table, backupPath)
+	timer := timing.Start(nameSpace, "RestoreTable", table)
+
+	entry, file, err := findBackup(nameSpace, backupPath)
+	count := 0
+	live := false
+	if err == nil {
+		err = withBackup(file, entry, func(src *bolt.Tx) error {
+			bucket := src.Bucket([]byte(table))
+			if bucket == nil {
+				return fmt.Errorf("%w: %v has no table %v", ErrBackupInvalid, file, table)
+			}
+			count = countRecords(bucket)
+			var restoreErr error
+			live, restoreErr = restoreBucket(nameSpace, table, bucket)
+			return restoreErr
+		})
+	}
+	if err != nil {
+		timer.Stop(0)
+		logHandler.ErrorLogger.Printf("[ADM] RestoreTable [...%v.db] %v - Error: %v", nameSpace, table, err)
+		return 0, err
+	}
+	timer.Stop(count)
+	if live {
+		refreshCache(entities.Table(table))
+	}
+	logHandler.DatabaseLogger.Printf("[ADM] RestoreTable [...%v.db] %v completed, %d records restored from %v", nameSpace, table, count, file)
+	return count, nil
+}
+
+// restoreBucket replaces the table bucket of the namespace with src, on the pooled connection
+// if there is one, and reports whether there was.
+//
+// A paused connection is refused rather than waited for, as the pool is held while the table
+// is written, and a Pause started meanwhile waits until the table has been written.
+func restoreBucket(nameSpace, table string, src *bolt.Bucket) (bool, error) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	waitForOpen(nameSpace)
+	want := ""
+	var boltDB *bolt.DB
+	db := connectionPool[nameSpace]
+	if db != nil {
+		db.pauseMu.Lock()
+		defer db.pauseMu.Unlock()
+		if db.pauses > 0 {
+			return false, fmt.Errorf("%w: cannot restore table %v of [...%v.db] until it is resumed", ErrPaused, table, nameSpace)
+		}
+		db.flushPending()
+		boltDB = db.connection.Bolt
+		want = db.connection.Node.Codec().Name()
+	} else {
+		file := ioHelpers.GetDBFileName(nameSpace)
+		var err error
+		boltDB, err = bolt.Open(file, 0666, &bolt.Options{Timeout: restoreLockTimeout})
+		if err != nil {
+			if errors.Is(err, bolterrors.ErrTimeout) {
+				return false, &ConnectError{NameSpace: nameSpace, Path: file, Reason: "file locked by another process", Err: ErrInUse}
+			}
+			return false, err
+		}
+		defer boltDB.Close()
+	}
+	return db != nil, boltDB.Update(func(tx *bolt.Tx) error {
+		name := []byte(table)
+		if current := tx.Bucket(name); current != nil {
+			if want == "" {
+				want = bucketCodec(current)
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		if got := bucketCodec(src); want != "" && got != "" && got != want {
+			return fmt.Errorf("%w: table %v was backed up with codec %q, but the namespace uses %q", ErrBackupInvalid, table, got, want)
+		}
+		dst, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src, dst)
+	})
+}
+
+// bucketCodec returns the codec Storm recorded for a table bucket.
+func bucketCodec(bucket *bolt.Bucket) string {
+	if meta := bucket.Bucket([]byte("__storm_metadata")); meta != nil {
+		return string(meta.Get([]byte("codec")))
+	}
+	return ""
+}
+
+// copyBucket copies the keys, values and nested buckets of src into dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
+
+// refreshCache clears the cache of a restored table, and hydrates it again. Tables that are
+// not cached are left alone.
+func refreshCache(table entities.Table) {
+	if err := cache.Clear(table); err != nil {
+		// the table is not cached
+		return
+	}
+	if err := cache.Hydrate(table); err != nil {
+		logHandler.WarningLogger.Printf("[ADM] Restore %v - cache cleared, but not hydrated: %v", table, err)
+	}
+}
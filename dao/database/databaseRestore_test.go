@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInspectBackup(t *testing.T) {
+	const nameSpace = "test_inspect"
+	db := openTestDB(t, nameSpace)
+	createTestRecords(t, db, "A", "B", "C")
+	dir := t.TempDir()
+	entry, err := db.BackupTo(dir, GzipCompression)
+	if err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+
+	file := filepath.Join(dir, entry.File)
+	report := InspectBackup(file)
+	if report.Err != nil {
+		t.Fatalf("InspectBackup: %v", report.Err)
+	}
+	if got := report.Tables[testTable]; got != 3 {
+		t.Errorf("InspectBackup counted %d records in %v, want 3", got, testTable)
+	}
+
+	corruptBackup(t, file)
+	if report := InspectBackup(file); !errors.Is(report.Err, ErrBackupInvalid) {
+		t.Errorf("InspectBackup returned %v, want %v", report.Err, ErrBackupInvalid)
+	}
+	if _, err := RestoreTable(nameSpace, testTable, file); !errors.Is(err, ErrBackupInvalid) {
+		t.Errorf("RestoreTable returned %v, want %v", err, ErrBackupInvalid)
+	}
+}
+
+func TestRestoreTableRoundTrip(t *testing.T) {
+	const nameSpace = "test_restore_table"
+	db := openTestDB(t, nameSpace)
+	createTestRecords(t, db, "A", "B", "C")
+	dir := t.TempDir()
+	entry, err := db.BackupTo(dir, NoCompression)
+	if err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+	createTestRecords(t, db, "D")
+	var first testRecord
+	if err := db.connection.One("Code", "A", &first); err != nil {
+		t.Fatalf("reading A: %v", err)
+	}
+	if err := db.connection.DeleteStruct(&first); err != nil {
+		t.Fatalf("deleting A: %v", err)
+	}
+
+	// The namespace stays connected while one table is restored
+	count, err := RestoreTable(nameSpace, testTable, filepath.Join(dir, entry.File))
+	if err != nil {
+		t.Fatalf("RestoreTable: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("RestoreTable restored %d records, want 3", count)
+	}
+	if got, want := storedCodes(t, db), []string{"A", "B", "C"}; !slices.Equal(got, want) {
+		t.Errorf("restored records are %v, want %v", got, want)
+	}
+	// The unique index is restored with the table
+	if err := db.Create(&testRecord{Code: "A"}); err == nil {
+		t.Error("Create of a duplicate code succeeded after RestoreTable")
+	}
+
+	if _, err := RestoreTable(nameSpace, "noSuchTable", dir); !errors.Is(err, ErrBackupInvalid) {
+		t.Errorf("RestoreTable of a missing table returned %v, want %v", err, ErrBackupInvalid)
+	}
+}
+
+// TestRestoreTablePaused checks that RestoreTable refuses a paused connection, rather than
+// waiting for it while holding the pool.
+func TestRestoreTablePaused(t *testing.T) {
+	const nameSpace = "test_restore_paused"
+	db := openTestDB(t, nameSpace)
+	createTestRecords(t, db, "A")
+	dir := t.TempDir()
+	if _, err := db.BackupTo(dir, NoCompression); err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+	createTestRecords(t, db, "B")
+	if err := db.Pause(); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := RestoreTable(nameSpace, testTable, dir)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPaused) {
+			t.Errorf("RestoreTable of a paused connection returned %v, want %v", err, ErrPaused)
+		}
+	case <-time.After(5 * time.Second):
+		db.Resume()
+		t.Fatal("RestoreTable waited for the paused connection")
+	}
+
+	// The pool is free, so another namespace can connect while this one is paused
+	closeTestDB(t, openTestDB(t, "test_restore_paused_other"))
+	if err := db.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if count, err := RestoreTable(nameSpace, testTable, dir); err != nil || count != 1 {
+		t.Fatalf("RestoreTable after Resume returned %d, %v; want 1", count, err)
+	}
+	if got := storedCodes(t, db); !slices.Equal(got, []string{"A"}) {
+		t.Errorf("the restored table holds %v, want [A]", got)
+	}
+}
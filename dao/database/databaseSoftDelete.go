@@ -0,0 +1,89 @@
+package database
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/asdine/storm/v3/q"
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// softDeleteTables holds the tables opened WithSoftDelete.
+var softDeleteTables sync.Map // entities.Table -> bool
+
+// setSoftDelete records whether reads of table skip soft-deleted records.
+func setSoftDelete(table any, enabled bool) {
+	tableName := entities.GetStructType(table)
+	if enabled {
+		softDeleteTables.Store(tableName, true)
+		logHandler.DatabaseLogger.Printf("[CON]{CONNECT} Soft delete enabled for table %v", tableName)
+		return
+	}
+	softDeleteTables.Delete(tableName)
+}
+
+// IsSoftDelete reports whether table was opened WithSoftDelete.
+func IsSoftDelete(table any) bool {
+	_, ok := softDeleteTables.Load(entities.GetStructType(table))
+	return ok
+}
+
+// IsDeleted reports whether record has been soft-deleted: whether it has an Audit field whose
+// DeletedAt is set.
+func IsDeleted(record any) bool {
+	value := reflect.Indirect(reflect.ValueOf(record))
+	if value.Kind() != reflect.Struct {
+		return false
+	}
+	field := value.FieldByName("Audit")
+	if !field.IsValid() {
+		return false
+	}
+	a, ok := field.Interface().(audit.Audit)
+	return ok && a.IsDeleted()
+}
+
+// withoutDeleted returns records without the soft-deleted ones, if T is a soft-delete table.
+// records is not modified.
+func withoutDeleted[T any](records []T) []T {
+	var record T
+	if !IsSoftDelete(record) {
+		return records
+	}
+	result := make([]T, 0, len(records))
+	for _, r := range records {
+		if !IsDeleted(r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// withoutDeletedRecords returns records, read untyped from the table of to, without the
+// soft-deleted ones, if it is a soft-delete table. records is not modified.
+func withoutDeletedRecords(to any, records []any) []any {
+	if !IsSoftDelete(to) {
+		return records
+	}
+	result := make([]any, 0, len(records))
+	for _, r := range records {
+		if !IsDeleted(r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// notDeleted returns a Storm matcher for records that have not been soft-deleted.
+func notDeleted() q.Matcher {
+	return q.NewFieldMatcher("Audit", notDeletedMatcher{})
+}
+
+type notDeletedMatcher struct{}
+
+func (notDeletedMatcher) MatchField(v any) (bool, error) {
+	a, ok := v.(audit.Audit)
+	return !ok || !a.IsDeleted(), nil
+}
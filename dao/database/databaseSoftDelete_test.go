@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/cache"
+)
+
+// softDeleteRecord is a table opened WithSoftDelete by the tests.
+type softDeleteRecord struct {
+	ID    int    `storm:"id,increment"`
+	Code  string `storm:"unique"`
+	Audit audit.Audit
+}
+
+// softDeleteDB opens an empty soft-delete namespace holding A, B and C, with B deleted.
+func softDeleteDB(t *testing.T, nameSpace string, caching bool) *DB {
+	t.Helper()
+	removeTestDB(t, nameSpace)
+	db, err := Open(&softDeleteRecord{}, WithNameSpace(nameSpace), WithSoftDelete(true), WithCaching(caching))
+	if err != nil {
+		t.Fatalf("Open(%v): %v", nameSpace, err)
+	}
+	t.Cleanup(func() {
+		closeTestDB(t, db)
+		_ = cache.Disable(&softDeleteRecord{})
+		setSoftDelete(&softDeleteRecord{}, false)
+		removeTestDB(t, nameSpace)
+	})
+	for _, code := range []string{"A", "B", "C"} {
+		record := &softDeleteRecord{Code: code}
+		if err := db.Create(record); err != nil {
+			t.Fatalf("Create %v: %v", code, err)
+		}
+		if code == "B" {
+			record.Audit.DeletedAt = time.Now()
+			if err := db.Update(record); err != nil {
+				t.Fatalf("Update %v: %v", code, err)
+			}
+		}
+	}
+	return db
+}
+
+func codesOf(records []any) []string {
+	var codes []string
+	for _, record := range records {
+		switch r := record.(type) {
+		case softDeleteRecord:
+			codes = append(codes, r.Code)
+		case *softDeleteRecord:
+			codes = append(codes, r.Code)
+		}
+	}
+	return codes
+}
+
+// TestUntypedReadsSkipDeleted checks that Get, GetAll and GetAllWhere skip soft-deleted
+// records, whether they are read from the cache or from Storm.
+func TestUntypedReadsSkipDeleted(t *testing.T) {
+	for _, caching := range []bool{false, true} {
+		t.Run(fmt.Sprintf("caching=%t", caching), func(t *testing.T) {
+			db := softDeleteDB(t, fmt.Sprintf("test_soft_delete_%t", caching), caching)
+
+			var deleted softDeleteRecord
+			if _, err := db.Get("Code", "B", &deleted); !errors.Is(err, storm.ErrNotFound) {
+				t.Errorf("Get of a deleted record returned %v, want %v", err, storm.ErrNotFound)
+			}
+			var kept softDeleteRecord
+			if _, err := db.Get("Code", "A", &kept); err != nil || kept.Code != "A" {
+				t.Errorf("Get of A returned %+v, %v", kept, err)
+			}
+
+			all, err := db.GetAll(&[]softDeleteRecord{})
+			if err != nil {
+				t.Fatalf("GetAll: %v", err)
+			}
+			if got := fmt.Sprint(codesOf(all)); got != "[A C]" {
+				t.Errorf("GetAll returned %v, want [A C]", got)
+			}
+			// Skip and Limit count the records returned, not the deleted ones
+			paged, err := db.GetAll(&[]softDeleteRecord{}, storm.Skip(1), storm.Limit(1))
+			if err != nil {
+				t.Fatalf("GetAll with Skip and Limit: %v", err)
+			}
+			if got := fmt.Sprint(codesOf(paged)); got != "[C]" {
+				t.Errorf("GetAll with Skip(1), Limit(1) returned %v, want [C]", got)
+			}
+
+			matching, err := db.GetAllWhere("Code", "B", &[]softDeleteRecord{})
+			if err != nil {
+				t.Fatalf("GetAllWhere: %v", err)
+			}
+			if len(matching) != 0 {
+				t.Errorf("GetAllWhere of a deleted record returned %v", codesOf(matching))
+			}
+		})
+	}
+}
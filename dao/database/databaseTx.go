@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// Writer is the write surface shared by *DB and *Tx.
+//
+// Generated DAOs use it so the same create/update logic can run either directly
+// against the database or inside a transaction.
+type Writer interface {
+	Create(data any) error
+	Update(data any) error
+	Delete(data any) error
+}
+
+var (
+	_ Writer = (*DB)(nil)
+	_ Writer = (*Tx)(nil)
+)
+
+type cacheAction int
+
+const (
+	cacheAdd cacheAction = iota
+	cacheRemove
+)
+
+// stagedCacheChange is a cache mutation held back until the transaction commits.
+type stagedCacheChange struct {
+	action cacheAction
+	data   any
+}
+
+// Tx is a read-write transaction against a single database namespace.
+//
+// All DAOs connected to the same namespace share the same underlying Storm database,
+// so a Tx can be used to make changes to several tables atomically. Cache changes are
+// staged and only applied to the cache once the transaction has committed.
+type Tx struct {
+	ctx         context.Context
+	db          *DB
+	node        storm.Node
+	staged      []stagedCacheChange
+	afterCommit []func()
+}
+
+// WithTx runs fn inside a read-write transaction.
+//
+// Reads that need to see uncommitted changes should use tx.Get; bolt allows only one
+// writer at a time, so fn must not call the non-transactional write methods on db.
+//
+// The transaction is committed if fn returns nil, and rolled back if fn returns an
+// error or panics. Cache changes made through the Tx are only applied after a
+// successful commit, and then the functions passed to AfterCommit are run.
+//
+// Example:
+//
+//	err := db.WithTx(ctx, func(tx *database.Tx) error {
+//		if err := tx.Create(&order); err != nil {
+//			return err
+//		}
+//		return tx.Update(&customer)
+//	})
+func (db *DB) WithTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	clock := timing.Start(db.Name, "Transaction", db.databaseName)
+	logHandler.DatabaseLogger.Printf("[TX] Begin [...%v.db]", db.Name)
+	db.flushPending()
+
+	node, err := db.connection.Begin(true)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[TX] Begin [...%v.db] - Error: %v", db.Name, err)
+		clock.Stop(0)
+		return err
+	}
+
+	tx := &Tx{db: db, node: node}
+	tx.ctx = ContextWithTx(ctx, tx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logHandler.ErrorLogger.Printf("[TX] Rollback [...%v.db] - Panic: %v", db.Name, r)
+			_ = node.Rollback()
+			clock.Stop(0)
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		logHandler.DatabaseLogger.Printf("[TX] Rollback [...%v.db] - Error: %v", db.Name, err)
+		if rbErr := node.Rollback(); rbErr != nil {
+			logHandler.ErrorLogger.Printf("[TX] Rollback [...%v.db] - Error: %v", db.Name, rbErr)
+		}
+		clock.Stop(0)
+		return err
+	}
+
+	if err = node.Commit(); err != nil {
+		logHandler.ErrorLogger.Printf("[TX] Commit [...%v.db] - Error: %v", db.Name, err)
+		clock.Stop(0)
+		return err
+	}
+	logHandler.DatabaseLogger.Printf("[TX] Commit [...%v.db] - %d change(s)", db.Name, len(tx.staged))
+
+	tx.applyStagedCacheChanges()
+	for _, fn := range tx.afterCommit {
+		fn()
+	}
+
+	clock.Stop(len(tx.staged))
+	return nil
+}
+
+// AfterCommit registers fn to run once the transaction has committed, in the order registered.
+// fn is not run if the transaction rolls back.
+//
+// Generated DAOs use it to publish the audit events of changes made in the transaction.
+func (tx *Tx) AfterCommit(fn func()) {
+	tx.afterCommit = append(tx.afterCommit, fn)
+}
+
+// Context returns the context the transaction was started with, carrying the Tx.
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+type txContextKey struct{}
+
+// ContextWithTx returns a copy of ctx that carries tx.
+func ContextWithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// TxFromContext returns the transaction carried by ctx, if any.
+//
+// Generated DAOs pass the transaction context to their hooks, so a postCreate or
+// postUpdate hook can make its own changes inside the same transaction.
+func TxFromContext(ctx context.Context) (*Tx, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tx, ok := ctx.Value(txContextKey{}).(*Tx)
+	return tx, ok
+}
+
+// DB returns the database connection the transaction belongs to.
+func (tx *Tx) DB() *DB {
+	return tx.db
+}
+
+// Get retrieves a single record inside the transaction.
+//
+// Reads bypass the cache so that changes made earlier in the transaction are visible.
+func (tx *Tx) Get(field entities.Field, value, to any) (any, error) {
+	logHandler.DatabaseLogger.Printf("[TX][GET] %v WHERE %+v=%+v) [...%v.db]", entities.GetStructType(to), field.String(), value, tx.db.Name)
+	err := tx.node.One(field.String(), value, to)
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[TX][GET] %v WHERE %+v=%+v) [...%v.db] - Error from DB: %v", entities.GetStructType(to), field.String(), value, tx.db.Name, err)
+		return nil, err
+	}
+	return to, nil
+}
+
+// Create adds a new record inside the transaction.
+func (tx *Tx) Create(data any) error {
+	logHandler.DatabaseLogger.Printf("[TX][CREATE] %v [...%v.db] (%.10s)", entities.GetStructType(data), tx.db.Name, fmt.Sprintf("%+v", data))
+	if err := validate(data, tx.db); err != nil {
+		return commonErrors.ErrCreateWrapper(err)
+	}
+	if err := tx.node.Save(data); err != nil {
+		logHandler.ErrorLogger.Printf("[TX][CREATE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), tx.db.Name, fmt.Sprintf("%+v", data), err)
+		return err
+	}
+	tx.stage(cacheAdd, data)
+	return nil
+}
+
+// Update modifies an existing record inside the transaction.
+func (tx *Tx) Update(data any) error {
+	logHandler.DatabaseLogger.Printf("[TX][UPDATE] %v [...%v.db] (%.10s)", entities.GetStructType(data), tx.db.Name, fmt.Sprintf("%+v", data))
+	if err := validate(data, tx.db); err != nil {
+		return commonErrors.ErrWrapper(err)
+	}
+	if err := tx.node.Update(data); err != nil {
+		logHandler.ErrorLogger.Printf("[TX][UPDATE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), tx.db.Name, fmt.Sprintf("%+v", data), err)
+		return err
+	}
+	tx.stage(cacheAdd, data)
+	return nil
+}
+
+// Delete removes a record inside the transaction.
+func (tx *Tx) Delete(data any) error {
+	logHandler.DatabaseLogger.Printf("[TX][DELETE] %v [...%v.db] (%.10s)", entities.GetStructType(data), tx.db.Name, fmt.Sprintf("%+v", data))
+	if err := tx.node.DeleteStruct(data); err != nil {
+		logHandler.ErrorLogger.Printf("[TX][DELETE] %v [...%v.db] (%.10s) - Error: %v", entities.GetStructType(data), tx.db.Name, fmt.Sprintf("%+v", data), err)
+		return err
+	}
+	tx.stage(cacheRemove, data)
+	return nil
+}
+
+// stage records a cache change to be applied on commit.
+//
+// A copy of the record is staged, so later changes the caller makes to data do not
+// leak into the cache.
+func (tx *Tx) stage(action cacheAction, data any) {
+	if !cache.IsEnabled(data) {
+		return
+	}
+	tx.staged = append(tx.staged, stagedCacheChange{action: action, data: snapshot(data)})
+}
+
+// applyStagedCacheChanges applies staged cache changes in the order they were made.
+func (tx *Tx) applyStagedCacheChanges() {
+	for _, change := range tx.staged {
+		var err error
+		switch change.action {
+		case cacheAdd:
+			err = cache.AddEntry(change.data)
+		case cacheRemove:
+			err = cache.RemoveEntry(change.data)
+		}
+		if err != nil {
+			// The database is the source of truth; drop the stale entry so it is reloaded.
+			logHandler.ErrorLogger.Printf("[TX] Commit [...%v.db] - Error applying cache change for %v: %v", tx.db.Name, entities.GetStructType(change.data), err)
+			_ = cache.RemoveEntry(change.data)
+		}
+	}
+}
+
+// snapshot returns a pointer to a copy of the struct pointed to by data.
+func snapshot(data any) any {
+	rv := reflect.ValueOf(data)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return data
+	}
+	cp := reflect.New(rv.Elem().Type())
+	cp.Elem().Set(rv.Elem())
+	return cp.Interface()
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/cache"
+)
+
+// cachedName returns the name the cache holds for the record with code.
+func cachedName(t *testing.T, code string) string {
+	t.Helper()
+	record, err := cache.GetWhere(&testRecord{}, "Code", code)
+	if err != nil {
+		return ""
+	}
+	return record.Name
+}
+
+func TestWithTxCommit(t *testing.T) {
+	db := openTestDB(t, "test_tx_commit", WithCaching(true))
+	createTestRecords(t, db, "A")
+	var a testRecord
+	if err := db.connection.One("Code", "A", &a); err != nil {
+		t.Fatalf("reading A: %v", err)
+	}
+
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		if err := tx.Create(&testRecord{Code: "B", Name: "created"}); err != nil {
+			return err
+		}
+		a.Name = "updated"
+		if err := tx.Update(&a); err != nil {
+			return err
+		}
+		// Changes are visible inside the transaction, but not yet cached
+		var b testRecord
+		if _, err := tx.Get("Code", "B", &b); err != nil {
+			t.Errorf("tx.Get of an uncommitted record: %v", err)
+		}
+		if name := cachedName(t, "B"); name != "" {
+			t.Error("the cache holds a record before the transaction commits")
+		}
+		if ctxTx, ok := TxFromContext(tx.Context()); !ok || ctxTx != tx {
+			t.Error("the transaction's context does not carry it")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if got, want := storedCodes(t, db), []string{"A", "B"}; !slices.Equal(got, want) {
+		t.Errorf("Storm holds %v, want %v", got, want)
+	}
+	if cachedName(t, "A") != "updated" || cachedName(t, "B") != "created" {
+		t.Errorf("the cache holds A=%q, B=%q after commit", cachedName(t, "A"), cachedName(t, "B"))
+	}
+}
+
+func TestWithTxRollback(t *testing.T) {
+	for _, fail := range []string{"error", "panic"} {
+		t.Run(fail, func(t *testing.T) {
+			db := openTestDB(t, "test_tx_rollback_"+fail, WithCaching(true))
+			createTestRecords(t, db, "A", "C")
+			var a, c testRecord
+			if err := db.connection.One("Code", "A", &a); err != nil {
+				t.Fatalf("reading A: %v", err)
+			}
+			if err := db.connection.One("Code", "C", &c); err != nil {
+				t.Fatalf("reading C: %v", err)
+			}
+
+			failure := errors.New("failed")
+			run := func() (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = failure
+					}
+				}()
+				return db.WithTx(context.Background(), func(tx *Tx) error {
+					if err := tx.Create(&testRecord{Code: "B"}); err != nil {
+						return err
+					}
+					a.Name = "updated"
+					if err := tx.Update(&a); err != nil {
+						return err
+					}
+					if err := tx.Delete(&c); err != nil {
+						return err
+					}
+					if fail == "panic" {
+						panic(failure)
+					}
+					return failure
+				})
+			}
+			if err := run(); !errors.Is(err, failure) {
+				t.Fatalf("WithTx returned %v, want %v", err, failure)
+			}
+
+			if got, want := storedCodes(t, db), []string{"A", "C"}; !slices.Equal(got, want) {
+				t.Errorf("Storm holds %v after rollback, want %v", got, want)
+			}
+			var stored testRecord
+			if err := db.connection.One("Code", "A", &stored); err != nil || stored.Name != "" {
+				t.Errorf("Storm holds A as %+v, %v after rollback", stored, err)
+			}
+			if cachedName(t, "A") != "" {
+				t.Error("the cache holds the rolled back update")
+			}
+			if _, err := cache.GetWhere(&testRecord{}, "Code", "B"); err == nil {
+				t.Error("the cache holds the rolled back create")
+			}
+			if _, err := cache.GetWhere(&testRecord{}, "Code", "C"); err != nil {
+				t.Errorf("the cache lost the record whose delete was rolled back: %v", err)
+			}
+		})
+	}
+}
+
+// TestTxWriteErrors checks that a failed write inside a transaction is returned to fn.
+func TestTxWriteErrors(t *testing.T) {
+	db := openTestDB(t, "test_tx_errors", WithCaching(true))
+	createTestRecords(t, db, "A")
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		return tx.Create(&testRecord{Code: "A"})
+	})
+	if !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Errorf("WithTx returned %v, want %v", err, storm.ErrAlreadyExists)
+	}
+	if got := storedCodes(t, db); !slices.Equal(got, []string{"A"}) {
+		t.Errorf("Storm holds %v, want [A]", got)
+	}
+}
+
+// TestUpdateFailureLeavesCache checks that an update Storm refuses does not reach the cache.
+func TestUpdateFailureLeavesCache(t *testing.T) {
+	db := openTestDB(t, "test_update_failure", WithCaching(true))
+	createTestRecords(t, db, "A", "B")
+	var b testRecord
+	if err := db.connection.One("Code", "B", &b); err != nil {
+		t.Fatalf("reading B: %v", err)
+	}
+
+	b.Code, b.Name = "A", "duplicate"
+	if err := db.Update(&b); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("Update returned %v, want %v", err, storm.ErrAlreadyExists)
+	}
+	var cached testRecord
+	if _, err := db.Get("ID", b.ID, &cached); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if cached.Code != "B" || cached.Name != "" {
+		t.Errorf("Get returned %+v after a failed update, want the stored record", cached)
+	}
+
+	b.Code, b.Name = "B", "renamed"
+	if err := db.Update(&b); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if cachedName(t, "B") != "renamed" {
+		t.Errorf("the cache holds B as %q after Update, want renamed", cachedName(t, "B"))
+	}
+}
+
+// TestAfterCommit checks that AfterCommit functions run in order once the transaction has
+// committed and its cache changes are applied, and not at all if it rolls back.
+func TestAfterCommit(t *testing.T) {
+	db := openTestDB(t, "test_tx_after_commit", WithCaching(true))
+	var ran []string
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		if err := tx.Create(&testRecord{Code: "A", Name: "first"}); err != nil {
+			return err
+		}
+		tx.AfterCommit(func() { ran = append(ran, "1:"+cachedName(t, "A")) })
+		tx.AfterCommit(func() { ran = append(ran, "2") })
+		if len(ran) != 0 {
+			t.Errorf("AfterCommit functions ran before the commit: %v", ran)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if want := []string{"1:first", "2"}; !slices.Equal(ran, want) {
+		t.Errorf("AfterCommit functions ran as %v, want %v", ran, want)
+	}
+
+	ran = nil
+	failed := errors.New("failed")
+	err = db.WithTx(context.Background(), func(tx *Tx) error {
+		tx.AfterCommit(func() { ran = append(ran, "rolled back") })
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("WithTx returned %v, want %v", err, failed)
+	}
+	func() {
+		defer func() { _ = recover() }()
+		_ = db.WithTx(context.Background(), func(tx *Tx) error {
+			tx.AfterCommit(func() { ran = append(ran, "panicked") })
+			panic("failed")
+		})
+	}()
+	if len(ran) != 0 {
+		t.Errorf("AfterCommit functions of rolled back transactions ran: %v", ran)
+	}
+}
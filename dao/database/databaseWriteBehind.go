@@ -0,0 +1,186 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/entities"
+	"github.com/mt1976/frantic-core/commonErrors"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// writeBehindItem is a queued save.
+//
+// provisional is the copy added to the cache at Create time; record is the copy saved
+// to the database, which picks up any ID assigned by Storm.
+type writeBehindItem struct {
+	provisional any
+	record      any
+}
+
+// writeBehindQueue is a bounded queue of saves, drained by a single background writer.
+type writeBehindQueue struct {
+	db         *DB
+	items      chan writeBehindItem
+	stop       chan struct{}
+	retries    int
+	retryDelay time.Duration
+	onError    WriteErrorHandler
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+	closed  bool
+	lastErr error
+}
+
+// newWriteBehindQueue creates the queue and starts its writer.
+func newWriteBehindQueue(db *DB, size, retries int, retryDelay time.Duration, onError WriteErrorHandler) *writeBehindQueue {
+	if size < 1 {
+		size = 1
+	}
+	wb := &writeBehindQueue{
+		db:         db,
+		items:      make(chan writeBehindItem, size),
+		stop:       make(chan struct{}),
+		retries:    retries,
+		retryDelay: retryDelay,
+		onError:    onError,
+	}
+	wb.idle = sync.NewCond(&wb.mu)
+	logHandler.DatabaseLogger.Printf("[WRITE BEHIND] Starting [...%v.db] writer (queue=%d, retries=%d)", db.Name, size, retries)
+	go wb.run()
+	return wb
+}
+
+// enqueue adds a save to the queue, blocking while the queue is full.
+func (wb *writeBehindQueue) enqueue(item writeBehindItem) error {
+	wb.mu.Lock()
+	if wb.closed {
+		wb.mu.Unlock()
+		return commonErrors.ErrCreateWrapper(fmt.Errorf("write-behind queue for [...%v.db] is closed", wb.db.Name))
+	}
+	wb.pending++
+	wb.mu.Unlock()
+
+	wb.items <- item
+	return nil
+}
+
+// run is the background writer.
+func (wb *writeBehindQueue) run() {
+	for {
+		select {
+		case item := <-wb.items:
+			wb.save(item)
+			wb.mu.Lock()
+			wb.pending--
+			if wb.pending == 0 {
+				wb.idle.Broadcast()
+			}
+			wb.mu.Unlock()
+		case <-wb.stop:
+			return
+		}
+	}
+}
+
+// save writes a queued record, retrying on failure, then reconciles the cache.
+func (wb *writeBehindQueue) save(item writeBehindItem) {
+	tableName := entities.GetStructType(item.record)
+	var err error
+	for attempt := 0; attempt <= wb.retries; attempt++ {
+		if attempt > 0 {
+			logHandler.WarningLogger.Printf("[WRITE BEHIND] %v [...%v.db] - Retry %d/%d after: %v", tableName, wb.db.Name, attempt, wb.retries, err)
+			time.Sleep(wb.retryDelay * time.Duration(attempt))
+		}
+		if err = wb.db.connection.Save(item.record); err == nil {
+			break
+		}
+	}
+
+	if cache.IsEnabled(item.record) {
+		// Replace the provisional entry, which may have been keyed before an ID was assigned.
+		_ = cache.RemoveEntry(item.provisional)
+		if err == nil {
+			if cacheErr := cache.AddEntry(item.record); cacheErr != nil {
+				logHandler.ErrorLogger.Printf("[WRITE BEHIND] %v [...%v.db] - Error updating Cache: %v", tableName, wb.db.Name, cacheErr)
+			}
+		}
+	}
+
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[WRITE BEHIND] %v [...%v.db] (%.10s) - Save failed after %d retries: %v", tableName, wb.db.Name, fmt.Sprintf("%+v", item.record), wb.retries, err)
+		wb.mu.Lock()
+		wb.lastErr = err
+		wb.mu.Unlock()
+		if wb.onError != nil {
+			wb.onError(item.record, err)
+		}
+	}
+}
+
+// wait blocks until every queued save has been processed, leaving any save error to be
+// reported by flush.
+func (wb *writeBehindQueue) wait() {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.waitLocked()
+}
+
+func (wb *writeBehindQueue) waitLocked() {
+	for wb.pending > 0 {
+		wb.idle.Wait()
+	}
+}
+
+// flush waits until every queued save has been processed.
+//
+// It returns the last save error since the previous flush, if any.
+func (wb *writeBehindQueue) flush() error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.waitLocked()
+	err := wb.lastErr
+	wb.lastErr = nil
+	return err
+}
+
+// close stops accepting saves, flushes the queue and stops the writer.
+func (wb *writeBehindQueue) close() error {
+	wb.mu.Lock()
+	if wb.closed {
+		wb.mu.Unlock()
+		return nil
+	}
+	wb.closed = true
+	wb.mu.Unlock()
+
+	err := wb.flush()
+	close(wb.stop)
+	logHandler.DatabaseLogger.Printf("[WRITE BEHIND] Stopped [...%v.db] writer", wb.db.Name)
+	return err
+}
+
+// Flush waits until all write-behind saves queued on db have been written.
+//
+// It returns the last save error since the previous Flush, if any. When the connection
+// uses WriteThrough, Flush returns immediately.
+func (db *DB) Flush() error {
+	if db.writeBehind == nil {
+		return nil
+	}
+	logHandler.DatabaseLogger.Printf("[WRITE BEHIND] Flushing [...%v.db]", db.Name)
+	return db.writeBehind.flush()
+}
+
+// flushPending waits for the write-behind queue, if any, to be written. Save errors are
+// kept for the next Flush, and reported to the write error handler.
+func (db *DB) flushPending() {
+	if db.writeBehind == nil {
+		return
+	}
+	db.writeBehind.wait()
+}
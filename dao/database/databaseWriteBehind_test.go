@@ -0,0 +1,186 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/mt1976/frantic-amphora/dao/cache"
+)
+
+// writeBehindDB opens an empty namespace with the cache on and saves queued to the writer.
+func writeBehindDB(t *testing.T, nameSpace string, options ...Option) *DB {
+	t.Helper()
+	return openTestDB(t, nameSpace, append([]Option{WithCaching(true), WithWriteMode(WriteBehind)}, options...)...)
+}
+
+func TestWriteBehindFlush(t *testing.T) {
+	db := writeBehindDB(t, "test_wb_flush")
+	const count = 20
+	for i := range count {
+		if err := db.Create(&testRecord{Code: fmt.Sprintf("F%02d", i), Name: "flush"}); err != nil {
+			t.Fatalf("Create %d: %v", i, err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	stored := storedRecords(t, db)
+	if len(stored) != count {
+		t.Fatalf("Storm holds %d records after Flush, want %d", len(stored), count)
+	}
+	cached, err := cache.GetAll(&testRecord{})
+	if err != nil {
+		t.Fatalf("cache.GetAll: %v", err)
+	}
+	if len(cached) != count {
+		t.Fatalf("cache holds %d records after Flush, want %d", len(cached), count)
+	}
+	for _, record := range cached {
+		if record.ID < 100 {
+			t.Errorf("cached record %v has ID %d; the provisional entry was not replaced", record.Code, record.ID)
+		}
+	}
+}
+
+func TestWriteBehindRetryAndErrorHandler(t *testing.T) {
+	var mu sync.Mutex
+	var failed []any
+	var failures []error
+	const retries = 2
+	const delay = 20 * time.Millisecond
+	db := writeBehindDB(t, "test_wb_retry",
+		WithWriteRetries(retries, delay),
+		WithWriteErrorHandler(func(data any, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, data)
+			failures = append(failures, err)
+		}))
+
+	// A record saved around the queue makes the queued save break the unique index
+	if err := db.connection.Save(&testRecord{Code: "DUP"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	started := time.Now()
+	if err := db.Create(&testRecord{Code: "DUP", Name: "queued"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	err := db.Flush()
+	elapsed := time.Since(started)
+	if !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Fatalf("Flush returned %v, want %v", err, storm.ErrAlreadyExists)
+	}
+	// The writer waits delay, then 2*delay, between attempts
+	if elapsed < 3*delay {
+		t.Errorf("save failed after %v; want at least %v of retries", elapsed, 3*delay)
+	}
+	if err := db.Flush(); err != nil {
+		t.Errorf("second Flush returned %v; the error should only be reported once", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 {
+		t.Fatalf("error handler called %d times, want 1", len(failed))
+	}
+	if record, ok := failed[0].(*testRecord); !ok || record.Name != "queued" {
+		t.Errorf("error handler given %#v, want the queued record", failed[0])
+	}
+	if !errors.Is(failures[0], storm.ErrAlreadyExists) {
+		t.Errorf("error handler given %v, want %v", failures[0], storm.ErrAlreadyExists)
+	}
+	if _, err := cache.GetWhere(&testRecord{}, "Name", "queued"); err == nil {
+		t.Error("the cache still holds the record that failed to save")
+	}
+	if stored := storedRecords(t, db); len(stored) != 1 {
+		t.Errorf("Storm holds %d records, want 1", len(stored))
+	}
+}
+
+// TestWriteBehindErrorKeptForFlush checks that writes which wait for the queue leave the
+// save error for Flush to return.
+func TestWriteBehindErrorKeptForFlush(t *testing.T) {
+	db := writeBehindDB(t, "test_wb_kept", WithWriteRetries(0, 0))
+	if err := db.connection.Save(&testRecord{Code: "DUP"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := db.Create(&testRecord{Code: "DUP", Name: "queued"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var stored testRecord
+	if err := db.connection.One("Code", "DUP", &stored); err != nil {
+		t.Fatalf("reading DUP: %v", err)
+	}
+	stored.Name = "updated"
+	// Update waits for the queued create before it writes
+	if err := db.Update(&stored); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := db.Flush(); !errors.Is(err, storm.ErrAlreadyExists) {
+		t.Errorf("Flush after Update returned %v, want %v", err, storm.ErrAlreadyExists)
+	}
+}
+
+func TestWriteBehindCloseFlushesQueue(t *testing.T) {
+	const nameSpace = "test_wb_close"
+	db := writeBehindDB(t, nameSpace, WithWriteQueueSize(4))
+	const count = 25
+	for i := range count {
+		if err := db.Create(&testRecord{Code: fmt.Sprintf("C%02d", i)}); err != nil {
+			t.Fatalf("Create %d: %v", i, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := db.Create(&testRecord{Code: "LATE"}); err == nil {
+		t.Error("Create after Close returned no error")
+	}
+
+	reopened, err := Open(&testRecord{}, WithNameSpace(nameSpace))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeTestDB(t, reopened)
+	if stored := storedRecords(t, reopened); len(stored) != count {
+		t.Errorf("Storm holds %d records after Close, want %d", len(stored), count)
+	}
+}
+
+func TestWriteThroughFlushIsNoop(t *testing.T) {
+	db := openTestDB(t, "test_wb_through", WithCaching(true))
+	if err := db.Create(&testRecord{Code: "T1"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+	if stored := storedRecords(t, db); len(stored) != 1 {
+		t.Errorf("Storm holds %d records, want 1", len(stored))
+	}
+}
+
+func TestWriteBehindRefusesHistory(t *testing.T) {
+	const nameSpace = "test_wb_history"
+	removeTestDB(t, nameSpace)
+	t.Cleanup(func() { removeTestDB(t, nameSpace) })
+	_, err := Open(&testRecord{}, WithNameSpace(nameSpace), WithHistory(true), WithWriteMode(WriteBehind))
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) || !errors.Is(err, ErrHistoryWriteBehind) {
+		t.Fatalf("Open with history and write-behind returned %v, want a *ConnectError for %v", err, ErrHistoryWriteBehind)
+	}
+
+	// A table with history is refused a shared write-behind connection too
+	db := writeBehindDB(t, nameSpace)
+	if _, err := Open(&softDeleteRecord{}, WithNameSpace(nameSpace), WithHistory(true)); !errors.Is(err, ErrHistoryWriteBehind) {
+		t.Errorf("Open with history on a write-behind connection returned %v, want %v", err, ErrHistoryWriteBehind)
+	}
+	if db.refs != 1 {
+		t.Errorf("the connection has %d refs after the refusal, want 1", db.refs)
+	}
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-core/ioHelpers"
+)
+
+// testRecord is the table used by the package tests.
+type testRecord struct {
+	ID    int    `storm:"id,increment=100"`
+	Code  string `storm:"unique"`
+	Group string `storm:"index"`
+	Name  string
+}
+
+// openTestDB opens an empty namespace for the table testRecord, closing it and removing its
+// file when the test ends.
+func openTestDB(t *testing.T, nameSpace string, options ...Option) *DB {
+	t.Helper()
+	removeTestDB(t, nameSpace)
+	db, err := Open(&testRecord{}, append([]Option{WithNameSpace(nameSpace)}, options...)...)
+	if err != nil {
+		t.Fatalf("Open(%v): %v", nameSpace, err)
+	}
+	t.Cleanup(func() {
+		closeTestDB(t, db)
+		_ = cache.Disable(&testRecord{})
+		removeTestDB(t, nameSpace)
+	})
+	return db
+}
+
+// closeTestDB closes db, if it is still open.
+func closeTestDB(t *testing.T, db *DB) {
+	t.Helper()
+	if err := db.Close(); err != nil && !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Close(%v): %v", db.Name, err)
+	}
+}
+
+// removeTestDB removes the database file of nameSpace, and any file kept by a restore,
+// creating the database folder if need be.
+func removeTestDB(t *testing.T, nameSpace string) {
+	t.Helper()
+	file := ioHelpers.GetDBFileName(nameSpace)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("creating database folder: %v", err)
+	}
+	for _, path := range []string{file, file + ".pre-restore"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("removing %v: %v", path, err)
+		}
+	}
+}
+
+// storedRecords returns the records of testRecord held by Storm, bypassing the cache.
+func storedRecords(t *testing.T, db *DB) []testRecord {
+	t.Helper()
+	var records []testRecord
+	if err := db.connection.All(&records); err != nil {
+		t.Fatalf("reading %v from Storm: %v", db.Name, err)
+	}
+	return records
+}
+
+// TestConcurrentAccess runs creates, updates, deletes and reads through a cached DB while the
+// cache is hydrated and purged. Run it with -race.
+func TestConcurrentAccess(t *testing.T) {
+	db := openTestDB(t, "test_concurrent", WithCaching(true), WithIndex("Group"))
+	cache.RegisterHydrator(&testRecord{}, func() ([]any, error) {
+		var records []testRecord
+		if err := db.connection.All(&records); err != nil {
+			return nil, err
+		}
+		rtn := make([]any, 0, len(records))
+		for i := range records {
+			rtn = append(rtn, &records[i])
+		}
+		return rtn, nil
+	})
+	if err := cache.RegisterExpiry(&testRecord{}, time.Hour); err != nil {
+		t.Fatalf("RegisterExpiry: %v", err)
+	}
+
+	const workers, iterations = 4, 24
+	var wg sync.WaitGroup
+	run := func(work func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				work(i)
+			}
+		}()
+	}
+	for w := range workers {
+		run(func(i int) {
+			record := &testRecord{Code: fmt.Sprintf("W%d-%d", w, i), Group: fmt.Sprintf("G%d", i%3)}
+			if err := db.Create(record); err != nil {
+				t.Errorf("Create %v: %v", record.Code, err)
+				return
+			}
+			record.Name = "updated"
+			if err := db.Update(record); err != nil {
+				t.Errorf("Update %v: %v", record.Code, err)
+			}
+			if i%2 == 0 {
+				if err := db.Delete(record); err != nil {
+					t.Errorf("Delete %v: %v", record.Code, err)
+				}
+			}
+		})
+		run(func(i int) {
+			var record testRecord
+			_, _ = db.Get("Code", fmt.Sprintf("W%d-%d", w, i), &record)
+			_, _ = db.GetAll(&[]testRecord{})
+			_, _ = db.GetAllWhere("Group", "G1", &[]testRecord{})
+			_, _ = Query[testRecord](db).Where("Name", Eq, "updated").Count()
+		})
+	}
+	run(func(int) { _ = cache.HydrateForType(&testRecord{}) })
+	run(func(int) { cache.PurgeExpiredEntries() })
+	wg.Wait()
+
+	// Half of each worker's records were deleted
+	if stored := storedRecords(t, db); len(stored) != workers*iterations/2 {
+		t.Errorf("Storm holds %d records, want %d", len(stored), workers*iterations/2)
+	}
+	for _, record := range storedRecords(t, db) {
+		if record.Name != "updated" {
+			t.Errorf("record %v was not updated", record.Code)
+		}
+	}
+}
+
+// TestEvictedRecordsReadFromStorm checks that records evicted from the cache are still read,
+// one at a time and as a table.
+func TestEvictedRecordsReadFromStorm(t *testing.T) {
+	db := openTestDB(t, "test_evicted", WithCaching(true), WithCacheCapacity(2, 0, cache.LRU))
+	createTestRecords(t, db, "A", "B", "C", "D")
+	if count, _ := cache.Count(&testRecord{}); count != 2 {
+		t.Fatalf("the cache holds %d records, want 2", count)
+	}
+	var a testRecord
+	if _, err := db.Get("Code", "A", &a); err != nil || a.Code != "A" {
+		t.Errorf("Get of an evicted record returned %+v, %v", a, err)
+	}
+	all, err := db.GetAll(&[]testRecord{})
+	if err != nil || len(all) != 4 {
+		t.Errorf("GetAll returned %d records, %v; want 4", len(all), err)
+	}
+	if count, err := Query[testRecord](db).Count(); err != nil || count != 4 {
+		t.Errorf("Query Count returned %d, %v; want 4", count, err)
+	}
+}
+
+// TestGetOfRecordCachedByGetAllWhere checks that Get reads a record GetAllWhere cached from
+// Storm, which caches it as a struct rather than a pointer.
+func TestGetOfRecordCachedByGetAllWhere(t *testing.T) {
+	db := openTestDB(t, "test_get_cached_struct", WithCaching(true), WithCacheCapacity(1, 0, cache.LRU))
+	createTestRecords(t, db, "A", "B")
+	// A was evicted, so GetAllWhere reads Storm and caches what it finds
+	if _, err := db.GetAllWhere("Group", "", &[]testRecord{}); err != nil {
+		t.Fatalf("GetAllWhere: %v", err)
+	}
+	var b testRecord
+	if _, err := db.Get("Code", "B", &b); err != nil || b.Code != "B" {
+		t.Errorf("Get returned %+v, %v", b, err)
+	}
+}
+
+// TestCacheMetricsFromReads checks that reads count cache hits, and fallbacks to Storm when
+// the cache cannot answer them.
+func TestCacheMetricsFromReads(t *testing.T) {
+	db := openTestDB(t, "test_metrics", WithCaching(true), WithCacheCapacity(1, 0, cache.LRU))
+	createTestRecords(t, db, "A", "B")
+	before, _ := cache.GetMetricsForType(&testRecord{})
+
+	var b testRecord
+	if _, err := db.Get("Code", "B", &b); err != nil {
+		t.Fatalf("Get of a cached record: %v", err)
+	}
+	after, _ := cache.GetMetricsForType(&testRecord{})
+	if after.Hits != before.Hits+1 || after.Fallbacks != before.Fallbacks {
+		t.Errorf("Get of a cached record counted %d hits, %d fallbacks", after.Hits-before.Hits, after.Fallbacks-before.Fallbacks)
+	}
+
+	// A was evicted, so the table is no longer complete
+	if _, err := db.GetAll(&[]testRecord{}); err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if _, err := db.GetAllWhere("Group", "", &[]testRecord{}); err != nil {
+		t.Fatalf("GetAllWhere: %v", err)
+	}
+	final, _ := cache.GetMetricsForType(&testRecord{})
+	if final.Fallbacks != after.Fallbacks+2 {
+		t.Errorf("reads of an incomplete table counted %d fallbacks, want 2", final.Fallbacks-after.Fallbacks)
+	}
+}
+
+// TestWarmCacheBackend checks that a warm cache file is loaded after a restart, unless the
+// database has been written since.
+func TestWarmCacheBackend(t *testing.T) {
+	const nameSpace = "test_warm_cache"
+	backend := WithCacheBackend(cache.FileBackend(t.TempDir()))
+	db := openTestDB(t, nameSpace, WithCaching(true), backend)
+	t.Cleanup(func() {
+		closeTestDB(t, db)
+		cache.Initialise()
+	})
+	createTestRecords(t, db, "A", "B")
+	restart := func(options ...Option) {
+		t.Helper()
+		closeTestDB(t, db)
+		cache.Initialise()
+		var err error
+		if db, err = Open(&testRecord{}, append([]Option{WithNameSpace(nameSpace)}, options...)...); err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+	}
+
+	restart(WithCaching(true), backend)
+	if count, _ := cache.Count(&testRecord{}); count != 2 {
+		t.Errorf("the warm cache holds %d records after a restart, want 2", count)
+	}
+
+	// A write the cache does not see makes the file out of date
+	restart()
+	if err := db.Create(&testRecord{Code: "C"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	restart(WithCaching(true), backend)
+	if count, _ := cache.Count(&testRecord{}); count != 0 {
+		t.Errorf("the warm cache holds %d records after the database changed, want 0", count)
+	}
+}
+
+func TestCacheBackendRefusedWithEncryption(t *testing.T) {
+	const nameSpace = "test_encrypted_backend"
+	removeTestDB(t, nameSpace)
+	t.Cleanup(func() { removeTestDB(t, nameSpace) })
+	keys, err := NewKeyRing("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	_, err = Open(&testRecord{}, WithNameSpace(nameSpace), WithEncryption(true), WithKeyProvider(keys),
+		WithCaching(true), WithCacheBackend(cache.FileBackend(t.TempDir())))
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) || !errors.Is(err, ErrEncryptedCacheBackend) {
+		t.Fatalf("Open with encryption and a cache backend returned %v, want a *ConnectError for %v", err, ErrEncryptedCacheBackend)
+	}
+}
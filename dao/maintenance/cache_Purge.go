@@ -0,0 +1,64 @@
+package maintenance
+
+import (
+	"fmt"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-amphora/jobs"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// CachePurgeJob removes expired entries from the cache.
+//
+// Its schedule is read from purgeSchedule in the [Cache] section of the config.
+type CachePurgeJob struct {
+	purged int
+}
+
+func (job *CachePurgeJob) Run() error {
+	jobs.PreRun(job)
+	performCachePurge(job)
+	jobs.PostRun(job)
+	return nil
+}
+
+func (job *CachePurgeJob) Service() func() {
+	return func() {
+		_ = job.Run()
+	}
+}
+
+func (job *CachePurgeJob) Schedule() string {
+	return getCacheSettings().Cache.PurgeSchedule
+}
+
+func (job *CachePurgeJob) Name() string {
+	return "Maintenance - Purge Expired Cache Entries"
+}
+
+// Purged returns the number of entries removed by the last run.
+func (job *CachePurgeJob) Purged() int {
+	return job.purged
+}
+
+func performCachePurge(job *CachePurgeJob) {
+	name := jobs.CodedName(job)
+	j := timing.Start(name, "Purge", job.Description())
+
+	job.purged = cache.PurgeExpiredEntries()
+	logHandler.ServiceLogger.Printf("[%v] [%v] Purged [%v] expired entries", domain, name, job.purged)
+
+	j.Stop(job.purged)
+}
+
+func (job *CachePurgeJob) AddDatabaseAccessFunctions(fn func() ([]*database.DB, error)) {
+	// The cache is shared by all databases, so there is nothing to add
+	logHandler.ServiceLogger.Printf("[%v] [%v] Database functions are not used", domain, job.Name())
+}
+
+func (job *CachePurgeJob) Description() string {
+	sched := jobs.GetHumanReadableCronFreq(job.Schedule())
+	return fmt.Sprintf("Purges Expired Cache Entries, next run at %v", sched)
+}
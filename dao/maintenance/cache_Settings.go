@@ -0,0 +1,43 @@
+package maintenance
+
+import (
+	"github.com/gorhill/cronexpr"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+var defaultCachePurgeSchedule = "*/10 * * * *"
+
+// The synchronise job writes every cached record back, so it only runs if a schedule is set
+var defaultCacheSynchroniseSchedule = ""
+
+// cacheSettings is the [Cache] section of the common config file.
+type cacheSettings struct {
+	Cache struct {
+		PurgeSchedule       string `toml:"purgeSchedule"`
+		SynchroniseSchedule string `toml:"synchroniseSchedule"`
+	} `toml:"Cache"`
+}
+
+// getCacheSettings reads the [Cache] section of common.toml.
+// Missing or invalid schedules are replaced by the defaults; an empty schedule disables the job.
+func getCacheSettings() cacheSettings {
+	var settings cacheSettings
+	if err := readCommonTOML(&settings); err != nil {
+		logHandler.WarningLogger.Printf("[%v] Reading common.toml Error: [%v], using default cache schedules", domain, err.Error())
+	}
+	settings.Cache.PurgeSchedule = validSchedule("Cache.purgeSchedule", settings.Cache.PurgeSchedule, defaultCachePurgeSchedule)
+	settings.Cache.SynchroniseSchedule = validSchedule("Cache.synchroniseSchedule", settings.Cache.SynchroniseSchedule, defaultCacheSynchroniseSchedule)
+	return settings
+}
+
+// validSchedule returns schedule if it is a valid cron expression, otherwise defaultSchedule.
+func validSchedule(setting, schedule, defaultSchedule string) string {
+	if schedule == "" {
+		return defaultSchedule
+	}
+	if _, err := cronexpr.Parse(schedule); err != nil {
+		logHandler.WarningLogger.Printf("[%v] Invalid %v [%v] Error: [%v], using [%v]", domain, setting, schedule, err.Error(), defaultSchedule)
+		return defaultSchedule
+	}
+	return schedule
+}
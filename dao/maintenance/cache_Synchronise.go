@@ -0,0 +1,73 @@
+package maintenance
+
+import (
+	"fmt"
+
+	"github.com/mt1976/frantic-amphora/dao/cache"
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-amphora/jobs"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// CacheSynchroniseJob writes the cached records of every table with a registered
+// synchroniser back to the database.
+//
+// Its schedule is read from synchroniseSchedule in the [Cache] section of the config. It has
+// no default, so the job is not scheduled unless one is set.
+type CacheSynchroniseJob struct {
+	synchronised int
+}
+
+func (job *CacheSynchroniseJob) Run() error {
+	jobs.PreRun(job)
+	err := performCacheSynchronise(job)
+	jobs.PostRun(job)
+	return err
+}
+
+func (job *CacheSynchroniseJob) Service() func() {
+	return func() {
+		_ = job.Run()
+	}
+}
+
+func (job *CacheSynchroniseJob) Schedule() string {
+	return getCacheSettings().Cache.SynchroniseSchedule
+}
+
+func (job *CacheSynchroniseJob) Name() string {
+	return "Maintenance - Synchronise Cache"
+}
+
+// Synchronised returns the number of records synchronised by the last run.
+func (job *CacheSynchroniseJob) Synchronised() int {
+	return job.synchronised
+}
+
+func performCacheSynchronise(job *CacheSynchroniseJob) error {
+	name := jobs.CodedName(job)
+	j := timing.Start(name, "Synchronise", job.Description())
+
+	count, err := cache.SynchroniseAll()
+	job.synchronised = count
+	if err != nil {
+		logHandler.ErrorLogger.Printf("[%v] [%v] Synchronised [%v] records, Error: [%v]", domain, name, count, err.Error())
+		j.Stop(count)
+		return err
+	}
+	logHandler.ServiceLogger.Printf("[%v] [%v] Synchronised [%v] records", domain, name, count)
+
+	j.Stop(count)
+	return nil
+}
+
+func (job *CacheSynchroniseJob) AddDatabaseAccessFunctions(fn func() ([]*database.DB, error)) {
+	// Each table's synchroniser writes through its own DAO, so there is nothing to add
+	logHandler.ServiceLogger.Printf("[%v] [%v] Database functions are not used", domain, job.Name())
+}
+
+func (job *CacheSynchroniseJob) Description() string {
+	sched := jobs.GetHumanReadableCronFreq(job.Schedule())
+	return fmt.Sprintf("Synchronises Cached Records to the Database, next run at %v", sched)
+}
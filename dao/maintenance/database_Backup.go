@@ -12,15 +12,18 @@ import (
 	"github.com/mt1976/frantic-core/timing"
 )
 
+// DatabaseBackupJob takes online backups of databases into a dated backup folder, with a
+// manifest of their checksums. Set Compression to compress them.
 type DatabaseBackupJob struct {
+	Compression       database.Compression
 	databaseAccessors []func() ([]*database.DB, error)
 }
 
 func (job *DatabaseBackupJob) Run() error {
 	jobs.PreRun(job)
-	performDatabaseBackup(job)
+	err := performDatabaseBackup(job)
 	jobs.PostRun(job)
-	return nil
+	return err
 }
 
 func (job *DatabaseBackupJob) Service() func() {
@@ -37,7 +40,7 @@ func (job *DatabaseBackupJob) Name() string {
 	return "Maintenance - Backup Database"
 }
 
-func performDatabaseBackup(job *DatabaseBackupJob) {
+func performDatabaseBackup(job *DatabaseBackupJob) error {
 	logHandler.ServiceLogger.Printf("[%v] [%v] Started", domain, job.Name())
 
 	// Get a coded name for the job
@@ -54,28 +57,44 @@ func performDatabaseBackup(job *DatabaseBackupJob) {
 	//create a folder
 	err := ioHelpers.MkDir(fullBackupPath)
 	if err != nil {
-		logHandler.ServiceLogger.Panicf("[%v] [%v] Error: [%v]", domain, name, err.Error())
+		logHandler.ServiceLogger.Printf("[%v] [%v] Error: [%v]", domain, name, err.Error())
+		j.Stop(0)
+		return err
 	}
 	count := 0
+	var firstErr error
+	// DAOs in a namespace share one pooled connection; back each up once
+	done := make(map[string]bool)
 	for _, thisFunc := range job.databaseAccessors {
 		dbList, err := thisFunc()
 		if err != nil {
-			logHandler.ServiceLogger.Panicf("[%v] [%v] Error: [%v]", domain, name, err.Error())
-			panic(err)
+			logHandler.ServiceLogger.Printf("[%v] [%v] Error: [%v]", domain, name, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 		for _, db := range dbList {
+			if done[db.Name] {
+				continue
+			}
+			done[db.Name] = true
 			count++
 			logHandler.ServiceLogger.Printf("[%v] [%v] Backup [%v]", domain, name, db.Name)
-			db.Disconnect()
-			logHandler.ServiceLogger.Printf("[%v] [%v] Disconnected [%v]", domain, name, db.Name)
-			db.Backup(fullBackupPath)
-			logHandler.ServiceLogger.Printf("[%v] [%v] Done [%v]", domain, name, db.Name)
-			db.Reconnect()
-			logHandler.ServiceLogger.Printf("[%v] [%v] Reconnected [%v]", domain, name, db.Name)
+			backup, err := db.BackupTo(fullBackupPath, job.Compression)
+			if err != nil {
+				logHandler.ServiceLogger.Printf("[%v] [%v] Error backing up [%v]: [%v]", domain, name, db.Name, err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			logHandler.ServiceLogger.Printf("[%v] [%v] Done [%v] [%v]", domain, name, db.Name, backup.File)
 		}
 	}
 	j.Stop(count)
 	logHandler.ServiceLogger.Printf("[%v] [%v] Completed", domain, job.Name())
+	return firstErr
 }
 
 func (job *DatabaseBackupJob) AddDatabaseAccessFunctions(fn func() ([]*database.DB, error)) {
@@ -2,28 +2,30 @@ package maintenance
 
 import (
 	"fmt"
-	"os"
-	"time"
 
 	"github.com/mt1976/frantic-amphora/dao/database"
 	"github.com/mt1976/frantic-amphora/jobs"
 	"github.com/mt1976/frantic-core/application"
-	"github.com/mt1976/frantic-core/commonConfig"
 	"github.com/mt1976/frantic-core/dateHelpers"
 	"github.com/mt1976/frantic-core/ioHelpers"
 	"github.com/mt1976/frantic-core/logHandler"
-	"github.com/mt1976/frantic-core/paths"
 	"github.com/mt1976/frantic-core/timing"
 )
 
+// DatabaseBackupCleanerJob prunes the backup folders that fall outside its retention policy,
+// which is read from the [Backups] section of common.toml unless Retention is set. Set DryRun
+// to log what would be pruned, without deleting anything.
 type DatabaseBackupCleanerJob struct {
+	Retention *BackupRetention
+	DryRun    bool
+	pruned    []string
 }
 
 func (job *DatabaseBackupCleanerJob) Run() error {
 	jobs.PreRun(job)
-	pruneExpiredBackups(job)
+	err := pruneExpiredBackups(job)
 	jobs.PostRun(job)
-	return nil
+	return err
 }
 
 func (job *DatabaseBackupCleanerJob) Service() func() {
@@ -41,87 +43,60 @@ func (job *DatabaseBackupCleanerJob) Name() string {
 	return "Maintenance - Prune Old Backups"
 }
 
-func pruneExpiredBackups(job *DatabaseBackupCleanerJob) {
+// Pruned returns the backup folders pruned by the last run, or that would have been in a dry run.
+func (job *DatabaseBackupCleanerJob) Pruned() []string {
+	return job.pruned
+}
 
-	settings := commonConfig.Get()
-	// Do something every day at midnight
+func pruneExpiredBackups(job *DatabaseBackupCleanerJob) error {
 	name := jobs.CodedName(job)
-
 	j := timing.Start(job.Name(), "Maintenance", job.Description())
-	// Get Settings
-
-	retainBackupDays := settings.GetBackup_RetainForDays()
-
-	logHandler.ServiceLogger.Printf("[%v] RetainBackupDays: [%v]", name, retainBackupDays)
-	today := jobs.StartOfDay(time.Now())
 
-	// get today's date
-	DMY := dateHelpers.Format.DMY
-	todayStr := today.Format(DMY)
-	logHandler.ServiceLogger.Printf("[%v] Today: [%v]", name, todayStr)
-	deleteBeforeDate := today.AddDate(0, 0, -retainBackupDays)
-	deleteBeforeDateStr := deleteBeforeDate.Format(DMY)
-	logHandler.ServiceLogger.Printf("[%v] Delete Backups Before: [%v]", name, deleteBeforeDateStr)
-
-	// Get Backups path
-	path := paths.Backups().String()
-	logHandler.ServiceLogger.Printf("[%v] Path: [%v]", name, path)
-	full := paths.Application().String()
-	logHandler.ServiceLogger.Printf("[%v] AppPath: [%v]", name, full)
-	backupPath := full + path
-	logHandler.ServiceLogger.Printf("[%v] BackupPath: [%v]", name, backupPath)
+	policy := BackupRetentionSettings()
+	if job.Retention != nil {
+		policy = *job.Retention
+	}
+	logHandler.ServiceLogger.Printf("[%v] Retention: [%v] DryRun: [%v]", name, policy, job.DryRun)
+	job.pruned = nil
+	if policy.keepsNothing() {
+		logHandler.WarningLogger.Printf("[%v] Retention keeps no backups; nothing will be pruned", name)
+	}
 
-	// Get all folders in the backup directory
-	folders, err := ioHelpers.Dir(backupPath)
+	decisions, err := PlanBackupPrune(policy)
 	if err != nil {
-		logHandler.ServiceLogger.Panicf("[%v] Error: [%v]", name, err.Error())
-		return
+		logHandler.ErrorLogger.Printf("[%v] Error: [%v]", name, err.Error())
+		j.Stop(0)
+		return err
 	}
-	noFolders := len(folders)
+	DMY := dateHelpers.Format.DMY
+	noFolders := len(decisions)
 	logHandler.ServiceLogger.Printf("[%v] No Folders: [%v]", name, noFolders)
-	count := 0
-	// For each folder check if it is before the deleteBeforeDate
-	for x, folder := range folders {
-		// Get the date from the folder name
-		backupDate, err := getDateFromBackupFolderName(folder)
-		if err != nil {
-			logHandler.ServiceLogger.Panicf("[%v] Error: [%v]", name, err.Error())
-			return
+	var firstErr error
+	for x, d := range decisions {
+		if d.Keep {
+			logHandler.ServiceLogger.Printf("[%v] (%v/%v) Keeping Folder: [%v] Backup: [%v] Reason: [%v]", name, x+1, noFolders, d.Folder, d.Taken.Format(DMY), d.Reason)
+			continue
 		}
-
-		logHandler.ServiceLogger.Printf("[%v] (%v/%v) Folder: [%v] Backup: [%v] Delete Before: [%v]", name, x+1, noFolders, folder, backupDate.Format(DMY), deleteBeforeDate.Format(DMY))
-
-		// Check if the backupDate is before the deleteBeforeDate
-		if backupDate.Before(deleteBeforeDate) {
-			// Delete the folder
-			logHandler.ServiceLogger.Printf("[%v] Deleting: [%v] FolderDate: [%v] DeleteDate: [%v]", name, folder, backupDate.Format(DMY), deleteBeforeDateStr)
-			count++
-			logHandler.ServiceLogger.Printf("[%v] (%v/%v) Deleting Folder: [%v]", name, x+1, noFolders, folder)
-			// logHandler.ServiceLogger.Printf("[%v] pathSeparator=[%v]", name, os.PathSeparator)
-			// logHandler.ServiceLogger.Printf("[%v] PathListSeparator=[%v]", name, os.PathListSeparator)
-			deletePath := fmt.Sprintf("%v%c%v", backupPath, os.PathSeparator, folder)
-			logHandler.ServiceLogger.Printf("[%v] Deleting: [%v]", name, deletePath)
-			err := ioHelpers.DeleteFolder(deletePath)
-			if err != nil {
-				logHandler.ErrorLogger.Printf("[%v] Error: [%v]", name, err.Error())
-				return
+		if job.DryRun {
+			logHandler.ServiceLogger.Printf("[%v] (%v/%v) Would Delete Folder: [%v] Backup: [%v] Reason: [%v] Size: [%v]", name, x+1, noFolders, d.Folder, d.Taken.Format(DMY), d.Reason, d.Size)
+			job.pruned = append(job.pruned, d.Folder)
+			continue
+		}
+		logHandler.ServiceLogger.Printf("[%v] (%v/%v) Deleting Folder: [%v] Backup: [%v] Reason: [%v]", name, x+1, noFolders, d.Folder, d.Taken.Format(DMY), d.Reason)
+		if err := ioHelpers.DeleteFolder(d.Path); err != nil {
+			logHandler.ErrorLogger.Printf("[%v] Error: [%v]", name, err.Error())
+			if firstErr == nil {
+				firstErr = err
 			}
-			msg := "Backup Pruned Folder: [%v] On: [%v]"
-			msg = fmt.Sprintf(msg, folder, application.HostName())
-			logHandler.ServiceLogger.Printf("[%v] [%v]", name, msg)
+			continue
 		}
+		job.pruned = append(job.pruned, d.Folder)
+		msg := "Backup Pruned Folder: [%v] On: [%v]"
+		msg = fmt.Sprintf(msg, d.Folder, application.HostName())
+		logHandler.ServiceLogger.Printf("[%v] [%v]", name, msg)
 	}
-	j.Stop(count)
-}
-
-func getDateFromBackupFolderName(folder string) (date time.Time, err error) {
-	// Get the date from the folder name
-	date, err = time.Parse(dateHelpers.Format.BackupFolder, folder)
-	if err != nil {
-		logHandler.ServiceLogger.Panicf("[%v] [%v] Error: [%v]", domain, "BACKUP", err.Error())
-		return
-	}
-	return
+	j.Stop(len(job.pruned))
+	return firstErr
 }
 
 func (job *DatabaseBackupCleanerJob) AddDatabaseAccessFunctions(fn func() ([]*database.DB, error)) {
@@ -130,9 +105,7 @@ func (job *DatabaseBackupCleanerJob) AddDatabaseAccessFunctions(fn func() ([]*da
 }
 
 func (job *DatabaseBackupCleanerJob) Description() string {
-	settings := commonConfig.Get()
-	retainBackupDays := settings.GetBackup_RetainForDays()
 	sched := jobs.GetHumanReadableCronFreq(job.Schedule())
-	returnString := fmt.Sprintf("Prunes Old Backups, Retaining the last %v days, run at %v", retainBackupDays, sched)
+	returnString := fmt.Sprintf("Prunes Old Backups outside the retention policy, run at %v", sched)
 	return returnString
 }
@@ -0,0 +1,103 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-amphora/jobs"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// DefaultPurgeDeletedAfter is how long SoftDeletePurgeJob keeps soft-deleted records when its
+// OlderThan is not set.
+const DefaultPurgeDeletedAfter = 30 * 24 * time.Hour
+
+// PurgeDeletedFunc permanently removes the records of a table soft-deleted more than olderThan
+// ago, and returns the number removed. The PurgeDeleted function of a DAO generated with
+// dao-gen -soft-delete is one.
+type PurgeDeletedFunc func(ctx context.Context, olderThan time.Duration) (int, error)
+
+// SoftDeletePurgeJob permanently removes soft-deleted records, once they are older than
+// OlderThan, from each table added with AddTable.
+type SoftDeletePurgeJob struct {
+	OlderThan time.Duration
+	tables    []purgeTable
+	purged    map[string]int
+}
+
+type purgeTable struct {
+	name  string
+	purge PurgeDeletedFunc
+}
+
+// AddTable adds the table, whose soft-deleted records are removed by purge, to the job.
+func (job *SoftDeletePurgeJob) AddTable(name string, purge PurgeDeletedFunc) {
+	job.tables = append(job.tables, purgeTable{name: name, purge: purge})
+}
+
+func (job *SoftDeletePurgeJob) Run() error {
+	jobs.PreRun(job)
+	err := purgeDeletedRecords(job)
+	jobs.PostRun(job)
+	return err
+}
+
+func (job *SoftDeletePurgeJob) Service() func() {
+	return func() {
+		_ = job.Run()
+	}
+}
+
+func (job *SoftDeletePurgeJob) Schedule() string {
+	return "40 0 * * *"
+}
+
+func (job *SoftDeletePurgeJob) Name() string {
+	return "Maintenance - Purge Deleted Records"
+}
+
+// Purged returns the number of records removed from each table by the last run.
+func (job *SoftDeletePurgeJob) Purged() map[string]int {
+	return job.purged
+}
+
+func purgeDeletedRecords(job *SoftDeletePurgeJob) error {
+	name := jobs.CodedName(job)
+	olderThan := job.OlderThan
+	if olderThan <= 0 {
+		olderThan = DefaultPurgeDeletedAfter
+	}
+	j := timing.Start(name, "Purge", job.Description())
+
+	job.purged = make(map[string]int)
+	total := 0
+	var firstErr error
+	for x, table := range job.tables {
+		count, err := table.purge(context.Background(), olderThan)
+		job.purged[table.name] = count
+		total += count
+		if err != nil {
+			logHandler.ErrorLogger.Printf("[%v] [%v] (%v/%v) Table: [%v] Error: [%v]", domain, name, x+1, len(job.tables), table.name, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logHandler.ServiceLogger.Printf("[%v] [%v] (%v/%v) Table: [%v] Purged [%v] records deleted over %v ago", domain, name, x+1, len(job.tables), table.name, count, olderThan)
+	}
+	j.Stop(total)
+	return firstErr
+}
+
+func (job *SoftDeletePurgeJob) AddDatabaseAccessFunctions(fn func() ([]*database.DB, error)) {
+	// Tables are added with AddTable, as each DAO removes its own records
+	logHandler.ServiceLogger.Printf("[%v] [%v] Database functions are not used", domain, job.Name())
+}
+
+func (job *SoftDeletePurgeJob) Description() string {
+	sched := jobs.GetHumanReadableCronFreq(job.Schedule())
+	return fmt.Sprintf("Purges Soft-Deleted Records, next run at %v", sched)
+}
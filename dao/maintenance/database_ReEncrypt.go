@@ -0,0 +1,86 @@
+package maintenance
+
+import (
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-amphora/jobs"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/timing"
+)
+
+// DatabaseReEncryptJob rewrites the records of encrypted databases with the current key.
+// Run it after rotating the key, or after enabling encryption on an existing database.
+type DatabaseReEncryptJob struct {
+	databaseAccessors []func() ([]*database.DB, error)
+	reEncrypted       int
+}
+
+func (job *DatabaseReEncryptJob) Run() error {
+	jobs.PreRun(job)
+	err := performDatabaseReEncrypt(job)
+	jobs.PostRun(job)
+	return err
+}
+
+func (job *DatabaseReEncryptJob) Service() func() {
+	return func() {
+		_ = job.Run()
+	}
+}
+
+func (job *DatabaseReEncryptJob) Schedule() string {
+	return "30 3 * * 0"
+}
+
+func (job *DatabaseReEncryptJob) Name() string {
+	return "Maintenance - Re-encrypt Database"
+}
+
+// ReEncrypted returns the number of records rewritten by the last run.
+func (job *DatabaseReEncryptJob) ReEncrypted() int {
+	return job.reEncrypted
+}
+
+func performDatabaseReEncrypt(job *DatabaseReEncryptJob) error {
+	logHandler.ServiceLogger.Printf("[%v] [%v] Started", domain, job.Name())
+
+	name := jobs.CodedName(job)
+	j := timing.Start(name, "ReEncrypt", job.Description())
+
+	job.reEncrypted = 0
+	var firstErr error
+	for _, thisFunc := range job.databaseAccessors {
+		dbList, err := thisFunc()
+		if err != nil {
+			logHandler.ServiceLogger.Printf("[%v] [%v] Error: [%v]", domain, name, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, db := range dbList {
+			count, err := db.ReEncrypt()
+			if err != nil {
+				logHandler.ServiceLogger.Printf("[%v] [%v] Error re-encrypting [%v]: [%v]", domain, name, db.Name, err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			job.reEncrypted += count
+			logHandler.ServiceLogger.Printf("[%v] [%v] Re-encrypted [%v] records in [%v]", domain, name, count, db.Name)
+		}
+	}
+	j.Stop(job.reEncrypted)
+	logHandler.ServiceLogger.Printf("[%v] [%v] Completed", domain, job.Name())
+	return firstErr
+}
+
+func (job *DatabaseReEncryptJob) AddDatabaseAccessFunctions(fn func() ([]*database.DB, error)) {
+	logHandler.ServiceLogger.Printf("[%v] [%v] Adding Function", domain, job.Name())
+	job.databaseAccessors = append(job.databaseAccessors, fn)
+	logHandler.ServiceLogger.Printf("[%v] [%v] Function Added - No Funcs=(%v)", domain, job.Name(), len(job.databaseAccessors))
+}
+
+func (job *DatabaseReEncryptJob) Description() string {
+	return "Database Re-encryption, runs at 03:30 on Sundays"
+}
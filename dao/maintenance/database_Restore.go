@@ -0,0 +1,141 @@
+package maintenance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/dao/database"
+	"github.com/mt1976/frantic-core/dateHelpers"
+	"github.com/mt1976/frantic-core/ioHelpers"
+	"github.com/mt1976/frantic-core/logHandler"
+	"github.com/mt1976/frantic-core/paths"
+)
+
+// BackupSet is a dated backup folder written by DatabaseBackupJob.
+type BackupSet struct {
+	Folder   string    // name of the folder, in the backup root
+	Path     string    // full path of the folder
+	Taken    time.Time // parsed from the folder name
+	Size     int64     // of the files in the folder
+	Files    []database.BackupFile
+	Manifest bool // false for folders written before manifests were kept
+	Err      error
+}
+
+// BackupRoot returns the folder DatabaseBackupJob writes its backup folders into.
+func BackupRoot() string {
+	return paths.Application().String() + paths.Backups().String()
+}
+
+// ListBackups returns the backup folders in the backup root, newest first. Folders whose name
+// is not a backup date are skipped.
+func ListBackups() ([]BackupSet, error) {
+	root := BackupRoot()
+	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+		// no backups have been taken
+		return nil, nil
+	}
+	folders, err := ioHelpers.Dir(root)
+	if err != nil {
+		return nil, err
+	}
+	var sets []BackupSet
+	for _, folder := range folders {
+		taken, err := time.ParseInLocation(dateHelpers.Format.BackupFolder, folder, time.Local)
+		if err != nil {
+			logHandler.WarningLogger.Printf("[%v] [%v] Skipping [%v]: not a backup folder", domain, "Backups", folder)
+			continue
+		}
+		set := BackupSet{Folder: folder, Path: filepath.Join(root, folder), Taken: taken}
+		set.Size = folderSize(set.Path)
+		_, err = os.Stat(filepath.Join(set.Path, database.BackupManifestName))
+		set.Manifest = err == nil
+		set.Files, set.Err = database.ListBackupFiles(set.Path)
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Taken.After(sets[j].Taken) })
+	return sets, nil
+}
+
+// folderSize returns the total size of the files in the folder at path.
+func folderSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// BackupPath resolves folder, the name of a backup folder in the backup root, to its path.
+// An absolute path, or a path that exists, is returned as it is, so a backup file, or a folder
+// elsewhere, can be given.
+func BackupPath(folder string) string {
+	if filepath.IsAbs(folder) {
+		return folder
+	}
+	if _, err := os.Stat(folder); err == nil {
+		return folder
+	}
+	return filepath.Join(BackupRoot(), folder)
+}
+
+// VerifyBackup inspects each backup in the backup folder, or the single backup file, folder.
+// Each report holds the record count of every table in the backup, or why it is not valid;
+// the error returned is that of the first invalid backup.
+func VerifyBackup(folder string) ([]database.BackupReport, error) {
+	path := BackupPath(folder)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := database.ListBackupFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			files = append(files, filepath.Join(path, entry.File))
+		}
+	}
+	var reports []database.BackupReport
+	var firstErr error
+	for _, file := range files {
+		report := database.InspectBackup(file)
+		if report.Err != nil {
+			logHandler.ServiceLogger.Printf("[%v] [%v] Invalid [%v]: [%v]", domain, "Verify", file, report.Err.Error())
+			if firstErr == nil {
+				firstErr = report.Err
+			}
+		} else {
+			logHandler.ServiceLogger.Printf("[%v] [%v] Valid [%v] Tables=(%v)", domain, "Verify", file, len(report.Tables))
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) == 0 {
+		return nil, errors.New("no backups in " + path)
+	}
+	return reports, firstErr
+}
+
+// RestoreNamespace restores the namespace nameSpace from the backup folder, or backup file,
+// folder. See database.Restore; the namespace must not be connected.
+func RestoreNamespace(nameSpace, folder string) error {
+	return database.Restore(nameSpace, BackupPath(folder))
+}
+
+// RestoreTable restores the table of the namespace nameSpace from the backup folder, or
+// backup file, folder, and returns the number of records restored. See database.RestoreTable;
+// the namespace may be connected.
+func RestoreTable(nameSpace, table, folder string) (int, error) {
+	return database.RestoreTable(nameSpace, table, BackupPath(folder))
+}
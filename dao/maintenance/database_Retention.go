@@ -0,0 +1,150 @@
+package maintenance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mt1976/frantic-amphora/jobs"
+	"github.com/mt1976/frantic-core/commonConfig"
+	"github.com/mt1976/frantic-core/logHandler"
+)
+
+// BackupRetention decides which backup folders DatabaseBackupCleanerJob keeps. A folder is
+// kept if any rule keeps it; the rest are pruned.
+//
+// Daily, Weekly and Monthly are grandfather-father-son rules: each keeps the newest backup of
+// that many of the most recent days, ISO weeks or months that have a backup.
+type BackupRetention struct {
+	RetainDays   int   // keep every backup taken in the last RetainDays days
+	Daily        int   // keep the newest backup of each of the last Daily days with a backup
+	Weekly       int   // keep the newest backup of each of the last Weekly weeks with a backup
+	Monthly      int   // keep the newest backup of each of the last Monthly months with a backup
+	Minimum      int   // always keep the newest Minimum backups, whatever the other rules say
+	MaxTotalSize int64 // if above zero, prune the oldest kept backups, beyond Minimum, until the total size is within MaxTotalSize bytes
+}
+
+// keepsNothing reports whether the policy has no rule that keeps a backup.
+func (r BackupRetention) keepsNothing() bool {
+	return r.RetainDays <= 0 && r.Daily <= 0 && r.Weekly <= 0 && r.Monthly <= 0 && r.Minimum <= 0
+}
+
+func (r BackupRetention) String() string {
+	return fmt.Sprintf("retainDays=%d daily=%d weekly=%d monthly=%d minimum=%d maxTotalSize=%d", r.RetainDays, r.Daily, r.Weekly, r.Monthly, r.Minimum, r.MaxTotalSize)
+}
+
+// backupSettings is the [Backups] section of the common config file, beyond retainDays,
+// which commonConfig.Settings reads.
+type backupSettings struct {
+	Backups struct {
+		KeepDaily      int `toml:"keepDaily"`
+		KeepWeekly     int `toml:"keepWeekly"`
+		KeepMonthly    int `toml:"keepMonthly"`
+		KeepMinimum    int `toml:"keepMinimum"`
+		MaxTotalSizeMB int `toml:"maxTotalSizeMB"`
+	} `toml:"Backups"`
+}
+
+// BackupRetentionSettings returns the retention policy in the [Backups] section of common.toml.
+func BackupRetentionSettings() BackupRetention {
+	var settings backupSettings
+	if err := readCommonTOML(&settings); err != nil {
+		logHandler.WarningLogger.Printf("[%v] Reading common.toml Error: [%v], using retainDays only", domain, err.Error())
+	}
+	return BackupRetention{
+		RetainDays:   commonConfig.Get().GetBackup_RetainForDays(),
+		Daily:        settings.Backups.KeepDaily,
+		Weekly:       settings.Backups.KeepWeekly,
+		Monthly:      settings.Backups.KeepMonthly,
+		Minimum:      settings.Backups.KeepMinimum,
+		MaxTotalSize: int64(settings.Backups.MaxTotalSizeMB) * 1024 * 1024,
+	}
+}
+
+// PruneDecision records whether a backup folder is kept or pruned, and why.
+type PruneDecision struct {
+	BackupSet
+	Keep   bool
+	Reason string
+}
+
+// PlanBackupPrune applies the retention policy to the backup folders in the backup root, and
+// returns a decision for each, newest first. Nothing is deleted.
+func PlanBackupPrune(policy BackupRetention) ([]PruneDecision, error) {
+	sets, err := ListBackups()
+	if err != nil {
+		return nil, err
+	}
+	return planPrune(sets, policy, time.Now()), nil
+}
+
+// planPrune applies policy, at now, to sets, which are newest first. A policy with no rule
+// that keeps a backup keeps them all, rather than pruning every backup.
+func planPrune(sets []BackupSet, policy BackupRetention, now time.Time) []PruneDecision {
+	decisions := make([]PruneDecision, len(sets))
+	for i, set := range sets {
+		decisions[i] = PruneDecision{BackupSet: set, Reason: "expired"}
+		if policy.keepsNothing() {
+			decisions[i] = PruneDecision{BackupSet: set, Keep: true, Reason: "no retention policy"}
+		}
+	}
+	if policy.keepsNothing() {
+		return decisions
+	}
+	keep := func(i int, reason string) {
+		if !decisions[i].Keep {
+			decisions[i].Keep = true
+			decisions[i].Reason = reason
+		}
+	}
+	if policy.RetainDays > 0 {
+		cutoff := jobs.StartOfDay(now).AddDate(0, 0, -policy.RetainDays)
+		for i, d := range decisions {
+			if !d.Taken.Before(cutoff) {
+				keep(i, "retainDays")
+			}
+		}
+	}
+	periods := []struct {
+		reason string
+		count  int
+		period func(time.Time) string
+	}{
+		{"daily", policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, p := range periods {
+		seen := make(map[string]bool)
+		for i, d := range decisions {
+			if len(seen) >= p.count {
+				break
+			}
+			if key := p.period(d.Taken); !seen[key] {
+				seen[key] = true
+				keep(i, p.reason)
+			}
+		}
+	}
+	for i := 0; i < len(decisions) && i < policy.Minimum; i++ {
+		keep(i, "minimum")
+	}
+	if policy.MaxTotalSize > 0 {
+		var total int64
+		for _, d := range decisions {
+			if d.Keep {
+				total += d.Size
+			}
+		}
+		for i := len(decisions) - 1; i >= policy.Minimum && total > policy.MaxTotalSize; i-- {
+			if decisions[i].Keep {
+				decisions[i].Keep = false
+				decisions[i].Reason = "maxTotalSize"
+				total -= decisions[i].Size
+			}
+		}
+	}
+	return decisions
+}
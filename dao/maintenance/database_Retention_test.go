@@ -0,0 +1,69 @@
+package maintenance
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// at returns the hour of a day in 2026, in the local zone jobs.StartOfDay uses.
+func at(month time.Month, day, hour int) time.Time {
+	return time.Date(2026, month, day, hour, 0, 0, 0, time.Local)
+}
+
+func TestPlanPrune(t *testing.T) {
+	now := at(time.March, 18, 12) // a Wednesday, in ISO week 12
+	for _, test := range []struct {
+		name   string
+		policy BackupRetention
+		taken  []time.Time
+		want   []string
+	}{
+		{"no policy keeps everything", BackupRetention{},
+			[]time.Time{at(time.March, 18, 1), at(time.January, 1, 1)},
+			[]string{"no retention policy", "no retention policy"}},
+		{"retainDays counts from the start of the day", BackupRetention{RetainDays: 2},
+			[]time.Time{at(time.March, 18, 1), at(time.March, 16, 0), at(time.March, 15, 23)},
+			[]string{"retainDays", "retainDays", "expired"}},
+		{"daily keeps the newest of each day with a backup", BackupRetention{Daily: 3},
+			[]time.Time{at(time.March, 18, 10), at(time.March, 18, 2), at(time.March, 17, 23), at(time.March, 15, 9), at(time.March, 15, 1), at(time.March, 14, 12)},
+			[]string{"daily", "expired", "daily", "daily", "expired", "expired"}},
+		{"weekly uses ISO weeks", BackupRetention{Weekly: 2},
+			[]time.Time{at(time.March, 18, 1), at(time.March, 10, 1), at(time.March, 9, 1), at(time.March, 8, 1), at(time.February, 20, 1)},
+			[]string{"weekly", "weekly", "expired", "expired", "expired"}},
+		{"monthly", BackupRetention{Monthly: 2},
+			[]time.Time{at(time.March, 18, 1), at(time.March, 1, 1), at(time.February, 27, 1), at(time.January, 5, 1)},
+			[]string{"monthly", "expired", "monthly", "expired"}},
+		{"the first rule to keep a backup gives the reason", BackupRetention{Daily: 1, Weekly: 2, Monthly: 3},
+			[]time.Time{at(time.March, 18, 1), at(time.March, 17, 1), at(time.March, 10, 1), at(time.February, 2, 1), at(time.February, 1, 1), at(time.January, 3, 1)},
+			[]string{"daily", "expired", "weekly", "monthly", "expired", "monthly"}},
+		{"minimum", BackupRetention{Minimum: 2},
+			[]time.Time{at(time.January, 3, 1), at(time.January, 2, 1), at(time.January, 1, 1)},
+			[]string{"minimum", "minimum", "expired"}},
+		{"maxTotalSize prunes the oldest kept backups", BackupRetention{Daily: 4, MaxTotalSize: 25},
+			[]time.Time{at(time.March, 18, 1), at(time.March, 17, 1), at(time.March, 16, 1), at(time.March, 15, 1)},
+			[]string{"daily", "daily", "maxTotalSize", "maxTotalSize"}},
+		{"maxTotalSize does not prune the minimum", BackupRetention{Daily: 3, Minimum: 2, MaxTotalSize: 5},
+			[]time.Time{at(time.March, 18, 1), at(time.March, 17, 1), at(time.March, 16, 1)},
+			[]string{"daily", "daily", "maxTotalSize"}},
+	} {
+		sets := make([]BackupSet, len(test.taken))
+		for i, taken := range test.taken {
+			sets[i] = BackupSet{Folder: taken.Format(time.DateTime), Taken: taken, Size: 10}
+		}
+		decisions := planPrune(sets, test.policy, now)
+		var got []string
+		for i, d := range decisions {
+			got = append(got, d.Reason)
+			if kept := d.Reason != "expired" && d.Reason != "maxTotalSize"; d.Keep != kept {
+				t.Errorf("%v: backup %d has Keep %t with reason %q", test.name, i, d.Keep, d.Reason)
+			}
+			if d.Folder != sets[i].Folder {
+				t.Errorf("%v: decision %d is for %v, want %v", test.name, i, d.Folder, sets[i].Folder)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%v: planPrune decided %q, want %q", test.name, got, test.want)
+		}
+	}
+}
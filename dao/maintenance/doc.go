@@ -1,3 +1,4 @@
-// Package maintenance contains database maintenance tasks such as pruning and
-// backup orchestration.
+// Package maintenance contains database and cache maintenance tasks such as pruning,
+// backup orchestration, backup verification and restore, re-encryption after a key rotation,
+// purges of soft-deleted records, cache expiry purges and cache synchronisation.
 package maintenance
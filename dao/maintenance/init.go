@@ -1,7 +1,31 @@
 package maintenance
 
+import (
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mt1976/frantic-core/paths"
+)
+
 var domain = "Maintenance"
 
+// readCommonTOML decodes the common config file into into.
+//
+// commonConfig.Settings only holds the settings frantic-core knows about, so the sections
+// used by maintenance jobs are read from the file here.
+func readCommonTOML(into any) error {
+	filename := paths.Application().String() + paths.Config().String() + string(os.PathSeparator) + "common.toml"
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := toml.Unmarshal(content, into); err != nil {
+		return fmt.Errorf("parsing %v: %w", filename, err)
+	}
+	return nil
+}
+
 func init() {
 
 	//logHandler.InfoLogger.Println("Text - Initialising")
@@ -9,7 +9,9 @@ package templateStoreV3
 import (
 	"context"
 	"fmt"
+	"iter"
 	"reflect"
+	"time"
 
 	"github.com/mt1976/frantic-amphora/dao"
 	"github.com/mt1976/frantic-amphora/dao/audit"
@@ -107,6 +109,80 @@ func GetAllUncached() ([]TemplateStoreV3, error) {
 	return result, nil
 }
 
+// GetPage returns a page of TemplateStoreV3 records, filtered and sorted as requested. Pass the
+// NextCursor of a page as the Cursor of the request for the next. See database.GetPage.
+func GetPage(ctx context.Context, request database.PageRequest) (database.Page[TemplateStoreV3], error) {
+	dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+
+	clock := timing.Start(tableName, "GetPage", request.String())
+	page, err := database.GetPage[TemplateStoreV3](activeDBConnection, request)
+	if err != nil {
+		clock.Stop(0)
+		return database.Page[TemplateStoreV3]{}, err
+	}
+	page.Items, err = postGetList(ctx, page.Items)
+	if err != nil {
+		clock.Stop(0)
+		return database.Page[TemplateStoreV3]{}, err
+	}
+	clock.Stop(len(page.Items))
+	return page, nil
+}
+
+// ForEach calls fn with each TemplateStoreV3 record, other than soft-deleted ones, read from the
+// database a batch at a time, so the table is never held in memory. It stops at the first
+// error from fn, which it returns, or when ctx is done. The cache is not used; see database.Each.
+func ForEach(ctx context.Context, fn func(TemplateStoreV3) error) error {
+	dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+
+	clock := timing.Start(tableName, "ForEach", "ALL")
+	count := 0
+	err := database.Each(activeDBConnection, func(record TemplateStoreV3) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if softDelete && record.Audit.IsDeleted() {
+			return nil
+		}
+		if err := record.postGet(ctx); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	clock.Stop(count)
+	return err
+}
+
+// Iterate returns an iterator over the TemplateStoreV3 records, read as ForEach reads them. An
+// error ends the iteration, and is yielded with an empty record.
+func Iterate(ctx context.Context) iter.Seq2[TemplateStoreV3, error] {
+	return func(yield func(TemplateStoreV3, error) bool) {
+		dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+		for record, err := range database.Iterate[TemplateStoreV3](activeDBConnection) {
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err == nil && softDelete && record.Audit.IsDeleted() {
+				continue
+			}
+			if err == nil {
+				err = record.postGet(ctx)
+			}
+			if err != nil {
+				yield(TemplateStoreV3{}, err)
+				return
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
 // GetAllWhere returns all records matching a field/value filter.
 func GetAllWhere(field entities.Field, value any) ([]TemplateStoreV3, error) {
 	//	logHandler.DatabaseLogger.Printf("SELECT %v WHERE (%v=%v)", tableName, field.String(), value)
@@ -127,6 +203,33 @@ func GetAllWhere(field entities.Field, value any) ([]TemplateStoreV3, error) {
 	return result, nil
 }
 
+// Query starts a composable query against the TemplateStoreV3 table.
+//
+// Run the query with GetAllMatching, or directly with Find/First/Count.
+func Query() *database.QueryBuilder[TemplateStoreV3] {
+	dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+	return database.Query[TemplateStoreV3](activeDBConnection)
+}
+
+// GetAllMatching returns all records matching the supplied query.
+func GetAllMatching(query *database.QueryBuilder[TemplateStoreV3]) ([]TemplateStoreV3, error) {
+	dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+
+	clock := timing.Start(tableName, "GetAllMatching", query.String())
+	records, err := query.Find()
+	if err != nil {
+		clock.Stop(0)
+		return nil, err
+	}
+	result, err := postGetList(context.Background(), records)
+	if err != nil {
+		clock.Stop(0)
+		return nil, err
+	}
+	clock.Stop(len(result))
+	return result, nil
+}
+
 // New returns an empty TemplateStoreV3 record.
 func New() TemplateStoreV3 {
 	return TemplateStoreV3{}
@@ -145,12 +248,31 @@ func Create(ctx context.Context, basis TemplateStoreV3) (TemplateStoreV3, error)
 	return basis, nil
 }
 
+// CreateTx constructs and inserts a new TemplateStoreV3 record inside the supplied transaction.
+//
+// Hooks such as postCreate receive the transaction context (see database.TxFromContext),
+// so their changes are committed or rolled back together with the record.
+func CreateTx(ctx context.Context, tx *database.Tx, basis TemplateStoreV3) (TemplateStoreV3, error) {
+	dao.CheckDAOReadyState(tableName, audit.CREATE, databaseConnectionActive)
+	if err := checkTx(tx); err != nil {
+		return basis, ce.ErrDAOCreateWrapper(tableName, basis.ID, err)
+	}
+	logHandler.TraceLogger.Printf("CreateTx %v Record: %v", tableName, basis.Key)
+	err := basis.insertOrUpdateWith(database.ContextWithTx(ctx, tx), tx, fmt.Sprintf("New %v Record", tableName), audit.CREATE, CREATE)
+	if err != nil {
+		return basis, ce.ErrDAOCreateWrapper(tableName, basis.ID, err)
+	}
+
+	return basis, nil
+}
+
 // Delete deletes a record by ID.
 func Delete(ctx context.Context, id int, note string) error {
 	return DeleteBy(ctx, Fields.ID, id, note)
 }
 
-// DeleteBy deletes a record by field/value.
+// DeleteBy deletes the records matching field/value. If the table soft-deletes, they are marked
+// deleted, and can be restored, rather than removed.
 func DeleteBy(ctx context.Context, field entities.Field, value any, note string) error {
 	//	logHandler.DatabaseLogger.Printf("DELETE %v WHERE %v=%v", tableName, field, value)
 	dao.CheckDAOReadyState(tableName, audit.DELETE, databaseConnectionActive)
@@ -169,31 +291,196 @@ func DeleteBy(ctx context.Context, field entities.Field, value any, note string)
 	}
 
 	for _, record := range recordList {
-		if err := record.Audit.Action(ctx, audit.DELETE.WithMessage(note)); err != nil {
+		if err := record.delete(ctx, note, false); err != nil {
 			clock.Stop(0)
-			return ce.ErrDAOUpdateAuditWrapper(tableName, value, err)
+			return err
 		}
+	}
 
-		if err := record.preDeleteProcessing(ctx); err != nil {
-			clock.Stop(0)
-			return ce.ErrDAODeleteWrapper(tableName, field.String(), value, err)
-		}
+	clock.Stop(1)
+	return nil
+}
 
-		if err := activeDBConnection.Delete(&record); err != nil {
-			clock.Stop(0)
-			return ce.ErrDAODeleteWrapper(tableName, field.String(), value, err)
+// Restore restores the soft-deleted record with the given ID, clearing its deletion audit.
+func Restore(ctx context.Context, id int) error {
+	dao.CheckDAOReadyState(tableName, audit.RESTORE, databaseConnectionActive)
+
+	if !softDelete {
+		return fmt.Errorf("restoring %v: %w", tableName, database.ErrSoftDeleteDisabled)
+	}
+	clock := timing.Start(tableName, "Restore", fmt.Sprintf("%v", id))
+
+	record, err := Query().IncludeDeleted().Where(Fields.ID, database.Eq, id).First()
+	if err != nil || !record.Audit.IsDeleted() {
+		clock.Stop(0)
+		return ce.ErrRecordNotFoundWrapper(tableName, Fields.ID.String(), fmt.Sprintf("%v", id))
+	}
+	if err := record.postGet(ctx); err != nil {
+		clock.Stop(0)
+		return err
+	}
+	if err := record.insertOrUpdate(ctx, fmt.Sprintf("Restored %v %v", tableName, id), audit.RESTORE, UPDATE); err != nil {
+		clock.Stop(0)
+		return err
+	}
+	clock.Stop(1)
+	return nil
+}
+
+// PurgeDeleted permanently removes the records soft-deleted more than olderThan ago, and
+// returns the number removed.
+func PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	dao.CheckDAOReadyState(tableName, audit.DELETE, databaseConnectionActive)
+
+	if !softDelete {
+		return 0, fmt.Errorf("purging %v: %w", tableName, database.ErrSoftDeleteDisabled)
+	}
+	clock := timing.Start(tableName, "PurgeDeleted", olderThan.String())
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+	err := database.Each(activeDBConnection, func(record TemplateStoreV3) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !record.Audit.IsDeleted() || record.Audit.DeletedAt.After(cutoff) {
+			return nil
+		}
+		if err := record.delete(ctx, fmt.Sprintf("Purging %v %v, deleted %v", tableName, record.ID, record.Audit.DeletedAtDisplay), true); err != nil {
+			return err
 		}
+		purged++
+		return nil
+	})
+	logHandler.DatabaseLogger.Printf("[PURGE] %v: %d records deleted before %v purged", tableName, purged, cutoff.Format(time.RFC3339))
+	clock.Stop(purged)
+	return purged, err
+}
+
+// History returns each version of the record with the given ID, oldest first, including the
+// version written when it was deleted. Records are upgraded, as Get upgrades them.
+func History(ctx context.Context, id int) ([]database.Version[TemplateStoreV3], error) {
+	dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+
+	if !withHistory {
+		return nil, fmt.Errorf("reading %v history: %w", tableName, database.ErrHistoryDisabled)
+	}
+	clock := timing.Start(tableName, "History", fmt.Sprintf("%v", id))
 
-		if err := record.postDeleteProcessing(ctx); err != nil {
+	versions, err := database.History[TemplateStoreV3](activeDBConnection, id)
+	if err != nil {
+		clock.Stop(0)
+		return nil, ce.ErrNotFoundWrapper(tableName, err)
+	}
+	for i := range versions {
+		if err := versions[i].Record.postGet(ctx); err != nil {
 			clock.Stop(0)
-			return ce.ErrDAODeleteWrapper(tableName, field.String(), value, err)
+			return nil, err
 		}
 	}
+	clock.Stop(len(versions))
+	return versions, nil
+}
+
+// AsOf returns the record with the given ID as it was at the time at. It is not found if the
+// record had not been created, or had been deleted, by then.
+func AsOf(ctx context.Context, id int, at time.Time) (TemplateStoreV3, error) {
+	dao.CheckDAOReadyState(tableName, audit.GET, databaseConnectionActive)
+
+	if !withHistory {
+		return TemplateStoreV3{}, fmt.Errorf("reading %v history: %w", tableName, database.ErrHistoryDisabled)
+	}
+	clock := timing.Start(tableName, "AsOf", fmt.Sprintf("%v %v", id, at.Format(time.RFC3339)))
 
+	version, err := database.VersionAsOf[TemplateStoreV3](activeDBConnection, id, at)
+	if err != nil {
+		clock.Stop(0)
+		return TemplateStoreV3{}, ce.ErrRecordNotFoundWrapper(tableName, Fields.ID.String(), fmt.Sprintf("%v", id))
+	}
+	record := version.Record
+	if err := record.postGet(ctx); err != nil {
+		clock.Stop(0)
+		return TemplateStoreV3{}, err
+	}
+	clock.Stop(1)
+	return record, nil
+}
+
+// Revert saves the record with the given ID with the fields of its version numbered version,
+// validated and audited as any update is, with the REVERT audit action. The audit itself is
+// not reverted, and a deleted record stays deleted.
+func Revert(ctx context.Context, id int, version int) error {
+	dao.CheckDAOReadyState(tableName, audit.REVERT, databaseConnectionActive)
+
+	if !withHistory {
+		return fmt.Errorf("reverting %v: %w", tableName, database.ErrHistoryDisabled)
+	}
+	clock := timing.Start(tableName, "Revert", fmt.Sprintf("%v %v", id, version))
+
+	current, err := Query().IncludeDeleted().Where(Fields.ID, database.Eq, id).First()
+	if err != nil {
+		clock.Stop(0)
+		return ce.ErrRecordNotFoundWrapper(tableName, Fields.ID.String(), fmt.Sprintf("%v", id))
+	}
+	snapshot, err := database.GetVersion[TemplateStoreV3](activeDBConnection, id, version)
+	if err != nil {
+		clock.Stop(0)
+		return ce.ErrRecordNotFoundWrapper(tableName, "Version", fmt.Sprintf("%v %v", id, version))
+	}
+	record := snapshot.Record
+	if err := record.postGet(ctx); err != nil {
+		clock.Stop(0)
+		return err
+	}
+	record.ID = current.ID
+	record.Audit = current.Audit
+	if err := record.insertOrUpdate(ctx, fmt.Sprintf("Reverted %v %v to version %v", tableName, id, version), audit.REVERT, UPDATE); err != nil {
+		clock.Stop(0)
+		return err
+	}
 	clock.Stop(1)
 	return nil
 }
 
+// MigrateAll migrates every record written at an older schema version, and saves it with the
+// MIGRATE audit action. Records at the current version are left alone, so it can be run again.
+// Progress is logged; it returns the number of records migrated.
+func MigrateAll(ctx context.Context) (int, error) {
+	dao.CheckDAOReadyState(tableName, audit.PROCESS, databaseConnectionActive)
+
+	clock := timing.Start(tableName, "MigrateAll", "ALL")
+	total, err := Query().IncludeDeleted().Count()
+	if err != nil {
+		clock.Stop(0)
+		return 0, ce.ErrNotFoundWrapper(tableName, err)
+	}
+	checked := 0
+	migrated := 0
+	// Records are read as stored, not through ForEach, whose postGet would migrate them first
+	err = database.Each(activeDBConnection, func(record TemplateStoreV3) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		changed, err := record.migrationProcessing()
+		if err != nil {
+			return ce.ErrDAOUpdateWrapper(tableName, err)
+		}
+		if changed {
+			if err := record.insertOrUpdate(ctx, fmt.Sprintf("Migrated to version %d", audit.CurrentDBVersion()), audit.MIGRATE, UPDATE); err != nil {
+				return err
+			}
+			migrated++
+		}
+		checked++
+		if checked%100 == 0 || checked == total {
+			logHandler.DatabaseLogger.Printf("[MIGRATE] %v: %d/%d records checked, %d migrated", tableName, checked, total, migrated)
+		}
+		return nil
+	})
+	clock.Stop(migrated)
+	return migrated, err
+}
+
 // Validate runs record validation and returns an error if invalid.
 func (record *TemplateStoreV3) Validate() error {
 	return record.validationProcessing()
@@ -204,6 +491,14 @@ func (record *TemplateStoreV3) Update(ctx context.Context, note string) error {
 	return record.insertOrUpdate(ctx, note, audit.UPDATE, UPDATE)
 }
 
+// UpdateTx persists changes to an existing record inside the supplied transaction.
+func (record *TemplateStoreV3) UpdateTx(ctx context.Context, tx *database.Tx, note string) error {
+	if err := checkTx(tx); err != nil {
+		return ce.ErrDAOUpdateWrapper(tableName, err)
+	}
+	return record.insertOrUpdateWith(database.ContextWithTx(ctx, tx), tx, note, audit.UPDATE, UPDATE)
+}
+
 // UpdateWithAction persists changes using the provided audit action.
 func (record *TemplateStoreV3) UpdateWithAction(ctx context.Context, auditAction audit.Action, note string) error {
 	return record.insertOrUpdate(ctx, note, auditAction, UPDATE)
@@ -231,34 +526,38 @@ func GetLookup(field, value entities.Field) (lookup.Lookup, error) {
 
 	clock := timing.Start(tableName, "Lookup", "BUILD")
 
-	recordList, err := GetAll()
-	if err != nil {
-		lkpErr := ce.ErrDAOLookupWrapper(tableName, field.String(), value, err)
-		logHandler.ErrorLogger.Print(lkpErr.Error())
-		clock.Stop(0)
-		return lookup.Lookup{}, lkpErr
-	}
-
 	var rtnLookup lookup.Lookup
 	rtnLookup.Data = make([]lookup.LookupData, 0)
 
-	for _, a := range recordList {
+	err := ForEach(context.Background(), func(a TemplateStoreV3) error {
 		key := reflect.ValueOf(a).FieldByName(field.String()).Interface().(string)
 		val := reflect.ValueOf(a).FieldByName(value.String()).Interface().(string)
 		rtnLookup.Data = append(rtnLookup.Data, lookup.LookupData{Key: key, Value: val})
+		return nil
+	})
+	if err != nil {
+		lkpErr := ce.ErrDAOLookupWrapper(tableName, field.String(), value, err)
+		logHandler.ErrorLogger.Print(lkpErr.Error())
+		clock.Stop(0)
+		return lookup.Lookup{}, lkpErr
 	}
 
 	clock.Stop(len(rtnLookup.Data))
 	return rtnLookup, nil
 }
 
-// Drop drops the underlying database bucket/table for this entity.
+// Drop drops the underlying database bucket/table for this entity, and its history.
 func Drop() error {
 	logHandler.TraceLogger.Printf("Drop %v", tableName)
 	err := activeDBConnection.Drop(TemplateStoreV3{})
 	if err != nil {
 		return err
 	}
+	if withHistory {
+		if err := database.DropHistory[TemplateStoreV3](activeDBConnection); err != nil {
+			return err
+		}
+	}
 	if postDrop != nil {
 		if err := postDrop(context.Background()); err != nil {
 			return err
@@ -267,7 +566,7 @@ func Drop() error {
 	return nil
 }
 
-// ClearDown deletes all records from this table.
+// ClearDown permanently removes all records from this table, soft-deleted ones included.
 func ClearDown(ctx context.Context) error {
 	logHandler.TraceLogger.Printf("ClearDown %v", tableName)
 
@@ -275,25 +574,36 @@ func ClearDown(ctx context.Context) error {
 
 	clock := timing.Start(tableName, "Clear", "INITIALISE")
 
-	recordList, err := GetAll()
+	total, err := Query().IncludeDeleted().Count()
 	if err != nil {
 		logHandler.ErrorLogger.Print(ce.ErrDAOInitialisationWrapper(tableName, err).Error())
 		clock.Stop(0)
 		return ce.ErrDAOInitialisationWrapper(tableName, err)
 	}
 
+	i := 0
 	count := 0
-	logHandler.TraceLogger.Printf("Clearing %v records", len(recordList))
+	logHandler.TraceLogger.Printf("Clearing %v records", total)
 
-	for i, record := range recordList {
-		logHandler.TraceLogger.Printf("(%v/%v) DELETE %v WHERE %v=%v", i+1, len(recordList), tableName, Fields.ID, record.ID)
+	err = database.Each(activeDBConnection, func(record TemplateStoreV3) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i++
+		logHandler.TraceLogger.Printf("(%v/%v) DELETE %v WHERE %v=%v", i, total, tableName, Fields.ID, record.ID)
 
-		delErr := Delete(ctx, record.ID, fmt.Sprintf("Clearing %v %v @ initialisation ", tableName, record.ID))
+		delErr := record.delete(ctx, fmt.Sprintf("Clearing %v %v @ initialisation ", tableName, record.ID), true)
 		if delErr != nil {
 			logHandler.ErrorLogger.Print(ce.ErrDAOInitialisationWrapper(tableName, delErr).Error())
-			continue
+			return nil
 		}
 		count++
+		return nil
+	})
+	if err != nil {
+		logHandler.ErrorLogger.Print(ce.ErrDAOInitialisationWrapper(tableName, err).Error())
+		clock.Stop(count)
+		return ce.ErrDAOInitialisationWrapper(tableName, err)
 	}
 
 	if postClearDown != nil {
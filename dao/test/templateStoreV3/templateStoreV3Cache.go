@@ -17,7 +17,8 @@ import (
 func CacheHydrator(ctx context.Context) func() ([]any, error) {
 	_ = ctx
 	return func() ([]any, error) {
-		records, err := GetAll()
+		// soft-deleted records are cached too, for queries that include them
+		records, err := GetAllMatching(Query().IncludeDeleted())
 		if err != nil {
 			return nil, err
 		}
@@ -19,8 +19,17 @@ var activeDBConnection *database.DB
 var databaseConnectionActive bool
 var cfg *commonConfig.Settings
 
+// softDelete is set by dao-gen -soft-delete. If true, Delete marks records deleted, rather
+// than removing them, and reads skip them; see Restore and PurgeDeleted.
+const softDelete = false
+
+// withHistory is set by dao-gen -with-history. If true, a snapshot of each version of a
+// record is kept; see History, AsOf and Revert.
+const withHistory = false
+
 // Initialise opens the database connection for TemplateStoreV3 and optionally enables caching.
-func Initialise(ctx context.Context, cached bool) {
+// It returns an error if the connection cannot be opened.
+func Initialise(ctx context.Context, cached bool) error {
 	//logHandler.DatabaseLogger.Printf("Opening connection to %v", tableName)
 	logHandler.TraceLogger.Printf("Initialising %v DAO Caching: %t", tableName, cached)
 
@@ -28,11 +37,18 @@ func Initialise(ctx context.Context, cached bool) {
 	cfg = commonConfig.Get()
 	_ = cfg
 
-	activeDBConnection = database.Connect(TemplateStoreV3{}, database.WithVerbose(false), database.WithCaching(cached), database.WithCacheKey(Fields.Key), database.WithNameSpace("main"))
+	db, err := database.Open(TemplateStoreV3{}, database.WithVerbose(false), database.WithCaching(cached), database.WithCacheKey(Fields.Key), database.WithSoftDelete(softDelete), database.WithHistory(withHistory), database.WithNameSpace("main"), database.WithIndex(Fields.GID, Fields.UserCode, Fields.LastHost), database.WithUniqueIndex(Fields.Key, Fields.Raw))
+	if err != nil {
+		logHandler.ErrorLogger.Printf("Error initialising %v DAO: %v", tableName, err.Error())
+		clock.Stop(0)
+		return err
+	}
+	activeDBConnection = db
 	databaseConnectionActive = true
 
 	clock.Stop(1)
 	//logHandler.DatabaseLogger.Printf("Opened connection to %v", tableName)
+	return nil
 }
 
 // IsInitialised reports whether the DAO has an active database connection.
@@ -10,6 +10,8 @@ import (
 	"context"
 
 	"github.com/mt1976/frantic-amphora/dao"
+	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/database"
 	"github.com/mt1976/frantic-core/logHandler"
 )
 
@@ -28,6 +30,8 @@ type postDeleteFunc func(ctx context.Context, record *TemplateStoreV3) error
 type postCloneFunc func(ctx context.Context, record *TemplateStoreV3) error
 type postDropFunc func(ctx context.Context) error
 
+var migrations = database.NewMigrations[TemplateStoreV3](tableName)
+
 var creator creatorFunc
 var upgrader upgraderFunc
 var defaulter defaulterFunc
@@ -100,6 +104,15 @@ func RegisterUpgrader(fn upgraderFunc) {
 	upgrader = fn
 }
 
+// RegisterMigration registers a migration of TemplateStoreV3 records from the schema version
+// fromVersion to toVersion. Records written at an older version are migrated when they are
+// read, and saved by MigrateAll.
+func RegisterMigration(fromVersion, toVersion int, fn func(TemplateStoreV3) (TemplateStoreV3, error)) error {
+	logHandler.EventLogger.Printf("[REGISTER] Migration %d->%d for %v (%v)", fromVersion, toVersion, tableName, dao.GetFunctionName(fn))
+	logHandler.DatabaseLogger.Printf("[REGISTER] Migration %d->%d for %v (%v)", fromVersion, toVersion, tableName, dao.GetFunctionName(fn))
+	return migrations.Register(fromVersion, toVersion, fn)
+}
+
 // RegisterDefaulter registers a defaulter function for TemplateStoreV3.
 func RegisterDefaulter(fn defaulterFunc) {
 	logHandler.DatabaseLogger.Printf("[REGISTER] Defaulter for %v (%v)", tableName, dao.GetFunctionName(fn))
@@ -163,6 +176,23 @@ func (record *TemplateStoreV3) upgradeProcessing() error {
 	return nil
 }
 
+// migrationProcessing applies the registered migrations to a record written at an older schema
+// version, and reports whether there were any.
+func (record *TemplateStoreV3) migrationProcessing() (bool, error) {
+	version, target := record.Audit.DBVersion.Get(), audit.CurrentDBVersion()
+	if version >= target || !migrations.Pending(version, target) {
+		return false, nil
+	}
+	logHandler.DatabaseLogger.Printf("[MIGRATE] record %v of %v from version %d to %d", record.Key, TableName.String(), version, target)
+	migrated, err := migrations.Apply(*record, version, target)
+	if err != nil {
+		return false, err
+	}
+	*record = migrated
+	record.Audit.DBVersion.Set(target)
+	return true, nil
+}
+
 // defaultProcessing applies any default values prior to validation and persistence.
 func (record *TemplateStoreV3) defaultProcessing() error {
 	if defaulter != nil {
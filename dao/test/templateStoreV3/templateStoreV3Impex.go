@@ -37,18 +37,18 @@ func ExportAllToJSON(message string) {
 	dao.CheckDAOReadyState(tableName, audit.EXPORT, databaseConnectionActive)
 
 	clock := timing.Start(tableName, "Export", "ALL")
-	recordList, _ := GetAll()
-	if len(recordList) == 0 {
-		logHandler.WarningLogger.Printf("[%v] %v data not found", tableName, tableName)
-		clock.Stop(0)
-		return
-	}
-
-	err := importExportHelper.ExportJSON(message, recordList, Fields.ID)
+	count := 0
+	err := ForEach(context.Background(), func(record TemplateStoreV3) error {
+		count++
+		return importExportHelper.ExportJSON(message, []TemplateStoreV3{record}, Fields.ID)
+	})
 	if err != nil {
 		logHandler.ExportLogger.Panicf("error exporting all %v's: %v", tableName, err.Error())
 	}
-	clock.Stop(len(recordList))
+	if count == 0 {
+		logHandler.WarningLogger.Printf("[%v] %v data not found", tableName, tableName)
+	}
+	clock.Stop(count)
 }
 
 // ExportRecordToCSV exports the record as a CSV file.
@@ -67,13 +67,11 @@ func (record *TemplateStoreV3) ExportRecordToCSV(name string) error {
 	return nil
 }
 
-// ExportAllToCSV exports all records as a CSV file.
+// ExportAllToCSV exports all records as a CSV file, a record at a time.
 func ExportAllToCSV(msg string) error {
-	exportListData, err := GetAll()
-	if err != nil {
-		logHandler.ExportLogger.Panicf("error Getting all %v's: %v", tableName, err.Error())
-	}
-	return importExportHelper.ExportCSV(msg, exportListData, Fields.ID)
+	dao.CheckDAOReadyState(tableName, audit.EXPORT, databaseConnectionActive)
+
+	return importExportHelper.ExportCSVSeq(msg, Iterate(context.Background()))
 }
 
 // ImportAllFromCSV imports records for this table from a CSV file.
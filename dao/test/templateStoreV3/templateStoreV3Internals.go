@@ -13,6 +13,7 @@ import (
 
 	"github.com/mt1976/frantic-amphora/dao"
 	"github.com/mt1976/frantic-amphora/dao/audit"
+	"github.com/mt1976/frantic-amphora/dao/database"
 	ce "github.com/mt1976/frantic-core/commonErrors"
 	"github.com/mt1976/frantic-core/idHelpers"
 	"github.com/mt1976/frantic-core/logHandler"
@@ -28,6 +29,12 @@ const (
 
 // insertOrUpdate performs shared validation/audit and then creates or updates the record.
 func (record *TemplateStoreV3) insertOrUpdate(ctx context.Context, note string, auditAction audit.Action, operation op) error {
+	return record.insertOrUpdateWith(ctx, activeDBConnection, note, auditAction, operation)
+}
+
+// insertOrUpdateWith is insertOrUpdate against the supplied writer, which is either the
+// active connection or a transaction on it.
+func (record *TemplateStoreV3) insertOrUpdateWith(ctx context.Context, writer database.Writer, note string, auditAction audit.Action, operation op) error {
 	isCreateOperation := false
 	if operation == CREATE {
 		isCreateOperation = true
@@ -57,7 +64,8 @@ func (record *TemplateStoreV3) insertOrUpdate(ctx context.Context, note string,
 			logHandler.DatabaseLogger.Printf("Invoking custom creator for %v record %v", tableName, record.Key)
 			id, skip, createdRecord, err := creator(ctx, *record)
 			if err != nil {
-				logHandler.ErrorLogger.Panic(ce.ErrDAOCreateWrapper(tableName, fmt.Sprintf("%v", record.Key), err))
+				clock.Stop(0)
+				return failWrite(writer, ce.ErrDAOCreateWrapper(tableName, fmt.Sprintf("%v", record.Key), err))
 			}
 			if !skip {
 				record = &createdRecord
@@ -84,7 +92,13 @@ func (record *TemplateStoreV3) insertOrUpdate(ctx context.Context, note string,
 		return valErr
 	}
 
-	auditErr := record.Audit.Action(ctx, auditAction.WithMessage(note))
+	// an update records the fields it changes from the stored version
+	var changes []audit.FieldChange
+	if !isCreateOperation {
+		changes = audit.Diff(record.stored(writer), *record)
+	}
+
+	auditErr := record.Audit.Action(ctx, auditAction.WithMessage(note).WithChanges(changes))
 	if auditErr != nil {
 		audErr := ce.ErrDAOUpdateAuditWrapper(tableName, record.ID, auditErr)
 		logHandler.ErrorLogger.Print(audErr.Error())
@@ -95,23 +109,29 @@ func (record *TemplateStoreV3) insertOrUpdate(ctx context.Context, note string,
 	var actionError error
 	if isCreateOperation {
 		logHandler.DatabaseLogger.Printf("Creating %v record %v %v", tableName, record.Key, record.ID)
-		actionError = activeDBConnection.Create(record)
+		actionError = writer.Create(record)
 		logHandler.DatabaseLogger.Printf("Created %v record %v %v", tableName, record.Key, record.ID)
 
 	} else {
 		logHandler.DatabaseLogger.Printf("Updating %v record %v %v", tableName, record.Key, record.ID)
-		actionError = activeDBConnection.Update(record)
+		actionError = writer.Update(record)
 		logHandler.DatabaseLogger.Printf("Updated %v record %v %v", tableName, record.Key, record.ID)
 	}
 
 	logHandler.DatabaseLogger.Printf("%v operation completed for %v record %v", operation, tableName, record.Key)
 	if actionError != nil {
 		//godump.Dump(record)
-		updErr := ce.ErrDAOUpdateWrapper(tableName, actionError)
-		logHandler.ErrorLogger.Panic(updErr.Error(), actionError)
 		clock.Stop(0)
-		return updErr
+		return failWrite(writer, ce.ErrDAOUpdateWrapper(tableName, actionError))
+	}
+	if err := record.saveVersion(writer); err != nil {
+		verErr := ce.ErrDAOUpdateWrapper(tableName, err)
+		logHandler.ErrorLogger.Print(verErr.Error())
+		clock.Stop(0)
+		return verErr
 	}
+	record.publish(ctx, writer)
+
 	var err error
 	var update bool = false
 	var message string = ""
@@ -136,13 +156,11 @@ func (record *TemplateStoreV3) insertOrUpdate(ctx context.Context, note string,
 			message = "Post " + string(operation) + " Processing"
 		}
 		logHandler.DatabaseLogger.Printf("Post %v processing requires update for %v record %v %v", operation, tableName, record.Key, record.ID)
-		actionError = activeDBConnection.Update(&newRec)
+		actionError = writer.Update(&newRec)
 		//err = record.UpdateWithAction(ctx, audit.UPDATE, message)
 		if actionError != nil {
-			updErr := ce.ErrDAOCreateWrapper(tableName, record.ID, actionError)
-			logHandler.ErrorLogger.Panic(updErr.Error())
 			clock.Stop(0)
-			return updErr
+			return failWrite(writer, ce.ErrDAOCreateWrapper(tableName, record.ID, actionError))
 		}
 	}
 
@@ -150,6 +168,18 @@ func (record *TemplateStoreV3) insertOrUpdate(ctx context.Context, note string,
 	return nil
 }
 
+// failWrite logs err, a failed write through writer, and returns it. Inside a transaction
+// the error rolls the transaction back, through WithTx; outside one, it panics, as writes
+// always have.
+func failWrite(writer database.Writer, err error) error {
+	if _, inTx := writer.(*database.Tx); inTx {
+		logHandler.ErrorLogger.Print(err.Error())
+		return err
+	}
+	logHandler.ErrorLogger.Panic(err.Error())
+	return err
+}
+
 // postGetList runs post-get processing for each record in the list.
 func postGetList(ctx context.Context, recordList []TemplateStoreV3) ([]TemplateStoreV3, error) {
 	clock := timing.Start(tableName, "Process", "POSTGET")
@@ -165,8 +195,11 @@ func postGetList(ctx context.Context, recordList []TemplateStoreV3) ([]TemplateS
 	return returnList, nil
 }
 
-// postGet runs upgrade/default/validation processing after a record is loaded.
+// postGet runs migration/upgrade/default/validation processing after a record is loaded.
 func (record *TemplateStoreV3) postGet(ctx context.Context) error {
+	if _, migrationError := record.migrationProcessing(); migrationError != nil {
+		return migrationError
+	}
 	if upgradeError := record.upgradeProcessing(); upgradeError != nil {
 		return upgradeError
 	}
@@ -179,6 +212,94 @@ func (record *TemplateStoreV3) postGet(ctx context.Context) error {
 	return record.postGetProcessing(ctx)
 }
 
+// delete removes the record from the database or, if the table soft-deletes and purge is
+// false, saves it marked deleted.
+func (record *TemplateStoreV3) delete(ctx context.Context, note string, purge bool) error {
+	if err := record.Audit.Action(ctx, audit.DELETE.WithMessage(note)); err != nil {
+		return ce.ErrDAOUpdateAuditWrapper(tableName, record.ID, err)
+	}
+
+	if err := record.preDeleteProcessing(ctx); err != nil {
+		return ce.ErrDAODeleteWrapper(tableName, Fields.ID.String(), record.ID, err)
+	}
+
+	var err error
+	if softDelete && !purge {
+		logHandler.DatabaseLogger.Printf("Marking %v record %v %v deleted", tableName, record.Key, record.ID)
+		err = activeDBConnection.Update(record)
+	} else {
+		err = activeDBConnection.Delete(record)
+	}
+	if err != nil {
+		return ce.ErrDAODeleteWrapper(tableName, Fields.ID.String(), record.ID, err)
+	}
+	if err := record.saveVersion(activeDBConnection); err != nil {
+		return ce.ErrDAODeleteWrapper(tableName, Fields.ID.String(), record.ID, err)
+	}
+	record.publish(ctx, activeDBConnection)
+
+	if err := record.postDeleteProcessing(ctx); err != nil {
+		return ce.ErrDAODeleteWrapper(tableName, Fields.ID.String(), record.ID, err)
+	}
+	return nil
+}
+
+// stored returns the version of the record stored through writer, soft-deleted or not, or the
+// zero TemplateStoreV3 if there is none. Inside a transaction it is read through the transaction,
+// so changes made earlier in it are seen; otherwise it is read from the database, not the cache.
+func (record *TemplateStoreV3) stored(writer database.Writer) TemplateStoreV3 {
+	var stored TemplateStoreV3
+	var err error
+	switch w := writer.(type) {
+	case *database.Tx:
+		_, err = w.Get(Fields.ID, record.ID, &stored)
+	case *database.DB:
+		_, err = w.GetStored(Fields.ID, record.ID, &stored)
+	}
+	if err != nil {
+		return TemplateStoreV3{}
+	}
+	return stored
+}
+
+// saveVersion adds the record, as just written through writer, to its history, if the table
+// keeps one. Its version is its audit sequence.
+func (record *TemplateStoreV3) saveVersion(writer database.Writer) error {
+	if !withHistory {
+		return nil
+	}
+	action := record.Audit.LastAction()
+	return database.SaveVersion(writer, record.ID, database.Version[TemplateStoreV3]{
+		Version: record.Audit.AuditSequence.Int(),
+		At:      action.At,
+		Action:  action.Action,
+		User:    action.User,
+		Notes:   action.Notes,
+		Record:  *record,
+	})
+}
+
+// publish sends the audit event of the action just written through writer to the audit
+// event store, if one is open. Inside a transaction it is sent once the transaction has
+// committed, and not at all if it rolls back. The change has been made, so an error is only
+// logged.
+func (record *TemplateStoreV3) publish(ctx context.Context, writer database.Writer) {
+	event, ok := record.Audit.TakeEvent(tableName, record.ID, record.Key)
+	if !ok {
+		return
+	}
+	send := func() {
+		if err := audit.PublishEvent(ctx, event); err != nil {
+			logHandler.WarningLogger.Printf("Error recording audit event for %v record %v: %v", tableName, event.Key, err)
+		}
+	}
+	if tx, inTx := writer.(*database.Tx); inTx {
+		tx.AfterCommit(send)
+		return
+	}
+	send()
+}
+
 // checkForDuplicate checks whether the record key already exists.
 func (record *TemplateStoreV3) checkForDuplicate() error {
 	dao.CheckDAOReadyState(tableName, audit.PROCESS, databaseConnectionActive)
@@ -198,3 +319,11 @@ func (record *TemplateStoreV3) checkForDuplicate() error {
 
 	return nil
 }
+
+// checkTx checks that the transaction belongs to the database this DAO is connected to.
+func checkTx(tx *database.Tx) error {
+	if tx == nil || tx.DB() == nil || tx.DB().Name != activeDBConnection.Name {
+		return fmt.Errorf("transaction is not on the %v namespace used by %v", activeDBConnection.Name, tableName)
+	}
+	return nil
+}
@@ -49,7 +49,7 @@ func DuplicateCheck(record *templateStoreV3.TemplateStoreV3) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	if responseRecord.Audit.DeletedBy != "" {
+	if responseRecord.Audit.IsDeleted() {
 		return false, nil
 	}
 	return true, nil
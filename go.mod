@@ -5,6 +5,7 @@ go 1.25
 require (
 	github.com/dustin/go-humanize v1.0.1
 	github.com/goforj/godump v1.9.0
+	github.com/klauspost/compress v1.18.0
 	github.com/mt1976/frantic-core v1.8.0
 )
 
@@ -16,12 +17,13 @@ require (
 	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/gopherjs/gopherjs v1.20.0 // indirect
 	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/vmihailenco/msgpack v4.0.4+incompatible // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 )
 
 require (
-	github.com/BurntSushi/toml v1.6.0 // indirect
+	github.com/BurntSushi/toml v1.6.0
 	github.com/asdine/storm/v3 v3.2.1
 	github.com/beorn7/floats v1.0.0
 	github.com/gabriel-vasile/mimetype v1.4.12 // indirect
@@ -36,7 +38,7 @@ require (
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/segmentio/ksuid v1.0.4 // indirect
 	github.com/shopspring/decimal v1.4.0
-	go.etcd.io/bbolt v1.4.3 // indirect
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/crypto v0.47.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
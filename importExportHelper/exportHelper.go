@@ -4,6 +4,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
+	"iter"
 	"os/user"
 	"reflect"
 	"time"
@@ -48,8 +49,58 @@ func ExportCSV[T any](exportName string, exportList []T, idField entities.Field)
 		logHandler.ExportLogger.Panicf("error exporting %v: %v", exportName, err.Error())
 	}
 
+	exportFile.WriteString(generatedFooter(len(exportList), exportName))
+
+	exportFile.Close()
+
+	logHandler.ExportLogger.Printf("Exported (%v/%v) %v(s) to [%v]", len(exportList), len(exportList), exportName, exportFile.Name())
+	//logHandler.EventLogger.Printf("Exported (%v/%v) %v(s) to [%v]", len(exportList), len(exportList), exportName, exportFile.Name())
+	clock.Stop(len(exportList))
+	return nil
+}
+
+// ExportCSVSeq is ExportCSV for records produced one at a time, such as by database.Iterate, so
+// they need not be held in memory. The export stops at the first error from records.
+func ExportCSVSeq[T any](exportName string, records iter.Seq2[T, error]) error {
+	clock := timing.Start(exportName, "Export", "")
+
+	// named as ExportCSV names an export of several records
+	var zero T
+	exportName = idHelpers.GetUUID() + SEP + reflect.TypeOf(zero).Name()
+	logHandler.ExportLogger.Printf("Exporting %v.csv", exportName)
+	exportFile := openTargetFile(exportName, exportString, logHandler.ExportLogger, "csv", paths.Defaults().String())
+	defer exportFile.Close()
+
+	writer := csv.NewWriter(exportFile)
+	writer.Comma = FIELDSEPARATOR
+	writer.UseCRLF = true
+	out := gocsv.NewSafeCSVWriter(writer)
+	if err := gocsv.MarshalCSV([]T{}, out); err != nil {
+		clock.Stop(0)
+		return err
+	}
+	count := 0
+	for record, err := range records {
+		if err != nil {
+			clock.Stop(count)
+			return err
+		}
+		if err := gocsv.MarshalCSVWithoutHeaders([]T{record}, out); err != nil {
+			clock.Stop(count)
+			return err
+		}
+		count++
+	}
+	exportFile.WriteString(generatedFooter(count, exportName))
+
+	logHandler.ExportLogger.Printf("Exported (%v/%v) %v(s) to [%v]", count, count, exportName, exportFile.Name())
+	clock.Stop(count)
+	return exportFile.Close()
+}
+
+// generatedFooter returns the metadata line written at the end of a CSV export.
+func generatedFooter(noItems int, exportName string) string {
 	//Example: # Generated 4 Zones at 11:39:05 on 2025-02-25
-	noItems := len(exportList)
 	plurality := "s"
 	if noItems == 1 {
 		plurality = ""
@@ -63,15 +114,7 @@ func ExportCSV[T any](exportName string, exportList []T, idField entities.Field)
 	}
 	on := application.SystemIdentity()
 	os := application.OS()
-	msg := fmt.Sprintf("# Generated (%v) %v%v at %v %v by %v on %v(%v)", len(exportList), exportName, plurality, time.Now().Format("15:04:05"), time.Now().Format("2006-01-02"), by, on, os)
-	exportFile.WriteString(msg)
-
-	exportFile.Close()
-
-	logHandler.ExportLogger.Printf("Exported (%v/%v) %v(s) to [%v]", len(exportList), len(exportList), exportName, exportFile.Name())
-	//logHandler.EventLogger.Printf("Exported (%v/%v) %v(s) to [%v]", len(exportList), len(exportList), exportName, exportFile.Name())
-	clock.Stop(len(exportList))
-	return nil
+	return fmt.Sprintf("# Generated (%v) %v%v at %v %v by %v on %v(%v)", noItems, exportName, plurality, time.Now().Format("15:04:05"), time.Now().Format("2006-01-02"), by, on, os)
 }
 
 func ExportJSON[T any](exportName string, exportList []T, idField entities.Field) error {
@@ -40,6 +40,9 @@ func Announce(name, inAction string) {
 }
 
 func GetHumanReadableCronFreq(freq string) string {
+	if freq == "" {
+		return "never (disabled)"
+	}
 	//bkHuman1, _ := crondescriptor.NewCronDescriptor(freq)
 	//bkHuman, _ := bkHuman1.GetDescription(crondescriptor.Full)
 	nextTime := cronexpr.MustParse(freq).Next(time.Now())
@@ -71,6 +74,10 @@ func CodedName(job Job) string {
 
 func AddJobToScheduler(j Job) {
 	//logHandler.ServiceLogger.Printf("[%v] Scheduling Job [%v] [%v]", domain, j.Name(), j.Schedule())
+	if j.Schedule() == "" {
+		logHandler.ServiceLogger.Printf("[%v] Job %v has no schedule, not scheduled", domain, stringHelpers.DQuote(j.Name()))
+		return
+	}
 	clock := timing.Start(domain, "Schedule", j.Name())
 	// Start the job
 	jobID, err := scheduledTasks.AddFunc(j.Schedule(), j.Service())
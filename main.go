@@ -82,7 +82,9 @@ func main() {
 	logHandler.InfoBanner("INFO", "START", "Starting DAO Test Application - Phase 1")
 
 	logHandler.InfoLogger.Println("Initialize User Store")
-	templateStoreV3.Initialise(ctx, false)
+	if err := templateStoreV3.Initialise(ctx, false); err != nil {
+		logHandler.ErrorLogger.Fatalf("Error initialising User Store: %v", err)
+	}
 	templateStoreV3.RegisterCreator(tmpllogic.Creator)
 	templateStoreV3.RegisterDuplicateCheck(tmpllogic.DuplicateCheck)
 	templateStoreV3.RegisterWorker(tmpllogic.JobProcessor)